@@ -0,0 +1,89 @@
+package importlimits
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	appserver "ratelimit-challenge/internal/app/server"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	"ratelimit-challenge/pkg/connections"
+	"ratelimit-challenge/pkg/utility"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// NewCommand creates a new user limit import command, connecting to Redis
+// the way the server does
+func NewCommand() *cobra.Command {
+	return NewCommandWith(connections.NewRedis)
+}
+
+// NewCommandWith is like NewCommand but connects to Redis with connect
+func NewCommandWith(connect connections.RedisConnector) *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "import-limits",
+		Short: "Import per-user rate limits from an NDJSON file",
+		Long:  "Stream per-user rate limits, one {\"user_id\": ..., \"limit\": ...} object per line, into Redis",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runImport(cmd.Context(), connect, file)
+		},
+	}
+
+	cmd.Flags().StringVar(&file, "file", "", "NDJSON file to import, or - for stdin")
+	_ = cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func runImport(ctx context.Context, connect connections.RedisConnector, file string) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	logger, err := utility.NewLogger(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+	defer logger.Sync()
+
+	var input io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", file, err)
+		}
+		defer f.Close()
+		input = f
+	}
+
+	client, err := connect(appserver.RedisConfig(cfg).WithDB(cfg.Redis.ConfigDatabase()), logger)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	service := ratelimiter.NewService(client, &cfg.RateLimit, logger)
+
+	// Report progress every 100k entries
+	lastReported := 0
+	imported, err := service.ImportUserLimits(ctx, input, func(imported int) {
+		if imported-lastReported >= 100000 {
+			logger.Info("importing user limits", zap.Int("imported", imported))
+			lastReported = imported
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("import failed after %d entries: %w", imported, err)
+	}
+
+	logger.Info("user limit import complete", zap.Int("imported", imported))
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package limitconfig
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	appserver "ratelimit-challenge/internal/app/server"
+	"ratelimit-challenge/internal/service/ratelimiter"
+
+	"github.com/spf13/cobra"
+)
+
+// ServiceFactory builds the service the commands act on, and a function
+// closing its connections
+type ServiceFactory func(ctx context.Context) (*ratelimiter.Service, func(context.Context) error, error)
+
+// NewCommand creates the command managing user limits, connecting to Redis
+// the way the server does
+func NewCommand() *cobra.Command {
+	return NewCommandWith(appserver.NewRateLimiter)
+}
+
+// NewCommandWith is like NewCommand but gets the service from newService
+func NewCommandWith(newService ServiceFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Manage per-user rate limits",
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <user_id> <limit>",
+		Short: "Set a user's rate limit",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			limit, err := strconv.Atoi(args[1])
+			if err != nil || limit <= 0 {
+				return fmt.Errorf("limit must be a positive integer, got %q", args[1])
+			}
+			return withService(cmd.Context(), newService, func(service *ratelimiter.Service) error {
+				if err := service.SetUserLimit(cmd.Context(), args[0], limit); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "set %s to %d\n", service.UserConfigKey(args[0]), limit)
+				return nil
+			})
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "reset <user_id>",
+		Short: "Reset a user to the default rate limit",
+		Long:  "Delete a user's custom rate limit, so the default applies again, and reset their window",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withService(cmd.Context(), newService, func(service *ratelimiter.Service) error {
+				if err := service.DeleteUserLimit(cmd.Context(), args[0]); err != nil {
+					return err
+				}
+				if err := service.Reset(cmd.Context(), args[0]); err != nil {
+					return fmt.Errorf("failed to reset window: %w", err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "deleted %s and reset the window\n", service.UserConfigKey(args[0]))
+				return nil
+			})
+		},
+	})
+
+	return cmd
+}
+
+// withService runs fn with a service from newService, closing it afterwards
+func withService(ctx context.Context, newService ServiceFactory, fn func(*ratelimiter.Service) error) error {
+	service, stop, err := newService(ctx)
+	if err != nil {
+		return err
+	}
+	defer stop(context.Background())
+	return fn(service)
+}
@@ -0,0 +1,74 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+
+	appserver "ratelimit-challenge/internal/app/server"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/pkg/connections"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"ratelimit-challenge/pkg/utility"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// NewCommand creates a new key prefix migration command, connecting to Redis
+// the way the server does
+func NewCommand() *cobra.Command {
+	return NewCommandWith(connections.NewRedis)
+}
+
+// NewCommandWith is like NewCommand but connects to Redis with connect
+func NewCommandWith(connect connections.RedisConnector) *cobra.Command {
+	var from, to string
+
+	cmd := &cobra.Command{
+		Use:   "migrate-prefix",
+		Short: "Rename rate limiter keys to a new prefix",
+		Long:  "Rename all Redis keys under one prefix to another prefix, carrying existing counters over without downtime",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runMigration(cmd.Context(), connect, from, to)
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", "", "old key prefix (e.g. rate_limit:sliding:)")
+	cmd.Flags().StringVar(&to, "to", "", "new key prefix")
+	_ = cmd.MarkFlagRequired("from")
+	_ = cmd.MarkFlagRequired("to")
+
+	return cmd
+}
+
+func runMigration(ctx context.Context, connect connections.RedisConnector, from, to string) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	logger, err := utility.NewLogger(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+	defer logger.Sync()
+
+	client, err := connect(appserver.RedisConfig(cfg).WithDB(cfg.Redis.LimiterDatabase()), logger)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	renamed, err := ratelimiter.MigrateKeyPrefix(ctx, client, from, to, cfg.RateLimit.ScanConcurrency)
+	if err != nil {
+		return fmt.Errorf("migration failed after %d keys: %w", renamed, err)
+	}
+
+	logger.Info("key prefix migration complete",
+		zap.String("from", from),
+		zap.String("to", to),
+		zap.Int("renamed", renamed),
+	)
+
+	return nil
+}
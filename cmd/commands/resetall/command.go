@@ -0,0 +1,71 @@
+package resetall
+
+import (
+	"context"
+	"fmt"
+
+	appserver "ratelimit-challenge/internal/app/server"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/pkg/connections"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"ratelimit-challenge/pkg/utility"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+// NewCommand creates a new command resetting every key under a prefix,
+// connecting to Redis the way the server does
+func NewCommand() *cobra.Command {
+	return NewCommandWith(connections.NewRedis)
+}
+
+// NewCommandWith is like NewCommand but connects to Redis with connect
+func NewCommandWith(connect connections.RedisConnector) *cobra.Command {
+	var prefix string
+
+	cmd := &cobra.Command{
+		Use:   "reset-all",
+		Short: "Delete all rate limiter keys under a prefix",
+		Long:  "Delete every Redis key under a prefix, e.g. rate_limit:sliding: to reset all users' windows, spreading the deletes over rate_limit.scan_concurrency workers",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runReset(cmd.Context(), connect, prefix)
+		},
+	}
+
+	cmd.Flags().StringVar(&prefix, "prefix", "", "key prefix to delete (e.g. rate_limit:sliding:)")
+	_ = cmd.MarkFlagRequired("prefix")
+
+	return cmd
+}
+
+func runReset(ctx context.Context, connect connections.RedisConnector, prefix string) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	logger, err := utility.NewLogger(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create logger: %w", err)
+	}
+	defer logger.Sync()
+
+	client, err := connect(appserver.RedisConfig(cfg).WithDB(cfg.Redis.LimiterDatabase()), logger)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	deleted, err := ratelimiter.ResetAll(ctx, client, prefix, cfg.RateLimit.ScanConcurrency)
+	if err != nil {
+		return fmt.Errorf("reset failed after %d keys: %w", deleted, err)
+	}
+
+	logger.Info("reset complete",
+		zap.String("prefix", prefix),
+		zap.Int("deleted", deleted),
+	)
+
+	return nil
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
@@ -59,6 +60,9 @@ func runServer() error {
 		defer shutdownCancel()
 		return app.Shutdown(shutdownCtx)
 	case err := <-errChan:
-		return err
+		// Still close the connections the app opened
+		shutdownCtx, shutdownCancel := context.WithTimeout(ctx, 10*time.Second)
+		defer shutdownCancel()
+		return errors.Join(err, app.Shutdown(shutdownCtx))
 	}
 }
@@ -1,6 +1,10 @@
 package commands
 
 import (
+	"ratelimit-challenge/cmd/commands/importlimits"
+	"ratelimit-challenge/cmd/commands/limitconfig"
+	"ratelimit-challenge/cmd/commands/migrate"
+	"ratelimit-challenge/cmd/commands/resetall"
 	"ratelimit-challenge/cmd/commands/server"
 
 	"github.com/spf13/cobra"
@@ -16,6 +20,10 @@ func NewRootCommand() *cobra.Command {
 
 	// Add subcommands
 	rootCmd.AddCommand(server.NewCommand())
+	rootCmd.AddCommand(migrate.NewCommand())
+	rootCmd.AddCommand(importlimits.NewCommand())
+	rootCmd.AddCommand(resetall.NewCommand())
+	rootCmd.AddCommand(limitconfig.NewCommand())
 
 	return rootCmd
 }
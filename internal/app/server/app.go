@@ -2,27 +2,36 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"ratelimit-challenge/internal/config"
 	"ratelimit-challenge/internal/server"
 	"ratelimit-challenge/internal/service/ratelimiter"
+	"ratelimit-challenge/pkg/audit"
 	"ratelimit-challenge/pkg/connections"
+	ratelimiterpkg "ratelimit-challenge/pkg/ratelimiter"
 	"ratelimit-challenge/pkg/utility"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
 // App represents the server application
 type App struct {
+	fxApp  *fx.App
 	server *server.Server
 	logger *zap.Logger
 }
 
 // NewApp creates a new server app with dependency injection
-func NewApp() (*App, error) {
+// Any opts are applied on top of the app's own, e.g. to fx.Decorate a
+// dependency in tests
+func NewApp(opts ...fx.Option) (*App, error) {
 	var app *App
 
 	// In Uber FX, fx.Invoke callbacks are executed during fx.New() construction
@@ -32,12 +41,16 @@ func NewApp() (*App, error) {
 			config.LoadConfig,
 			utility.NewLogger,
 			provideRedis,
-			ratelimiter.NewService,
+			provideMetricsRegistry,
+			provideAuditSink,
+			provideRateLimiter,
 			server.NewServer,
 		),
 		fx.Options(
 			fx.NopLogger, // Disable fx default logger
 		),
+		fx.Options(opts...),
+		fx.Invoke(closeRedisOnStop),
 		fx.Invoke(func(
 			srv *server.Server,
 			logger *zap.Logger,
@@ -63,6 +76,7 @@ func NewApp() (*App, error) {
 	if app == nil {
 		return nil, fmt.Errorf("failed to create app")
 	}
+	app.fxApp = fxApp
 
 	return app, nil
 }
@@ -72,17 +86,280 @@ func (a *App) Start() error {
 	return a.server.Start()
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server, then stops the remaining
+// dependencies (running their fx OnStop hooks), closing Redis last
+// The dependencies are stopped even if requests didn't finish in time, so
+// their connections aren't leaked
 func (a *App) Shutdown(ctx context.Context) error {
-	return a.server.Shutdown(ctx)
+	serverErr := a.server.Shutdown(ctx)
+	if serverErr != nil {
+		a.logger.Warn("server did not shut down gracefully", zap.Error(serverErr))
+	}
+
+	// ctx may have run out waiting for requests to finish
+	stopCtx := ctx
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		stopCtx, cancel = context.WithTimeout(context.WithoutCancel(ctx), a.fxApp.StopTimeout())
+		defer cancel()
+	}
+	return errors.Join(serverErr, a.fxApp.Stop(stopCtx))
+}
+
+// NewRateLimiter builds the rate limiter service with the same dependencies
+// as the server, for commands acting on it without serving requests
+// The returned stop function closes its connections
+func NewRateLimiter(ctx context.Context) (*ratelimiter.Service, func(context.Context) error, error) {
+	var service *ratelimiter.Service
+	fxApp := fx.New(
+		fx.Provide(
+			config.LoadConfig,
+			utility.NewLogger,
+			provideRedis,
+			provideMetricsRegistry,
+			provideAuditSink,
+			provideRateLimiter,
+		),
+		fx.NopLogger,
+		fx.Invoke(closeRedisOnStop),
+		fx.Populate(&service),
+	)
+	if err := fxApp.Err(); err != nil {
+		return nil, nil, err
+	}
+	if err := fxApp.Start(ctx); err != nil {
+		return nil, nil, fmt.Errorf("fx.Start failed: %w", err)
+	}
+	return service, fxApp.Stop, nil
 }
 
 // Provide functions for dependency injection
-func provideRedis(cfg *config.Config, logger *zap.Logger) (*redis.Client, error) {
-	return connections.NewRedis(connections.RedisConfig{
-		Host:     cfg.Redis.Host,
-		Port:     cfg.Redis.Port,
-		Password: cfg.Redis.Password,
-		DB:       cfg.Redis.DB,
-	}, logger)
+// The client provided is connected to the limiters' database
+func provideRedis(cfg *config.Config, logger *zap.Logger) (redis.UniversalClient, error) {
+	return connections.NewRedis(RedisConfig(cfg).WithDB(cfg.Redis.LimiterDatabase()), logger)
+}
+
+// closeRedisOnStop closes the limiters' Redis client when the app stops
+// It is invoked before anything else is constructed: OnStop hooks run in
+// reverse, so every other dependency has stopped using Redis by then
+func closeRedisOnStop(client redis.UniversalClient, lc fx.Lifecycle) {
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return client.Close()
+		},
+	})
+}
+
+// RedisConfig returns the Redis connection settings, selecting redis.db and
+// connecting per redis.mode; commands connecting outside the app use it so
+// they reach the same deployment as the server
+func RedisConfig(cfg *config.Config) connections.RedisConfig {
+	return connections.RedisConfig{
+		Mode:             cfg.Redis.Mode,
+		Host:             cfg.Redis.Host,
+		Port:             cfg.Redis.Port,
+		Password:         cfg.Redis.Password,
+		DB:               cfg.Redis.DB,
+		Addrs:            cfg.Redis.Addrs,
+		MasterName:       cfg.Redis.MasterName,
+		SentinelPassword: cfg.Redis.SentinelPassword,
+	}
+}
+
+// provideMetricsRegistry creates the registry served on /metrics, holding the
+// Go runtime and process collectors alongside the service's own metrics
+func provideMetricsRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
+// provideAuditSink creates the configured audit sink
+func provideAuditSink(cfg *config.Config, logger *zap.Logger, lc fx.Lifecycle) (audit.Sink, error) {
+	if !cfg.Audit.Enabled {
+		return audit.NopSink{}, nil
+	}
+
+	if cfg.Audit.Sink == "kafka" {
+		sink := audit.NewKafkaSink(
+			audit.NewKafkaProducer(cfg.Audit.Kafka.Brokers, cfg.Audit.Kafka.Topic),
+			audit.KafkaConfig{
+				BatchSize:     cfg.Audit.Kafka.BatchSize,
+				FlushInterval: cfg.Audit.Kafka.FlushInterval,
+				BufferSize:    cfg.Audit.Kafka.BufferSize,
+			},
+		)
+
+		// Deliver queued events on shutdown
+		lc.Append(fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				err := drainAuditSink(ctx, cfg, sink)
+				if dropped := sink.Dropped(); dropped > 0 {
+					logger.Warn("audit events dropped", zap.Uint64("count", dropped))
+				}
+				return err
+			},
+		})
+
+		return sink, nil
+	}
+
+	sink, err := audit.NewFileSink(audit.FileConfig{
+		Path:           cfg.Audit.Path,
+		MaxSize:        cfg.Audit.MaxSize,
+		MaxBackups:     cfg.Audit.MaxBackups,
+		RotateInterval: cfg.Audit.RotateInterval,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Flush buffered events on shutdown
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return drainAuditSink(ctx, cfg, sink)
+		},
+	})
+
+	return sink, nil
+}
+
+// drainAuditSink flushes and closes the sink, within audit.drain_timeout
+func drainAuditSink(ctx context.Context, cfg *config.Config, sink audit.Sink) error {
+	if cfg.Audit.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Audit.DrainTimeout)
+		defer cancel()
+	}
+	return audit.Drain(ctx, sink)
+}
+
+func provideRateLimiter(
+	redisClient redis.UniversalClient,
+	cfg *config.Config,
+	logger *zap.Logger,
+	sink audit.Sink,
+	registry *prometheus.Registry,
+	lc fx.Lifecycle,
+) (*ratelimiter.Service, error) {
+	opts := []ratelimiter.Option{
+		ratelimiter.WithAuditSink(sink),
+		ratelimiter.WithMetrics(ratelimiterpkg.NewMetrics(registry)),
+		ratelimiter.WithCacheMetrics(ratelimiter.NewCacheMetrics(registry)),
+		ratelimiter.WithResetMetrics(ratelimiter.NewResetMetrics(registry)),
+		ratelimiter.WithDecisionMetrics(ratelimiter.NewDecisionMetrics(registry)),
+		ratelimiter.WithCheckMetrics(ratelimiter.NewCheckMetrics(registry)),
+	}
+
+	// User limits get a connection of their own when kept in another database
+	if db := cfg.Redis.ConfigDatabase(); db != cfg.Redis.LimiterDatabase() {
+		configClient, err := connections.NewRedis(RedisConfig(cfg).WithDB(db), logger)
+		if err != nil {
+			return nil, err
+		}
+		lc.Append(fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				return configClient.Close()
+			},
+		})
+		opts = append(opts, ratelimiter.WithConfigClient(configClient))
+	}
+
+	// The regional algorithm merges counters from the other regions' Redis
+	if len(cfg.RateLimit.RegionPeers) > 0 {
+		peers, err := provideRegionPeers(cfg, logger, lc)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, ratelimiter.WithRegionPeers(peers...))
+	}
+
+	// Remaining-request reads may be served by a replica
+	if cfg.Redis.ReplicaHost != "" && cfg.RateLimit.ReplicaReads != "primary" {
+		replicaConfig := RedisConfig(cfg).WithDB(cfg.Redis.LimiterDatabase())
+		replicaConfig.Mode = connections.ModeSingle
+		replicaConfig.Host = cfg.Redis.ReplicaHost
+		replicaConfig.Port = cfg.Redis.ReplicaPort
+
+		replica, err := connections.NewRedis(replicaConfig, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to redis replica: %w", err)
+		}
+		lc.Append(fx.Hook{
+			OnStop: func(ctx context.Context) error {
+				return replica.Close()
+			},
+		})
+		opts = append(opts, ratelimiter.WithReplicaClient(replica))
+	}
+
+	service := ratelimiter.NewService(redisClient, &cfg.RateLimit, logger, opts...)
+
+	if len(cfg.RateLimit.RegionPeers) > 0 {
+		syncCtx, stopSync := context.WithCancel(context.Background())
+		lc.Append(fx.Hook{
+			OnStart: func(ctx context.Context) error {
+				go service.SyncRegions(syncCtx, cfg.RateLimit.RegionSyncInterval)
+				return nil
+			},
+			OnStop: func(ctx context.Context) error {
+				stopSync()
+				return nil
+			},
+		})
+	}
+
+	// Refuse to start if Redis rejects any Lua script, or our permission to
+	// run them, rather than failing every request that evaluates one
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			if err := service.ValidateScripts(ctx); err != nil {
+				if ratelimiterpkg.IsFatal(err) {
+					return fmt.Errorf("redis rejected the rate limiter's credentials or permissions: %w", err)
+				}
+				return fmt.Errorf("lua script validation failed: %w", err)
+			}
+			logger.Info("lua scripts validated")
+			return nil
+		},
+	})
+
+	return service, nil
+}
+
+// provideRegionPeers connects to the peer regions' Redis, given as host:port,
+// using the limiters' database and credentials
+func provideRegionPeers(cfg *config.Config, logger *zap.Logger, lc fx.Lifecycle) ([]redis.UniversalClient, error) {
+	peers := make([]redis.UniversalClient, 0, len(cfg.RateLimit.RegionPeers))
+	for _, addr := range cfg.RateLimit.RegionPeers {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid region peer %q: %w", addr, err)
+		}
+		peerConfig := RedisConfig(cfg).WithDB(cfg.Redis.LimiterDatabase())
+		peerConfig.Mode = connections.ModeSingle
+		peerConfig.Host = host
+		peerConfig.Port = port
+
+		peer, err := connections.NewRedis(peerConfig, logger)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to region peer %s: %w", addr, err)
+		}
+		peers = append(peers, peer)
+	}
+
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			var errs []error
+			for _, peer := range peers {
+				errs = append(errs, peer.Close())
+			}
+			return errors.Join(errs...)
+		},
+	})
+
+	return peers, nil
 }
@@ -15,6 +15,7 @@ type Config struct {
 	API       HTTPConfig      `mapstructure:"api"`
 	Redis     RedisConfig     `mapstructure:"redis"`
 	Logger    LoggerConfig    `mapstructure:"logger"`
+	Audit     AuditConfig     `mapstructure:"audit"`
 	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
 	Debug     bool            `mapstructure:"debug"`
 }
@@ -34,14 +35,54 @@ type HTTPConfig struct {
 	WriteTimeout    time.Duration `mapstructure:"write_timeout"`
 	IdleTimeout     time.Duration `mapstructure:"idle_timeout"`
 	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	// Middleware to enable, in the order they run: "request_id", "logger",
+	// "recover", "cors" and "rate_limit"
+	Middleware []string `mapstructure:"middleware"`
 }
 
 // RedisConfig contains Redis connection settings
 type RedisConfig struct {
+	// How to connect: "single" to Host:Port, "cluster" to a Redis Cluster
+	// through the seed nodes in Addrs, or "sentinel" to the master named
+	// MasterName through the sentinels in Addrs
+	Mode     string `mapstructure:"mode"`
 	Host     string `mapstructure:"host"`
 	Port     string `mapstructure:"port"`
 	Password string `mapstructure:"password"`
 	DB       int    `mapstructure:"db"`
+	// Cluster seed nodes or sentinels, as host:port (cluster and sentinel
+	// modes only)
+	Addrs []string `mapstructure:"addrs"`
+	// Master monitored by the sentinels (sentinel mode only)
+	MasterName string `mapstructure:"master_name"`
+	// Password of the sentinels themselves, if they require one
+	SentinelPassword string `mapstructure:"sentinel_password"`
+	// Database holding the limiters' counters, e.g. so it can be flushed
+	// without touching other data (-1 uses DB)
+	LimiterDB int `mapstructure:"limiter_db"`
+	// Database holding user limits, the default limit and quota schedules
+	// (-1 uses DB)
+	ConfigDB int `mapstructure:"config_db"`
+	// Replica serving remaining-request reads, per rate_limit.replica_reads
+	// (empty ReplicaHost disables)
+	ReplicaHost string `mapstructure:"replica_host"`
+	ReplicaPort string `mapstructure:"replica_port"`
+}
+
+// LimiterDatabase returns the database the limiters' counters are kept in
+func (c RedisConfig) LimiterDatabase() int {
+	if c.LimiterDB >= 0 {
+		return c.LimiterDB
+	}
+	return c.DB
+}
+
+// ConfigDatabase returns the database user limits are kept in
+func (c RedisConfig) ConfigDatabase() int {
+	if c.ConfigDB >= 0 {
+		return c.ConfigDB
+	}
+	return c.DB
 }
 
 // LoggerConfig contains observability settings
@@ -51,20 +92,228 @@ type LoggerConfig struct {
 	Encoding         string   `mapstructure:"encoding"`
 	OutputPaths      []string `mapstructure:"log_path"`
 	ErrorOutputPaths []string `mapstructure:"error_path"`
+	// Log one in every N successful requests; failed and non-2xx requests are
+	// always logged. 1 logs every request, 0 logs only failed requests
+	RequestSampleRate int `mapstructure:"request_sample_rate"`
+}
+
+// AuditConfig contains audit log settings
+type AuditConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// Where events are sent: "file" or "kafka"
+	Sink string `mapstructure:"sink"`
+	// File the audit events are written to as JSON lines
+	Path string `mapstructure:"path"`
+	// Rotate the file once it reaches this many bytes (0 disables)
+	MaxSize int64 `mapstructure:"max_size"`
+	// Number of rotated files to keep
+	MaxBackups int `mapstructure:"max_backups"`
+	// Rotate the file after this long regardless of size (0 disables)
+	RotateInterval time.Duration `mapstructure:"rotate_interval"`
+	// Kafka settings, used when Sink is "kafka"
+	Kafka AuditKafkaConfig `mapstructure:"kafka"`
+	// How long shutdown waits for buffered events to be flushed before
+	// giving up on them (0 waits for as long as shutdown allows)
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+}
+
+// AuditKafkaConfig contains settings for publishing audit events to Kafka
+type AuditKafkaConfig struct {
+	Brokers []string `mapstructure:"brokers"`
+	Topic   string   `mapstructure:"topic"`
+	// Maximum number of events published in one batch
+	BatchSize int `mapstructure:"batch_size"`
+	// Publish a partial batch after this long
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+	// Events queued beyond this are dropped rather than blocking requests
+	BufferSize int `mapstructure:"buffer_size"`
 }
 
 // RateLimitConfig contains rate limiter configuration
 type RateLimitConfig struct {
-	// Default rate limit per user (requests per second)
+	// Default rate limit per user, in requests per LimitUnit
 	DefaultLimit int `mapstructure:"default_limit"`
 	// Window size in seconds for sliding window
 	WindowSize int `mapstructure:"window_size"`
-	// Algorithm to use: "sliding_window" or "leaky_bucket"
+	// How a window changed at runtime takes effect: "gradual" (default) keeps
+	// counting over the old window until requests made under it have aged
+	// out when the new one is shorter, "immediate" switches at once
+	WindowChangePolicy string `mapstructure:"window_change_policy"`
+	// How limits are read: "per_window" (default) allows limit requests per
+	// window, "per_second" allows limit requests per second of the window
+	LimitUnit string `mapstructure:"limit_unit"`
+	// Count requests separately per API version, taken from the /api/<version>
+	// path prefix, so usage of e.g. /api/v1 and /api/v2 is tracked apart
+	ScopeByAPIVersion bool `mapstructure:"scope_by_api_version"`
+	// Give each route a budget of its own, scoped by the route's path, so
+	// e.g. exhausting writes leaves reads untouched
+	// Off by default: turning it on splits every user's existing budget
+	// into one per route, so it is left to deployments to opt in
+	ScopeByRoute bool `mapstructure:"scope_by_route"`
+	// Limits per API version for users without a custom limit, e.g.
+	// {"v2": 50}; versions without an entry use the default limit
+	APIVersionLimits map[string]int `mapstructure:"api_version_limits"`
+	// Algorithm to use: "sliding_window", "leaky_bucket", "fixed_window",
+	// the cheapest, "gcra", which spaces requests evenly after a burst, or
+	// "regional", which shares each limit approximately across regions
 	Algorithm string `mapstructure:"algorithm"`
+	// Requests the "gcra" algorithm admits back to back before enforcing
+	// the steady rate of limit per window (0 admits the whole limit)
+	Burst int `mapstructure:"burst"`
+	// Algorithm per route, keyed by the route's path as registered, e.g.
+	// "/api/v1/upload": "leaky_bucket"; other routes use Algorithm
+	EndpointAlgorithms map[string]string `mapstructure:"endpoint_algorithms"`
+	// How many requests a limit of N allows per window: "strict" allows N,
+	// "inclusive" allows N+1, still accepting a request once N are counted
+	Boundary string `mapstructure:"boundary"`
+	// Algorithm checked alongside Algorithm on every request in shadow mode;
+	// its decisions are logged for comparison but never enforced ("" disables)
+	ShadowAlgorithm string `mapstructure:"shadow_algorithm"`
+	// Percentages of their limit at which a user's usage is reported to the
+	// audit sink, once per crossing, e.g. [80, 100] (empty disables)
+	UsageThresholds []int `mapstructure:"usage_thresholds"`
 	// Enable local caching for rate limit configs
 	EnableLocalCache bool `mapstructure:"enable_local_cache"`
 	// Local cache TTL in seconds
 	LocalCacheTTL int `mapstructure:"local_cache_ttl"`
+	// Most user limits held in the local cache, evicting the least recently
+	// used beyond it (0 for no bound)
+	LocalCacheMaxEntries int `mapstructure:"local_cache_max_entries"`
+	// How long custom user limits persist in Redis, in seconds; unrelated
+	// to LocalCacheTTL (0 keeps them until changed or deleted)
+	ConfigTTL int `mapstructure:"config_ttl"`
+	// What to do with a counted request whose client disconnects before
+	// the handler completes: "count" keeps it, "refund" returns the slot
+	DisconnectPolicy string `mapstructure:"disconnect_policy"`
+	// What to do with requests while Redis rejects the limiter's credentials
+	// or permissions: "fail_closed" rejects them with 503, "fail_open" allows
+	// them like during any other Redis failure
+	FatalErrorPolicy string `mapstructure:"fatal_error_policy"`
+	// Reject requests with 503 whenever the limiter fails to decide, e.g.
+	// while Redis is unreachable, instead of letting them through
+	FailClosed bool `mapstructure:"fail_closed"`
+	// Check every request and report the decision, but never deny one, e.g.
+	// to measure what a new limit would block before enforcing it
+	DryRun bool `mapstructure:"dry_run"`
+	// Users tracked by the in-memory limiter deciding requests while Redis is
+	// unreachable, least recently seen first to go (0 disables the fallback)
+	MemoryFallbackSize int `mapstructure:"memory_fallback_size"`
+	// Place a user in the penalty box once their denied requests within one
+	// window reach this multiple of their limit (0 disables the penalty box)
+	PenaltyMultiplier int `mapstructure:"penalty_multiplier"`
+	// Penalty box duration in seconds, during which every request is denied
+	PenaltyDuration int `mapstructure:"penalty_duration"`
+	// Requests over the limit allowed in a row before denying, so brief
+	// bursts pass flagged instead of failing (0 disables)
+	SoftOverages int `mapstructure:"soft_overages"`
+	// Soft overage window in seconds; consecutive overages further apart
+	// than this start a new run
+	SoftOverageWindow int `mapstructure:"soft_overage_window"`
+	// Resets of one user's counter allowed through the management API per
+	// ResetWindow; further resets are refused and logged (0 disables)
+	ResetLimit int `mapstructure:"reset_limit"`
+	// Reset window in seconds
+	ResetWindow int `mapstructure:"reset_window"`
+	// Distinct resources, e.g. document IDs, a user may access per
+	// DistinctWindow; repeated access to one is free (0 disables)
+	DistinctLimit int `mapstructure:"distinct_limit"`
+	// Distinct resource window in seconds
+	DistinctWindow int `mapstructure:"distinct_window"`
+	// Enforce users' quota schedules, e.g. monthly quotas resetting on their
+	// signup anniversary; costs a Redis lookup per allowed request
+	EnableQuotas bool `mapstructure:"enable_quotas"`
+	// Identical requests from a user, i.e. same endpoint and body, within
+	// this interval of each other count once, e.g. "300ms"; the duplicates
+	// are rejected with 409 (0 disables)
+	DebounceInterval time.Duration `mapstructure:"debounce_interval"`
+	// Largest request body, in bytes, read to tell duplicates apart; requests
+	// with larger bodies are never treated as duplicates
+	DebounceMaxBody int64 `mapstructure:"debounce_max_body"`
+	// Always allow a user's very first request, whatever the state of their
+	// window, unless they are blocked; later requests are limited as usual
+	OnboardingGrace bool `mapstructure:"onboarding_grace"`
+	// Bytes of responses a user may receive per EgressWindow; once reached,
+	// further requests are denied until the window ends (0 disables)
+	EgressLimit int64 `mapstructure:"egress_limit"`
+	// Egress window in seconds
+	EgressWindow int `mapstructure:"egress_window"`
+	// User IDs allowed to pick the algorithm per request via the
+	// X-RateLimit-Algorithm header, e.g. for A/B comparisons
+	TrustedIdentities []string `mapstructure:"trusted_identities"`
+	// IPs or CIDRs of upstreams (e.g. an API gateway) allowed to set the
+	// request's limit via the X-RateLimit-Limit-Override header; clients
+	// presenting a verified TLS certificate are trusted too
+	TrustedUpstreams []string `mapstructure:"trusted_upstreams"`
+	// IPs or CIDRs of clients exempt from rate limiting, e.g. internal
+	// ranges
+	AllowlistCIDRs []string `mapstructure:"allowlist_cidrs"`
+	// IPs or CIDRs of proxies whose X-Forwarded-For header is trusted for the
+	// client's IP; when set, other clients are identified by the address
+	// they connect from whatever headers they send
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// Largest limit an upstream may set via X-RateLimit-Limit-Override
+	// (0 disables overrides)
+	MaxLimitOverride int `mapstructure:"max_limit_override"`
+	// Round the X-RateLimit-Remaining reported to clients down to a multiple
+	// of this, e.g. 10, to avoid leaking exact counts (0 or 1 is exact)
+	RemainingGranularity int `mapstructure:"remaining_granularity"`
+	// Omit X-RateLimit-Remaining once it drops below this (0 always shows it)
+	RemainingFloor int `mapstructure:"remaining_floor"`
+	// Quota headers sent to clients: "legacy" (X-RateLimit-*), "standard"
+	// (the IETF draft's RateLimit-Limit, RateLimit-Remaining and
+	// RateLimit-Reset) or "both"
+	HeaderStyle string `mapstructure:"header_style"`
+	// Who a request is limited as when its X-User-ID header and JWT subject
+	// disagree: "prefer_jwt", "prefer_header" or "require_match" (rejects)
+	IdentityPolicy string `mapstructure:"identity_policy"`
+	// Steps normalizing user IDs before they are keyed, in order: "trim",
+	// "lowercase" and "canonical" (Unicode NFKC), e.g. so "User123 " and
+	// "user123" share a bucket
+	IdentityNormalization []string `mapstructure:"identity_normalization"`
+	// Longest user ID accepted, in bytes, before it is keyed (0 disables)
+	MaxIdentityLength int `mapstructure:"max_identity_length"`
+	// What happens to longer user IDs: "reject" answers 400, "hash" limits
+	// the user as the SHA-256 of their ID
+	OversizedIdentityPolicy string `mapstructure:"oversized_identity_policy"`
+	// Secret verifying HMAC-signed bearer tokens; tokens are ignored if empty
+	JWTSecret string `mapstructure:"jwt_secret"`
+	// How long Redis keys outlive their window, e.g. "500ms"
+	// 0 pads by a tenth of the window, but at least 100ms
+	TTLPadding time.Duration `mapstructure:"ttl_padding"`
+	// How long Redis keys are kept after their last write, e.g. "24h"
+	// 0 derives it from the window and TTLPadding; shorter TTLs are raised
+	// to that
+	KeyTTL time.Duration `mapstructure:"key_ttl"`
+	// Retry a check this many times when Redis is briefly unreachable
+	// (0 disables); only failures before the check ran are retried
+	RetryAttempts int `mapstructure:"retry_attempts"`
+	// Delay before the first retry, doubled for each further one
+	RetryBackoff time.Duration `mapstructure:"retry_backoff"`
+	// Workers deleting or renaming keys at once in bulk operations over
+	// every key, e.g. reset-all and migrate-prefix
+	ScanConcurrency int `mapstructure:"scan_concurrency"`
+	// Most checks a single RateLimitBatch call may make; larger batches are
+	// rejected
+	MaxBatchSize int `mapstructure:"max_batch_size"`
+	// Region this instance runs in, for the "regional" algorithm; unique
+	// across the regions sharing limits and without ':'
+	Region string `mapstructure:"region"`
+	// Redis addresses (host:port) of the other regions, whose counters the
+	// "regional" algorithm merges into ours every RegionSyncInterval
+	RegionPeers []string `mapstructure:"region_peers"`
+	// How often the other regions' counters are merged
+	RegionSyncInterval time.Duration `mapstructure:"region_sync_interval"`
+	// Where remaining-request reads go when a replica is configured:
+	// "primary" ignores the replica, "replica" trusts it, and "conservative"
+	// reads it but subtracts what could have been admitted within
+	// ReplicaStaleness, so replication lag never overstates capacity
+	ReplicaReads string `mapstructure:"replica_reads"`
+	// Replication lag tolerated, e.g. "200ms"
+	ReplicaStaleness time.Duration `mapstructure:"replica_staleness"`
+	// Prefix of every key, e.g. "billing" for billing:rate_limit:sliding:<user>,
+	// so applications sharing a Redis keep their limits apart; empty keeps
+	// the bare rate_limit: keys
+	KeyNamespace string `mapstructure:"key_namespace"`
 }
 
 // LoadConfig loads configuration from file and environment variables
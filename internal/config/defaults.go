@@ -15,12 +15,21 @@ func setDefaults() {
 	viper.SetDefault("api.write_timeout", "15s")
 	viper.SetDefault("api.idle_timeout", "60s")
 	viper.SetDefault("api.shutdown_timeout", "10s")
+	viper.SetDefault("api.middleware", MiddlewareNames)
 
 	// Redis defaults
+	viper.SetDefault("redis.mode", "single")
 	viper.SetDefault("redis.host", "localhost")
 	viper.SetDefault("redis.port", "6379")
 	viper.SetDefault("redis.password", "")
 	viper.SetDefault("redis.db", 0)
+	viper.SetDefault("redis.limiter_db", -1)   // same as redis.db
+	viper.SetDefault("redis.config_db", -1)    // same as redis.db
+	viper.SetDefault("redis.replica_host", "") // disabled
+	viper.SetDefault("redis.replica_port", "6379")
+	viper.SetDefault("redis.addrs", []string{})
+	viper.SetDefault("redis.master_name", "")
+	viper.SetDefault("redis.sentinel_password", "")
 
 	// Logger defaults
 	viper.SetDefault("logger.development", true)
@@ -28,13 +37,84 @@ func setDefaults() {
 	viper.SetDefault("logger.encoding", "json")
 	viper.SetDefault("logger.log_path", []string{"stdout"})
 	viper.SetDefault("logger.error_path", []string{"stderr"})
+	viper.SetDefault("logger.request_sample_rate", 1) // log every request
+
+	// Audit defaults
+	viper.SetDefault("audit.enabled", false)
+	viper.SetDefault("audit.sink", "file")
+	viper.SetDefault("audit.path", "audit.log")
+	viper.SetDefault("audit.max_size", 100*1024*1024) // 100 MB
+	viper.SetDefault("audit.max_backups", 5)
+	viper.SetDefault("audit.rotate_interval", "24h")
+	viper.SetDefault("audit.kafka.brokers", []string{"localhost:9092"})
+	viper.SetDefault("audit.kafka.topic", "rate-limit-audit")
+	viper.SetDefault("audit.kafka.batch_size", 100)
+	viper.SetDefault("audit.kafka.flush_interval", "1s")
+	viper.SetDefault("audit.kafka.buffer_size", 10000)
+	viper.SetDefault("audit.drain_timeout", "5s")
 
 	// Rate limiter defaults
 	viper.SetDefault("rate_limit.default_limit", 100) // 100 requests per second
 	viper.SetDefault("rate_limit.window_size", 1)     // 1 second window
+	viper.SetDefault("rate_limit.window_change_policy", "gradual")
+	viper.SetDefault("rate_limit.limit_unit", "per_window")
+	viper.SetDefault("rate_limit.scope_by_api_version", false)
+	viper.SetDefault("rate_limit.scope_by_route", false) // opt in, see the guide
+	viper.SetDefault("rate_limit.api_version_limits", map[string]int{})
 	viper.SetDefault("rate_limit.algorithm", "sliding_window")
+	viper.SetDefault("rate_limit.endpoint_algorithms", map[string]string{})
+	viper.SetDefault("rate_limit.burst", 0) // the limit
+	viper.SetDefault("rate_limit.boundary", "strict")
+	viper.SetDefault("rate_limit.shadow_algorithm", "")      // disabled
+	viper.SetDefault("rate_limit.usage_thresholds", []int{}) // disabled
 	viper.SetDefault("rate_limit.enable_local_cache", true)
 	viper.SetDefault("rate_limit.local_cache_ttl", 60) // 60 seconds
+	viper.SetDefault("rate_limit.local_cache_max_entries", 100000)
+	viper.SetDefault("rate_limit.config_ttl", 0) // no expiry
+	viper.SetDefault("rate_limit.disconnect_policy", "count")
+	viper.SetDefault("rate_limit.fatal_error_policy", "fail_closed")
+	viper.SetDefault("rate_limit.fail_closed", false) // fail open
+	viper.SetDefault("rate_limit.dry_run", false)     // enforce limits
+	viper.SetDefault("rate_limit.memory_fallback_size", 10000)
+	viper.SetDefault("rate_limit.penalty_multiplier", 0) // disabled
+	viper.SetDefault("rate_limit.penalty_duration", 300) // 5 minutes
+	viper.SetDefault("rate_limit.soft_overages", 0)      // disabled
+	viper.SetDefault("rate_limit.soft_overage_window", 10)
+	viper.SetDefault("rate_limit.reset_limit", 0) // disabled
+	viper.SetDefault("rate_limit.reset_window", 3600)
+	viper.SetDefault("rate_limit.distinct_limit", 0) // disabled
+	viper.SetDefault("rate_limit.distinct_window", 3600)
+	viper.SetDefault("rate_limit.enable_quotas", false)
+	viper.SetDefault("rate_limit.debounce_interval", 0)       // disabled
+	viper.SetDefault("rate_limit.debounce_max_body", 64*1024) // 64KB
+	viper.SetDefault("rate_limit.onboarding_grace", false)
+	viper.SetDefault("rate_limit.egress_limit", 0) // disabled
+	viper.SetDefault("rate_limit.egress_window", 60)
+	viper.SetDefault("rate_limit.trusted_identities", []string{})
+	viper.SetDefault("rate_limit.trusted_upstreams", []string{})
+	viper.SetDefault("rate_limit.allowlist_cidrs", []string{})
+	viper.SetDefault("rate_limit.trusted_proxies", []string{})
+	viper.SetDefault("rate_limit.max_limit_override", 10000)
+	viper.SetDefault("rate_limit.remaining_granularity", 0) // exact
+	viper.SetDefault("rate_limit.remaining_floor", 0)       // always shown
+	viper.SetDefault("rate_limit.header_style", "both")
+	viper.SetDefault("rate_limit.identity_policy", "prefer_jwt")
+	viper.SetDefault("rate_limit.identity_normalization", []string{"trim"})
+	viper.SetDefault("rate_limit.max_identity_length", 256)
+	viper.SetDefault("rate_limit.oversized_identity_policy", "reject")
+	viper.SetDefault("rate_limit.jwt_secret", "")
+	viper.SetDefault("rate_limit.ttl_padding", 0)    // a tenth of the window
+	viper.SetDefault("rate_limit.key_ttl", 0)        // window plus padding
+	viper.SetDefault("rate_limit.retry_attempts", 0) // disabled
+	viper.SetDefault("rate_limit.retry_backoff", "10ms")
+	viper.SetDefault("rate_limit.scan_concurrency", 4)
+	viper.SetDefault("rate_limit.max_batch_size", 10000)
+	viper.SetDefault("rate_limit.region", "local")
+	viper.SetDefault("rate_limit.region_peers", []string{})
+	viper.SetDefault("rate_limit.region_sync_interval", "1s")
+	viper.SetDefault("rate_limit.replica_reads", "primary")
+	viper.SetDefault("rate_limit.replica_staleness", "100ms")
+	viper.SetDefault("rate_limit.key_namespace", "") // no namespace
 
 	// Debug mode
 	viper.SetDefault("debug", false)
@@ -1,34 +1,283 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"strings"
 )
 
+// MiddlewareNames lists the known middleware in their default order
+var MiddlewareNames = []string{"request_id", "logger", "recover", "cors", "maintenance", "debounce", "rate_limit", "egress"}
+
 // validateConfig validates the configuration
+// Every failed check is reported, so all misconfigurations surface in one run
 func validateConfig(cfg *Config) error {
+	var errs []error
+
 	// Validate HTTP config
 	if cfg.API.Port == "" {
-		return fmt.Errorf("api.port is required")
+		errs = append(errs, errors.New("api.port is required"))
+	}
+	seen := make(map[string]bool, len(cfg.API.Middleware))
+	for _, name := range cfg.API.Middleware {
+		if !isKnownMiddleware(name) {
+			errs = append(errs, fmt.Errorf("api.middleware: unknown middleware %q", name))
+		} else if seen[name] {
+			errs = append(errs, fmt.Errorf("api.middleware: %q is listed more than once", name))
+		}
+		seen[name] = true
 	}
 
 	// Validate Redis config
-	if cfg.Redis.Host == "" {
-		return fmt.Errorf("redis.host is required")
+	switch cfg.Redis.Mode {
+	case "single":
+		if cfg.Redis.Host == "" {
+			errs = append(errs, errors.New("redis.host is required"))
+		}
+		if cfg.Redis.Port == "" {
+			errs = append(errs, errors.New("redis.port is required"))
+		}
+	case "cluster", "sentinel":
+		if len(cfg.Redis.Addrs) == 0 {
+			errs = append(errs, fmt.Errorf("redis.addrs is required in %s mode", cfg.Redis.Mode))
+		}
+		for _, addr := range cfg.Redis.Addrs {
+			if _, _, err := net.SplitHostPort(addr); err != nil {
+				errs = append(errs, fmt.Errorf("redis.addrs: %q is not a host:port address", addr))
+			}
+		}
+	default:
+		errs = append(errs, errors.New("redis.mode must be one of 'single', 'cluster' or 'sentinel'"))
+	}
+	if cfg.Redis.Mode == "sentinel" && cfg.Redis.MasterName == "" {
+		errs = append(errs, errors.New("redis.master_name is required in sentinel mode"))
+	}
+	// A cluster only has database 0
+	if cfg.Redis.Mode == "cluster" && (cfg.Redis.DB != 0 || cfg.Redis.LimiterDatabase() != 0 || cfg.Redis.ConfigDatabase() != 0) {
+		errs = append(errs, errors.New("redis.db, redis.limiter_db and redis.config_db must select database 0 in cluster mode"))
 	}
-	if cfg.Redis.Port == "" {
-		return fmt.Errorf("redis.port is required")
+	if cfg.Redis.DB < 0 || cfg.Redis.LimiterDB < -1 || cfg.Redis.ConfigDB < -1 {
+		errs = append(errs, errors.New("redis.db must not be negative, and redis.limiter_db and redis.config_db must be -1 or a database index"))
+	}
+
+	// Validate Logger config
+	if cfg.Logger.RequestSampleRate < 0 {
+		errs = append(errs, errors.New("logger.request_sample_rate must not be negative"))
+	}
+
+	// Validate Audit config
+	if cfg.Audit.Sink != "file" && cfg.Audit.Sink != "kafka" {
+		errs = append(errs, errors.New("audit.sink must be either 'file' or 'kafka'"))
+	}
+	if cfg.Audit.Enabled && cfg.Audit.Sink == "file" && cfg.Audit.Path == "" {
+		errs = append(errs, errors.New("audit.path is required when audit is enabled"))
+	}
+	if cfg.Audit.Enabled && cfg.Audit.Sink == "kafka" && (len(cfg.Audit.Kafka.Brokers) == 0 || cfg.Audit.Kafka.Topic == "") {
+		errs = append(errs, errors.New("audit.kafka.brokers and audit.kafka.topic are required for the kafka sink"))
+	}
+	if cfg.Audit.MaxSize < 0 || cfg.Audit.MaxBackups < 0 || cfg.Audit.RotateInterval < 0 {
+		errs = append(errs, errors.New("audit.max_size, audit.max_backups and audit.rotate_interval must not be negative"))
+	}
+	if cfg.Audit.DrainTimeout < 0 {
+		errs = append(errs, errors.New("audit.drain_timeout must not be negative"))
 	}
 
 	// Validate Rate Limit config
 	if cfg.RateLimit.DefaultLimit <= 0 {
-		return fmt.Errorf("rate_limit.default_limit must be greater than 0")
+		errs = append(errs, errors.New("rate_limit.default_limit must be greater than 0"))
 	}
 	if cfg.RateLimit.WindowSize <= 0 {
-		return fmt.Errorf("rate_limit.window_size must be greater than 0")
+		errs = append(errs, errors.New("rate_limit.window_size must be greater than 0"))
+	}
+	for version, limit := range cfg.RateLimit.APIVersionLimits {
+		if limit <= 0 {
+			errs = append(errs, fmt.Errorf("rate_limit.api_version_limits: limit for %q must be greater than 0", version))
+		}
+	}
+	if cfg.RateLimit.WindowChangePolicy != "gradual" && cfg.RateLimit.WindowChangePolicy != "immediate" {
+		errs = append(errs, errors.New("rate_limit.window_change_policy must be either 'gradual' or 'immediate'"))
+	}
+	if cfg.RateLimit.LimitUnit != "per_window" && cfg.RateLimit.LimitUnit != "per_second" {
+		errs = append(errs, errors.New("rate_limit.limit_unit must be either 'per_window' or 'per_second'"))
+	}
+	if !isAlgorithm(cfg.RateLimit.Algorithm) {
+		errs = append(errs, errors.New("rate_limit.algorithm must be 'sliding_window', 'leaky_bucket', 'fixed_window', 'gcra' or 'regional'"))
+	}
+	for path, algorithm := range cfg.RateLimit.EndpointAlgorithms {
+		if !isAlgorithm(algorithm) {
+			errs = append(errs, fmt.Errorf("rate_limit.endpoint_algorithms: algorithm for %q must be 'sliding_window', 'leaky_bucket', 'fixed_window', 'gcra' or 'regional'", path))
+		}
+	}
+	if cfg.RateLimit.Boundary != "strict" && cfg.RateLimit.Boundary != "inclusive" {
+		errs = append(errs, errors.New("rate_limit.boundary must be either 'strict' or 'inclusive'"))
+	}
+	if cfg.RateLimit.ShadowAlgorithm != "" && !isAlgorithm(cfg.RateLimit.ShadowAlgorithm) {
+		errs = append(errs, errors.New("rate_limit.shadow_algorithm must be empty, 'sliding_window', 'leaky_bucket', 'fixed_window', 'gcra' or 'regional'"))
+	}
+	if cfg.RateLimit.ShadowAlgorithm != "" && cfg.RateLimit.ShadowAlgorithm == cfg.RateLimit.Algorithm {
+		errs = append(errs, errors.New("rate_limit.shadow_algorithm must differ from rate_limit.algorithm"))
+	}
+	if cfg.RateLimit.DisconnectPolicy != "count" && cfg.RateLimit.DisconnectPolicy != "refund" {
+		errs = append(errs, errors.New("rate_limit.disconnect_policy must be either 'count' or 'refund'"))
+	}
+	if cfg.RateLimit.FatalErrorPolicy != "fail_closed" && cfg.RateLimit.FatalErrorPolicy != "fail_open" {
+		errs = append(errs, errors.New("rate_limit.fatal_error_policy must be either 'fail_closed' or 'fail_open'"))
+	}
+	switch cfg.RateLimit.IdentityPolicy {
+	case "prefer_jwt", "prefer_header", "require_match":
+	default:
+		errs = append(errs, errors.New("rate_limit.identity_policy must be one of 'prefer_jwt', 'prefer_header' or 'require_match'"))
+	}
+	for _, step := range cfg.RateLimit.IdentityNormalization {
+		switch step {
+		case "trim", "lowercase", "canonical":
+		default:
+			errs = append(errs, fmt.Errorf("rate_limit.identity_normalization: unknown step %q, must be one of 'trim', 'lowercase' or 'canonical'", step))
+		}
+	}
+	for _, threshold := range cfg.RateLimit.UsageThresholds {
+		if threshold <= 0 || threshold > 100 {
+			errs = append(errs, fmt.Errorf("rate_limit.usage_thresholds: %d must be between 1 and 100", threshold))
+		}
+	}
+	if cfg.RateLimit.MaxIdentityLength < 0 {
+		errs = append(errs, errors.New("rate_limit.max_identity_length must not be negative"))
+	}
+	if cfg.RateLimit.OversizedIdentityPolicy != "reject" && cfg.RateLimit.OversizedIdentityPolicy != "hash" {
+		errs = append(errs, errors.New("rate_limit.oversized_identity_policy must be either 'reject' or 'hash'"))
+	}
+	if cfg.RateLimit.PenaltyMultiplier < 0 {
+		errs = append(errs, errors.New("rate_limit.penalty_multiplier must not be negative"))
+	}
+	if cfg.RateLimit.PenaltyMultiplier > 0 && cfg.RateLimit.PenaltyDuration <= 0 {
+		errs = append(errs, errors.New("rate_limit.penalty_duration must be greater than 0 when the penalty box is enabled"))
+	}
+	if cfg.RateLimit.SoftOverages < 0 {
+		errs = append(errs, errors.New("rate_limit.soft_overages must not be negative"))
+	}
+	if cfg.RateLimit.SoftOverages > 0 && cfg.RateLimit.SoftOverageWindow <= 0 {
+		errs = append(errs, errors.New("rate_limit.soft_overage_window must be greater than 0 when soft overages are enabled"))
+	}
+	if cfg.RateLimit.ConfigTTL < 0 {
+		errs = append(errs, errors.New("rate_limit.config_ttl must not be negative"))
 	}
-	if cfg.RateLimit.Algorithm != "sliding_window" && cfg.RateLimit.Algorithm != "leaky_bucket" {
-		return fmt.Errorf("rate_limit.algorithm must be either 'sliding_window' or 'leaky_bucket'")
+	if cfg.RateLimit.LocalCacheMaxEntries < 0 {
+		errs = append(errs, errors.New("rate_limit.local_cache_max_entries must not be negative"))
 	}
+	if cfg.RateLimit.ResetLimit < 0 {
+		errs = append(errs, errors.New("rate_limit.reset_limit must not be negative"))
+	}
+	if cfg.RateLimit.ResetLimit > 0 && cfg.RateLimit.ResetWindow <= 0 {
+		errs = append(errs, errors.New("rate_limit.reset_window must be greater than 0 when the reset limit is enabled"))
+	}
+	if cfg.RateLimit.DistinctLimit < 0 {
+		errs = append(errs, errors.New("rate_limit.distinct_limit must not be negative"))
+	}
+	if cfg.RateLimit.DistinctLimit > 0 && cfg.RateLimit.DistinctWindow <= 0 {
+		errs = append(errs, errors.New("rate_limit.distinct_window must be greater than 0 when distinct resource limiting is enabled"))
+	}
+	if cfg.RateLimit.DebounceInterval < 0 {
+		errs = append(errs, errors.New("rate_limit.debounce_interval must not be negative"))
+	}
+	if cfg.RateLimit.DebounceInterval > 0 && cfg.RateLimit.DebounceMaxBody <= 0 {
+		errs = append(errs, errors.New("rate_limit.debounce_max_body must be greater than 0 when debouncing is enabled"))
+	}
+	if cfg.RateLimit.EgressLimit < 0 {
+		errs = append(errs, errors.New("rate_limit.egress_limit must not be negative"))
+	}
+	if cfg.RateLimit.EgressLimit > 0 && cfg.RateLimit.EgressWindow <= 0 {
+		errs = append(errs, errors.New("rate_limit.egress_window must be greater than 0 when egress limiting is enabled"))
+	}
+	if cfg.RateLimit.TTLPadding < 0 {
+		errs = append(errs, errors.New("rate_limit.ttl_padding must not be negative"))
+	}
+	if cfg.RateLimit.Burst < 0 {
+		errs = append(errs, errors.New("rate_limit.burst must not be negative"))
+	}
+	if cfg.RateLimit.KeyTTL < 0 {
+		errs = append(errs, errors.New("rate_limit.key_ttl must not be negative"))
+	}
+	if cfg.RateLimit.RetryAttempts < 0 || cfg.RateLimit.RetryBackoff < 0 {
+		errs = append(errs, errors.New("rate_limit.retry_attempts and rate_limit.retry_backoff must not be negative"))
+	}
+	if cfg.RateLimit.ScanConcurrency <= 0 {
+		errs = append(errs, errors.New("rate_limit.scan_concurrency must be greater than 0"))
+	}
+	if cfg.RateLimit.MaxBatchSize <= 0 {
+		errs = append(errs, errors.New("rate_limit.max_batch_size must be greater than 0"))
+	}
+	if cfg.RateLimit.Region == "" || strings.Contains(cfg.RateLimit.Region, ":") {
+		errs = append(errs, errors.New("rate_limit.region must be set and must not contain ':'"))
+	}
+	// Braces would move keys into the wrong Redis Cluster hash slot
+	if strings.ContainsAny(cfg.RateLimit.KeyNamespace, "{}") {
+		errs = append(errs, errors.New("rate_limit.key_namespace must not contain '{' or '}'"))
+	}
+	if len(cfg.RateLimit.RegionPeers) > 0 && cfg.RateLimit.RegionSyncInterval <= 0 {
+		errs = append(errs, errors.New("rate_limit.region_sync_interval must be greater than 0 when region peers are set"))
+	}
+	if cfg.RateLimit.MemoryFallbackSize < 0 {
+		errs = append(errs, errors.New("rate_limit.memory_fallback_size must not be negative"))
+	}
+	switch cfg.RateLimit.ReplicaReads {
+	case "primary", "replica", "conservative":
+	default:
+		errs = append(errs, errors.New("rate_limit.replica_reads must be one of 'primary', 'replica' or 'conservative'"))
+	}
+	if cfg.RateLimit.ReplicaStaleness < 0 {
+		errs = append(errs, errors.New("rate_limit.replica_staleness must not be negative"))
+	}
+	for _, peer := range cfg.RateLimit.RegionPeers {
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			errs = append(errs, fmt.Errorf("rate_limit.region_peers: %q is not a host:port address", peer))
+		}
+	}
+	for _, upstream := range cfg.RateLimit.TrustedUpstreams {
+		if _, _, err := net.ParseCIDR(upstream); err != nil && net.ParseIP(upstream) == nil {
+			errs = append(errs, fmt.Errorf("rate_limit.trusted_upstreams: %q is not an IP or CIDR", upstream))
+		}
+	}
+	for _, allowed := range cfg.RateLimit.AllowlistCIDRs {
+		if _, _, err := net.ParseCIDR(allowed); err != nil && net.ParseIP(allowed) == nil {
+			errs = append(errs, fmt.Errorf("rate_limit.allowlist_cidrs: %q is not an IP or CIDR", allowed))
+		}
+	}
+	for _, proxy := range cfg.RateLimit.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("rate_limit.trusted_proxies: %q is not an IP or CIDR", proxy))
+		}
+	}
+	if cfg.RateLimit.RemainingGranularity < 0 || cfg.RateLimit.RemainingFloor < 0 {
+		errs = append(errs, errors.New("rate_limit.remaining_granularity and rate_limit.remaining_floor must not be negative"))
+	}
+	switch cfg.RateLimit.HeaderStyle {
+	case "legacy", "standard", "both":
+	default:
+		errs = append(errs, errors.New("rate_limit.header_style must be one of 'legacy', 'standard' or 'both'"))
+	}
+	if cfg.RateLimit.MaxLimitOverride < 0 {
+		errs = append(errs, errors.New("rate_limit.max_limit_override must not be negative"))
+	}
+
+	return errors.Join(errs...)
+}
 
-	return nil
+// isKnownMiddleware reports whether name is one of MiddlewareNames
+func isKnownMiddleware(name string) bool {
+	for _, known := range MiddlewareNames {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// isAlgorithm reports whether algorithm names a rate limiting algorithm
+func isAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case "sliding_window", "leaky_bucket", "fixed_window", "gcra", "regional":
+		return true
+	}
+	return false
 }
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"ratelimit-challenge/internal/service/ratelimiter"
+	ratelimiterpkg "ratelimit-challenge/pkg/ratelimiter"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.uber.org/zap"
@@ -24,9 +28,29 @@ func RegisterRoutes(
 	api.GET("/test", h.Test)
 
 	// Rate limit management endpoints
+	api.POST("/rate-limit/batch", h.RateLimitBatch)
 	api.POST("/rate-limit/:user_id", h.SetUserLimit)
+	api.GET("/rate-limit/:user_id", h.GetUserLimit)
+	api.GET("/rate-limit/:user_id/overview", h.GetOverview)
 	api.GET("/rate-limit/:user_id/remaining", h.GetRemaining)
+	api.GET("/rate-limit/:user_id/stats", h.GetStats)
+	api.GET("/rate-limit/:user_id/window", h.GetWindow)
+	api.POST("/rate-limit/:user_id/warm", h.WarmUserLimit)
+	api.PUT("/rate-limit/:user_id/quota", h.SetQuotaSchedule)
+	api.GET("/rate-limit/:user_id/quota", h.GetQuota)
 	api.DELETE("/rate-limit/:user_id", h.ResetRateLimit)
+	api.DELETE("/rate-limit/:user_id/config", h.DeleteUserLimit)
+
+	// Admin endpoints
+	api.PUT("/admin/default-limit", h.SetDefaultLimit)
+	api.POST("/admin/limits/import", h.ImportUserLimits)
+	api.POST("/admin/campaign", h.StartCampaign)
+	api.GET("/admin/campaign", h.GetCampaign)
+	api.DELETE("/admin/campaign", h.EndCampaign)
+	api.PUT("/admin/maintenance", h.StartMaintenance)
+	api.GET("/admin/maintenance", h.GetMaintenance)
+	api.DELETE("/admin/maintenance", h.EndMaintenance)
+	api.GET("/admin/diagnostics", h.GetDiagnostics)
 }
 
 // Handler contains handler functions
@@ -50,6 +74,8 @@ func (h *Handler) Test(c echo.Context) error {
 }
 
 // SetUserLimit sets a custom rate limit for a user
+// The body is either {"limit": N} or burst and sustained tiers, e.g.
+// {"burst": {"limit": 10, "window": 1}, "sustained": {"limit": 100, "window": 60}}
 func (h *Handler) SetUserLimit(c echo.Context) error {
 	userID := c.Param("user_id")
 	if userID == "" {
@@ -59,7 +85,10 @@ func (h *Handler) SetUserLimit(c echo.Context) error {
 	}
 
 	var req struct {
-		Limit int `json:"limit"`
+		Limit     int               `json:"limit"`
+		Burst     *ratelimiter.Tier `json:"burst"`
+		Sustained *ratelimiter.Tier `json:"sustained"`
+		Blocked   bool              `json:"blocked"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -68,13 +97,21 @@ func (h *Handler) SetUserLimit(c echo.Context) error {
 		})
 	}
 
+	if req.Blocked {
+		return h.blockUser(c, userID)
+	}
+
+	if req.Burst != nil || req.Sustained != nil {
+		return h.setUserTiers(c, userID, req.Burst, req.Sustained)
+	}
+
 	if req.Limit <= 0 {
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error": "limit must be greater than 0",
 		})
 	}
 
-	if err := h.rateLimiter.SetUserLimit(c.Request().Context(), userID, req.Limit); err != nil {
+	if err := h.rateLimiter.SetUserLimit(scopeContext(c), userID, req.Limit); err != nil {
 		h.logger.Error("failed to set user limit",
 			zap.String("user_id", userID),
 			zap.Error(err),
@@ -91,6 +128,437 @@ func (h *Handler) SetUserLimit(c echo.Context) error {
 	})
 }
 
+// GetUserLimit returns the limit a user is configured with, and whether it
+// is their own; users without one get the default limit
+func (h *Handler) GetUserLimit(c echo.Context) error {
+	userID := c.Param("user_id")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "user_id is required",
+		})
+	}
+
+	limit, configured, err := h.rateLimiter.GetUserLimit(scopeContext(c), userID)
+	if err != nil {
+		h.logger.Error("failed to get user limit",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to get user limit",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_id":    userID,
+		"limit":      limit,
+		"configured": configured,
+	})
+}
+
+// DeleteUserLimit removes a user's custom limit, reverting them to the
+// default; unlike ResetRateLimit, their counted requests are kept
+func (h *Handler) DeleteUserLimit(c echo.Context) error {
+	userID := c.Param("user_id")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "user_id is required",
+		})
+	}
+
+	if err := h.rateLimiter.DeleteUserLimit(scopeContext(c), userID); err != nil {
+		h.logger.Error("failed to delete user limit",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to delete user limit",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "user rate limit deleted",
+		"user_id": userID,
+	})
+}
+
+// WarmUserLimit reloads a user's custom limit into this instance's cache,
+// e.g. right after it was changed through another instance
+func (h *Handler) WarmUserLimit(c echo.Context) error {
+	userID := c.Param("user_id")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "user_id is required",
+		})
+	}
+
+	limit, tiers, err := h.rateLimiter.WarmUserLimit(c.Request().Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to warm user limit",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to warm user limit",
+		})
+	}
+
+	blocked, err := h.rateLimiter.IsBlocked(c.Request().Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to warm user limit",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to warm user limit",
+		})
+	}
+
+	response := map[string]interface{}{
+		"message": "user rate limit warmed",
+		"user_id": userID,
+		"custom":  limit > 0 || tiers != nil || blocked,
+	}
+	if blocked {
+		response["blocked"] = true
+	} else if tiers != nil {
+		response["burst"] = tiers.Burst
+		response["sustained"] = tiers.Sustained
+	} else if limit > 0 {
+		response["limit"] = limit
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// SetQuotaSchedule sets a user's quota per billing period and when it resets,
+// e.g. {"limit": 10000, "period": "monthly", "anchor": "2024-01-31T09:00:00Z"}
+func (h *Handler) SetQuotaSchedule(c echo.Context) error {
+	userID := c.Param("user_id")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "user_id is required",
+		})
+	}
+
+	var schedule ratelimiter.QuotaSchedule
+	if err := c.Bind(&schedule); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request body",
+		})
+	}
+	if err := schedule.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	if err := h.rateLimiter.SetQuotaSchedule(c.Request().Context(), userID, schedule); err != nil {
+		h.logger.Error("failed to set quota schedule",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to set quota schedule",
+		})
+	}
+
+	_, resetsAt := schedule.Bounds(time.Now())
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":   "user quota schedule updated",
+		"user_id":   userID,
+		"limit":     schedule.Limit,
+		"period":    schedule.Period,
+		"resets_at": resetsAt,
+	})
+}
+
+// GetQuota returns a user's quota usage in the current billing period
+func (h *Handler) GetQuota(c echo.Context) error {
+	userID := c.Param("user_id")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "user_id is required",
+		})
+	}
+
+	usage, err := h.rateLimiter.GetQuota(c.Request().Context(), userID)
+	if err != nil {
+		h.logger.Error("failed to get quota",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to get quota",
+		})
+	}
+	if usage == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "user has no quota schedule",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_id":   userID,
+		"limit":     usage.Limit,
+		"used":      usage.Used,
+		"remaining": usage.Limit - usage.Used,
+		"resets_at": usage.ResetsAt,
+	})
+}
+
+// setUserTiers stores burst and sustained limits for a user
+func (h *Handler) setUserTiers(c echo.Context, userID string, burst, sustained *ratelimiter.Tier) error {
+	if burst == nil || sustained == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "burst and sustained must be set together",
+		})
+	}
+
+	tiers := ratelimiter.UserTiers{Burst: *burst, Sustained: *sustained}
+	if err := tiers.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+
+	if err := h.rateLimiter.SetUserTiers(c.Request().Context(), userID, tiers); err != nil {
+		h.logger.Error("failed to set user tiers",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to set user limit",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":   "user rate limit updated",
+		"user_id":   userID,
+		"burst":     tiers.Burst,
+		"sustained": tiers.Sustained,
+	})
+}
+
+// blockUser denies every request from the user until they're given a limit
+func (h *Handler) blockUser(c echo.Context, userID string) error {
+	if err := h.rateLimiter.BlockUser(c.Request().Context(), userID); err != nil {
+		h.logger.Error("failed to block user",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to block user",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "user blocked",
+		"user_id": userID,
+		"blocked": true,
+	})
+}
+
+// SetDefaultLimit sets the default limit used for users without a custom limit
+func (h *Handler) SetDefaultLimit(c echo.Context) error {
+	var req struct {
+		Limit int `json:"limit"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request body",
+		})
+	}
+
+	if req.Limit <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "limit must be greater than 0",
+		})
+	}
+
+	if err := h.rateLimiter.SetDefaultLimit(c.Request().Context(), req.Limit); err != nil {
+		h.logger.Error("failed to set default limit", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to set default limit",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "default rate limit updated",
+		"limit":   req.Limit,
+	})
+}
+
+// ImportUserLimits sets custom limits from an NDJSON request body, one
+// {"user_id": ..., "limit": ...} object per line
+// The body is processed as it streams in, so imports of any size are fine
+func (h *Handler) ImportUserLimits(c echo.Context) error {
+	imported, err := h.rateLimiter.ImportUserLimits(c.Request().Context(), c.Request().Body, nil)
+	if err != nil {
+		h.logger.Error("user limit import failed",
+			zap.Int("imported", imported),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusBadRequest, map[string]interface{}{
+			"error":    err.Error(),
+			"imported": imported,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  "user limits imported",
+		"imported": imported,
+	})
+}
+
+// StartCampaign starts a shared request pool, replacing any active campaign
+func (h *Handler) StartCampaign(c echo.Context) error {
+	var req struct {
+		Name   string    `json:"name"`
+		Total  int       `json:"total"`
+		EndsAt time.Time `json:"ends_at"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request body",
+		})
+	}
+
+	if req.Total <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "total must be greater than 0",
+		})
+	}
+
+	if !req.EndsAt.After(time.Now()) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "ends_at must be in the future",
+		})
+	}
+
+	campaign := ratelimiter.Campaign{Name: req.Name, Total: req.Total, EndsAt: req.EndsAt}
+	if err := h.rateLimiter.StartCampaign(c.Request().Context(), campaign); err != nil {
+		h.logger.Error("failed to start campaign",
+			zap.String("name", req.Name),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to start campaign",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  "campaign started",
+		"campaign": campaign,
+	})
+}
+
+// GetCampaign returns the active campaign and how much of its pool is used
+func (h *Handler) GetCampaign(c echo.Context) error {
+	campaign, err := h.rateLimiter.GetCampaign(c.Request().Context())
+	if err != nil {
+		h.logger.Error("failed to get campaign", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to get campaign",
+		})
+	}
+
+	if campaign == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error": "no active campaign",
+		})
+	}
+
+	return c.JSON(http.StatusOK, campaign)
+}
+
+// EndCampaign ends the active campaign
+func (h *Handler) EndCampaign(c echo.Context) error {
+	if err := h.rateLimiter.EndCampaign(c.Request().Context()); err != nil {
+		h.logger.Error("failed to end campaign", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to end campaign",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "campaign ended",
+	})
+}
+
+// StartMaintenance puts the whole API into maintenance mode, answering every
+// request with 503 until it is ended
+func (h *Handler) StartMaintenance(c echo.Context) error {
+	var req struct {
+		RetryAfter int `json:"retry_after"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request body",
+		})
+	}
+
+	if req.RetryAfter <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "retry_after must be greater than 0",
+		})
+	}
+
+	if err := h.rateLimiter.StartMaintenance(c.Request().Context(), req.RetryAfter); err != nil {
+		h.logger.Error("failed to start maintenance", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to start maintenance",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":     "maintenance started",
+		"retry_after": req.RetryAfter,
+	})
+}
+
+// GetMaintenance reports whether the API is in maintenance mode
+func (h *Handler) GetMaintenance(c echo.Context) error {
+	status, err := h.rateLimiter.GetMaintenance(c.Request().Context())
+	if err != nil {
+		h.logger.Error("failed to get maintenance status", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to get maintenance status",
+		})
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
+
+// EndMaintenance takes the API out of maintenance mode
+func (h *Handler) EndMaintenance(c echo.Context) error {
+	if err := h.rateLimiter.EndMaintenance(c.Request().Context()); err != nil {
+		h.logger.Error("failed to end maintenance", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to end maintenance",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "maintenance ended",
+	})
+}
+
+// GetDiagnostics reports the version and SHA1 of every loaded Lua script
+func (h *Handler) GetDiagnostics(c echo.Context) error {
+	diagnostics, err := h.rateLimiter.Diagnostics(c.Request().Context())
+	if err != nil {
+		h.logger.Error("failed to load scripts", zap.Error(err))
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to load scripts",
+		})
+	}
+
+	return c.JSON(http.StatusOK, diagnostics)
+}
+
 // GetRemaining returns the remaining requests for a user
 func (h *Handler) GetRemaining(c echo.Context) error {
 	userID := c.Param("user_id")
@@ -108,7 +576,21 @@ func (h *Handler) GetRemaining(c echo.Context) error {
 		}
 	}
 
-	remaining, err := h.rateLimiter.GetRemaining(c.Request().Context(), userID, defaultLimit)
+	// The user may be limited over a window other than the configured one
+	ctx := versionContext(c)
+	window := 0
+	if windowStr := c.QueryParam("window"); windowStr != "" {
+		var err error
+		window, err = strconv.Atoi(windowStr)
+		if err != nil || window <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "window must be a positive number of seconds",
+			})
+		}
+		ctx = ratelimiter.WithWindow(ctx, time.Duration(window)*time.Second)
+	}
+
+	remaining, appliedLimit, err := h.rateLimiter.GetRemainingLimit(ctx, userID, defaultLimit)
 	if err != nil {
 		h.logger.Error("failed to get remaining requests",
 			zap.String("user_id", userID),
@@ -119,14 +601,274 @@ func (h *Handler) GetRemaining(c echo.Context) error {
 		})
 	}
 
+	response := map[string]interface{}{
+		"user_id":           userID,
+		"remaining":         remaining,
+		"remaining_percent": ratelimiterpkg.RemainingPercent(remaining, appliedLimit),
+		"limit":             defaultLimit,
+	}
+	if window > 0 {
+		response["window"] = window
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+// GetStats returns the user's rate limit state in detail
+// algorithm, limit, remaining, remaining_percent and the limit's source are
+// always present; the other fields depend on the algorithm
+func (h *Handler) GetStats(c echo.Context) error {
+	userID := c.Param("user_id")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "user_id is required",
+		})
+	}
+
+	// Get default limit from query parameter or use default
+	defaultLimit := 100
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		if limit, err := strconv.Atoi(limitStr); err == nil {
+			defaultLimit = limit
+		}
+	}
+
+	stats, err := h.rateLimiter.GetStats(versionContext(c), userID, defaultLimit)
+	if err != nil {
+		h.logger.Error("failed to get rate limit stats",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to get rate limit stats",
+		})
+	}
+
+	response := map[string]interface{}{
+		"user_id":           userID,
+		"algorithm":         stats.Algorithm,
+		"limit":             stats.Limit,
+		"remaining":         stats.Remaining,
+		"remaining_percent": ratelimiterpkg.RemainingPercent(stats.Remaining, stats.Limit),
+		"source":            stats.Source,
+	}
+	switch stats.Algorithm {
+	case "sliding_window":
+		response["count"] = stats.Count
+		response["oldest"] = timeOrNil(stats.Oldest)
+		response["newest"] = timeOrNil(stats.Newest)
+	case "leaky_bucket", "gcra":
+		response["level"] = stats.Level
+		response["capacity"] = stats.Capacity
+		response["leak_rate"] = stats.LeakRate
+		response["time_to_empty"] = stats.TimeToEmpty.Seconds()
+	case "fixed_window", "regional":
+		response["count"] = stats.Count
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// maxWindowEntries caps the request times GetWindow returns, so a user
+// with a huge limit doesn't produce a huge response
+const maxWindowEntries = 1000
+
+// GetWindow returns the times of the requests in the user's sliding window,
+// oldest first, and how many there are in total; only the oldest
+// maxWindowEntries are listed
+func (h *Handler) GetWindow(c echo.Context) error {
+	userID := c.Param("user_id")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "user_id is required",
+		})
+	}
+
+	entries, total, err := h.rateLimiter.DumpWindow(versionContext(c), userID, maxWindowEntries)
+	if errors.Is(err, ratelimiter.ErrNoWindowLog) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		h.logger.Error("failed to dump rate limit window",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to get rate limit window",
+		})
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"user_id":   userID,
-		"remaining": remaining,
-		"limit":     defaultLimit,
+		"count":     total,
+		"entries":   entries,
+		"truncated": len(entries) < total,
 	})
 }
 
+// RateLimitBatch checks several keys at once, each against its own limit,
+// e.g. {"checks": [{"key": "user123"}, {"key": "ip:10.0.0.1", "limit": 50, "window": 60}]}
+// A check without a limit uses the default limit and one without a window
+// the configured window, in seconds. Batches larger than
+// rate_limit.max_batch_size are rejected before anything is counted
+func (h *Handler) RateLimitBatch(c echo.Context) error {
+	var req struct {
+		Checks []struct {
+			Key    string `json:"key"`
+			Limit  int    `json:"limit"`
+			Window int    `json:"window"`
+		} `json:"checks"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "invalid request body",
+		})
+	}
+
+	if len(req.Checks) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "checks is required",
+		})
+	}
+
+	checks := make([]ratelimiter.Check, len(req.Checks))
+	for i, check := range req.Checks {
+		if check.Key == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "key is required for every check",
+			})
+		}
+		if check.Limit < 0 || check.Window < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "limit and window must not be negative",
+			})
+		}
+		checks[i] = ratelimiter.Check{
+			Key:    check.Key,
+			Limit:  check.Limit,
+			Window: time.Duration(check.Window) * time.Second,
+		}
+	}
+
+	results, err := h.rateLimiter.RateLimitBatch(c.Request().Context(), checks)
+	if errors.Is(err, ratelimiter.ErrBatchTooLarge) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": err.Error(),
+		})
+	}
+	if err != nil {
+		h.logger.Error("batch rate limit check failed",
+			zap.Int("checks", len(checks)),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to check rate limits",
+		})
+	}
+
+	// Checks that couldn't be run are reported as such; the others still
+	// count
+	response := make([]map[string]interface{}, len(results))
+	for i, result := range results {
+		entry := map[string]interface{}{
+			"key":       result.Key,
+			"limit":     result.Limit,
+			"remaining": result.Remaining,
+			"allowed":   result.Allowed,
+		}
+		if result.Err != nil {
+			entry["error"] = "failed to check rate limit"
+		}
+		response[i] = entry
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"allowed": ratelimiter.AllAllowed(results),
+		"results": response,
+	})
+}
+
+// GetOverview returns the user's limit, usage, reset time, the limit's
+// source and any active penalty or campaign in one response
+func (h *Handler) GetOverview(c echo.Context) error {
+	userID := c.Param("user_id")
+	if userID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error": "user_id is required",
+		})
+	}
+
+	// Get default limit from query parameter or use default
+	defaultLimit := 100
+	if limitStr := c.QueryParam("limit"); limitStr != "" {
+		if limit, err := strconv.Atoi(limitStr); err == nil {
+			defaultLimit = limit
+		}
+	}
+
+	overview, err := h.rateLimiter.GetOverview(versionContext(c), userID, defaultLimit)
+	if err != nil {
+		h.logger.Error("failed to get rate limit overview",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error": "failed to get rate limit overview",
+		})
+	}
+
+	response := map[string]interface{}{
+		"user_id":       userID,
+		"algorithm":     overview.Algorithm,
+		"limit":         overview.Limit,
+		"remaining":     overview.Remaining,
+		"used":          overview.Used,
+		"reset_at":      timeOrNil(overview.ResetAt),
+		"source":        overview.Source,
+		"configured":    overview.Source == ratelimiter.LimitSourceUser,
+		"penalty_until": timeOrNil(overview.PenaltyUntil),
+		"campaign":      nil,
+	}
+	if overview.Campaign != nil {
+		response["campaign"] = overview.Campaign
+	}
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// versionContext returns the request context, scoped to the API version and
+// the scope given by the api_version and scope query parameters, if any
+func versionContext(c echo.Context) context.Context {
+	ctx := scopeContext(c)
+	if version := c.QueryParam("api_version"); version != "" {
+		ctx = ratelimiter.WithAPIVersion(ctx, version)
+	}
+	return ctx
+}
+
+// scopeContext returns the request context, scoped to the scope given by the
+// scope query parameter, if any, e.g. a route path under scope_by_route
+func scopeContext(c echo.Context) context.Context {
+	ctx := c.Request().Context()
+	if scope := c.QueryParam("scope"); scope != "" {
+		ctx = ratelimiter.WithScope(ctx, scope)
+	}
+	return ctx
+}
+
+// timeOrNil returns nil for the zero time so it is rendered as null
+func timeOrNil(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
 // ResetRateLimit resets the rate limit for a user
+// With ?counter_only=true only their counters are cleared, and they aren't
+// given their onboarding grace again
 func (h *Handler) ResetRateLimit(c echo.Context) error {
 	userID := c.Param("user_id")
 	if userID == "" {
@@ -135,7 +877,25 @@ func (h *Handler) ResetRateLimit(c echo.Context) error {
 		})
 	}
 
-	if err := h.rateLimiter.Reset(c.Request().Context(), userID); err != nil {
+	// Resetting is itself limited, so it can't be used to dodge the limit
+	allowed, err := h.rateLimiter.AllowReset(c.Request().Context(), userID)
+	if err != nil {
+		h.logger.Warn("reset limit check failed, allowing reset",
+			zap.String("user_id", userID),
+			zap.Error(err),
+		)
+	} else if !allowed {
+		return c.JSON(http.StatusTooManyRequests, map[string]string{
+			"error":   "too many resets",
+			"message": "this user's rate limit was reset too often, try again later",
+		})
+	}
+
+	reset := h.rateLimiter.Reset
+	if counterOnly, _ := strconv.ParseBool(c.QueryParam("counter_only")); counterOnly {
+		reset = h.rateLimiter.ResetCounterOnly
+	}
+	if err := reset(scopeContext(c), userID); err != nil {
 		h.logger.Error("failed to reset rate limit",
 			zap.String("user_id", userID),
 			zap.Error(err),
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// readinessTimeout bounds the Redis PING behind the readiness probe, so a
+// hung connection fails the probe instead of stalling it
+const readinessTimeout = 2 * time.Second
+
+// Health serves the liveness and readiness probes
+type Health struct {
+	client redis.UniversalClient
+	logger *zap.Logger
+}
+
+// NewHealth creates the health handlers, checking readiness against client
+func NewHealth(client redis.UniversalClient, logger *zap.Logger) *Health {
+	return &Health{
+		client: client,
+		logger: logger,
+	}
+}
+
+// Live reports that the process is up, whatever the state of Redis
+func (h *Health) Live(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{
+		"status": "ok",
+	})
+}
+
+// Ready reports whether the instance can serve requests, i.e. whether Redis
+// answers a PING in time; 503 takes the instance out of the load balancer
+func (h *Health) Ready(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readinessTimeout)
+	defer cancel()
+
+	if err := h.client.Ping(ctx).Err(); err != nil {
+		h.logger.Warn("readiness check failed", zap.Error(err))
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{
+			"status": "unavailable",
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"status": "ok",
+	})
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"ratelimit-challenge/internal/service/ratelimiter"
+
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+)
+
+// defaultDebounceMaxBody is the largest body read to fingerprint a request
+// when DebounceConfig.MaxBodySize isn't set
+const defaultDebounceMaxBody = 64 * 1024
+
+// DebounceConfig defines the config for the debounce middleware
+type DebounceConfig struct {
+	// Skipper defines a function to skip the middleware
+	Skipper echoMiddleware.Skipper
+	// Service remembers recent requests
+	Service *ratelimiter.Service
+	// Logger used for duplicate requests
+	Logger *zap.Logger
+	// MaxBodySize is the largest body, in bytes, read to fingerprint a
+	// request; requests with larger bodies go through without being
+	// debounced (0 for 64KB)
+	MaxBodySize int64
+	// MaxIdentityLength and OversizedIdentityPolicy bound the X-User-ID
+	// header as in RateLimiterConfig
+	MaxIdentityLength       int
+	OversizedIdentityPolicy string
+}
+
+// DebounceWithConfig creates a middleware rejecting a request with 409 when
+// the same user sent an identical one, i.e. same method, URI and body, within
+// the service's DebounceInterval, e.g. a double-clicked submit
+// It should run before rate limiting, so the duplicates don't use up quota
+// Users are identified by the X-User-ID header, or the IP address
+func DebounceWithConfig(config DebounceConfig) echo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = echoMiddleware.DefaultSkipper
+	}
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = defaultDebounceMaxBody
+	}
+	service := config.Service
+	logger := config.Logger
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) || !service.DebounceEnabled() {
+				return next(c)
+			}
+
+			userID, err := boundIdentity(c.Request().Header.Get("X-User-ID"), config.MaxIdentityLength, config.OversizedIdentityPolicy)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]interface{}{
+					"error":   "invalid identity",
+					"message": err.Error(),
+				})
+			}
+			if userID == "" {
+				userID = c.RealIP()
+			}
+
+			fingerprint, ok, err := requestFingerprint(c.Request(), config.MaxBodySize)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{
+					"error": "failed to read request body",
+				})
+			}
+			if !ok {
+				logger.Debug("request body too large to debounce",
+					zap.String("user_id", userID),
+					zap.String("uri", c.Request().RequestURI),
+				)
+				return next(c)
+			}
+
+			first, err := service.FirstOfDuplicates(c.Request().Context(), userID, fingerprint)
+			if err != nil {
+				// Fail open, duplicates are then just counted as usual
+				logger.Error("duplicate request check failed",
+					zap.String("user_id", userID),
+					zap.Error(err),
+				)
+				return next(c)
+			}
+			if !first {
+				logger.Debug("rejecting duplicate request",
+					zap.String("user_id", userID),
+					zap.String("uri", c.Request().RequestURI),
+				)
+				return c.JSON(http.StatusConflict, map[string]interface{}{
+					"error":   "duplicate request",
+					"message": "an identical request was just received",
+				})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// requestFingerprint hashes the request's method, URI and body
+// At most maxBody bytes of the body are read, and put back in front of the
+// rest so handlers can still read all of it; ok is false, with no
+// fingerprint, for larger bodies
+func requestFingerprint(r *http.Request, maxBody int64) (fingerprint string, ok bool, err error) {
+	if r.ContentLength > maxBody {
+		return "", false, nil
+	}
+
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBody+1))
+		if err != nil {
+			r.Body.Close()
+			return "", false, err
+		}
+		r.Body = prefixedBody{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		if int64(len(body)) > maxBody {
+			return "", false, nil
+		}
+		hash.Write(body)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), true, nil
+}
+
+// prefixedBody is a request body whose start has already been read, reading
+// it again before the rest and closing the original body
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"ratelimit-challenge/internal/service/ratelimiter"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DebugHeader carries the trace of each rate limit decision when the
+// middleware runs in debug mode
+const DebugHeader = "X-RateLimit-Debug"
+
+// Sources of the identity a request is limited as
+const (
+	identitySourceJWT    = "jwt"
+	identitySourceHeader = "header"
+	identitySourceIP     = "ip"
+	// A custom KeyExtractor named the identity
+	identitySourceExtractor = "extractor"
+)
+
+// limitSourceOverride is the source reported for a limit set by a trusted
+// upstream's X-RateLimit-Limit-Override header
+const limitSourceOverride = "override"
+
+// decisionTrace is the full account of a rate limit decision, reported as JSON
+// in DebugHeader
+type decisionTrace struct {
+	IdentitySource string `json:"identity_source"`
+	Limit          int    `json:"limit"`
+	LimitSource    string `json:"limit_source"`
+	Algorithm      string `json:"algorithm"`
+	// Requests counted against the limit before and after this one
+	CountBefore int    `json:"count_before"`
+	CountAfter  int    `json:"count_after"`
+	Allowed     bool   `json:"allowed"`
+	Reason      string `json:"reason"`
+	// Code names the constraint that denied the request, if any
+	Code string `json:"code,omitempty"`
+}
+
+// identitySource names where userID, as resolved from the request, came from
+func identitySource(c echo.Context, userID string, jwtSecret []byte) string {
+	switch {
+	case userID == "":
+		return identitySourceIP
+	case userID == jwtSubject(c, jwtSecret):
+		return identitySourceJWT
+	}
+	return identitySourceHeader
+}
+
+// startTrace records the user's state before their request is checked
+func startTrace(ctx context.Context, service *ratelimiter.Service, userID string, limit int, source string, overridden bool) *decisionTrace {
+	trace := &decisionTrace{IdentitySource: source}
+	stats, err := service.GetStats(ctx, userID, limit)
+	if err == nil {
+		trace.Limit = stats.Limit
+		trace.LimitSource = string(stats.Source)
+		trace.Algorithm = stats.Algorithm
+		trace.CountBefore = stats.Limit - stats.Remaining
+	}
+	if overridden {
+		trace.Limit = limit
+		trace.LimitSource = limitSourceOverride
+	}
+	return trace
+}
+
+// finishTrace completes the trace with the decision and the user's state
+// after it, and sets DebugHeader on the response
+func finishTrace(c echo.Context, service *ratelimiter.Service, trace *decisionTrace, userID string, limit int, decision ratelimiter.Decision) {
+	trace.Allowed = decision.Allowed
+	trace.Reason = string(decision.Reason)
+	trace.Code = string(decision.Code)
+	trace.CountAfter = trace.CountBefore
+	if stats, err := service.GetStats(c.Request().Context(), userID, limit); err == nil {
+		trace.CountAfter = stats.Limit - stats.Remaining
+	}
+
+	if value, err := json.Marshal(trace); err == nil {
+		c.Response().Header().Set(DebugHeader, string(value))
+	}
+}
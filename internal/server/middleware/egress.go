@@ -0,0 +1,147 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"ratelimit-challenge/internal/service/ratelimiter"
+
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+)
+
+// egressFlushBytes is how many bytes a response may write before they are
+// counted, so long streams consume egress as they go rather than at the end
+const egressFlushBytes = 64 * 1024
+
+// EgressLimiterConfig defines the config for the egress limiter middleware
+type EgressLimiterConfig struct {
+	// Skipper defines a function to skip the middleware
+	Skipper echoMiddleware.Skipper
+	// Service tracks egress per user
+	Service *ratelimiter.Service
+	// Logger used for egress decisions
+	Logger *zap.Logger
+	// MaxIdentityLength and OversizedIdentityPolicy bound the X-User-ID
+	// header as in RateLimiterConfig, when it is used
+	MaxIdentityLength       int
+	OversizedIdentityPolicy string
+}
+
+// EgressLimiterWithConfig creates a middleware limiting the bytes sent to each
+// user
+// Requests are denied once the user's egress budget is exhausted; response
+// bytes are counted as they are written
+// Users are identified as the rate limiter middleware identified them, so it
+// should run first; otherwise the X-User-ID header or IP address is used
+func EgressLimiterWithConfig(config EgressLimiterConfig) echo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = echoMiddleware.DefaultSkipper
+	}
+	service := config.Service
+	logger := config.Logger
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) || !service.EgressEnabled() {
+				return next(c)
+			}
+
+			userID, _ := c.Get(IdentityContextKey).(string)
+			if userID == "" {
+				var err error
+				userID, err = boundIdentity(c.Request().Header.Get("X-User-ID"), config.MaxIdentityLength, config.OversizedIdentityPolicy)
+				if err != nil {
+					return c.JSON(http.StatusBadRequest, map[string]interface{}{
+						"error":   "invalid identity",
+						"message": err.Error(),
+					})
+				}
+			}
+			if userID == "" {
+				userID = c.RealIP()
+			}
+
+			decision, err := service.CheckEgress(c.Request().Context(), userID)
+			if err != nil {
+				// Fail open like the rate limiter does on errors
+				logger.Error("egress check failed",
+					zap.String("user_id", userID),
+					zap.Error(err),
+				)
+			} else if !decision.Allowed {
+				logger.Debug("egress limit exceeded", zap.String("user_id", userID))
+				c.Response().Header().Set("X-RateLimit-Code", string(decision.Code))
+				return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+					"error":   "egress limit exceeded",
+					"code":    decision.Code,
+					"message": "too much data sent",
+				})
+			}
+
+			// Count response bytes even if the client goes away mid-stream
+			ctx := context.WithoutCancel(c.Request().Context())
+			writer := &egressWriter{
+				ResponseWriter: c.Response().Writer,
+				consume: func(bytes int64) {
+					if err := service.ConsumeEgress(ctx, userID, bytes); err != nil {
+						logger.Warn("failed to consume egress",
+							zap.String("user_id", userID),
+							zap.Int64("bytes", bytes),
+							zap.Error(err),
+						)
+					}
+				},
+			}
+			c.Response().Writer = writer
+			defer writer.flushCount()
+
+			return next(c)
+		}
+	}
+}
+
+// egressWriter counts the bytes written to a response, consuming them in
+// egressFlushBytes chunks and once more when the response is done
+type egressWriter struct {
+	http.ResponseWriter
+	consume func(bytes int64)
+	pending int64
+}
+
+func (w *egressWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.pending += int64(n)
+	if w.pending >= egressFlushBytes {
+		w.flushCount()
+	}
+	return n, err
+}
+
+// flushCount consumes the bytes written since the last count
+func (w *egressWriter) flushCount() {
+	if w.pending == 0 {
+		return
+	}
+	w.consume(w.pending)
+	w.pending = 0
+}
+
+// Flush lets streaming handlers flush through the wrapper
+func (w *egressWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack lets websocket handlers take over the connection through the wrapper
+func (w *egressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap exposes the wrapped writer to http.ResponseController
+func (w *egressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+)
+
+// Identity policies for requests carrying both an X-User-ID header and a JWT
+const (
+	// IdentityPreferJWT uses the JWT subject when the two disagree
+	IdentityPreferJWT = "prefer_jwt"
+	// IdentityPreferHeader uses the header when the two disagree
+	IdentityPreferHeader = "prefer_header"
+	// IdentityRequireMatch rejects requests where the two disagree
+	IdentityRequireMatch = "require_match"
+)
+
+// Policies for identities longer than the configured maximum
+const (
+	// OversizedIdentityReject rejects the request with 400
+	OversizedIdentityReject = "reject"
+	// OversizedIdentityHash limits the request as the identity's SHA-256
+	OversizedIdentityHash = "hash"
+)
+
+// IdentityContextKey is the echo context key holding the identity the
+// request was rate limited as
+const IdentityContextKey = "rate_limit_identity"
+
+// errIdentityMismatch is returned under IdentityRequireMatch when the header
+// and the JWT subject name different users
+var errIdentityMismatch = fmt.Errorf("X-User-ID does not match the token subject")
+
+// errIdentityTooLong is returned under OversizedIdentityReject for identities
+// longer than the maximum
+var errIdentityTooLong = fmt.Errorf("user ID exceeds the maximum length")
+
+// boundIdentity keeps identities longer than maxLength bytes out of Redis
+// keys: under OversizedIdentityHash they are replaced by their hex encoded
+// SHA-256, otherwise they are rejected
+// A maxLength of 0 accepts any length
+func boundIdentity(userID string, maxLength int, policy string) (string, error) {
+	if maxLength <= 0 || len(userID) <= maxLength {
+		return userID, nil
+	}
+	if policy == OversizedIdentityHash {
+		sum := sha256.Sum256([]byte(userID))
+		return "sha256:" + hex.EncodeToString(sum[:]), nil
+	}
+	return "", errIdentityTooLong
+}
+
+// KeyExtractor returns the identity a request is rate limited as, e.g. a
+// claim of its bearer token or its API key
+// An empty identity limits the request by the client's IP instead
+type KeyExtractor func(c echo.Context) (string, error)
+
+// DefaultKeyExtractor returns the extractor used when none is configured:
+// the subject of a valid bearer token signed with jwtSecret, else the
+// X-User-ID header, reconciled per policy when the two disagree
+func DefaultKeyExtractor(policy string, jwtSecret []byte) KeyExtractor {
+	return func(c echo.Context) (string, error) {
+		return resolveIdentity(c, policy, jwtSecret)
+	}
+}
+
+// resolveIdentity returns the user the request should be limited as
+// It returns an empty ID when the request carries neither identity
+func resolveIdentity(c echo.Context, policy string, jwtSecret []byte) (string, error) {
+	header := c.Request().Header.Get("X-User-ID")
+	subject := jwtSubject(c, jwtSecret)
+
+	switch {
+	case subject == "":
+		return header, nil
+	case header == "" || header == subject:
+		return subject, nil
+	}
+
+	switch policy {
+	case IdentityPreferHeader:
+		return header, nil
+	case IdentityRequireMatch:
+		return "", errIdentityMismatch
+	default:
+		return subject, nil
+	}
+}
+
+// jwtSubject returns the subject of a valid HMAC-signed bearer token
+// Missing or invalid tokens yield an empty subject; rejecting them is left to
+// the authentication layer
+func jwtSubject(c echo.Context, secret []byte) string {
+	if len(secret) == 0 {
+		return ""
+	}
+
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+
+	claims := &jwt.StandardClaims{}
+	token, err := jwt.ParseWithClaims(strings.TrimPrefix(auth, "Bearer "), claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return secret, nil
+	})
+	if err != nil || !token.Valid {
+		return ""
+	}
+
+	return claims.Subject
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+)
+
+// MaintenanceConfig defines the config for the maintenance middleware
+type MaintenanceConfig struct {
+	// Skipper defines a function to skip the middleware, e.g. for the routes
+	// that must keep working during maintenance
+	Skipper echoMiddleware.Skipper
+	// Service holds the maintenance flag
+	Service *ratelimiter.Service
+	// Logger used for maintenance checks
+	Logger *zap.Logger
+}
+
+// MaintenanceWithConfig creates a middleware that rejects every request with
+// 503 and a Retry-After header while the API is in maintenance mode
+// It should run before rate limiting, so requests turned away don't count
+func MaintenanceWithConfig(config MaintenanceConfig) echo.MiddlewareFunc {
+	if config.Skipper == nil {
+		config.Skipper = echoMiddleware.DefaultSkipper
+	}
+	service := config.Service
+	logger := config.Logger
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+
+			status, err := service.GetMaintenance(c.Request().Context())
+			if err != nil {
+				// Fail open, so a Redis outage doesn't take the API down
+				logger.Error("maintenance check failed", zap.Error(err))
+				return next(c)
+			}
+			if !status.Active {
+				return next(c)
+			}
+
+			c.Response().Header().Set("Retry-After", strconv.Itoa(status.RetryAfter))
+			return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+				"error":       "service unavailable",
+				"message":     "the API is down for maintenance",
+				"retry_after": status.RetryAfter,
+			})
+		}
+	}
+}
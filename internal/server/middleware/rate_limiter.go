@@ -1,68 +1,607 @@
 package middleware
 
 import (
+	"context"
+	"errors"
+	"math"
 	"net/http"
 	"ratelimit-challenge/internal/service/ratelimiter"
+	ratelimiterpkg "ratelimit-challenge/pkg/ratelimiter"
+	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
 	"go.uber.org/zap"
 )
 
+// apiVersionPattern matches the version segment of an /api/<version> path
+var apiVersionPattern = regexp.MustCompile(`^/api/(v[0-9]+)(?:/|$)`)
+
+// RateLimiterConfig defines the config for the rate limiter middleware
+type RateLimiterConfig struct {
+	// Skipper defines a function to skip the middleware
+	Skipper echoMiddleware.Skipper
+	// Service performs the rate limit checks
+	Service *ratelimiter.Service
+	// Logger used for rate limit decisions
+	Logger *zap.Logger
+	// DefaultLimit applied when the user has no custom limit
+	DefaultLimit int
+	// DisconnectPolicy controls whether a request whose client disconnected
+	// before the handler completed still counts: "count" (default) or "refund"
+	DisconnectPolicy string
+	// TrustedIdentities may override the algorithm per request via the
+	// X-RateLimit-Algorithm header; the header is ignored for everyone else
+	TrustedIdentities []string
+	// IdentityPolicy decides who the request is limited as when the
+	// X-User-ID header and the JWT subject disagree: "prefer_jwt" (default),
+	// "prefer_header" or "require_match", which rejects the request with 401
+	IdentityPolicy string
+	// JWTSecret verifies HMAC-signed bearer tokens; without it tokens are ignored
+	JWTSecret []byte
+	// KeyExtractor names the identity each request is limited as, replacing
+	// DefaultKeyExtractor(IdentityPolicy, JWTSecret); when it fails, the
+	// request is rejected with 401 if FailClosed is set and let through
+	// unlimited otherwise
+	KeyExtractor KeyExtractor
+	// MaxIdentityLength is the longest identity accepted, in bytes (0
+	// disables the check)
+	MaxIdentityLength int
+	// OversizedIdentityPolicy handles longer identities: "reject" (default)
+	// answers 400, "hash" limits the request as the identity's SHA-256
+	OversizedIdentityPolicy string
+	// TrustedUpstreams are IPs or CIDRs allowed to set the request's limit via
+	// the X-RateLimit-Limit-Override header and its cost via X-Request-Cost,
+	// as are clients presenting a verified TLS certificate; the headers are
+	// ignored for everyone else
+	TrustedUpstreams []string
+	// AllowlistCIDRs are IPs or CIDRs of clients exempt from rate limiting,
+	// matched against echo's RealIP, so set TrustedProxyExtractor as the
+	// server's IPExtractor when clients could spoof forwarding headers
+	AllowlistCIDRs []string
+	// MaxLimitOverride bounds the limit a trusted upstream may set (0 disables
+	// overrides)
+	MaxLimitOverride int
+	// FatalErrorPolicy controls requests when the limiter is misconfigured,
+	// e.g. Redis rejects its credentials: "fail_closed" (default) rejects
+	// them with 503, "fail_open" lets them through like any other failure
+	FatalErrorPolicy string
+	// FailClosed rejects requests with 503 whenever the check fails, e.g.
+	// while Redis is unreachable; by default only a misconfigured limiter
+	// does, per FatalErrorPolicy, and other failures let requests through
+	FailClosed bool
+	// DryRun checks every request and reports the decision in the
+	// X-RateLimit-DryRun-Decision header, but lets denied requests through
+	// instead of answering 429, logging each of them at info level
+	DryRun bool
+	// RemainingGranularity rounds the remaining count reported to clients
+	// down to a multiple of itself, e.g. 10 (0 or 1 reports it exactly)
+	RemainingGranularity int
+	// RemainingFloor hides the remaining count from clients once it drops
+	// below this value (0 always shows it)
+	RemainingFloor int
+	// ScopeByAPIVersion counts requests separately per API version, taken
+	// from the /api/<version> path prefix
+	ScopeByAPIVersion bool
+	// ScopeByRoute gives each route a budget of its own, scoped by the
+	// route's path as registered, e.g. "/api/v1/orders/:id", at the user's
+	// custom limit for that scope if one is set, else their own; routes with
+	// a RouteLimit are already counted apart
+	ScopeByRoute bool
+	// EndpointAlgorithms selects the algorithm per route, keyed by the
+	// route's path as registered, e.g. "/api/v1/upload"; paths match
+	// case-insensitively, since config keys are lower-cased when loaded
+	EndpointAlgorithms map[string]string
+	// HeaderStyle selects the quota headers sent to clients: "legacy" sends
+	// X-RateLimit-*, "standard" the IETF draft's RateLimit-Limit,
+	// RateLimit-Remaining and RateLimit-Reset, and "both" (default) all of them
+	HeaderStyle string
+	// RouteLimit, if set, limits each route the middleware wraps to this many
+	// requests per window, counted apart from the user's other requests and
+	// regardless of their custom limit; see RateLimiterMiddlewareWithLimit
+	RouteLimit int
+	// RouteWindow, if set along with RouteLimit, counts the route's requests
+	// over this window instead of the service's; see
+	// RateLimiterMiddlewareWithLimitAndWindow
+	RouteWindow time.Duration
+	// Debug reports how each decision was reached in the X-RateLimit-Debug
+	// header, at the cost of two extra Redis lookups per request; it exposes
+	// internal state, so it must never be enabled in production
+	Debug bool
+}
+
 // RateLimiterMiddleware creates a middleware that enforces rate limiting
 // It extracts user ID from the request and checks against the rate limiter
 func RateLimiterMiddleware(rateLimiterService *ratelimiter.Service, logger *zap.Logger, defaultLimit int) echo.MiddlewareFunc {
+	return RateLimiterMiddlewareWithConfig(RateLimiterConfig{
+		Service:      rateLimiterService,
+		Logger:       logger,
+		DefaultLimit: defaultLimit,
+	})
+}
+
+// RateLimiterMiddlewareWithLimit creates a middleware limiting the routes or
+// groups it wraps to limit requests per window, e.g. a tighter limit for
+// login than the global middleware enforces
+// Each route is counted in a window of its own, so the global limit and the
+// route's are enforced independently
+func RateLimiterMiddlewareWithLimit(rateLimiterService *ratelimiter.Service, logger *zap.Logger, limit int) echo.MiddlewareFunc {
+	return RateLimiterMiddlewareWithConfig(RateLimiterConfig{
+		Service:      rateLimiterService,
+		Logger:       logger,
+		DefaultLimit: limit,
+		RouteLimit:   limit,
+	})
+}
+
+// RateLimiterMiddlewareWithLimitAndWindow is like
+// RateLimiterMiddlewareWithLimit but counts the route's requests over window
+// instead of the service's, e.g. 5 login attempts per 15 minutes alongside a
+// global limit per minute
+func RateLimiterMiddlewareWithLimitAndWindow(rateLimiterService *ratelimiter.Service, logger *zap.Logger, limit int, window time.Duration) echo.MiddlewareFunc {
+	return RateLimiterMiddlewareWithConfig(RateLimiterConfig{
+		Service:      rateLimiterService,
+		Logger:       logger,
+		DefaultLimit: limit,
+		RouteLimit:   limit,
+		RouteWindow:  window,
+	})
+}
+
+// DryRunDecisionHeader carries the decision the middleware would have
+// enforced, "allowed" or "denied", when it runs in dry-run mode
+const DryRunDecisionHeader = "X-RateLimit-DryRun-Decision"
+
+// RouteLimitContextKey is the echo context key holding the limit
+// RateLimiterMiddlewareWithLimit applied to the request's route
+const RouteLimitContextKey = "rate_limit_route_limit"
+
+// RouteLimit returns the limit RateLimiterMiddlewareWithLimit applied to the
+// request's route, if any
+func RouteLimit(c echo.Context) (int, bool) {
+	limit, ok := c.Get(RouteLimitContextKey).(int)
+	return limit, ok
+}
+
+// RateLimiterMiddlewareWithConfig creates a rate limiter middleware from config
+func RateLimiterMiddlewareWithConfig(config RateLimiterConfig) echo.MiddlewareFunc {
+	rateLimiterService := config.Service
+	logger := config.Logger
+	defaultLimit := config.DefaultLimit
+	if config.RouteLimit > 0 {
+		defaultLimit = config.RouteLimit
+	}
+	if config.Skipper == nil {
+		config.Skipper = echoMiddleware.DefaultSkipper
+	}
+	if config.DisconnectPolicy == "" {
+		config.DisconnectPolicy = "count"
+	}
+	if config.FatalErrorPolicy == "" {
+		config.FatalErrorPolicy = "fail_closed"
+	}
+	if config.HeaderStyle == "" {
+		config.HeaderStyle = "both"
+	}
+	keyExtractor := config.KeyExtractor
+	if keyExtractor == nil {
+		keyExtractor = DefaultKeyExtractor(config.IdentityPolicy, config.JWTSecret)
+	}
+	legacyHeaders := config.HeaderStyle != "standard"
+	standardHeaders := config.HeaderStyle != "legacy"
+	trusted := make(map[string]struct{}, len(config.TrustedIdentities))
+	for _, id := range config.TrustedIdentities {
+		trusted[id] = struct{}{}
+	}
+	endpointAlgorithms := make(map[string]string, len(config.EndpointAlgorithms))
+	for path, algorithm := range config.EndpointAlgorithms {
+		if ratelimiter.IsKnownAlgorithm(algorithm) {
+			endpointAlgorithms[strings.ToLower(path)] = algorithm
+		}
+	}
+	upstreams, err := parseNetworks(config.TrustedUpstreams)
+	if err != nil {
+		logger.Error("ignoring trusted upstreams", zap.Error(err))
+		upstreams = nil
+	}
+	allowlist, err := parseNetworks(config.AllowlistCIDRs)
+	if err != nil {
+		logger.Error("ignoring allowlist", zap.Error(err))
+		allowlist = nil
+	}
+
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			if config.Skipper(c) {
+				return next(c)
+			}
+			// Allowlisted clients, e.g. internal ranges, are never limited
+			if len(allowlist) > 0 && containsIP(allowlist, c.RealIP()) {
+				return next(c)
+			}
+			if config.RouteLimit > 0 {
+				// Leave the request as it was for the middleware around this
+				// one, which counts it in the user's own window
+				defer c.SetRequest(c.Request())
+				// Clients are told about the route's limit, not the user's
+				for _, header := range quotaHeaders {
+					c.Response().Header().Del(header)
+				}
+			}
+
 			// Extract user ID from request
-			// In a real application, this might come from:
-			// - JWT token
-			// - API key
-			// - Header (X-User-ID)
-			// - Query parameter
-			// For this example, we'll use X-User-ID header or default to IP address
-			userID := c.Request().Header.Get("X-User-ID")
+			// By default it is the JWT subject or X-User-ID header; a custom
+			// KeyExtractor may take it from anywhere else, e.g. an API key
+			// Without one, the request is limited by IP address
+			userID, err := keyExtractor(c)
+			if errors.Is(err, errIdentityMismatch) {
+				logger.Debug("rejecting request with conflicting identities",
+					zap.String("user_id", c.Request().Header.Get("X-User-ID")),
+					zap.Error(err),
+				)
+				return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+					"error":   "identity mismatch",
+					"message": err.Error(),
+				})
+			}
+			if err != nil {
+				if config.FailClosed {
+					logger.Warn("failed to identify request, rejecting it", zap.Error(err))
+					return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+						"error":   "unidentified request",
+						"message": err.Error(),
+					})
+				}
+				logger.Warn("failed to identify request, allowing it", zap.Error(err))
+				return next(c)
+			}
+			// Oversized identities never make it into a Redis key
+			bounded, err := boundIdentity(userID, config.MaxIdentityLength, config.OversizedIdentityPolicy)
+			if err != nil {
+				logger.Debug("rejecting oversized identity",
+					zap.Int("length", len(userID)),
+				)
+				return c.JSON(http.StatusBadRequest, map[string]interface{}{
+					"error":   "invalid identity",
+					"message": err.Error(),
+				})
+			}
+			source := ""
+			if config.Debug {
+				source = identitySource(c, userID, config.JWTSecret)
+				if config.KeyExtractor != nil && userID != "" {
+					source = identitySourceExtractor
+				}
+			}
+			userID = rateLimiterService.NormalizeIdentity(bounded)
+			limitedByIP := false
 			if userID == "" {
 				// Fallback to IP address if no user ID provided
 				userID = c.RealIP()
+				limitedByIP = true
+			}
+			c.Set(IdentityContextKey, userID)
+
+			// Usage of each API version is tracked separately
+			if config.ScopeByAPIVersion {
+				if version := APIVersion(c.Request().URL.Path); version != "" {
+					c.SetRequest(c.Request().WithContext(ratelimiter.WithAPIVersion(c.Request().Context(), version)))
+				}
+			}
+
+			// Each route may draw from a budget of its own, e.g. fewer writes
+			// than reads
+			if config.ScopeByRoute && config.RouteLimit == 0 && c.Path() != "" {
+				c.SetRequest(c.Request().WithContext(ratelimiter.WithScope(c.Request().Context(), c.Path())))
+			}
+
+			// Endpoints may use an algorithm of their own, e.g. leaky bucket
+			// for bursty uploads
+			if algorithm, ok := endpointAlgorithms[strings.ToLower(c.Path())]; ok {
+				c.SetRequest(c.Request().WithContext(ratelimiter.WithAlgorithm(c.Request().Context(), algorithm)))
+			}
+
+			// Routes with a limit of their own are counted apart, at that limit
+			// and over their own window if they have one
+			if config.RouteLimit > 0 {
+				ctx := ratelimiter.WithRoute(c.Request().Context(), c.Path())
+				if config.RouteWindow > 0 {
+					ctx = ratelimiter.WithWindow(ctx, config.RouteWindow)
+				}
+				c.SetRequest(c.Request().WithContext(ratelimiter.WithLimitOverride(ctx, config.RouteLimit)))
+				c.Set(RouteLimitContextKey, config.RouteLimit)
+			}
+
+			// Trusted callers may force a specific algorithm for this request
+			if algorithm := c.Request().Header.Get("X-RateLimit-Algorithm"); algorithm != "" {
+				if _, ok := trusted[userID]; ok && ratelimiter.IsKnownAlgorithm(algorithm) {
+					c.SetRequest(c.Request().WithContext(ratelimiter.WithAlgorithm(c.Request().Context(), algorithm)))
+				} else {
+					logger.Debug("ignoring algorithm override",
+						zap.String("user_id", userID),
+						zap.String("algorithm", algorithm),
+					)
+				}
+			}
+
+			// A trusted upstream may have already decided the limit
+			limit := defaultLimit
+			overridden := false
+			if override, ok := limitOverride(c, upstreams, config.MaxLimitOverride); ok {
+				limit = override
+				overridden = true
+				c.SetRequest(c.Request().WithContext(ratelimiter.WithLimitOverride(c.Request().Context(), override)))
+			} else if value := c.Request().Header.Get(LimitOverrideHeader); value != "" {
+				logger.Debug("ignoring limit override",
+					zap.String("user_id", userID),
+					zap.String("limit", value),
+				)
+			}
+
+			// ...and how many slots the request costs
+			if cost, ok := requestCost(c, upstreams); ok {
+				c.SetRequest(c.Request().WithContext(ratelimiter.WithRequestCost(c.Request().Context(), cost)))
+			} else if value := c.Request().Header.Get(RequestCostHeader); value != "" {
+				logger.Debug("ignoring request cost",
+					zap.String("user_id", userID),
+					zap.String("cost", value),
+				)
+			}
+
+			var trace *decisionTrace
+			if config.Debug {
+				trace = startTrace(c.Request().Context(), rateLimiterService, userID, limit, source, overridden)
 			}
 
 			// Check rate limit
-			allowed, err := rateLimiterService.RateLimit(c.Request().Context(), userID, defaultLimit)
+			decision, err := rateLimiterService.Check(c.Request().Context(), userID, limit)
+			if trace != nil {
+				finishTrace(c, rateLimiterService, trace, userID, limit, decision)
+			}
 			if err != nil {
-				logger.Error("rate limit check failed",
+				// A misconfigured limiter won't recover by itself, so letting
+				// traffic through would silently disable rate limiting
+				misconfigured := decision.Code == ratelimiter.CodeMisconfigured && config.FatalErrorPolicy == "fail_closed"
+				if config.FailClosed || misconfigured {
+					logger.Warn("rate limit check failed, rejecting request",
+						zap.String("user_id", userID),
+						zap.String("code", string(decision.Code)),
+						zap.Error(err),
+					)
+					message := "rate limiter is unavailable"
+					if misconfigured {
+						message = "rate limiter is misconfigured"
+					}
+					c.Response().Header().Set("X-RateLimit-Code", string(decision.Code))
+					return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+						"error":   "rate limiter unavailable",
+						"code":    decision.Code,
+						"message": message,
+					})
+				}
+				// Otherwise the request is allowed to prevent service degradation
+				logger.Warn("rate limit check failed, allowing request",
 					zap.String("user_id", userID),
+					zap.String("code", string(decision.Code)),
 					zap.Error(err),
 				)
-				// On error, we allow the request to prevent service degradation
-				// In production, you might want to fail closed instead
 				return next(c)
 			}
 
-			if !allowed {
+			if !decision.Allowed {
 				// Get remaining requests for better error message
-				remaining, _ := rateLimiterService.GetRemaining(c.Request().Context(), userID, defaultLimit)
+				remaining, appliedLimit := remainingAfter(c.Request().Context(), rateLimiterService, decision, userID, limit)
 
-				logger.Debug("rate limit exceeded",
-					zap.String("user_id", userID),
-					zap.Int("limit", defaultLimit),
-					zap.Int("remaining", remaining),
-				)
+				code := decision.Code
+				if code == ratelimiter.CodeRateLimited && limitedByIP {
+					code = ratelimiter.CodeIPLimited
+				}
+
+				// Users over their own limit are told exactly when a slot frees up
+				retryAfter := 1
+				if decision.Result != nil {
+					if decision.Result.RetryAfter > 0 {
+						retryAfter = retryAfterSeconds(decision.Result.RetryAfter)
+					}
+				} else if decision.Code == ratelimiter.CodeRateLimited {
+					if wait, err := rateLimiterService.RetryAfter(c.Request().Context(), userID, limit); err == nil && wait > 0 {
+						retryAfter = retryAfterSeconds(wait)
+					}
+				}
+
+				if config.DryRun {
+					logger.Info("rate limit would deny request (dry run)",
+						zap.String("user_id", userID),
+						zap.String("path", c.Path()),
+						zap.String("code", string(code)),
+						zap.Int("limit", appliedLimit),
+						zap.Int("remaining", remaining),
+					)
+				} else {
+					logger.Debug("rate limit exceeded",
+						zap.String("user_id", userID),
+						zap.String("code", string(code)),
+						zap.Int("limit", appliedLimit),
+						zap.Int("remaining", remaining),
+					)
+				}
 
-				return c.JSON(http.StatusTooManyRequests, map[string]interface{}{
+				body := map[string]interface{}{
 					"error":       "rate limit exceeded",
+					"code":        code,
 					"message":     "too many requests",
-					"retry_after": 1, // seconds
-					"remaining":   remaining,
-				})
+					"retry_after": retryAfter, // seconds
+				}
+				reported, showRemaining := reportedRemaining(remaining, config.RemainingGranularity, config.RemainingFloor)
+				if showRemaining {
+					body["remaining"] = reported
+				}
+
+				if standardHeaders {
+					setStandardHeaders(c.Response().Header(), appliedLimit, reported, showRemaining, resetSeconds(c.Request().Context(), rateLimiterService, decision))
+				}
+				c.Response().Header().Set("X-RateLimit-Code", string(code))
+				// In dry run the request goes ahead, only reporting the denial
+				if config.DryRun {
+					c.Response().Header().Set(DryRunDecisionHeader, "denied")
+					return next(c)
+				}
+				c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				return c.JSON(http.StatusTooManyRequests, body)
+			}
+			if config.DryRun {
+				c.Response().Header().Set(DryRunDecisionHeader, "allowed")
+			}
+
+			// Let the client know it is over the limit and will soon be denied
+			if decision.SoftOverage {
+				c.Response().Header().Set("X-RateLimit-Overage", "true")
 			}
 
 			// Get remaining requests and add to response headers
-			remaining, _ := rateLimiterService.GetRemaining(c.Request().Context(), userID, defaultLimit)
-			c.Response().Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
-			c.Response().Header().Set("X-RateLimit-Limit", strconv.Itoa(defaultLimit))
+			// They are set before calling the handler so clients see their quota
+			// whatever its outcome, including error responses written later by
+			// the error handler
+			// The limit reported, and the percentage of it remaining, is the one
+			// actually applied, e.g. the user's custom limit
+			remaining, appliedLimit := remainingAfter(c.Request().Context(), rateLimiterService, decision, userID, limit)
+			reported, showRemaining := reportedRemaining(remaining, config.RemainingGranularity, config.RemainingFloor)
+			if legacyHeaders {
+				c.Response().Header().Set("X-RateLimit-Limit", strconv.Itoa(appliedLimit))
+				if showRemaining {
+					c.Response().Header().Set("X-RateLimit-Remaining", strconv.Itoa(reported))
+					c.Response().Header().Set("X-RateLimit-Remaining-Percent", strconv.Itoa(ratelimiterpkg.RemainingPercent(reported, appliedLimit)))
+				}
+			}
+			if standardHeaders {
+				setStandardHeaders(c.Response().Header(), appliedLimit, reported, showRemaining, resetSeconds(c.Request().Context(), rateLimiterService, decision))
+			}
+
+			// Handlers add the cost of their limited sub-operations to the
+			// request with ratelimiter.AddCost, consumed once they complete
+			ctx, costs := ratelimiter.WithCostAccumulator(c.Request().Context())
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			err = next(c)
 
-			return next(c)
+			// The client went away before the handler completed, so the request
+			// never got a response; give the slot back if configured to
+			refunded := false
+			if config.DisconnectPolicy == "refund" && errors.Is(c.Request().Context().Err(), context.Canceled) {
+				refunded = true
+				refundCtx := context.WithoutCancel(c.Request().Context())
+				if refundErr := rateLimiterService.Refund(refundCtx, userID); refundErr != nil {
+					logger.Warn("failed to refund request after client disconnect",
+						zap.String("user_id", userID),
+						zap.Error(refundErr),
+					)
+				}
+			}
+
+			// The accumulated cost is committed in one step, or rolled back by
+			// never consuming it if the request failed
+			// A cost larger than what is left still drains the window, so the
+			// user's next requests are denied
+			if cost := costs.Total(); cost > 0 {
+				if refunded || err != nil || c.Response().Status >= http.StatusInternalServerError {
+					logger.Debug("rolling back cost of failed request",
+						zap.String("user_id", userID),
+						zap.Int("cost", cost),
+					)
+				} else {
+					commitCtx := context.WithoutCancel(c.Request().Context())
+					charged, commitErr := rateLimiterService.ConsumeN(commitCtx, userID, limit, cost)
+					if commitErr != nil {
+						logger.Warn("failed to commit request cost",
+							zap.String("user_id", userID),
+							zap.Int("cost", cost),
+							zap.Error(commitErr),
+						)
+					} else if charged < cost {
+						logger.Warn("request cost exceeds the remaining limit",
+							zap.String("user_id", userID),
+							zap.Int("cost", cost),
+							zap.Int("charged", charged),
+							zap.Int("uncharged", cost-charged),
+						)
+					}
+				}
+			}
+
+			return err
 		}
 	}
 }
+
+// APIVersion returns the API version from a path prefixed with /api/<version>,
+// e.g. "v2" for /api/v2/users, or "" if the path has no version
+func APIVersion(path string) string {
+	match := apiVersionPattern.FindStringSubmatch(path)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// remainingAfter returns the user's remaining requests after decision and
+// the limit they count against, as reported with it by the limiter when it
+// decided, or else looked up
+func remainingAfter(ctx context.Context, service *ratelimiter.Service, decision ratelimiter.Decision, userID string, limit int) (int, int) {
+	if decision.Result != nil {
+		return decision.Result.Remaining, decision.Result.Limit
+	}
+	remaining, appliedLimit, _ := service.GetRemainingLimit(ctx, userID, limit)
+	return remaining, appliedLimit
+}
+
+// quotaHeaders are every header describing the user's quota, in either style
+var quotaHeaders = []string{
+	"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Remaining-Percent",
+	"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset",
+}
+
+// setStandardHeaders sets the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers of the IETF draft
+// RateLimit-Remaining is left out when the remaining count is hidden
+func setStandardHeaders(header http.Header, limit, remaining int, showRemaining bool, reset int) {
+	header.Set("RateLimit-Limit", strconv.Itoa(limit))
+	if showRemaining {
+		header.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	}
+	header.Set("RateLimit-Reset", strconv.Itoa(reset))
+}
+
+// resetSeconds returns the whole seconds until the user's window rolls over,
+// i.e. until every request counted so far has left it, as reported by the
+// limiter that decided, or else the whole window
+func resetSeconds(ctx context.Context, service *ratelimiter.Service, decision ratelimiter.Decision) int {
+	if decision.Result != nil && !decision.Result.ResetAt.IsZero() {
+		if wait := time.Until(decision.Result.ResetAt); wait > 0 {
+			return retryAfterSeconds(wait)
+		}
+		return 0
+	}
+	return retryAfterSeconds(service.Window(ctx))
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds for the Retry-After
+// header, so clients never retry too early
+func retryAfterSeconds(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// reportedRemaining returns the remaining count to show clients, coarsened so
+// it doesn't reveal exact internal counts
+// Returns false once remaining is below floor and should be hidden
+func reportedRemaining(remaining, granularity, floor int) (int, bool) {
+	if remaining < floor {
+		return 0, false
+	}
+	if granularity > 1 {
+		remaining -= remaining % granularity
+	}
+	return remaining, true
+}
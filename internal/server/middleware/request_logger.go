@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"ratelimit-challenge/internal/config"
+	"sync/atomic"
+
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+)
+
+// RequestLogger creates a middleware that logs requests, sampled per config
+// Requests that fail or return a non-2xx status are always logged, while
+// successful ones are logged once every RequestSampleRate requests
+func RequestLogger(logger *zap.Logger, cfg config.LoggerConfig) echo.MiddlewareFunc {
+	var counter uint64
+
+	return echoMiddleware.RequestLoggerWithConfig(echoMiddleware.RequestLoggerConfig{
+		LogStatus:   true,
+		LogURI:      true,
+		LogError:    true,
+		LogMethod:   true,
+		LogLatency:  true,
+		LogRemoteIP: true,
+		LogValuesFunc: func(c echo.Context, v echoMiddleware.RequestLoggerValues) error {
+			failed := v.Error != nil || v.Status < 200 || v.Status >= 300
+			if !failed {
+				if cfg.RequestSampleRate <= 0 {
+					return nil
+				}
+				if atomic.AddUint64(&counter, 1)%uint64(cfg.RequestSampleRate) != 0 {
+					return nil
+				}
+			}
+
+			logger.Info("request",
+				zap.String("id", v.RequestID),
+				zap.String("method", v.Method),
+				zap.String("uri", v.URI),
+				zap.Int("status", v.Status),
+				zap.Duration("latency", v.Latency),
+				zap.String("remote_ip", v.RemoteIP),
+				zap.Error(v.Error),
+			)
+			return nil
+		},
+	})
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// LimitOverrideHeader carries the limit a trusted upstream decided for the
+// request, e.g. an API gateway that already knows the caller's plan
+const LimitOverrideHeader = "X-RateLimit-Limit-Override"
+
+// RequestCostHeader carries how many slots a request consumes, set by a
+// trusted upstream for expensive routes; requests without it cost 1
+const RequestCostHeader = "X-Request-Cost"
+
+// parseNetworks parses IPs and CIDRs into networks; a bare IP matches only
+// itself
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %q", entry)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// TrustedProxyExtractor returns an echo.IPExtractor taking the client's IP
+// from the X-Forwarded-For header set by the given proxies (IPs or CIDRs)
+// Requests that don't come through one of them are identified by the
+// address they connect from, so clients can't pick their IP by setting the
+// header themselves
+func TrustedProxyExtractor(proxies []string) (echo.IPExtractor, error) {
+	networks, err := parseNetworks(proxies)
+	if err != nil {
+		return nil, err
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, network := range networks {
+		options = append(options, echo.TrustIPRange(network))
+	}
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// containsIP reports whether ip, as returned by echo's RealIP, is in any of
+// the networks
+func containsIP(networks []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTrustedUpstream reports whether the request comes from a trusted upstream:
+// one presenting a verified client certificate, or connecting directly from
+// an allowlisted address
+// Forwarding headers are deliberately ignored since any client can set them
+func isTrustedUpstream(c echo.Context, networks []*net.IPNet) bool {
+	req := c.Request()
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitOverride returns the limit set by a trusted upstream via
+// LimitOverrideHeader
+// The header is ignored unless it comes from a trusted upstream and holds a
+// positive integer no greater than max
+func limitOverride(c echo.Context, networks []*net.IPNet, max int) (int, bool) {
+	value := c.Request().Header.Get(LimitOverrideHeader)
+	if value == "" || !isTrustedUpstream(c, networks) {
+		return 0, false
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > max {
+		return 0, false
+	}
+	return limit, true
+}
+
+// requestCost returns the cost set by a trusted upstream via
+// RequestCostHeader
+// The header is ignored unless it comes from a trusted upstream and holds a
+// positive integer
+func requestCost(c echo.Context, networks []*net.IPNet) (int, bool) {
+	value := c.Request().Header.Get(RequestCostHeader)
+	if value == "" || !isTrustedUpstream(c, networks) {
+		return 0, false
+	}
+
+	cost, err := strconv.Atoi(value)
+	if err != nil || cost <= 0 {
+		return 0, false
+	}
+	return cost, true
+}
@@ -8,8 +8,11 @@ import (
 	ratelimiterMiddleware "ratelimit-challenge/internal/server/middleware"
 	"ratelimit-challenge/internal/service/ratelimiter"
 
+	"github.com/go-redis/redis/v8"
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
@@ -26,14 +29,26 @@ func NewServer(
 	cfg *config.Config,
 	logger *zap.Logger,
 	rateLimiterService *ratelimiter.Service,
+	redisClient redis.UniversalClient,
+	metrics *prometheus.Registry,
 ) *Server {
 	e := echo.New()
 
 	// Hide Echo banner
 	e.HideBanner = true
 
+	// Behind known proxies, only their forwarding headers name the client
+	if len(cfg.RateLimit.TrustedProxies) > 0 {
+		extractor, err := ratelimiterMiddleware.TrustedProxyExtractor(cfg.RateLimit.TrustedProxies)
+		if err != nil {
+			logger.Error("ignoring trusted proxies", zap.Error(err))
+		} else {
+			e.IPExtractor = extractor
+		}
+	}
+
 	// Setup middleware
-	setupMiddleware(e, logger, cfg, rateLimiterService)
+	setupMiddleware(e, logger, cfg, rateLimiterService, redisClient, metrics)
 
 	// Setup routes
 	setupRoutes(e, rateLimiterService, logger)
@@ -46,57 +61,108 @@ func NewServer(
 	}
 }
 
-// setupMiddleware configures Echo middleware
+// setupMiddleware configures Echo middleware in the order given by
+// cfg.API.Middleware; names are validated when the config is loaded
 func setupMiddleware(
 	e *echo.Echo,
 	logger *zap.Logger,
 	cfg *config.Config,
 	rateLimiterService *ratelimiter.Service,
+	redisClient redis.UniversalClient,
+	metrics *prometheus.Registry,
 ) {
-	// Request ID middleware
-	e.Use(echoMiddleware.RequestID())
-
-	// Logger middleware
-	e.Use(echoMiddleware.RequestLoggerWithConfig(echoMiddleware.RequestLoggerConfig{
-		LogStatus:   true,
-		LogURI:      true,
-		LogError:    true,
-		LogMethod:   true,
-		LogLatency:  true,
-		LogRemoteIP: true,
-		LogValuesFunc: func(c echo.Context, v echoMiddleware.RequestLoggerValues) error {
-			logger.Info("request",
-				zap.String("id", v.RequestID),
-				zap.String("method", v.Method),
-				zap.String("uri", v.URI),
-				zap.Int("status", v.Status),
-				zap.Duration("latency", v.Latency),
-				zap.String("remote_ip", v.RemoteIP),
-				zap.Error(v.Error),
-			)
-			return nil
+	available := map[string]func() echo.MiddlewareFunc{
+		"request_id": func() echo.MiddlewareFunc {
+			return echoMiddleware.RequestID()
+		},
+		"logger": func() echo.MiddlewareFunc {
+			return ratelimiterMiddleware.RequestLogger(logger, cfg.Logger)
+		},
+		"recover": func() echo.MiddlewareFunc {
+			return echoMiddleware.Recover()
+		},
+		"cors": func() echo.MiddlewareFunc {
+			return echoMiddleware.CORS()
+		},
+		"maintenance": func() echo.MiddlewareFunc {
+			return ratelimiterMiddleware.MaintenanceWithConfig(ratelimiterMiddleware.MaintenanceConfig{
+				Skipper: skipMaintenance,
+				Service: rateLimiterService,
+				Logger:  logger,
+			})
 		},
-	}))
-
-	// Recover middleware
-	e.Use(echoMiddleware.Recover())
-
-	// CORS middleware
-	e.Use(echoMiddleware.CORS())
-
-	// Health check endpoint (must be before rate limiter to avoid rate limiting)
-	e.GET("/health", func(c echo.Context) error {
-		return c.JSON(http.StatusOK, map[string]string{
-			"status": "ok",
-		})
-	})
-
-	// Rate limiter middleware (applied to all routes except health check)
-	e.Use(ratelimiterMiddleware.RateLimiterMiddleware(
-		rateLimiterService,
-		logger,
-		cfg.RateLimit.DefaultLimit,
-	))
+		"debounce": func() echo.MiddlewareFunc {
+			return ratelimiterMiddleware.DebounceWithConfig(ratelimiterMiddleware.DebounceConfig{
+				Skipper:                 skipInternal,
+				Service:                 rateLimiterService,
+				Logger:                  logger,
+				MaxBodySize:             cfg.RateLimit.DebounceMaxBody,
+				MaxIdentityLength:       cfg.RateLimit.MaxIdentityLength,
+				OversizedIdentityPolicy: cfg.RateLimit.OversizedIdentityPolicy,
+			})
+		},
+		"rate_limit": func() echo.MiddlewareFunc {
+			return ratelimiterMiddleware.RateLimiterMiddlewareWithConfig(ratelimiterMiddleware.RateLimiterConfig{
+				Skipper:                 skipInternal,
+				Service:                 rateLimiterService,
+				Logger:                  logger,
+				DefaultLimit:            cfg.RateLimit.DefaultLimit,
+				DisconnectPolicy:        cfg.RateLimit.DisconnectPolicy,
+				FatalErrorPolicy:        cfg.RateLimit.FatalErrorPolicy,
+				FailClosed:              cfg.RateLimit.FailClosed,
+				DryRun:                  cfg.RateLimit.DryRun,
+				TrustedIdentities:       cfg.RateLimit.TrustedIdentities,
+				IdentityPolicy:          cfg.RateLimit.IdentityPolicy,
+				JWTSecret:               []byte(cfg.RateLimit.JWTSecret),
+				MaxIdentityLength:       cfg.RateLimit.MaxIdentityLength,
+				OversizedIdentityPolicy: cfg.RateLimit.OversizedIdentityPolicy,
+				TrustedUpstreams:        cfg.RateLimit.TrustedUpstreams,
+				AllowlistCIDRs:          cfg.RateLimit.AllowlistCIDRs,
+				MaxLimitOverride:        cfg.RateLimit.MaxLimitOverride,
+				RemainingGranularity:    cfg.RateLimit.RemainingGranularity,
+				RemainingFloor:          cfg.RateLimit.RemainingFloor,
+				HeaderStyle:             cfg.RateLimit.HeaderStyle,
+				ScopeByAPIVersion:       cfg.RateLimit.ScopeByAPIVersion,
+				ScopeByRoute:            cfg.RateLimit.ScopeByRoute,
+				EndpointAlgorithms:      cfg.RateLimit.EndpointAlgorithms,
+				Debug:                   cfg.Debug,
+			})
+		},
+		"egress": func() echo.MiddlewareFunc {
+			return ratelimiterMiddleware.EgressLimiterWithConfig(ratelimiterMiddleware.EgressLimiterConfig{
+				Skipper:                 skipInternal,
+				Service:                 rateLimiterService,
+				Logger:                  logger,
+				MaxIdentityLength:       cfg.RateLimit.MaxIdentityLength,
+				OversizedIdentityPolicy: cfg.RateLimit.OversizedIdentityPolicy,
+			})
+		},
+	}
+
+	for _, name := range cfg.API.Middleware {
+		if newMiddleware, ok := available[name]; ok {
+			e.Use(newMiddleware())
+		}
+	}
+
+	// Health check endpoints: liveness, and readiness checking Redis
+	health := handlers.NewHealth(redisClient, logger)
+	e.GET("/health", health.Live)
+	e.GET("/health/ready", health.Ready)
+
+	// Prometheus metrics endpoint
+	e.GET("/metrics", echo.WrapHandler(promhttp.HandlerFor(metrics, promhttp.HandlerOpts{})))
+}
+
+// skipInternal skips limiting for health checks and metric scrapes
+func skipInternal(c echo.Context) bool {
+	return c.Path() == "/health" || c.Path() == "/health/ready" || c.Path() == "/metrics"
+}
+
+// skipMaintenance keeps health checks, metric scrapes and the maintenance
+// toggle itself available during maintenance
+func skipMaintenance(c echo.Context) bool {
+	return skipInternal(c) || c.Path() == "/api/v1/admin/maintenance"
 }
 
 // setupRoutes configures API routes
@@ -119,16 +185,21 @@ func (s *Server) Start() error {
 		zap.String("env", s.config.App.Env),
 	)
 
-	server := &http.Server{
-		Addr:         addr,
-		ReadTimeout:  s.config.API.ReadTimeout,
-		WriteTimeout: s.config.API.WriteTimeout,
-		IdleTimeout:  s.config.API.IdleTimeout,
-	}
+	// Configure echo's own server, the one Shutdown drains
+	server := s.echo.Server
+	server.Addr = addr
+	server.ReadTimeout = s.config.API.ReadTimeout
+	server.WriteTimeout = s.config.API.WriteTimeout
+	server.IdleTimeout = s.config.API.IdleTimeout
 
 	return s.echo.StartServer(server)
 }
 
+// ServeHTTP serves a single request through the middleware and routes
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.echo.ServeHTTP(w, r)
+}
+
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.Info("shutting down HTTP server")
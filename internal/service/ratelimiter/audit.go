@@ -0,0 +1,33 @@
+package ratelimiter
+
+import (
+	"time"
+
+	"ratelimit-challenge/pkg/audit"
+
+	"go.uber.org/zap"
+)
+
+// recordEvent writes an audit event, logging instead of failing the request
+// when the sink rejects it
+func (s *Service) recordEvent(eventType, userID string, code ErrorCode, limit int) {
+	s.writeEvent(audit.Event{
+		Type:   eventType,
+		UserID: userID,
+		Code:   string(code),
+		Limit:  limit,
+	})
+}
+
+// writeEvent timestamps and writes an audit event, logging instead of
+// failing the request when the sink rejects it
+func (s *Service) writeEvent(event audit.Event) {
+	event.Time = time.Now()
+	if err := s.audit.Write(event); err != nil {
+		s.logger.Warn("failed to write audit event",
+			zap.String("type", event.Type),
+			zap.String("user_id", event.UserID),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,204 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"ratelimit-challenge/pkg/audit"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// campaignKey holds the active campaign; it expires when the campaign ends
+const campaignKey = "rate_limit:campaign"
+
+// campaignCacheTTL is how long an instance reuses the active campaign's end,
+// skipping the campaign script entirely while none is running
+const campaignCacheTTL = time.Second
+
+// campaignKeys returns the namespaced campaign key and the key counting the
+// requests taken from the campaign pool
+// The latter is hash tagged with the former's name, so a Redis Cluster keeps
+// both in the slot the campaign key hashes to, as the campaign script needs
+func (s *Service) campaignKeys() (string, string) {
+	key := s.key(campaignKey)
+	return key, "{" + key + "}:used"
+}
+
+// Campaign is a pool of requests shared by all users until it ends
+// Once the pool is exhausted every request is denied until EndsAt
+type Campaign struct {
+	Name   string    `json:"name"`
+	Total  int       `json:"total"`
+	EndsAt time.Time `json:"ends_at"`
+	Used   int       `json:"used"`
+}
+
+// campaignScript takes one request from the campaign pool
+// Returns -1 when no campaign is active, 1 if the request fits in the pool
+// and 0 once the pool is exhausted
+const campaignScript = `
+	local ends_at = redis.call('HGET', KEYS[1], 'ends_at')
+	if not ends_at then
+		return -1
+	end
+	local total = tonumber(redis.call('HGET', KEYS[1], 'total'))
+	local used = redis.call('INCR', KEYS[2])
+	if used == 1 then
+		redis.call('PEXPIREAT', KEYS[2], ends_at)
+	end
+	if used > total then
+		return 0
+	end
+	return 1
+`
+
+// campaignLua runs campaignScript with EVALSHA, falling back to EVAL when
+// Redis doesn't have it cached yet
+var campaignLua = redis.NewScript(campaignScript)
+
+// StartCampaign starts a campaign, replacing any active one
+// Other instances start drawing from its pool within a second
+func (s *Service) StartCampaign(ctx context.Context, campaign Campaign) error {
+	if campaign.Total <= 0 {
+		return fmt.Errorf("campaign total must be greater than 0")
+	}
+	if !campaign.EndsAt.After(time.Now()) {
+		return fmt.Errorf("campaign must end in the future")
+	}
+
+	key, usedKey := s.campaignKeys()
+	pipe := s.redisClient.TxPipeline()
+	pipe.Del(ctx, key, usedKey)
+	pipe.HSet(ctx, key,
+		"name", campaign.Name,
+		"total", campaign.Total,
+		"ends_at", campaign.EndsAt.UnixMilli(),
+	)
+	pipe.PExpireAt(ctx, key, campaign.EndsAt)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to start campaign: %w", err)
+	}
+	s.cacheCampaign(campaign.EndsAt)
+
+	s.logger.Info("campaign started",
+		zap.String("name", campaign.Name),
+		zap.Int("total", campaign.Total),
+		zap.Time("ends_at", campaign.EndsAt),
+	)
+
+	return nil
+}
+
+// EndCampaign ends the active campaign, if any
+func (s *Service) EndCampaign(ctx context.Context) error {
+	key, usedKey := s.campaignKeys()
+	if err := s.redisClient.Del(ctx, key, usedKey).Err(); err != nil {
+		return fmt.Errorf("failed to end campaign: %w", err)
+	}
+	s.cacheCampaign(time.Time{})
+
+	s.logger.Info("campaign ended")
+
+	return nil
+}
+
+// GetCampaign returns the active campaign, or nil if there is none
+func (s *Service) GetCampaign(ctx context.Context) (*Campaign, error) {
+	key, usedKey := s.campaignKeys()
+	fields, err := s.redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get campaign: %w", err)
+	}
+	if len(fields) == 0 {
+		s.cacheCampaign(time.Time{})
+		return nil, nil
+	}
+
+	used, err := s.redisClient.Get(ctx, usedKey).Int()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get campaign usage: %w", err)
+	}
+
+	campaign := campaignFromFields(fields, used)
+	s.cacheCampaign(campaign.EndsAt)
+	return campaign, nil
+}
+
+// campaignFromFields builds a Campaign from its stored hash and the number
+// of requests taken from its pool
+func campaignFromFields(fields map[string]string, used int) *Campaign {
+	total, _ := strconv.Atoi(fields["total"])
+	endsAt, _ := strconv.ParseInt(fields["ends_at"], 10, 64)
+	if used > total {
+		used = total
+	}
+
+	return &Campaign{
+		Name:   fields["name"],
+		Total:  total,
+		EndsAt: time.UnixMilli(endsAt),
+		Used:   used,
+	}
+}
+
+// checkCampaign takes a request from the campaign pool
+// Returns false only when a campaign is active and its pool is exhausted
+func (s *Service) checkCampaign(ctx context.Context, userID string) (bool, error) {
+	active, err := s.campaignActive(ctx)
+	if err != nil || !active {
+		return true, err
+	}
+
+	key, usedKey := s.campaignKeys()
+	result, err := campaignLua.Run(ctx, s.redisClient, []string{key, usedKey}).Int()
+	if err != nil {
+		return true, fmt.Errorf("campaign check failed: %w", err)
+	}
+	switch result {
+	case -1:
+		// Ended, or was ended on another instance
+		s.cacheCampaign(time.Time{})
+	case 0:
+		s.recordEvent(audit.EventDenied, userID, CodeGlobalLimited, 0)
+		return false, nil
+	}
+	return true, nil
+}
+
+// campaignActive reports whether a campaign is running
+// Its end is cached briefly, so Redis isn't queried on every request
+func (s *Service) campaignActive(ctx context.Context) (bool, error) {
+	now := time.Now()
+	s.cacheMutex.RLock()
+	if now.Before(s.campaignExpiry) {
+		endsAt := s.campaignEndsAt
+		s.cacheMutex.RUnlock()
+		return now.Before(endsAt), nil
+	}
+	s.cacheMutex.RUnlock()
+
+	key, _ := s.campaignKeys()
+	endsAt, err := s.redisClient.HGet(ctx, key, "ends_at").Int64()
+	switch {
+	case err == redis.Nil:
+		s.cacheCampaign(time.Time{})
+		return false, nil
+	case err != nil:
+		return false, fmt.Errorf("campaign check failed: %w", err)
+	}
+
+	s.cacheCampaign(time.UnixMilli(endsAt))
+	return now.Before(time.UnixMilli(endsAt)), nil
+}
+
+// cacheCampaign stores when the active campaign ends in the local cache
+func (s *Service) cacheCampaign(endsAt time.Time) {
+	s.cacheMutex.Lock()
+	s.campaignEndsAt = endsAt
+	s.campaignExpiry = time.Now().Add(campaignCacheTTL)
+	s.cacheMutex.Unlock()
+}
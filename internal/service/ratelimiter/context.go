@@ -0,0 +1,114 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+)
+
+type contextKey int
+
+const (
+	algorithmKey contextKey = iota
+	limitOverrideKey
+	apiVersionKey
+	windowKey
+	costKey
+	requestCostKey
+	routeKey
+	scopeKey
+)
+
+// WithAlgorithm returns a context that makes the service use the given
+// algorithm instead of the configured one for calls made with it
+// Callers are responsible for only honoring this for trusted requests
+func WithAlgorithm(ctx context.Context, algorithm string) context.Context {
+	return context.WithValue(ctx, algorithmKey, algorithm)
+}
+
+// algorithmFromContext returns the algorithm override, if any
+func algorithmFromContext(ctx context.Context) (string, bool) {
+	algorithm, ok := ctx.Value(algorithmKey).(string)
+	return algorithm, ok
+}
+
+// WithLimitOverride returns a context that makes the service use the given
+// limit for calls made with it, skipping the user's custom and default limits
+// Callers are responsible for only honoring this for trusted requests
+func WithLimitOverride(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, limitOverrideKey, limit)
+}
+
+// limitOverrideFromContext returns the limit override, if any
+func limitOverrideFromContext(ctx context.Context) (int, bool) {
+	limit, ok := ctx.Value(limitOverrideKey).(int)
+	return limit, ok
+}
+
+// WithAPIVersion returns a context that scopes calls made with it to the given
+// API version, e.g. "v2"
+// Each version's requests are counted separately, and limited by its entry
+// in APIVersionLimits for users without a custom limit
+func WithAPIVersion(ctx context.Context, version string) context.Context {
+	return context.WithValue(ctx, apiVersionKey, version)
+}
+
+// apiVersionFromContext returns the API version, if any
+func apiVersionFromContext(ctx context.Context) (string, bool) {
+	version, ok := ctx.Value(apiVersionKey).(string)
+	return version, ok && version != ""
+}
+
+// WithRoute returns a context that counts calls made with it in a window of
+// the route's own, e.g. "/api/v1/login", apart from the user's other
+// requests
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey, route)
+}
+
+// routeFromContext returns the route, if any
+func routeFromContext(ctx context.Context) (string, bool) {
+	route, ok := ctx.Value(routeKey).(string)
+	return route, ok && route != ""
+}
+
+// WithScope returns a context that gives calls made with it a budget of the
+// scope's own, e.g. "write", with a custom limit of its own if one is set
+// for the scope, else the user's
+// Unlike WithRoute, the scope's limit still comes from the user's config
+func WithScope(ctx context.Context, scope string) context.Context {
+	return context.WithValue(ctx, scopeKey, scope)
+}
+
+// scopeFromContext returns the scope, if any
+func scopeFromContext(ctx context.Context) (string, bool) {
+	scope, ok := ctx.Value(scopeKey).(string)
+	return scope, ok && scope != ""
+}
+
+// WithWindow returns a context that makes the service use the given window
+// instead of the configured one for calls made with it, e.g. to report a
+// user's remaining requests over the window they are actually limited by
+func WithWindow(ctx context.Context, window time.Duration) context.Context {
+	return context.WithValue(ctx, windowKey, window)
+}
+
+// windowFromContext returns the window override, if any
+func windowFromContext(ctx context.Context) (time.Duration, bool) {
+	window, ok := ctx.Value(windowKey).(time.Duration)
+	return window, ok && window > 0
+}
+
+// WithRequestCost returns a context that makes Check count the request
+// made with it as n slots instead of one, e.g. for an expensive endpoint
+// Callers are responsible for only honoring this for trusted requests
+func WithRequestCost(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, requestCostKey, n)
+}
+
+// requestCostFromContext returns the request's cost, 1 unless set
+func requestCostFromContext(ctx context.Context) int {
+	if n, ok := ctx.Value(requestCostKey).(int); ok && n > 0 {
+		return n
+	}
+	return 1
+}
@@ -0,0 +1,172 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"ratelimit-challenge/pkg/audit"
+
+	"go.uber.org/zap"
+)
+
+// CostAccumulator collects the cost of a request's limited sub-operations,
+// so it can be consumed from the user's limit in one step once the request
+// completes, or dropped if it fails
+// It is safe for concurrent use by the request's goroutines
+type CostAccumulator struct {
+	mu    sync.Mutex
+	total int
+}
+
+// Add adds n slots to the request's cost
+func (a *CostAccumulator) Add(n int) {
+	if n <= 0 {
+		return
+	}
+	a.mu.Lock()
+	a.total += n
+	a.mu.Unlock()
+}
+
+// Total returns the request's cost so far
+func (a *CostAccumulator) Total() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.total
+}
+
+// WithCostAccumulator returns a context carrying a new cost accumulator,
+// for handlers called with it to add their sub-operations' cost to
+func WithCostAccumulator(ctx context.Context) (context.Context, *CostAccumulator) {
+	accumulator := &CostAccumulator{}
+	return context.WithValue(ctx, costKey, accumulator), accumulator
+}
+
+// AddCost adds n slots to the cost of the request ctx belongs to
+// Reports false if ctx carries no accumulator, e.g. because the rate limiter
+// middleware didn't run, in which case the cost isn't counted
+func AddCost(ctx context.Context, n int) bool {
+	accumulator, ok := ctx.Value(costKey).(*CostAccumulator)
+	if !ok {
+		return false
+	}
+	accumulator.Add(n)
+	return true
+}
+
+// RateLimitN is like RateLimit for a request costing n slots, e.g. the
+// accumulated cost of a handler's sub-operations; either all n are consumed
+// or none are
+// Only the user's own limit applies: penalties, campaigns and quotas are
+// checked once per request by RateLimit
+func (s *Service) RateLimitN(ctx context.Context, userID string, limit, n int) (bool, error) {
+	userID = s.NormalizeIdentity(userID)
+	if n <= 0 {
+		return true, nil
+	}
+
+	custom := s.costLimit(ctx, userID, limit)
+	if custom.blocked {
+		s.recordEvent(audit.EventDenied, userID, CodeBlocked, 0)
+		return false, nil
+	}
+	if custom.tiers != nil {
+		decision, err := s.checkTiers(ctx, limiterKey(ctx, userID), custom.tiers, n)
+		return decision.Allowed, err
+	}
+
+	allowed, err := s.limiter(ctx).AllowN(ctx, limiterKey(ctx, userID), custom.limit, n, s.window(ctx))
+	if err != nil {
+		return false, fmt.Errorf("rate limit check failed: %w", err)
+	}
+	if !allowed {
+		s.recordEvent(audit.EventDenied, userID, CodeRateLimited, custom.limit)
+	}
+	return allowed, nil
+}
+
+// ConsumeN charges n slots of a request that has already been served, e.g.
+// its accumulated cost, returning how many were charged
+// Unlike RateLimitN a cost larger than what is left isn't dropped: whatever
+// remains is consumed instead, so the user's next requests are denied until
+// the window frees up again
+func (s *Service) ConsumeN(ctx context.Context, userID string, limit, n int) (int, error) {
+	userID = s.NormalizeIdentity(userID)
+	if n <= 0 {
+		return 0, nil
+	}
+
+	custom := s.costLimit(ctx, userID, limit)
+	if custom.blocked {
+		return 0, nil
+	}
+	key := limiterKey(ctx, userID)
+
+	if custom.tiers != nil {
+		decision, err := s.checkTiers(ctx, key, custom.tiers, n)
+		if err != nil || decision.Allowed {
+			return n, err
+		}
+		remaining, _, err := s.tiersRemaining(ctx, key, custom.tiers)
+		if err != nil || remaining <= 0 {
+			return 0, err
+		}
+		decision, err = s.checkTiers(ctx, key, custom.tiers, min(remaining, n))
+		if err != nil || !decision.Allowed {
+			return 0, err
+		}
+		return min(remaining, n), nil
+	}
+
+	limiter := s.limiter(ctx)
+	windowSize := s.window(ctx)
+	capacity, err := limiter.CheckN(ctx, key, custom.limit, n, windowSize)
+	if err != nil {
+		return 0, fmt.Errorf("rate limit check failed: %w", err)
+	}
+	if capacity.Allowed {
+		return n, nil
+	}
+	if capacity.Available <= 0 {
+		return 0, nil
+	}
+
+	// Drain what was left; if a concurrent request took some of it first the
+	// window is already exhausted and the rest goes uncharged
+	allowed, err := limiter.AllowN(ctx, key, custom.limit, capacity.Available, windowSize)
+	if err != nil {
+		return 0, fmt.Errorf("rate limit check failed: %w", err)
+	}
+	if !allowed {
+		return 0, nil
+	}
+	return capacity.Available, nil
+}
+
+// costLimit returns the user's limit a cost is charged against, falling back
+// to limit, the way RateLimit resolves it
+func (s *Service) costLimit(ctx context.Context, userID string, limit int) userConfig {
+	if override, ok := limitOverrideFromContext(ctx); ok {
+		return userConfig{limit: s.windowLimit(ctx, override)}
+	}
+
+	custom, err := s.getUserConfig(ctx, userID)
+	if err != nil {
+		s.logger.Warn("failed to get user limit, using provided limit",
+			zap.String("user_id", userID),
+			zap.Int("fallback_limit", limit),
+			zap.Error(err),
+		)
+		custom = userConfig{limit: limit}
+	}
+	if custom.blocked || custom.tiers != nil {
+		return custom
+	}
+
+	if custom.limit == 0 {
+		custom.limit, _ = s.baseLimit(ctx, limit)
+	}
+	custom.limit = s.windowLimit(ctx, custom.limit)
+	return custom
+}
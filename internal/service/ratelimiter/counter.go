@@ -0,0 +1,31 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// counterScript counts one more event, starting a new window when the count
+// is first created
+// Returns the number of events in the current window
+const counterScript = `
+	local count = redis.call('INCR', KEYS[1])
+	if count == 1 then
+		redis.call('PEXPIRE', KEYS[1], ARGV[1])
+	end
+	return count
+`
+
+// counterLua is counterScript, sent by its SHA once Redis has it
+var counterLua = redis.NewScript(counterScript)
+
+// countInWindow counts one more event under key, in fixed windows of the
+// given length starting with the first event
+func (s *Service) countInWindow(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return counterLua.Run(ctx, s.redisClient, []string{key},
+		strconv.FormatInt(window.Milliseconds(), 10),
+	).Int64()
+}
@@ -0,0 +1,30 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+)
+
+// DebounceEnabled reports whether identical requests in quick succession are
+// treated as one
+func (s *Service) DebounceEnabled() bool {
+	return s.config.DebounceInterval > 0
+}
+
+// FirstOfDuplicates reports whether a request is the first of a run of
+// identical ones, e.g. a double-clicked submit, marking it so identical
+// requests within DebounceInterval report false
+// fingerprint identifies the request, e.g. a hash of its endpoint and body
+func (s *Service) FirstOfDuplicates(ctx context.Context, userID, fingerprint string) (bool, error) {
+	userID = s.NormalizeIdentity(userID)
+	if !s.DebounceEnabled() {
+		return true, nil
+	}
+
+	key := s.key(fmt.Sprintf("rate_limit:debounce:%s:%s", escapeIdentity(userID), fingerprint))
+	first, err := s.redisClient.SetNX(ctx, key, 1, s.config.DebounceInterval).Result()
+	if err != nil {
+		return true, fmt.Errorf("failed to check for duplicate request: %w", err)
+	}
+	return first, nil
+}
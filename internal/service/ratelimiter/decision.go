@@ -0,0 +1,121 @@
+package ratelimiter
+
+import "ratelimit-challenge/pkg/ratelimiter"
+
+// ErrorCode is a stable, machine-readable reason for a denied request
+type ErrorCode string
+
+const (
+	// CodeRateLimited means the user exceeded their own limit
+	CodeRateLimited ErrorCode = "RATE_LIMITED"
+	// CodeGlobalLimited means a limit shared by all users was exhausted
+	CodeGlobalLimited ErrorCode = "GLOBAL_LIMITED"
+	// CodeIPLimited means the client, identified by IP address, exceeded its limit
+	CodeIPLimited ErrorCode = "IP_LIMITED"
+	// CodeQuotaExceeded means the user used up their quota for the current
+	// billing period
+	CodeQuotaExceeded ErrorCode = "QUOTA_EXCEEDED"
+	// CodeBlocked means the user is blocked regardless of their usage
+	CodeBlocked ErrorCode = "BLOCKED"
+	// CodeDegraded means the limiter couldn't make a decision
+	CodeDegraded ErrorCode = "DEGRADED"
+	// CodeMisconfigured means the limiter can't reach a decision until its
+	// configuration is fixed, e.g. Redis rejected its credentials
+	CodeMisconfigured ErrorCode = "MISCONFIGURED"
+)
+
+// Reason is why a rate limit check reached its decision, allowed or denied
+// The set is fixed so it can label metrics
+type Reason string
+
+const (
+	// ReasonUnderLimit means the request fit within the user's limit
+	ReasonUnderLimit Reason = "under_limit"
+	// ReasonOverLimit means the request exceeded the user's limit or quota;
+	// it may still be allowed as a soft overage
+	ReasonOverLimit Reason = "over_limit"
+	// ReasonBlocked means the user is blocked regardless of their usage
+	ReasonBlocked Reason = "blocked"
+	// ReasonGlobalLimit means a limit shared by all users was exhausted
+	ReasonGlobalLimit Reason = "global_limit"
+	// ReasonDegraded means the limiter couldn't make a decision
+	ReasonDegraded Reason = "degraded"
+	// ReasonBypassed means the request was allowed without being counted,
+	// e.g. a new user's first request or a check that is disabled
+	ReasonBypassed Reason = "bypassed"
+)
+
+// Reasons lists every Reason
+var Reasons = []Reason{
+	ReasonUnderLimit,
+	ReasonOverLimit,
+	ReasonBlocked,
+	ReasonGlobalLimit,
+	ReasonDegraded,
+	ReasonBypassed,
+}
+
+// Decision is the outcome of a rate limit check
+type Decision struct {
+	// Allowed reports whether the request may proceed
+	Allowed bool
+	// Code explains why the request was denied, empty when allowed
+	Code ErrorCode
+	// Reason explains why the request was allowed or denied
+	Reason Reason
+	// SoftOverage flags an allowed request that was over the limit, let
+	// through as one of the user's SoftOverages
+	SoftOverage bool
+	// Result is the user's remaining requests and when they may retry, as
+	// reported by the limiter that decided; nil when something else decided,
+	// e.g. a block, a quota or burst and sustained tiers
+	Result *Result
+}
+
+// failureCode returns the code for a check that failed with err
+func failureCode(err error) ErrorCode {
+	if ratelimiter.IsFatal(err) {
+		return CodeMisconfigured
+	}
+	return CodeDegraded
+}
+
+// Check is one dimension of a composite limit passed to AllowAll
+type Check = ratelimiter.Check
+
+// Result is the outcome of a single Check
+type Result = ratelimiter.Result
+
+// AllAllowed reports whether every check of a batch was allowed; a check that
+// failed counts as denied
+func AllAllowed(results []Result) bool {
+	for _, result := range results {
+		if !result.Allowed || result.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// LimitSource names where a user's effective limit came from
+type LimitSource string
+
+const (
+	// LimitSourceUser is the user's own custom limit
+	LimitSourceUser LimitSource = "user"
+	// LimitSourceAPIVersion is the limit configured for the request's API
+	// version
+	LimitSourceAPIVersion LimitSource = "api_version"
+	// LimitSourceDefault is the default limit set at runtime
+	LimitSourceDefault LimitSource = "default"
+	// LimitSourceFallback is the limit given by the caller, used when nothing
+	// else applies or the user's limit couldn't be looked up
+	LimitSourceFallback LimitSource = "fallback"
+)
+
+// Stats is a user's current rate limit state
+type Stats struct {
+	ratelimiter.Stats
+	// Source is where the user's limit came from
+	Source LimitSource
+}
@@ -0,0 +1,41 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ratelimit-challenge/pkg/audit"
+)
+
+// DistinctEnabled reports whether the distinct resources users access are
+// limited
+func (s *Service) DistinctEnabled() bool {
+	return s.config.DistinctLimit > 0
+}
+
+// CheckResource checks the user's access to a resource, e.g. a document ID
+// Users are denied once they have accessed DistinctLimit other resources in
+// the current distinct window; resources they already accessed in it are
+// always allowed and cost nothing
+func (s *Service) CheckResource(ctx context.Context, userID, resourceID string) (Decision, error) {
+	userID = s.NormalizeIdentity(userID)
+	if !s.DistinctEnabled() {
+		return Decision{Allowed: true, Reason: ReasonBypassed}, nil
+	}
+
+	allowed, err := s.distinct.Allow(ctx, escapeIdentity(userID), resourceID, s.config.DistinctLimit, s.distinctWindow())
+	if err != nil {
+		return Decision{Allowed: false, Code: failureCode(err), Reason: ReasonDegraded}, fmt.Errorf("distinct resource check failed: %w", err)
+	}
+	if !allowed {
+		s.recordEvent(audit.EventDenied, userID, CodeRateLimited, s.config.DistinctLimit)
+		return Decision{Allowed: false, Code: CodeRateLimited, Reason: ReasonOverLimit}, nil
+	}
+	return Decision{Allowed: true, Reason: ReasonUnderLimit}, nil
+}
+
+// distinctWindow returns the window distinct resources are counted over
+func (s *Service) distinctWindow() time.Duration {
+	return time.Duration(s.config.DistinctWindow) * time.Second
+}
@@ -0,0 +1,28 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoWindowLog is returned by DumpWindow when the algorithm in effect
+// keeps no log of the requests it counted
+var ErrNoWindowLog = errors.New("only the sliding_window algorithm keeps a log of requests")
+
+// DumpWindow returns the times of at most max of the oldest requests in the
+// user's window (all of them if max is 0 or less) and how many it holds in
+// total, e.g. for support teams checking why a user was limited
+func (s *Service) DumpWindow(ctx context.Context, userID string, max int) ([]time.Time, int, error) {
+	if s.algorithm(ctx) != "sliding_window" {
+		return nil, 0, ErrNoWindowLog
+	}
+
+	userID = s.NormalizeIdentity(userID)
+	times, total, err := s.composite.DumpN(ctx, limiterKey(ctx, userID), s.window(ctx), max)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to dump window: %w", err)
+	}
+	return times, total, nil
+}
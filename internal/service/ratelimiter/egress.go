@@ -0,0 +1,87 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"ratelimit-challenge/pkg/audit"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// egressScript adds bytes to the user's egress count, starting a new window
+// when the count is first created
+// Returns the bytes counted in the current window
+const egressScript = `
+	local sent = redis.call('INCRBY', KEYS[1], ARGV[1])
+	if sent == tonumber(ARGV[1]) then
+		redis.call('PEXPIRE', KEYS[1], ARGV[2])
+	end
+	return sent
+`
+
+// egressLua sends egressScript by its SHA, as every response runs it
+var egressLua = redis.NewScript(egressScript)
+
+// egressKey returns the key counting the bytes sent to a user
+func (s *Service) egressKey(userID string) string {
+	return s.key(fmt.Sprintf("rate_limit:egress:%s", escapeIdentity(userID)))
+}
+
+// EgressEnabled reports whether responses are limited by size
+func (s *Service) EgressEnabled() bool {
+	return s.config.EgressLimit > 0
+}
+
+// CheckEgress reports whether the user may be sent another response
+// Users are denied once the bytes sent to them in the current egress window
+// reach EgressLimit
+func (s *Service) CheckEgress(ctx context.Context, userID string) (Decision, error) {
+	userID = s.NormalizeIdentity(userID)
+	if !s.EgressEnabled() {
+		return Decision{Allowed: true, Reason: ReasonBypassed}, nil
+	}
+
+	sent, err := s.GetEgress(ctx, userID)
+	if err != nil {
+		return Decision{Allowed: false, Code: failureCode(err), Reason: ReasonDegraded}, err
+	}
+	if sent >= s.config.EgressLimit {
+		s.recordEvent(audit.EventDenied, userID, CodeRateLimited, 0)
+		return Decision{Allowed: false, Code: CodeRateLimited, Reason: ReasonOverLimit}, nil
+	}
+	return Decision{Allowed: true, Reason: ReasonUnderLimit}, nil
+}
+
+// ConsumeEgress counts bytes sent to the user against their egress budget
+func (s *Service) ConsumeEgress(ctx context.Context, userID string, bytes int64) error {
+	userID = s.NormalizeIdentity(userID)
+	if !s.EgressEnabled() || bytes <= 0 {
+		return nil
+	}
+
+	window := time.Duration(s.config.EgressWindow) * time.Second
+	err := egressLua.Run(ctx, s.redisClient, []string{s.egressKey(userID)},
+		strconv.FormatInt(bytes, 10),
+		strconv.FormatInt(window.Milliseconds(), 10),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("failed to consume egress: %w", err)
+	}
+	return nil
+}
+
+// GetEgress returns the bytes sent to the user in the current egress window
+func (s *Service) GetEgress(ctx context.Context, userID string) (int64, error) {
+	userID = s.NormalizeIdentity(userID)
+	sent, err := s.redisClient.Get(ctx, s.egressKey(userID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get egress: %w", err)
+	}
+	return sent, nil
+}
@@ -0,0 +1,34 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"ratelimit-challenge/pkg/ratelimiter"
+
+	"go.uber.org/zap"
+)
+
+// checkFallback decides a request the limiter failed to check with err
+// using the in-memory fallback limiter, if it is enabled and Redis couldn't
+// be reached
+// The fallback only counts this instance's requests, so across instances a
+// user may be admitted up to their limit per instance until Redis is back
+func (s *Service) checkFallback(ctx context.Context, userID, key string, limit int, windowSize time.Duration, err error) (Decision, bool) {
+	if s.memoryFallback == nil || !ratelimiter.IsUnavailable(err) {
+		return Decision{}, false
+	}
+
+	capacity, _ := s.memoryFallback.CheckN(ctx, key, limit, requestCostFromContext(ctx), windowSize)
+	s.logger.Warn("redis unavailable, rate limiting in memory",
+		zap.String("user_id", userID),
+		zap.Bool("allowed", capacity.Allowed),
+		zap.Error(err),
+	)
+
+	result := capacity.Result(userID, limit)
+	if capacity.Allowed {
+		return Decision{Allowed: true, Reason: ReasonUnderLimit, Result: &result}, true
+	}
+	return Decision{Allowed: false, Code: CodeRateLimited, Reason: ReasonOverLimit, Result: &result}, true
+}
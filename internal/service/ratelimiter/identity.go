@@ -0,0 +1,74 @@
+package ratelimiter
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Identity normalization steps, applied in the order configured
+const (
+	// NormalizeTrim removes leading and trailing whitespace
+	NormalizeTrim = "trim"
+	// NormalizeLowercase folds the identity to lower case
+	NormalizeLowercase = "lowercase"
+	// NormalizeCanonical applies Unicode NFKC, so e.g. full-width and
+	// composed characters match their plain equivalents
+	NormalizeCanonical = "canonical"
+)
+
+// reservedIdentities are names kept for keys the service owns, such as
+// counters shared by all users, which no user's keys may take
+var reservedIdentities = map[string]bool{
+	"global":      true,
+	"default":     true,
+	"campaign":    true,
+	"maintenance": true,
+}
+
+// identityEscaper escapes the key separator, and the escape character so
+// escaped IDs stay unique
+// Braces are escaped too, so an ID can't carry a Redis Cluster hash tag and
+// pick the slot its keys land on
+var identityEscaper = strings.NewReplacer("%", "%25", ":", "%3A", "{", "%7B", "}", "%7D")
+
+// escapeIdentity returns the user ID as it appears in Redis keys
+// Separators are escaped, so e.g. user "alice:v2" can't share a key with
+// alice's v2 counter, and reserved names are namespaced with a bare "%",
+// which no escaped ID can start with
+func escapeIdentity(userID string) string {
+	userID = identityEscaper.Replace(userID)
+	if reservedIdentities[userID] {
+		return "%" + userID
+	}
+	return userID
+}
+
+// identityUnescaper reverses identityEscaper
+var identityUnescaper = strings.NewReplacer("%25", "%", "%3A", ":", "%7B", "{", "%7D", "}")
+
+// unescapeIdentity returns the user ID a key segment made by escapeIdentity
+// was escaped from
+func unescapeIdentity(escaped string) string {
+	if name := strings.TrimPrefix(escaped, "%"); reservedIdentities[name] {
+		return name
+	}
+	return identityUnescaper.Replace(escaped)
+}
+
+// NormalizeIdentity returns the user ID as it is keyed, after the configured
+// IdentityNormalization steps, so e.g. "User123 " and "user123" can share a
+// bucket
+func (s *Service) NormalizeIdentity(userID string) string {
+	for _, step := range s.config.IdentityNormalization {
+		switch step {
+		case NormalizeTrim:
+			userID = strings.TrimSpace(userID)
+		case NormalizeLowercase:
+			userID = strings.ToLower(userID)
+		case NormalizeCanonical:
+			userID = norm.NFKC.String(userID)
+		}
+	}
+	return userID
+}
@@ -0,0 +1,87 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// importBatchSize is the number of limits written per pipeline
+const importBatchSize = 1000
+
+// UserLimit is one entry of a bulk limit import
+type UserLimit struct {
+	UserID string `json:"user_id"`
+	Limit  int    `json:"limit"`
+}
+
+// ImportUserLimits sets custom limits from an NDJSON stream, one UserLimit
+// object per line
+// Entries are decoded and written in pipelined batches as they are read, so
+// memory use doesn't grow with the size of the input. progress, if not nil,
+// is called with the running total after every batch
+// On error, the returned count is the number of limits written before it
+func (s *Service) ImportUserLimits(ctx context.Context, r io.Reader, progress func(imported int)) (int, error) {
+	decoder := json.NewDecoder(r)
+	ttl := s.configTTL()
+	batch := make([]UserLimit, 0, importBatchSize)
+	imported := 0
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		pipe := s.configClient.Pipeline()
+		for _, entry := range batch {
+			pipe.Set(ctx, s.configKey(entry.UserID), entry.Limit, ttl)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return fmt.Errorf("failed to write user limits: %w", err)
+		}
+
+		imported += len(batch)
+		batch = batch[:0]
+		if progress != nil {
+			progress(imported)
+		}
+		return nil
+	}
+
+	for entryNum := 1; ; entryNum++ {
+		var entry UserLimit
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return imported, fmt.Errorf("entry %d: invalid JSON: %w", entryNum, err)
+		}
+		entry.UserID = s.NormalizeIdentity(entry.UserID)
+		if entry.UserID == "" {
+			return imported, fmt.Errorf("entry %d: user_id is required", entryNum)
+		}
+		if entry.Limit <= 0 {
+			return imported, fmt.Errorf("entry %d: limit must be greater than 0", entryNum)
+		}
+
+		batch = append(batch, entry)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return imported, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return imported, err
+	}
+
+	// Cached limits for imported users may now be stale
+	if s.config.EnableLocalCache {
+		s.userLimits.clear()
+	}
+
+	return imported, nil
+}
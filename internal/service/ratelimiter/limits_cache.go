@@ -0,0 +1,131 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// limitsCache is the local cache of custom user limits
+// It holds at most maxEntries entries (0 for no bound), evicting the least
+// recently used one to make room, and treats entries past their expiry as
+// missing
+type limitsCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	// order holds a *limitsCacheEntry per ID, most recently used first
+	order   *list.List
+	entries map[string]*list.Element
+	metrics *CacheMetrics
+}
+
+// limitsCacheEntry is the custom limits cached under an ID
+type limitsCacheEntry struct {
+	id     string
+	config userConfig
+	expiry time.Time
+}
+
+// newLimitsCache creates a cache of at most maxEntries entries (0 for no
+// bound), reporting its size and evictions to metrics, if not nil
+func newLimitsCache(maxEntries int, metrics *CacheMetrics) *limitsCache {
+	return &limitsCache{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+		metrics:    metrics,
+	}
+}
+
+// get returns the limits cached under id, if they haven't expired, marking
+// them as the most recently used
+func (c *limitsCache) get(id string, now time.Time) (userConfig, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[id]
+	if !ok {
+		return userConfig{}, false
+	}
+	entry := element.Value.(*limitsCacheEntry)
+	if !now.Before(entry.expiry) {
+		return userConfig{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.config, true
+}
+
+// set caches custom under id until expiry, evicting the least recently used
+// entries if the cache is full
+func (c *limitsCache) set(id string, custom userConfig, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(id, custom, expiry)
+}
+
+// setAll is like set for several entries at once
+func (c *limitsCache) setAll(configs map[string]userConfig, expiry time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, custom := range configs {
+		c.setLocked(id, custom, expiry)
+	}
+}
+
+// setLocked is set for callers holding the lock
+func (c *limitsCache) setLocked(id string, custom userConfig, expiry time.Time) {
+	if element, ok := c.entries[id]; ok {
+		entry := element.Value.(*limitsCacheEntry)
+		entry.config = custom
+		entry.expiry = expiry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[id] = c.order.PushFront(&limitsCacheEntry{id: id, config: custom, expiry: expiry})
+	evicted := 0
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeLocked(c.order.Back())
+		evicted++
+	}
+	c.metrics.recordSize(c.order.Len(), evicted)
+}
+
+// delete removes the limits cached under id, if any
+func (c *limitsCache) delete(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[id]; ok {
+		c.removeLocked(element)
+		c.metrics.recordSize(c.order.Len(), 0)
+	}
+}
+
+// clear removes every entry
+func (c *limitsCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	c.metrics.recordSize(0, 0)
+}
+
+// removeExpired removes the entries that have expired by now
+func (c *limitsCache) removeExpired(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for element := c.order.Back(); element != nil; {
+		prev := element.Prev()
+		if !now.Before(element.Value.(*limitsCacheEntry).expiry) {
+			c.removeLocked(element)
+		}
+		element = prev
+	}
+	c.metrics.recordSize(c.order.Len(), 0)
+}
+
+// removeLocked removes an entry, for callers holding the lock
+func (c *limitsCache) removeLocked(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*limitsCacheEntry).id)
+}
@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// maintenanceKey holds the Retry-After seconds while the API is in
+// maintenance mode; it is absent otherwise
+const maintenanceKey = "rate_limit:maintenance"
+
+// maintenanceCacheTTL is how long an instance reuses the maintenance flag
+const maintenanceCacheTTL = time.Second
+
+// MaintenanceStatus reports whether the API is in maintenance mode
+type MaintenanceStatus struct {
+	Active bool `json:"active"`
+	// RetryAfter is the number of seconds clients are told to wait
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// StartMaintenance puts the whole API into maintenance mode across all
+// instances, telling clients to retry after the given number of seconds
+// Other instances pick up the change within a second
+func (s *Service) StartMaintenance(ctx context.Context, retryAfter int) error {
+	if retryAfter <= 0 {
+		return fmt.Errorf("retry after must be greater than 0")
+	}
+	if err := s.redisClient.Set(ctx, s.key(maintenanceKey), retryAfter, 0).Err(); err != nil {
+		return fmt.Errorf("failed to start maintenance: %w", err)
+	}
+	s.cacheMaintenance(MaintenanceStatus{Active: true, RetryAfter: retryAfter})
+
+	s.logger.Info("maintenance mode started", zap.Int("retry_after", retryAfter))
+	return nil
+}
+
+// EndMaintenance takes the API out of maintenance mode
+func (s *Service) EndMaintenance(ctx context.Context) error {
+	if err := s.redisClient.Del(ctx, s.key(maintenanceKey)).Err(); err != nil {
+		return fmt.Errorf("failed to end maintenance: %w", err)
+	}
+	s.cacheMaintenance(MaintenanceStatus{})
+
+	s.logger.Info("maintenance mode ended")
+	return nil
+}
+
+// GetMaintenance reports whether the API is in maintenance mode
+// The flag is cached briefly so Redis isn't queried on every request
+func (s *Service) GetMaintenance(ctx context.Context) (MaintenanceStatus, error) {
+	s.cacheMutex.RLock()
+	if time.Now().Before(s.maintenanceExpiry) {
+		status := s.maintenanceCache
+		s.cacheMutex.RUnlock()
+		return status, nil
+	}
+	s.cacheMutex.RUnlock()
+
+	var status MaintenanceStatus
+	val, err := s.redisClient.Get(ctx, s.key(maintenanceKey)).Result()
+	switch {
+	case err == redis.Nil:
+		// Not in maintenance
+	case err != nil:
+		return MaintenanceStatus{}, fmt.Errorf("failed to get maintenance status: %w", err)
+	default:
+		retryAfter, err := parseInt(val)
+		if err != nil {
+			return MaintenanceStatus{}, fmt.Errorf("invalid maintenance retry after %q: %w", val, err)
+		}
+		status = MaintenanceStatus{Active: true, RetryAfter: retryAfter}
+	}
+
+	s.cacheMaintenance(status)
+	return status, nil
+}
+
+// cacheMaintenance stores the maintenance status in the local cache
+func (s *Service) cacheMaintenance(status MaintenanceStatus) {
+	s.cacheMutex.Lock()
+	s.maintenanceCache = status
+	s.maintenanceExpiry = time.Now().Add(maintenanceCacheTTL)
+	s.cacheMutex.Unlock()
+}
@@ -0,0 +1,221 @@
+package ratelimiter
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CacheMetrics counts lookups of custom user limits in the local cache
+// Negative hits are cached answers that the user has no custom limit
+type CacheMetrics struct {
+	hits         atomic.Uint64
+	misses       atomic.Uint64
+	negativeHits atomic.Uint64
+	entries      atomic.Int64
+	evictions    atomic.Uint64
+}
+
+// NewCacheMetrics creates the cache metrics and registers them with reg,
+// along with the derived hit ratio
+func NewCacheMetrics(reg prometheus.Registerer) *CacheMetrics {
+	m := &CacheMetrics{}
+	lookups := func(result string, counter *atomic.Uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "rate_limit_user_limit_cache_lookups_total",
+			Help:        "Lookups of custom user limits in the local cache, by result.",
+			ConstLabels: prometheus.Labels{"result": result},
+		}, func() float64 {
+			return float64(counter.Load())
+		})
+	}
+	reg.MustRegister(
+		lookups("hit", &m.hits),
+		lookups("miss", &m.misses),
+		lookups("negative_hit", &m.negativeHits),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "rate_limit_user_limit_cache_hit_ratio",
+			Help: "Share of custom user limit lookups answered by the local cache, including negative hits.",
+		}, m.hitRatio),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "rate_limit_user_limit_cache_entries",
+			Help: "Custom user limits held in the local cache.",
+		}, func() float64 {
+			return float64(m.entries.Load())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "rate_limit_user_limit_cache_evictions_total",
+			Help: "Custom user limits evicted from the full local cache to make room for others.",
+		}, func() float64 {
+			return float64(m.evictions.Load())
+		}),
+	)
+	return m
+}
+
+// hitRatio returns the share of lookups answered from the cache
+func (m *CacheMetrics) hitRatio() float64 {
+	hits := m.hits.Load() + m.negativeHits.Load()
+	total := hits + m.misses.Load()
+	if total == 0 {
+		return 0
+	}
+	return float64(hits) / float64(total)
+}
+
+// WithCacheMetrics counts local cache lookups of custom user limits in m
+func WithCacheMetrics(m *CacheMetrics) Option {
+	return func(s *Service) {
+		s.cacheMetrics = m
+	}
+}
+
+// recordLookup counts one local cache lookup of a custom user limit
+// It is a no-op on nil metrics, so callers needn't check they are configured
+func (m *CacheMetrics) recordLookup(cached bool, custom userConfig) {
+	switch {
+	case m == nil:
+	case !cached:
+		m.misses.Add(1)
+	case custom.limit == 0 && custom.tiers == nil && !custom.blocked:
+		m.negativeHits.Add(1)
+	default:
+		m.hits.Add(1)
+	}
+}
+
+// recordSize reports the local cache's size after a change, and how many
+// entries the change evicted
+// It is a no-op on nil metrics, so callers needn't check they are configured
+func (m *CacheMetrics) recordSize(entries, evicted int) {
+	if m == nil {
+		return
+	}
+	m.entries.Store(int64(entries))
+	m.evictions.Add(uint64(evicted))
+}
+
+// ResetMetrics counts resets of user counters refused by AllowReset
+type ResetMetrics struct {
+	limited prometheus.Counter
+}
+
+// NewResetMetrics creates the reset metrics and registers them with reg
+func NewResetMetrics(reg prometheus.Registerer) *ResetMetrics {
+	m := &ResetMetrics{
+		limited: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rate_limit_resets_limited_total",
+			Help: "Resets of a user's counter refused for exceeding the reset limit.",
+		}),
+	}
+	reg.MustRegister(m.limited)
+	return m
+}
+
+// WithResetMetrics counts refused resets in m
+func WithResetMetrics(m *ResetMetrics) Option {
+	return func(s *Service) {
+		s.resetMetrics = m
+	}
+}
+
+// recordLimited counts one refused reset
+// It is a no-op on nil metrics, so callers needn't check they are configured
+func (m *ResetMetrics) recordLimited() {
+	if m != nil {
+		m.limited.Inc()
+	}
+}
+
+// DecisionMetrics counts rate limit decisions by the reason they were reached
+type DecisionMetrics struct {
+	decisions *prometheus.CounterVec
+}
+
+// NewDecisionMetrics creates the decision metrics and registers them with reg
+// Every reason is initialized, so each series exists before its first decision
+func NewDecisionMetrics(reg prometheus.Registerer) *DecisionMetrics {
+	m := &DecisionMetrics{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limit_decisions_total",
+			Help: "Rate limit decisions, by the reason the request was allowed or denied.",
+		}, []string{"reason"}),
+	}
+	for _, reason := range Reasons {
+		m.decisions.WithLabelValues(string(reason))
+	}
+	reg.MustRegister(m.decisions)
+	return m
+}
+
+// WithDecisionMetrics counts rate limit decisions in m
+func WithDecisionMetrics(m *DecisionMetrics) Option {
+	return func(s *Service) {
+		s.decisionMetrics = m
+	}
+}
+
+// record counts one decision reached for reason
+// It is a no-op on nil metrics, so callers needn't check they are configured
+func (m *DecisionMetrics) record(reason Reason) {
+	if m != nil {
+		m.decisions.WithLabelValues(string(reason)).Inc()
+	}
+}
+
+// CheckMetrics counts rate limit checks by outcome and times them
+type CheckMetrics struct {
+	requests *prometheus.CounterVec
+	errors   prometheus.Counter
+	duration prometheus.Histogram
+}
+
+// NewCheckMetrics creates the check metrics and registers them with reg
+// Both decisions are initialized, so each series exists before its first check
+func NewCheckMetrics(reg prometheus.Registerer) *CheckMetrics {
+	m := &CheckMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limit_requests_total",
+			Help: "Rate limit checks that reached a decision, by whether the request was allowed or denied.",
+		}, []string{"decision"}),
+		errors: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rate_limit_errors_total",
+			Help: "Rate limit checks that failed to reach a decision.",
+		}),
+		duration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "rate_limit_check_duration_seconds",
+			Help:    "Duration of rate limit checks, including their Redis round trips.",
+			Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+		}),
+	}
+	m.requests.WithLabelValues("allowed")
+	m.requests.WithLabelValues("denied")
+	reg.MustRegister(m.requests, m.errors, m.duration)
+	return m
+}
+
+// WithCheckMetrics counts and times rate limit checks in m
+func WithCheckMetrics(m *CheckMetrics) Option {
+	return func(s *Service) {
+		s.checkMetrics = m
+	}
+}
+
+// record counts one check started at start; a failed check counts as an
+// error rather than a decision, since the caller decides whether to fail open
+// It is a no-op on nil metrics, so callers needn't check they are configured
+func (m *CheckMetrics) record(decision Decision, err error, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.duration.Observe(time.Since(start).Seconds())
+	switch {
+	case err != nil:
+		m.errors.Inc()
+	case decision.Allowed:
+		m.requests.WithLabelValues("allowed").Inc()
+	default:
+		m.requests.WithLabelValues("denied").Inc()
+	}
+}
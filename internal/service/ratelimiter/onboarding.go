@@ -0,0 +1,50 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// onboardedTTL is how long a user is remembered as having used their grace
+const onboardedTTL = 30 * 24 * time.Hour
+
+// firstRequest reports whether this is the user's first request, marking
+// them as onboarded so it only ever reports true once
+// Users are remembered locally once seen, for as long as custom limits are
+// cached, so only their first request in that time goes to Redis
+func (s *Service) firstRequest(ctx context.Context, userID string) (bool, error) {
+	if _, seen := s.onboarded.get(userID, time.Now()); seen {
+		return false, nil
+	}
+
+	first, err := s.redisClient.SetNX(ctx, s.onboardedKey(userID), 1, onboardedTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check onboarding grace: %w", err)
+	}
+	s.onboarded.set(userID, userConfig{}, s.cacheExpiry())
+	if first {
+		s.logger.Debug("allowing first request under onboarding grace",
+			zap.String("user_id", userID),
+		)
+	}
+	return first, nil
+}
+
+// forgetOnboarded forgets the user was ever seen, so their next request is
+// given the onboarding grace again
+// Other instances may still remember them until their local entry expires
+func (s *Service) forgetOnboarded(ctx context.Context, userID string) error {
+	s.onboarded.delete(userID)
+	if err := s.redisClient.Del(ctx, s.onboardedKey(userID)).Err(); err != nil {
+		return fmt.Errorf("failed to reset onboarding grace: %w", err)
+	}
+	return nil
+}
+
+// onboardedKey returns the key marking a user as having been seen
+func (s *Service) onboardedKey(userID string) string {
+	return s.key(fmt.Sprintf("rate_limit:onboarded:%s", escapeIdentity(userID)))
+}
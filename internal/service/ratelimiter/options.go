@@ -0,0 +1,59 @@
+package ratelimiter
+
+import (
+	"ratelimit-challenge/pkg/audit"
+	"ratelimit-challenge/pkg/ratelimiter"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures optional Service dependencies
+type Option func(*Service)
+
+// WithAuditSink records denials and penalties to the given sink
+func WithAuditSink(sink audit.Sink) Option {
+	return func(s *Service) {
+		s.audit = sink
+	}
+}
+
+// WithConfigClient stores user limits, the default limit and quota schedules
+// through client, e.g. one connected to a database of their own, instead of
+// alongside the limiters' counters
+func WithConfigClient(client redis.UniversalClient) Option {
+	return func(s *Service) {
+		s.configClient = client
+	}
+}
+
+// WithMetrics records the latency of the limiters' Redis calls to m
+func WithMetrics(m *ratelimiter.Metrics) Option {
+	return func(s *Service) {
+		s.limiterMetrics = m
+	}
+}
+
+// WithReplicaClient serves remaining-request reads from a replica, as
+// configured by ReplicaReads; rate limit checks always use the primary
+func WithReplicaClient(client redis.UniversalClient) Option {
+	return func(s *Service) {
+		s.replicaClient = client
+	}
+}
+
+// WithRegionPeers merges counters from the other regions' Redis when
+// MergeRegions runs, for the regional algorithm
+func WithRegionPeers(peers ...redis.UniversalClient) Option {
+	return func(s *Service) {
+		s.regionPeers = peers
+	}
+}
+
+// WithTracerProvider records a span for each check, and for the Redis calls
+// it makes, through tp instead of the globally registered provider
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *Service) {
+		s.tracerProvider = tp
+	}
+}
@@ -0,0 +1,47 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// softOverageKey returns the key counting a user's consecutive overages
+func (s *Service) softOverageKey(key string) string {
+	return s.key(fmt.Sprintf("rate_limit:soft_overage:%s", key))
+}
+
+// softOveragesEnabled reports whether users may briefly exceed their limit
+func (s *Service) softOveragesEnabled() bool {
+	return s.config.SoftOverages > 0
+}
+
+// allowSoftOverage counts a request over the user's limit, reporting whether
+// it is still within the SoftOverages allowed in a row
+func (s *Service) allowSoftOverage(ctx context.Context, key string) (bool, error) {
+	window := time.Duration(s.config.SoftOverageWindow) * time.Second
+	count, err := s.countInWindow(ctx, s.softOverageKey(key), window)
+	if err != nil {
+		return false, fmt.Errorf("failed to count soft overage: %w", err)
+	}
+
+	if count > int64(s.config.SoftOverages) {
+		return false, nil
+	}
+	s.logger.Debug("allowing soft overage",
+		zap.String("key", key),
+		zap.Int64("consecutive", count),
+	)
+	return true, nil
+}
+
+// clearSoftOverages ends a run of consecutive overages once a request is
+// allowed within the limit again
+func (s *Service) clearSoftOverages(ctx context.Context, key string) error {
+	if err := s.redisClient.Del(ctx, s.softOverageKey(key)).Err(); err != nil {
+		return fmt.Errorf("failed to clear soft overages: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Overview gathers everything a dashboard shows about a user's rate limit
+type Overview struct {
+	Stats
+	// Requests counted against Limit, i.e. Limit less Remaining
+	Used int
+	// When the user next gets a request back: the oldest request leaving
+	// the window, or the bucket draining. Zero when nothing is counted
+	ResetAt time.Time
+	// When the user's penalty ends, zero when they aren't penalized
+	PenaltyUntil time.Time
+	// The active campaign, nil when there is none
+	Campaign *Campaign
+}
+
+// GetOverview returns the user's limit, usage, reset time and any active
+// penalty or campaign in one call
+// The penalty and campaign state are read in a single pipeline alongside
+// the limiter's own reads
+func (s *Service) GetOverview(ctx context.Context, userID string, limit int) (*Overview, error) {
+	userID = s.NormalizeIdentity(userID)
+
+	campaignKey, campaignUsedKey := s.campaignKeys()
+	pipe := s.redisClient.Pipeline()
+	penaltyTTL := pipe.PTTL(ctx, s.penaltyKey(userID))
+	campaign := pipe.HGetAll(ctx, campaignKey)
+	campaignUsed := pipe.Get(ctx, campaignUsedKey)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get rate limit overview: %w", err)
+	}
+
+	stats, err := s.GetStats(ctx, userID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	overview := &Overview{Stats: stats}
+	overview.Used = stats.Limit - stats.Remaining
+	if overview.Used < 0 {
+		overview.Used = 0
+	}
+	switch {
+	case !stats.Oldest.IsZero():
+		overview.ResetAt = stats.Oldest.Add(s.window(ctx))
+	case stats.TimeToEmpty > 0:
+		overview.ResetAt = now.Add(stats.TimeToEmpty)
+	}
+
+	if ttl := penaltyTTL.Val(); ttl > 0 {
+		overview.PenaltyUntil = now.Add(ttl)
+	}
+	if fields := campaign.Val(); len(fields) > 0 {
+		used, _ := campaignUsed.Int()
+		overview.Campaign = campaignFromFields(fields, used)
+	}
+
+	return overview, nil
+}
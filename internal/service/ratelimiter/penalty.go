@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"ratelimit-challenge/pkg/audit"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// penaltyKey returns the key marking the user's penalty, expiring with it
+func (s *Service) penaltyKey(userID string) string {
+	return s.key(fmt.Sprintf("rate_limit:penalty:%s", escapeIdentity(userID)))
+}
+
+// overageKey returns the key counting the user's denials in the current
+// window; it is hash tagged with the penalty key's name, so a Redis Cluster
+// keeps both in one slot for the penalty script
+func (s *Service) overageKey(userID string) string {
+	return "{" + s.penaltyKey(userID) + "}:overage"
+}
+
+// inPenaltyBox reports whether the user is currently serving a penalty
+func (s *Service) inPenaltyBox(ctx context.Context, userID string) (bool, error) {
+	n, err := s.redisClient.Exists(ctx, s.penaltyKey(userID)).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check penalty box: %w", err)
+	}
+	return n > 0, nil
+}
+
+// penaltyScript counts a denial and penalizes the user atomically, so
+// concurrent denials can't skip past the threshold
+// Returns 1 when the user was placed in the penalty box
+const penaltyScript = `
+	local overage_key = KEYS[1]
+	local penalty_key = KEYS[2]
+	local window_size_ms = tonumber(ARGV[1])
+	local threshold = tonumber(ARGV[2])
+	local penalty_seconds = tonumber(ARGV[3])
+
+	local overage = redis.call('INCR', overage_key)
+	if overage == 1 then
+		redis.call('PEXPIRE', overage_key, window_size_ms)
+	end
+
+	if overage >= threshold then
+		redis.call('SET', penalty_key, 1, 'EX', penalty_seconds)
+		redis.call('DEL', overage_key)
+		return 1
+	end
+	return 0
+`
+
+// penaltyLua sends penaltyScript by its SHA, as every denial of a user
+// runs it
+var penaltyLua = redis.NewScript(penaltyScript)
+
+// trackOverage records a denied request and places the user in the penalty
+// box once their denials within one window reach PenaltyMultiplier times
+// their limit
+func (s *Service) trackOverage(ctx context.Context, userID string, limit int, windowSize time.Duration) error {
+	threshold := limit * s.config.PenaltyMultiplier
+
+	result, err := penaltyLua.Run(ctx, s.redisClient, []string{s.overageKey(userID), s.penaltyKey(userID)},
+		strconv.FormatInt(windowSize.Milliseconds(), 10),
+		strconv.Itoa(threshold),
+		strconv.Itoa(s.config.PenaltyDuration),
+	).Result()
+	if err != nil {
+		return fmt.Errorf("failed to track overage: %w", err)
+	}
+
+	if result.(int64) == 1 {
+		s.logger.Warn("user placed in penalty box",
+			zap.String("user_id", userID),
+			zap.Int("limit", limit),
+			zap.Int("overage", threshold),
+			zap.Int("penalty_seconds", s.config.PenaltyDuration),
+		)
+		s.recordEvent(audit.EventPenalty, userID, CodeBlocked, limit)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"ratelimit-challenge/pkg/ratelimiter"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// preloadBatchSize is the number of users whose limits are read per pipeline
+const preloadBatchSize = 1000
+
+// preloadConcurrency is the number of batches PreloadAllLimits reads at once
+const preloadConcurrency = 4
+
+// PreloadLimits loads the custom limits of the given users into the local
+// cache, e.g. for the hottest users after a deploy, so their first requests
+// don't each wait on a Redis lookup
+// Limits are read in pipelined batches; users without a custom limit are
+// cached as such, and users whose limit can't be parsed are skipped. It is a
+// no-op when the local cache is disabled
+func (s *Service) PreloadLimits(ctx context.Context, userIDs []string) error {
+	if !s.config.EnableLocalCache {
+		return nil
+	}
+
+	for start := 0; start < len(userIDs); start += preloadBatchSize {
+		end := start + preloadBatchSize
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		if err := s.preloadBatch(ctx, userIDs[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preloadBatch loads one pipelined batch of users' custom limits into the
+// local cache
+func (s *Service) preloadBatch(ctx context.Context, userIDs []string) error {
+	normalized := make([]string, len(userIDs))
+	pipe := s.configClient.Pipeline()
+	cmds := make([]*redis.StringCmd, len(userIDs))
+	for i, userID := range userIDs {
+		normalized[i] = s.NormalizeIdentity(userID)
+		cmds[i] = pipe.Get(ctx, s.configKey(normalized[i]))
+	}
+	// Errors are checked per command below
+	_, _ = pipe.Exec(ctx)
+
+	configs := make(map[string]userConfig, len(cmds))
+	for i, cmd := range cmds {
+		val, err := cmd.Result()
+		if err == redis.Nil {
+			configs[normalized[i]] = userConfig{}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to preload user limits: %w", err)
+		}
+		custom, err := parseUserConfig(val)
+		if err != nil {
+			s.logger.Warn("skipping invalid user limit",
+				zap.String("user_id", normalized[i]),
+				zap.Error(err),
+			)
+			continue
+		}
+		configs[normalized[i]] = custom
+	}
+
+	s.cacheUserConfigs(configs)
+	return nil
+}
+
+// PreloadAllLimits loads every configured custom limit, including scoped
+// ones, into the local cache, scanning Redis for them so it is never blocked,
+// and returns how many were read
+// Limits that can't be parsed are logged and skipped. It is a no-op when the local
+// cache is disabled
+func (s *Service) PreloadAllLimits(ctx context.Context) (int, error) {
+	if !s.config.EnableLocalCache {
+		return 0, nil
+	}
+
+	prefix := s.configKey("")
+	loaded, err := ratelimiter.ReadAll(ctx, s.configClient, prefix, preloadConcurrency, func(key, value string) {
+		custom, err := parseUserConfig(value)
+		if err != nil {
+			s.logger.Warn("skipping invalid user limit",
+				zap.String("key", key),
+				zap.Error(err),
+			)
+			return
+		}
+		s.cacheUserConfig(configCacheID(strings.TrimPrefix(key, prefix)), custom)
+	})
+	if err != nil {
+		return loaded, fmt.Errorf("failed to preload user limits: %w", err)
+	}
+	return loaded, nil
+}
+
+// configCacheID returns the ID the custom limits held under a config key,
+// with its prefix trimmed, are cached under: the user's, or their scope's
+func configCacheID(suffix string) string {
+	userID, scope, scoped := strings.Cut(suffix, ":scope:")
+	if scoped {
+		return scopedCacheID(unescapeIdentity(userID), unescapeIdentity(scope))
+	}
+	return unescapeIdentity(suffix)
+}
+
+// cacheUserConfigs stores several users' custom limits in the local cache at
+// once, if enabled, keyed by the ID each is cached under
+func (s *Service) cacheUserConfigs(configs map[string]userConfig) {
+	if !s.config.EnableLocalCache || len(configs) == 0 {
+		return
+	}
+	s.userLimits.setAll(configs, s.cacheExpiry())
+}
@@ -0,0 +1,172 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// Quota periods
+const (
+	// QuotaDaily resets every day at the anchor's time of day
+	QuotaDaily = "daily"
+	// QuotaMonthly resets every month on the anchor's day and time of day,
+	// or on the last day of months too short to have it
+	QuotaMonthly = "monthly"
+)
+
+// QuotaSchedule is a user's quota of requests per billing period, resetting
+// at times aligned to the user's anchor, e.g. their signup, rather than on a
+// boundary shared by everyone
+type QuotaSchedule struct {
+	Limit  int       `json:"limit"`
+	Period string    `json:"period"`
+	Anchor time.Time `json:"anchor"`
+}
+
+// QuotaUsage is how much of their quota a user has used this period
+type QuotaUsage struct {
+	Limit    int       `json:"limit"`
+	Used     int       `json:"used"`
+	ResetsAt time.Time `json:"resets_at"`
+}
+
+// Validate checks that the schedule has a positive limit, a known period
+// and an anchor
+func (q QuotaSchedule) Validate() error {
+	if q.Limit <= 0 {
+		return fmt.Errorf("quota limit must be greater than 0")
+	}
+	if q.Period != QuotaDaily && q.Period != QuotaMonthly {
+		return fmt.Errorf("quota period must be either '%s' or '%s'", QuotaDaily, QuotaMonthly)
+	}
+	if q.Anchor.IsZero() {
+		return fmt.Errorf("quota anchor is required")
+	}
+	return nil
+}
+
+// Bounds returns the start and end of the period containing now
+func (q QuotaSchedule) Bounds(now time.Time) (time.Time, time.Time) {
+	now = now.In(q.Anchor.Location())
+	if q.Period == QuotaDaily {
+		start := q.boundary(now.Year(), now.Month(), now.Day())
+		if now.Before(start) {
+			start = start.AddDate(0, 0, -1)
+		}
+		return start, start.AddDate(0, 0, 1)
+	}
+
+	start := q.boundary(now.Year(), now.Month(), q.Anchor.Day())
+	if now.Before(start) {
+		start = q.boundary(now.Year(), now.Month()-1, q.Anchor.Day())
+	}
+	return start, q.boundary(start.Year(), start.Month()+1, q.Anchor.Day())
+}
+
+// boundary returns the reset time on the given day at the anchor's time of
+// day, moving days past the end of the month back to its last day
+func (q QuotaSchedule) boundary(year int, month time.Month, day int) time.Time {
+	// Day 0 of the next month is the last day of this one
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, q.Anchor.Location()).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month, day,
+		q.Anchor.Hour(), q.Anchor.Minute(), q.Anchor.Second(), q.Anchor.Nanosecond(),
+		q.Anchor.Location())
+}
+
+// quotaScheduleKey returns the key holding a user's quota schedule
+func (s *Service) quotaScheduleKey(userID string) string {
+	return s.key(fmt.Sprintf("rate_limit:quota_schedule:%s", escapeIdentity(userID)))
+}
+
+// quotaKey returns the key counting a user's requests in the period starting
+// at start
+func (s *Service) quotaKey(userID string, start time.Time) string {
+	return s.key(fmt.Sprintf("rate_limit:quota:%s:%d", escapeIdentity(userID), start.Unix()))
+}
+
+// SetQuotaSchedule sets a user's quota and when it resets
+// Quotas are only enforced when EnableQuotas is set
+func (s *Service) SetQuotaSchedule(ctx context.Context, userID string, schedule QuotaSchedule) error {
+	userID = s.NormalizeIdentity(userID)
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+
+	val, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("failed to encode quota schedule: %w", err)
+	}
+	if err := s.configClient.Set(ctx, s.quotaScheduleKey(userID), val, 0).Err(); err != nil {
+		return fmt.Errorf("failed to set quota schedule: %w", err)
+	}
+
+	s.logger.Info("user quota schedule updated",
+		zap.String("user_id", userID),
+		zap.Int("limit", schedule.Limit),
+		zap.String("period", schedule.Period),
+		zap.Time("anchor", schedule.Anchor),
+	)
+	return nil
+}
+
+// GetQuota returns the user's quota usage in the current period, or nil if
+// they have no quota schedule
+func (s *Service) GetQuota(ctx context.Context, userID string) (*QuotaUsage, error) {
+	userID = s.NormalizeIdentity(userID)
+	schedule, err := s.getQuotaSchedule(ctx, userID)
+	if err != nil || schedule == nil {
+		return nil, err
+	}
+
+	start, end := schedule.Bounds(time.Now())
+	used, err := s.redisClient.Get(ctx, s.quotaKey(userID, start)).Int()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get quota usage: %w", err)
+	}
+	if used > schedule.Limit {
+		used = schedule.Limit
+	}
+	return &QuotaUsage{Limit: schedule.Limit, Used: used, ResetsAt: end}, nil
+}
+
+// checkQuota counts a request against the user's quota for the current
+// period, reporting whether it is within it
+// Users without a quota schedule are always within it
+func (s *Service) checkQuota(ctx context.Context, userID string) (bool, error) {
+	schedule, err := s.getQuotaSchedule(ctx, userID)
+	if err != nil || schedule == nil {
+		return true, err
+	}
+
+	now := time.Now()
+	start, end := schedule.Bounds(now)
+	used, err := s.countInWindow(ctx, s.quotaKey(userID, start), end.Sub(now))
+	if err != nil {
+		return false, fmt.Errorf("failed to count quota: %w", err)
+	}
+	return used <= int64(schedule.Limit), nil
+}
+
+// getQuotaSchedule returns the user's quota schedule, or nil if none is set
+func (s *Service) getQuotaSchedule(ctx context.Context, userID string) (*QuotaSchedule, error) {
+	val, err := s.configClient.Get(ctx, s.quotaScheduleKey(userID)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quota schedule: %w", err)
+	}
+
+	var schedule QuotaSchedule
+	if err := json.Unmarshal([]byte(val), &schedule); err != nil {
+		return nil, fmt.Errorf("invalid quota schedule %q: %w", val, err)
+	}
+	return &schedule, nil
+}
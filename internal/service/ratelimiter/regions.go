@@ -0,0 +1,43 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MergeRegions pulls the regional counters of every peer region into this
+// region's Redis, returning the number of hashes merged
+// A peer that can't be reached doesn't stop the others from being merged
+func (s *Service) MergeRegions(ctx context.Context) (int, error) {
+	total := 0
+	var errs []error
+	for _, peer := range s.regionPeers {
+		merged, err := s.regional.Merge(ctx, peer, s.config.ScanConcurrency)
+		total += merged
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return total, errors.Join(errs...)
+}
+
+// SyncRegions merges the peer regions' counters every interval until ctx is
+// done; failed merges are logged and retried on the next tick
+func (s *Service) SyncRegions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.MergeRegions(ctx); err != nil && ctx.Err() == nil {
+				s.logger.Warn("failed to merge regional counters", zap.Error(err))
+			}
+		}
+	}
+}
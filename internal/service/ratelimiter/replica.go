@@ -0,0 +1,47 @@
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"ratelimit-challenge/pkg/ratelimiter"
+)
+
+// readLimiter returns the limiter remaining-request reads should go through,
+// and whether its answer may be stale and so must be discounted
+// Reads stay on the primary unless a replica is configured and
+// ReplicaReads allows it
+func (s *Service) readLimiter(ctx context.Context) (ratelimiter.RateLimiter, bool) {
+	primary := s.limiter(ctx)
+	if s.replicaLimiters == nil {
+		return primary, false
+	}
+	switch s.config.ReplicaReads {
+	case "replica", "conservative":
+		algorithm := s.config.Algorithm
+		if override, ok := algorithmFromContext(ctx); ok && IsKnownAlgorithm(override) {
+			algorithm = override
+		}
+		replica, ok := s.replicaLimiters[algorithm]
+		if !ok {
+			replica = s.replicaLimiters["leaky_bucket"]
+		}
+		return replica, s.config.ReplicaReads == "conservative"
+	}
+	return primary, false
+}
+
+// discountStaleness subtracts from remaining what the primary could have
+// admitted during ReplicaStaleness at the user's rate, which is what a
+// replica lagging that far behind may not have seen yet
+func (s *Service) discountStaleness(remaining, limit int, windowSize time.Duration) int {
+	if windowSize <= 0 || s.config.ReplicaStaleness <= 0 {
+		return remaining
+	}
+	inFlight := int(math.Ceil(float64(limit) * float64(s.config.ReplicaStaleness) / float64(windowSize)))
+	if remaining -= inFlight; remaining < 0 {
+		return 0
+	}
+	return remaining
+}
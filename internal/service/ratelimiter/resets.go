@@ -0,0 +1,43 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// resetsKey returns the key counting resets of a user's counter
+func (s *Service) resetsKey(userID string) string {
+	return s.key(fmt.Sprintf("rate_limit:resets:%s", escapeIdentity(userID)))
+}
+
+// AllowReset counts a reset of the user's counter through the management API,
+// reporting whether it is within ResetLimit per ResetWindow
+// Frequent resets could be used to escape the limit entirely, so those over
+// the limit are refused, logged and counted
+func (s *Service) AllowReset(ctx context.Context, userID string) (bool, error) {
+	userID = s.NormalizeIdentity(userID)
+	if s.config.ResetLimit <= 0 {
+		return true, nil
+	}
+
+	window := time.Duration(s.config.ResetWindow) * time.Second
+	count, err := s.countInWindow(ctx, s.resetsKey(userID), window)
+	if err != nil {
+		return false, fmt.Errorf("failed to count resets: %w", err)
+	}
+
+	if count > int64(s.config.ResetLimit) {
+		s.logger.Warn("user counter reset too often, refusing",
+			zap.String("user_id", userID),
+			zap.Int64("resets", count),
+			zap.Int("reset_limit", s.config.ResetLimit),
+			zap.Duration("reset_window", window),
+		)
+		s.resetMetrics.recordLimited()
+		return false, nil
+	}
+	return true, nil
+}
@@ -0,0 +1,41 @@
+package ratelimiter
+
+import (
+	"context"
+
+	"ratelimit-challenge/pkg/ratelimiter"
+)
+
+// ScriptDiagnostics reports the Lua scripts loaded on this node
+type ScriptDiagnostics struct {
+	Version string            `json:"script_version"`
+	Scripts map[string]string `json:"scripts"`
+}
+
+// scripts returns every Lua script the service runs, keyed by name
+func scripts() map[string]string {
+	scripts := ratelimiter.Scripts()
+	scripts["campaign"] = campaignScript
+	scripts["counter"] = counterScript
+	scripts["egress"] = egressScript
+	scripts["penalty"] = penaltyScript
+	scripts["threshold"] = thresholdScript
+	return scripts
+}
+
+// ValidateScripts loads every Lua script the service runs into Redis,
+// returning an error naming the first one Redis rejects
+func (s *Service) ValidateScripts(ctx context.Context) error {
+	_, err := ratelimiter.LoadScripts(ctx, s.redisClient, scripts())
+	return err
+}
+
+// Diagnostics loads every Lua script and reports the SHA1 Redis assigned
+// to each, so operators can confirm all nodes run identical scripts
+func (s *Service) Diagnostics(ctx context.Context) (*ScriptDiagnostics, error) {
+	shas, err := ratelimiter.LoadScripts(ctx, s.redisClient, scripts())
+	if err != nil {
+		return nil, err
+	}
+	return &ScriptDiagnostics{Version: ratelimiter.ScriptVersion, Scripts: shas}, nil
+}
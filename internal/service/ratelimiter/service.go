@@ -2,49 +2,146 @@ package ratelimiter
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"sync"
 	"time"
 
 	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/pkg/audit"
 	"ratelimit-challenge/pkg/ratelimiter"
 
 	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
 var _ = redis.Nil // Ensure redis package is imported
 
+// defaultLimitKey holds the fleet-wide default limit, overriding the config
+const defaultLimitKey = "rate_limit:default"
+
+// defaultLimitCacheTTL is how long an instance reuses the dynamic default
+const defaultLimitCacheTTL = 5 * time.Second
+
 // Service provides rate limiting functionality with support for dynamic user limits
 type Service struct {
 	slidingWindow ratelimiter.RateLimiter
 	leakyBucket   ratelimiter.RateLimiter
+	fixedWindow   ratelimiter.RateLimiter
+	gcra          ratelimiter.RateLimiter
+	regional      *ratelimiter.RegionalCounter
+	composite     *ratelimiter.SlidingWindow
+	distinct      *ratelimiter.Cardinality
 	config        *config.RateLimitConfig
 	logger        *zap.Logger
-	redisClient   *redis.Client
+	redisClient   redis.UniversalClient
+	// configClient stores user limits, the default limit and quota schedules;
+	// the same as redisClient unless they live in a database of their own
+	configClient redis.UniversalClient
+	audit        audit.Sink
+	// regionPeers are the other regions' Redis, merged by MergeRegions
+	regionPeers []redis.UniversalClient
+	// replicaClient serves remaining-request reads per config.ReplicaReads,
+	// through replicaLimiters, if set
+	replicaClient   redis.UniversalClient
+	replicaLimiters map[string]ratelimiter.RateLimiter
+	// memoryFallback decides requests while Redis is unreachable, if set
+	memoryFallback *ratelimiter.MemoryLimiter
+
+	// Records the latency of the limiters' Redis calls, if set
+	limiterMetrics *ratelimiter.Metrics
+	// Counts local cache lookups of custom user limits, if set
+	cacheMetrics *CacheMetrics
+	// Counts resets refused by AllowReset, if set
+	resetMetrics *ResetMetrics
+	// Counts rate limit decisions by reason, if set
+	decisionMetrics *DecisionMetrics
+	// Counts and times rate limit checks by outcome, if set
+	checkMetrics *CheckMetrics
+	// Records a span per check, the global provider if nil
+	tracerProvider trace.TracerProvider
 
 	// Local cache for user-specific rate limits
 	// This reduces Redis lookups for frequently accessed users
-	userLimitsCache map[string]int
-	cacheMutex      sync.RWMutex
-	cacheExpiry     map[string]time.Time
+	userLimits *limitsCache
+	// Users this instance knows have had their onboarding grace, so their
+	// requests don't each ask Redis again
+	onboarded *limitsCache
+
+	// Guards the default limit, maintenance and campaign caches
+	cacheMutex sync.RWMutex
+
+	// Local cache for the dynamic default limit (0 when none is set)
+	defaultLimitCache  int
+	defaultLimitExpiry time.Time
+
+	// Local cache for the maintenance flag
+	maintenanceCache  MaintenanceStatus
+	maintenanceExpiry time.Time
+
+	// Local cache for when the active campaign ends (zero when none is)
+	campaignEndsAt time.Time
+	campaignExpiry time.Time
+
+	// Window in effect, and the one it replaced while switching over
+	windowMutex    sync.RWMutex
+	windowSize     time.Duration
+	previousWindow time.Duration
+	windowChanged  time.Time
 }
 
 // NewService creates a new rate limiter service
 func NewService(
-	redisClient *redis.Client,
+	redisClient redis.UniversalClient,
 	cfg *config.RateLimitConfig,
 	logger *zap.Logger,
+	opts ...Option,
 ) *Service {
 	service := &Service{
-		slidingWindow:   ratelimiter.NewSlidingWindow(redisClient, logger),
-		leakyBucket:     ratelimiter.NewLeakyBucket(redisClient, logger),
-		config:          cfg,
-		logger:          logger,
-		redisClient:     redisClient,
-		userLimitsCache: make(map[string]int),
-		cacheExpiry:     make(map[string]time.Time),
+		config:       cfg,
+		logger:       logger,
+		redisClient:  redisClient,
+		configClient: redisClient,
+		audit:        audit.NopSink{},
+		windowSize:   time.Duration(cfg.WindowSize) * time.Second,
+	}
+	for _, opt := range opts {
+		opt(service)
+	}
+	service.userLimits = newLimitsCache(cfg.LocalCacheMaxEntries, service.cacheMetrics)
+	service.onboarded = newLimitsCache(cfg.LocalCacheMaxEntries, nil)
+
+	limiterOpts := []ratelimiter.Option{
+		ratelimiter.WithTTLPadding(cfg.TTLPadding),
+		ratelimiter.WithKeyTTL(cfg.KeyTTL),
+		ratelimiter.WithRetry(cfg.RetryAttempts, cfg.RetryBackoff),
+		ratelimiter.WithBoundary(cfg.Boundary),
+		ratelimiter.WithMetrics(service.limiterMetrics),
+		ratelimiter.WithTracerProvider(service.tracerProvider),
+		ratelimiter.WithKeyNamespace(cfg.KeyNamespace),
+	}
+	slidingWindow := ratelimiter.NewSlidingWindow(redisClient, logger, limiterOpts...)
+	service.slidingWindow = slidingWindow
+	service.composite = slidingWindow
+	service.leakyBucket = ratelimiter.NewLeakyBucket(redisClient, logger, limiterOpts...)
+	service.fixedWindow = ratelimiter.NewFixedWindow(redisClient, logger, limiterOpts...)
+	service.gcra = ratelimiter.NewGCRA(redisClient, logger, cfg.Burst, limiterOpts...)
+	service.regional = ratelimiter.NewRegionalCounter(redisClient, logger, cfg.Region, limiterOpts...)
+	service.distinct = ratelimiter.NewCardinality(redisClient, logger, limiterOpts...)
+	if service.replicaClient != nil {
+		service.replicaLimiters = map[string]ratelimiter.RateLimiter{
+			"sliding_window": ratelimiter.NewSlidingWindow(service.replicaClient, logger, limiterOpts...),
+			"leaky_bucket":   ratelimiter.NewLeakyBucket(service.replicaClient, logger, limiterOpts...),
+			"fixed_window":   ratelimiter.NewFixedWindow(service.replicaClient, logger, limiterOpts...),
+			"gcra":           ratelimiter.NewGCRA(service.replicaClient, logger, cfg.Burst, limiterOpts...),
+			"regional":       ratelimiter.NewRegionalCounter(service.replicaClient, logger, cfg.Region, limiterOpts...),
+		}
+	}
+
+	if cfg.MemoryFallbackSize > 0 {
+		service.memoryFallback = ratelimiter.NewMemoryLimiter(cfg.MemoryFallbackSize, limiterOpts...)
 	}
 
 	// Start cache cleanup goroutine
@@ -59,140 +156,815 @@ func NewService(
 // This is the main function that should be called for each request
 // It supports dynamic rate limits per user (stored in Redis)
 func (s *Service) RateLimit(ctx context.Context, userID string, limit int) (bool, error) {
-	// Get user-specific limit if configured, otherwise use provided limit
-	userLimit, err := s.getUserLimit(ctx, userID)
+	decision, err := s.Check(ctx, userID, limit)
+	if err != nil {
+		return false, err
+	}
+	return decision.Allowed, nil
+}
+
+// RateLimitWithWindow is like RateLimit but counts the request over the given
+// window instead of the configured one; a window of zero uses the configured
+// one
+// Callers limiting the same user over different windows should also scope
+// each call with WithRoute, since a window only applies to the key it counts
+func (s *Service) RateLimitWithWindow(ctx context.Context, userID string, limit int, window time.Duration) (bool, error) {
+	return s.RateLimit(WithWindow(ctx, window), userID, limit)
+}
+
+// RateLimitScoped is like RateLimit but counts the request against the
+// user's budget for scope alone, e.g. separate "read" and "write" budgets,
+// at the scope's custom limit if one is set with SetScopedLimit
+func (s *Service) RateLimitScoped(ctx context.Context, userID, scope string, limit int) (bool, error) {
+	return s.RateLimit(WithScope(ctx, scope), userID, limit)
+}
+
+// Check is like RateLimit but also reports which condition denied the request
+func (s *Service) Check(ctx context.Context, userID string, limit int) (Decision, error) {
+	start := time.Now()
+	ctx, span := s.startSpan(ctx, userID)
+	decision, err := s.check(ctx, userID, limit)
+	endSpan(span, decision, err)
+	s.decisionMetrics.record(decision.Reason)
+	s.checkMetrics.record(decision, err, start)
+	return decision, err
+}
+
+// check makes the decision reported by Check
+func (s *Service) check(ctx context.Context, userID string, limit int) (Decision, error) {
+	userID = s.NormalizeIdentity(userID)
+	// Users in the penalty box are denied outright until it expires
+	if s.config.PenaltyMultiplier > 0 {
+		blocked, err := s.inPenaltyBox(ctx, userID)
+		if err != nil {
+			s.logger.Warn("penalty box check failed, skipping",
+				zap.String("user_id", userID),
+				zap.Error(err),
+			)
+		}
+		if blocked {
+			s.recordEvent(audit.EventDenied, userID, CodeBlocked, limit)
+			return Decision{Allowed: false, Code: CodeBlocked, Reason: ReasonBlocked}, nil
+		}
+	}
+
+	// A shared campaign pool, when active, is drawn from before per-user limits
+	inCampaign, err := s.checkCampaign(ctx, userID)
 	if err != nil {
-		s.logger.Warn("failed to get user limit, using provided limit",
+		s.logger.Warn("campaign check failed, skipping",
 			zap.String("user_id", userID),
-			zap.Int("fallback_limit", limit),
 			zap.Error(err),
 		)
-		userLimit = limit
 	}
+	if !inCampaign {
+		return Decision{Allowed: false, Code: CodeGlobalLimited, Reason: ReasonGlobalLimit}, nil
+	}
+
+	// Get user-specific limit if configured, otherwise use provided limit
+	// A trusted upstream may have already decided the limit, skipping the lookup
+	userLimit, overridden := limitOverrideFromContext(ctx)
+	var custom userConfig
+	if !overridden {
+		custom, err = s.getUserConfig(ctx, userID)
+		if err != nil {
+			s.logger.Warn("failed to get user limit, using provided limit",
+				zap.String("user_id", userID),
+				zap.Int("fallback_limit", limit),
+				zap.Error(err),
+			)
+			custom.limit = limit
+		}
+
+		if custom.blocked {
+			s.recordEvent(audit.EventDenied, userID, CodeBlocked, 0)
+			return Decision{Allowed: false, Code: CodeBlocked, Reason: ReasonBlocked}, nil
+		}
+	}
+
+	// A brand-new user's very first request is allowed, e.g. even when they
+	// share an exhausted IP bucket, unless they have been blocked
+	if s.config.OnboardingGrace {
+		first, err := s.firstRequest(ctx, userID)
+		if err != nil {
+			s.logger.Warn("onboarding grace check failed, skipping",
+				zap.String("user_id", userID),
+				zap.Error(err),
+			)
+		}
+		if first {
+			return Decision{Allowed: true, Reason: ReasonBypassed}, nil
+		}
+	}
+
+	if !overridden {
+		// Users with burst and sustained tiers are checked against both at once
+		if custom.tiers != nil {
+			return s.checkTiers(ctx, limiterKey(ctx, userID), custom.tiers, requestCostFromContext(ctx))
+		}
+		userLimit = custom.limit
 
-	// Use configured limit if user limit not found
-	if userLimit == 0 {
-		userLimit = limit
+		// Use the default limit if user limit not found
+		if userLimit == 0 {
+			userLimit, _ = s.baseLimit(ctx, limit)
+		}
 	}
+	userLimit = s.windowLimit(ctx, userLimit)
 
-	windowSize := time.Duration(s.config.WindowSize) * time.Second
+	windowSize := s.window(ctx)
 
 	// Select algorithm based on configuration
-	var limiter ratelimiter.RateLimiter
-	if s.config.Algorithm == "sliding_window" {
-		limiter = s.slidingWindow
-	} else {
-		limiter = s.leakyBucket
+	limiter := s.limiter(ctx)
+
+	// Check rate limit, with the shadow algorithm running alongside if enabled
+	key := limiterKey(ctx, userID)
+	shadow := s.startShadow(ctx, limiter, key, userLimit, windowSize)
+	capacity, err := limiter.CheckN(ctx, key, userLimit, requestCostFromContext(ctx), windowSize)
+	allowed := capacity.Allowed
+	s.recordShadow(shadow, userID, allowed)
+	if err != nil {
+		if decision, ok := s.checkFallback(ctx, userID, key, userLimit, windowSize, err); ok {
+			return decision, nil
+		}
+		return Decision{Allowed: false, Code: failureCode(err), Reason: ReasonDegraded}, fmt.Errorf("rate limit check failed: %w", err)
+	}
+	result := capacity.Result(userID, userLimit)
+
+	if allowed {
+		if s.softOveragesEnabled() {
+			if err := s.clearSoftOverages(ctx, key); err != nil {
+				s.logger.Warn("soft overage tracking failed",
+					zap.String("user_id", userID),
+					zap.Error(err),
+				)
+			}
+		}
+		// Requests within the window also count against the user's quota
+		if s.config.EnableQuotas {
+			withinQuota, err := s.checkQuota(ctx, userID)
+			if err != nil {
+				s.logger.Warn("quota check failed, skipping",
+					zap.String("user_id", userID),
+					zap.Error(err),
+				)
+			}
+			if err == nil && !withinQuota {
+				s.recordEvent(audit.EventDenied, userID, CodeQuotaExceeded, userLimit)
+				return Decision{Allowed: false, Code: CodeQuotaExceeded, Reason: ReasonOverLimit}, nil
+			}
+		}
+		if s.usageThresholdsEnabled() {
+			if err := s.trackThresholds(ctx, userID, limiter, key, userLimit, windowSize); err != nil {
+				s.logger.Warn("usage threshold tracking failed",
+					zap.String("user_id", userID),
+					zap.Error(err),
+				)
+			}
+		}
+		return Decision{Allowed: true, Reason: ReasonUnderLimit, Result: &result}, nil
+	}
+
+	// A short run of overages is tolerated, flagged, before denying
+	if s.softOveragesEnabled() {
+		soft, err := s.allowSoftOverage(ctx, key)
+		if err != nil {
+			s.logger.Warn("soft overage check failed, denying",
+				zap.String("user_id", userID),
+				zap.Error(err),
+			)
+		}
+		if soft {
+			return Decision{Allowed: true, Reason: ReasonOverLimit, SoftOverage: true}, nil
+		}
 	}
 
-	// Check rate limit
-	allowed, err := limiter.Allow(ctx, userID, userLimit, windowSize)
+	if s.config.PenaltyMultiplier > 0 {
+		if err := s.trackOverage(ctx, userID, userLimit, windowSize); err != nil {
+			s.logger.Warn("penalty box tracking failed",
+				zap.String("user_id", userID),
+				zap.Error(err),
+			)
+		}
+	}
+
+	// A denied user has used their whole limit, e.g. one lowered mid-window
+	if s.usageThresholdsEnabled() {
+		if err := s.reportThresholds(ctx, userID, userLimit, 100, windowSize); err != nil {
+			s.logger.Warn("usage threshold tracking failed",
+				zap.String("user_id", userID),
+				zap.Error(err),
+			)
+		}
+	}
+
+	s.recordEvent(audit.EventDenied, userID, CodeRateLimited, userLimit)
+	return Decision{Allowed: false, Code: CodeRateLimited, Reason: ReasonOverLimit, Result: &result}, nil
+}
+
+// AllowAll checks several limits at once, e.g. per-user and per-tenant
+// The request is allowed only if every check passes, and then consumes from
+// all of them; a denied request consumes from none
+// Composite checks always use the sliding window algorithm
+func (s *Service) AllowAll(ctx context.Context, checks []Check) (bool, []Result, error) {
+	allowed, results, err := s.composite.AllowAll(ctx, checks)
+	if err != nil {
+		return false, nil, fmt.Errorf("composite rate limit check failed: %w", err)
+	}
+	return allowed, results, nil
+}
+
+// ErrBatchTooLarge is returned by RateLimitBatch for a batch of more checks
+// than rate_limit.max_batch_size
+var ErrBatchTooLarge = errors.New("batch exceeds rate_limit.max_batch_size")
+
+// RateLimitBatch checks several principals at once, e.g. the user, their API
+// key and their IP, in a single round trip to Redis per 1000 checks
+// Unlike AllowAll, each check is decided on its own: one that is over its
+// limit doesn't stop the others from consuming a slot. Checks without a
+// Limit use the default limit and checks without a Window the configured
+// window. Use AllAllowed to deny the request if any check failed
+// Batched checks always use the sliding window algorithm
+func (s *Service) RateLimitBatch(ctx context.Context, checks []Check) ([]Result, error) {
+	if maxSize := s.config.MaxBatchSize; maxSize > 0 && len(checks) > maxSize {
+		return nil, fmt.Errorf("%w: %d checks, at most %d allowed", ErrBatchTooLarge, len(checks), maxSize)
+	}
+
+	keyed := make([]Check, len(checks))
+	for i, check := range checks {
+		if check.Limit == 0 {
+			check.Limit, _ = s.baseLimit(ctx, s.config.DefaultLimit)
+		}
+		if check.Window == 0 {
+			check.Window = s.window(ctx)
+		}
+		check.Key = limiterKey(ctx, s.NormalizeIdentity(check.Key))
+		keyed[i] = check
+	}
+
+	results, err := s.composite.AllowEach(ctx, keyed)
+	// Report results under the keys they were asked for
+	for i := range results {
+		results[i].Key = checks[i].Key
+	}
 	if err != nil {
-		return false, fmt.Errorf("rate limit check failed: %w", err)
+		return results, fmt.Errorf("batch rate limit check failed: %w", err)
 	}
+	return results, nil
+}
+
+// GetRemainingWithWindow is like GetRemaining over the given window instead
+// of the configured one; a window of zero uses the configured one
+func (s *Service) GetRemainingWithWindow(ctx context.Context, userID string, limit int, window time.Duration) (int, error) {
+	return s.GetRemaining(WithWindow(ctx, window), userID, limit)
+}
 
-	return allowed, nil
+// GetRemainingScoped is like GetRemaining for the user's budget for scope
+func (s *Service) GetRemainingScoped(ctx context.Context, userID, scope string, limit int) (int, error) {
+	return s.GetRemaining(WithScope(ctx, scope), userID, limit)
 }
 
 // GetRemaining returns the number of remaining requests for a user
+// For users with burst and sustained tiers, it is the tighter of the two
 func (s *Service) GetRemaining(ctx context.Context, userID string, limit int) (int, error) {
-	userLimit, err := s.getUserLimit(ctx, userID)
-	if err != nil {
-		userLimit = limit
+	remaining, _, err := s.GetRemainingLimit(ctx, userID, limit)
+	return remaining, err
+}
+
+// GetRemainingLimit is like GetRemaining but also returns the limit it
+// applied, e.g. the user's custom limit rather than limit, so the remaining
+// requests can be reported as a share of it
+// Blocked users get a limit of 0
+func (s *Service) GetRemainingLimit(ctx context.Context, userID string, limit int) (int, int, error) {
+	userID = s.NormalizeIdentity(userID)
+	userLimit, overridden := limitOverrideFromContext(ctx)
+	if !overridden {
+		custom, err := s.getUserConfig(ctx, userID)
+		if err != nil {
+			custom.limit = limit
+		}
+		if custom.blocked {
+			return 0, 0, nil
+		}
+		if custom.tiers != nil {
+			return s.tiersRemaining(ctx, limiterKey(ctx, userID), custom.tiers)
+		}
+		userLimit = custom.limit
+		if userLimit == 0 {
+			userLimit, _ = s.baseLimit(ctx, limit)
+		}
 	}
-	if userLimit == 0 {
-		userLimit = limit
+	userLimit = s.windowLimit(ctx, userLimit)
+
+	windowSize := s.window(ctx)
+
+	limiter, stale := s.readLimiter(ctx)
+	remaining, err := limiter.GetRemaining(ctx, limiterKey(ctx, userID), userLimit, windowSize)
+	if err != nil || !stale {
+		return remaining, userLimit, err
 	}
+	return s.discountStaleness(remaining, userLimit, windowSize), userLimit, nil
+}
 
-	windowSize := time.Duration(s.config.WindowSize) * time.Second
+// GetRemainingAt projects the number of remaining requests for a user to the
+// given instant, assuming they make no further requests until then
+// Useful for scheduling work that should wait for quota to free up
+func (s *Service) GetRemainingAt(ctx context.Context, userID string, limit int, windowSize time.Duration, at time.Time) (int, error) {
+	userID = s.NormalizeIdentity(userID)
+	userLimit, _ := s.getUserLimit(ctx, userID, limit)
+	userLimit = s.windowLimit(ctx, userLimit)
+
+	limiter, stale := s.readLimiter(ctx)
+	remaining, err := limiter.GetRemainingAt(ctx, limiterKey(ctx, userID), userLimit, windowSize, at)
+	if err != nil || !stale {
+		return remaining, err
+	}
+	return s.discountStaleness(remaining, userLimit, windowSize), nil
+}
 
-	var limiter ratelimiter.RateLimiter
-	if s.config.Algorithm == "sliding_window" {
-		limiter = s.slidingWindow
-	} else {
-		limiter = s.leakyBucket
+// RetryAfter returns how long until the user may make another request,
+// assuming they make none until then
+// For users with burst and sustained tiers, it is the longer wait of the two
+// Blocked users get 0: they stay blocked until given a limit, however long
+// they wait
+func (s *Service) RetryAfter(ctx context.Context, userID string, limit int) (time.Duration, error) {
+	userID = s.NormalizeIdentity(userID)
+	userLimit, overridden := limitOverrideFromContext(ctx)
+	if !overridden {
+		custom, err := s.getUserConfig(ctx, userID)
+		if err != nil {
+			custom.limit = limit
+		}
+		if custom.blocked {
+			return 0, nil
+		}
+		if custom.tiers != nil {
+			return s.tiersRetryAfter(ctx, limiterKey(ctx, userID), custom.tiers)
+		}
+		userLimit = custom.limit
+		if userLimit == 0 {
+			userLimit, _ = s.baseLimit(ctx, limit)
+		}
 	}
+	userLimit = s.windowLimit(ctx, userLimit)
+
+	return s.limiter(ctx).RetryAfter(ctx, limiterKey(ctx, userID), userLimit, s.window(ctx))
+}
 
-	return limiter.GetRemaining(ctx, userID, userLimit, windowSize)
+// GetStats returns the user's current rate limit state for diagnostics
+// The fields set depend on the algorithm in effect; Source tells where the
+// limit came from
+func (s *Service) GetStats(ctx context.Context, userID string, limit int) (Stats, error) {
+	userID = s.NormalizeIdentity(userID)
+	userLimit, source := s.getUserLimit(ctx, userID, limit)
+	userLimit = s.windowLimit(ctx, userLimit)
+
+	windowSize := s.window(ctx)
+
+	stats, err := s.limiter(ctx).GetStats(ctx, limiterKey(ctx, userID), userLimit, windowSize)
+	return Stats{Stats: stats, Source: source}, err
 }
 
 // SetUserLimit sets a custom rate limit for a specific user
 // This allows dynamic configuration of rate limits per user
+// With a context from WithScope, the limit only applies to that scope
 func (s *Service) SetUserLimit(ctx context.Context, userID string, limit int) error {
-	key := fmt.Sprintf("rate_limit:config:%s", userID)
-	err := s.redisClient.Set(ctx, key, limit, time.Duration(s.config.LocalCacheTTL)*time.Second).Err()
+	userID = s.NormalizeIdentity(userID)
+	key, cacheID := s.userConfigKey(ctx, userID)
+	err := s.configClient.Set(ctx, key, limit, s.configTTL()).Err()
 	if err != nil {
 		return fmt.Errorf("failed to set user limit: %w", err)
 	}
 
-	// Update local cache
-	if s.config.EnableLocalCache {
-		s.cacheMutex.Lock()
-		s.userLimitsCache[userID] = limit
-		s.cacheExpiry[userID] = time.Now().Add(time.Duration(s.config.LocalCacheTTL) * time.Second)
-		s.cacheMutex.Unlock()
-	}
+	s.cacheUserConfig(cacheID, userConfig{limit: limit})
 
+	scope, _ := scopeFromContext(ctx)
 	s.logger.Info("user rate limit updated",
 		zap.String("user_id", userID),
+		zap.String("scope", scope),
 		zap.Int("limit", limit),
 	)
 
 	return nil
 }
 
-// Reset clears the rate limit for a user
+// SetScopedLimit sets a custom rate limit for the user's budget for scope,
+// leaving their other scopes at their own limit
+func (s *Service) SetScopedLimit(ctx context.Context, userID, scope string, limit int) error {
+	return s.SetUserLimit(WithScope(ctx, scope), userID, limit)
+}
+
+// UserConfigKey returns the Redis key holding a user's custom limit
+func (s *Service) UserConfigKey(userID string) string {
+	return s.configKey(s.NormalizeIdentity(userID))
+}
+
+// configKey returns the key holding a normalized user's custom limit
+func (s *Service) configKey(userID string) string {
+	return s.key(fmt.Sprintf("rate_limit:config:%s", escapeIdentity(userID)))
+}
+
+// userConfigKey returns the key holding a normalized user's custom limits
+// for the scope of this call, if any, and the ID they are cached under
+func (s *Service) userConfigKey(ctx context.Context, userID string) (string, string) {
+	if scope, ok := scopeFromContext(ctx); ok {
+		return s.configKey(userID) + ":scope:" + escapeIdentity(scope), scopedCacheID(userID, scope)
+	}
+	return s.configKey(userID), userID
+}
+
+// scopedCacheID returns the ID a user's custom limits for scope are cached
+// under, kept apart from user IDs by a NUL separator
+func scopedCacheID(userID, scope string) string {
+	return escapeIdentity(userID) + "\x00" + escapeIdentity(scope)
+}
+
+// key returns key under the configured namespace, if any
+func (s *Service) key(key string) string {
+	return ratelimiter.NamespacedKey(s.config.KeyNamespace, key)
+}
+
+// GetUserLimit returns the limit configured for a user by SetUserLimit and
+// whether one is, consulting the local cache before Redis
+// Users without one get the base limit, and blocked users a configured limit
+// of 0; a missing config is not an error
+func (s *Service) GetUserLimit(ctx context.Context, userID string) (int, bool, error) {
+	userID = s.NormalizeIdentity(userID)
+	custom, err := s.getUserConfig(ctx, userID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get user limit: %w", err)
+	}
+	switch {
+	case custom.blocked:
+		return 0, true, nil
+	case custom.limit > 0:
+		return custom.limit, true, nil
+	}
+	limit, _ := s.baseLimit(ctx, s.config.DefaultLimit)
+	return limit, false, nil
+}
+
+// DeleteUserLimit removes a user's custom limits, reverting them to the
+// default; deleting a user without custom limits is a no-op
+// With a context from WithScope, only the scope's limit is removed,
+// reverting the scope to the user's limit
+// Other instances keep the cached limits until their cache entry expires
+func (s *Service) DeleteUserLimit(ctx context.Context, userID string) error {
+	userID = s.NormalizeIdentity(userID)
+	key, cacheID := s.userConfigKey(ctx, userID)
+	if err := s.configClient.Del(ctx, key).Err(); err != nil {
+		return fmt.Errorf("failed to delete user limit: %w", err)
+	}
+
+	s.userLimits.delete(cacheID)
+
+	s.logger.Info("user rate limit deleted", zap.String("user_id", userID))
+
+	return nil
+}
+
+// BlockUser denies every request from a user, replacing any custom limit
+// Setting a limit or tiers for the user lifts the block
+func (s *Service) BlockUser(ctx context.Context, userID string) error {
+	userID = s.NormalizeIdentity(userID)
+	key := s.configKey(userID)
+	if err := s.configClient.Set(ctx, key, blockedValue, s.configTTL()).Err(); err != nil {
+		return fmt.Errorf("failed to block user: %w", err)
+	}
+
+	s.cacheUserConfig(userID, userConfig{blocked: true})
+
+	s.logger.Info("user blocked", zap.String("user_id", userID))
+
+	return nil
+}
+
+// IsBlocked reports whether the user is blocked by BlockUser
+func (s *Service) IsBlocked(ctx context.Context, userID string) (bool, error) {
+	userID = s.NormalizeIdentity(userID)
+	custom, err := s.getUserConfig(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to get user limit: %w", err)
+	}
+	return custom.blocked, nil
+}
+
+// WarmUserLimit refreshes the user's custom limits in the local cache from
+// Redis, so this instance sees a change made elsewhere without waiting for
+// the cached entry to expire
+// Returns the user's limit, or their tiers, with a zero limit and nil tiers
+// meaning the user has no custom limit
+func (s *Service) WarmUserLimit(ctx context.Context, userID string) (int, *UserTiers, error) {
+	userID = s.NormalizeIdentity(userID)
+	custom, err := s.loadUserConfig(ctx, userID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to warm user limit: %w", err)
+	}
+	return custom.limit, custom.tiers, nil
+}
+
+// SetDefaultLimit sets the default limit for every user without a custom
+// limit, overriding the configured default across all instances
+// Other instances pick up the change within a few seconds
+func (s *Service) SetDefaultLimit(ctx context.Context, limit int) error {
+	if err := s.configClient.Set(ctx, s.key(defaultLimitKey), limit, 0).Err(); err != nil {
+		return fmt.Errorf("failed to set default limit: %w", err)
+	}
+
+	s.cacheMutex.Lock()
+	s.defaultLimitCache = limit
+	s.defaultLimitExpiry = time.Now().Add(defaultLimitCacheTTL)
+	s.cacheMutex.Unlock()
+
+	s.logger.Info("default rate limit updated", zap.Int("limit", limit))
+
+	return nil
+}
+
+// Refund returns the slot consumed by the user's latest request
+// Used when the request was counted but never completed (e.g. client disconnect)
+func (s *Service) Refund(ctx context.Context, userID string) error {
+	userID = s.NormalizeIdentity(userID)
+	return s.limiter(ctx).Refund(ctx, limiterKey(ctx, userID), s.window(ctx))
+}
+
+// ResetScoped is like Reset for the user's budget for scope alone, leaving
+// their egress and distinct counts in place
+func (s *Service) ResetScoped(ctx context.Context, userID, scope string) error {
+	return s.Reset(WithScope(ctx, scope), userID)
+}
+
+// Reset clears the rate limit for a user, starting them over as if new
+// It deletes what ResetCounterOnly does and also forgets when the user was
+// first seen, so they are given their onboarding grace again; their custom
+// limit and any penalty box entry are left in place
 func (s *Service) Reset(ctx context.Context, userID string) error {
-	var limiter ratelimiter.RateLimiter
-	if s.config.Algorithm == "sliding_window" {
-		limiter = s.slidingWindow
-	} else {
-		limiter = s.leakyBucket
+	if err := s.ResetCounterOnly(ctx, userID); err != nil {
+		return err
+	}
+	// Users are first seen once, whatever the scope
+	if _, scoped := scopeFromContext(ctx); s.config.OnboardingGrace && !scoped {
+		return s.forgetOnboarded(ctx, s.NormalizeIdentity(userID))
+	}
+	return nil
+}
+
+// ResetCounterOnly clears the counters limiting a user and nothing else
+// Only the request counters of the algorithm in effect and of the user's
+// burst and sustained tiers, their egress and distinct counts and their soft
+// overages are deleted; when they were first seen, their custom limit and
+// any penalty box entry are left in place
+func (s *Service) ResetCounterOnly(ctx context.Context, userID string) error {
+	userID = s.NormalizeIdentity(userID)
+	key := limiterKey(ctx, userID)
+	windowSize := s.window(ctx)
+	if err := s.limiter(ctx).Reset(ctx, key, windowSize); err != nil {
+		return err
+	}
+	for _, key := range []string{burstKey(key), sustainedKey(key)} {
+		if err := s.composite.Reset(ctx, key, windowSize); err != nil {
+			return err
+		}
+	}
+	// Egress and distinct counts are the user's across scopes
+	_, scoped := scopeFromContext(ctx)
+	if s.EgressEnabled() && !scoped {
+		if err := s.redisClient.Del(ctx, s.egressKey(userID)).Err(); err != nil {
+			return fmt.Errorf("failed to reset egress: %w", err)
+		}
+	}
+	if s.DistinctEnabled() && !scoped {
+		if err := s.distinct.Reset(ctx, escapeIdentity(userID), s.distinctWindow()); err != nil {
+			return err
+		}
+	}
+	if s.softOveragesEnabled() {
+		return s.clearSoftOverages(ctx, key)
+	}
+	return nil
+}
+
+// IsKnownAlgorithm reports whether the service implements the named algorithm
+func IsKnownAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case "sliding_window", "leaky_bucket", "fixed_window", "gcra", "regional":
+		return true
+	}
+	return false
+}
+
+// limiter returns the limiter for the algorithm in effect for this call
+// An override carried by the context takes precedence over the configuration
+func (s *Service) limiter(ctx context.Context) ratelimiter.RateLimiter {
+	return s.limiterFor(s.algorithm(ctx))
+}
+
+// algorithm returns the algorithm in effect for this call
+// An override carried by the context takes precedence over the configuration
+func (s *Service) algorithm(ctx context.Context) string {
+	if override, ok := algorithmFromContext(ctx); ok && IsKnownAlgorithm(override) {
+		return override
+	}
+	return s.config.Algorithm
+}
+
+// limiterFor returns the limiter implementing the named algorithm
+func (s *Service) limiterFor(algorithm string) ratelimiter.RateLimiter {
+	switch algorithm {
+	case "sliding_window":
+		return s.slidingWindow
+	case "fixed_window":
+		return s.fixedWindow
+	case "gcra":
+		return s.gcra
+	case "regional":
+		return s.regional
+	}
+	return s.leakyBucket
+}
+
+// getUserLimit resolves the single limit in effect for a user and where it
+// came from: their custom limit, else the base limit
+// Users with burst and sustained tiers instead get the base limit, blocked
+// users a limit of 0, and fallback is used as is if the user's limit can't be
+// looked up
+func (s *Service) getUserLimit(ctx context.Context, userID string, fallback int) (int, LimitSource) {
+	custom, err := s.getUserConfig(ctx, userID)
+	if err != nil {
+		return fallback, LimitSourceFallback
+	}
+	if custom.blocked {
+		return 0, LimitSourceUser
 	}
+	if custom.limit > 0 {
+		return custom.limit, LimitSourceUser
+	}
+	return s.baseLimit(ctx, fallback)
+}
 
-	return limiter.Reset(ctx, userID)
+// getUserConfig retrieves the custom limits for a user
+// First checks local cache, then Redis; the zero value means no custom limit
+// Users without a custom limit are cached too, so they don't cost a Redis
+// lookup on every request
+// Calls scoped by WithScope get the scope's custom limits, if any, else the
+// user's; a blocked user is blocked in every scope
+func (s *Service) getUserConfig(ctx context.Context, userID string) (userConfig, error) {
+	custom, err := s.getConfig(ctx, userID, s.configKey(userID))
+	if _, ok := scopeFromContext(ctx); !ok || err != nil || custom.blocked {
+		return custom, err
+	}
+	key, cacheID := s.userConfigKey(ctx, userID)
+	scoped, err := s.getConfig(ctx, cacheID, key)
+	if err != nil || scoped != (userConfig{}) {
+		return scoped, err
+	}
+	return custom, nil
 }
 
-// getUserLimit retrieves the rate limit for a user
-// First checks local cache, then Redis, then returns default
-func (s *Service) getUserLimit(ctx context.Context, userID string) (int, error) {
+// getConfig retrieves the custom limits held in key, cached under cacheID
+func (s *Service) getConfig(ctx context.Context, cacheID, key string) (userConfig, error) {
 	// Check local cache first
 	if s.config.EnableLocalCache {
-		s.cacheMutex.RLock()
-		if limit, exists := s.userLimitsCache[userID]; exists {
-			if expiry, ok := s.cacheExpiry[userID]; ok && time.Now().Before(expiry) {
-				s.cacheMutex.RUnlock()
-				return limit, nil
-			}
+		cached, exists := s.userLimits.get(cacheID, time.Now())
+		s.cacheMetrics.recordLookup(exists, cached)
+		if exists {
+			return cached, nil
 		}
-		s.cacheMutex.RUnlock()
 	}
 
-	// Check Redis
-	key := fmt.Sprintf("rate_limit:config:%s", userID)
-	val, err := s.redisClient.Get(ctx, key).Result()
+	return s.loadConfig(ctx, cacheID, key)
+}
+
+// loadUserConfig reads a user's custom limits from Redis into the local cache
+func (s *Service) loadUserConfig(ctx context.Context, userID string) (userConfig, error) {
+	return s.loadConfig(ctx, userID, s.configKey(userID))
+}
+
+// loadConfig reads the custom limits held in key from Redis into the local
+// cache, under cacheID
+func (s *Service) loadConfig(ctx context.Context, cacheID, key string) (userConfig, error) {
+	val, err := s.configClient.Get(ctx, key).Result()
 	if err == redis.Nil {
-		// No custom limit configured, return 0 to use default
-		return 0, nil
+		// No custom limit configured, return the zero value to use default
+		s.cacheUserConfig(cacheID, userConfig{})
+		return userConfig{}, nil
 	}
 	if err != nil {
-		return 0, err
+		return userConfig{}, err
 	}
 
-	limit, err := parseInt(val)
+	parsed, err := parseUserConfig(val)
 	if err != nil {
-		return 0, fmt.Errorf("invalid limit value: %w", err)
+		return userConfig{}, err
 	}
 
-	// Update local cache
-	if s.config.EnableLocalCache {
-		s.cacheMutex.Lock()
-		s.userLimitsCache[userID] = limit
-		s.cacheExpiry[userID] = time.Now().Add(time.Duration(s.config.LocalCacheTTL) * time.Second)
-		s.cacheMutex.Unlock()
+	s.cacheUserConfig(cacheID, parsed)
+
+	return parsed, nil
+}
+
+// cacheUserConfig stores a user's custom limits in the local cache, if enabled
+func (s *Service) cacheUserConfig(userID string, custom userConfig) {
+	if !s.config.EnableLocalCache {
+		return
 	}
+	s.userLimits.set(userID, custom, s.cacheExpiry())
+}
 
-	return limit, nil
+// limiterKey returns the key the user's requests are counted under
+// Requests scoped to an API version are counted separately for each version,
+// requests scoped to a route separately for each route, and requests given a
+// scope separately for each scope
+func limiterKey(ctx context.Context, userID string) string {
+	key := escapeIdentity(userID)
+	if version, ok := apiVersionFromContext(ctx); ok {
+		key += ":" + version
+	}
+	if route, ok := routeFromContext(ctx); ok {
+		key += ":route:" + escapeIdentity(route)
+	}
+	if scope, ok := scopeFromContext(ctx); ok {
+		key += ":scope:" + escapeIdentity(scope)
+	}
+	return key
+}
+
+// baseLimit returns the limit for users without a custom limit: the limit
+// of the request's API version if one is configured, otherwise the default
+func (s *Service) baseLimit(ctx context.Context, fallback int) (int, LimitSource) {
+	if version, ok := apiVersionFromContext(ctx); ok {
+		if limit, ok := s.config.APIVersionLimits[version]; ok && limit > 0 {
+			return limit, LimitSourceAPIVersion
+		}
+	}
+	return s.defaultLimit(ctx, fallback)
+}
+
+// windowLimit converts a configured limit into requests per window
+func (s *Service) windowLimit(ctx context.Context, limit int) int {
+	if s.config.LimitUnit == "per_second" {
+		return limit * int(s.window(ctx)/time.Second)
+	}
+	return limit
+}
+
+// window returns the window in effect for this call
+// An override carried by the context takes precedence over the configuration
+func (s *Service) window(ctx context.Context) time.Duration {
+	if window, ok := windowFromContext(ctx); ok {
+		return window
+	}
+	return s.configuredWindow()
+}
+
+// configTTL returns how long custom user limits persist in Redis
+// Zero means they never expire
+func (s *Service) configTTL() time.Duration {
+	return time.Duration(s.config.ConfigTTL) * time.Second
+}
+
+// defaultLimit returns the dynamic default limit, or fallback if none is set
+// The value is cached briefly so Redis isn't queried on every request
+func (s *Service) defaultLimit(ctx context.Context, fallback int) (int, LimitSource) {
+	s.cacheMutex.RLock()
+	if time.Now().Before(s.defaultLimitExpiry) {
+		limit := s.defaultLimitCache
+		s.cacheMutex.RUnlock()
+		if limit > 0 {
+			return limit, LimitSourceDefault
+		}
+		return fallback, LimitSourceFallback
+	}
+	s.cacheMutex.RUnlock()
+
+	limit := 0
+	val, err := s.configClient.Get(ctx, s.key(defaultLimitKey)).Result()
+	switch {
+	case err == redis.Nil:
+		// No dynamic default configured
+	case err != nil:
+		s.logger.Warn("failed to get default limit, using provided limit",
+			zap.Int("fallback_limit", fallback),
+			zap.Error(err),
+		)
+		return fallback, LimitSourceFallback
+	default:
+		if limit, err = parseInt(val); err != nil {
+			s.logger.Warn("invalid default limit, using provided limit",
+				zap.String("value", val),
+				zap.Error(err),
+			)
+			limit = 0
+		}
+	}
+
+	s.cacheMutex.Lock()
+	s.defaultLimitCache = limit
+	s.defaultLimitExpiry = time.Now().Add(defaultLimitCacheTTL)
+	s.cacheMutex.Unlock()
+
+	if limit > 0 {
+		return limit, LimitSourceDefault
+	}
+	return fallback, LimitSourceFallback
 }
 
 // cleanupCache periodically removes expired entries from the local cache
@@ -201,18 +973,15 @@ func (s *Service) cleanupCache() {
 	defer ticker.Stop()
 
 	for range ticker.C {
-		s.cacheMutex.Lock()
-		now := time.Now()
-		for userID, expiry := range s.cacheExpiry {
-			if now.After(expiry) {
-				delete(s.userLimitsCache, userID)
-				delete(s.cacheExpiry, userID)
-			}
-		}
-		s.cacheMutex.Unlock()
+		s.userLimits.removeExpired(time.Now())
 	}
 }
 
+// cacheExpiry returns when user limits cached now expire
+func (s *Service) cacheExpiry() time.Time {
+	return time.Now().Add(time.Duration(s.config.LocalCacheTTL) * time.Second)
+}
+
 // parseInt safely parses an integer from a string
 func parseInt(s string) (int, error) {
 	if s == "" {
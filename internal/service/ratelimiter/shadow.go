@@ -0,0 +1,65 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"ratelimit-challenge/pkg/ratelimiter"
+
+	"go.uber.org/zap"
+)
+
+// shadowCheck runs the shadow algorithm alongside the enforced one
+// Its outcome is only reported, never enforced
+type shadowCheck struct {
+	algorithm string
+	allowed   bool
+	err       error
+	done      chan struct{}
+}
+
+// startShadow starts the shadow check, or returns nil when shadow mode is off
+// or the shadow algorithm is the one already enforced for this call
+func (s *Service) startShadow(ctx context.Context, enforced ratelimiter.RateLimiter, userID string, limit int, windowSize time.Duration) *shadowCheck {
+	algorithm := s.config.ShadowAlgorithm
+	if algorithm == "" || !IsKnownAlgorithm(algorithm) {
+		return nil
+	}
+	limiter := s.limiterFor(algorithm)
+	if limiter == enforced {
+		return nil
+	}
+
+	check := &shadowCheck{algorithm: algorithm, done: make(chan struct{})}
+	go func() {
+		defer close(check.done)
+		check.allowed, check.err = limiter.AllowN(ctx, userID, limit, requestCostFromContext(ctx), windowSize)
+	}()
+	return check
+}
+
+// recordShadow waits for the shadow check and reports it next to the
+// enforced decision
+func (s *Service) recordShadow(check *shadowCheck, userID string, allowed bool) {
+	if check == nil {
+		return
+	}
+	<-check.done
+
+	if check.err != nil {
+		s.logger.Warn("shadow rate limit check failed",
+			zap.String("user_id", userID),
+			zap.String("algorithm", check.algorithm),
+			zap.Error(check.err),
+		)
+		return
+	}
+
+	s.logger.Info("shadow rate limit decision",
+		zap.String("user_id", userID),
+		zap.String("algorithm", check.algorithm),
+		zap.Bool("allowed", allowed),
+		zap.Bool("shadow_allowed", check.allowed),
+		zap.Bool("agrees", allowed == check.allowed),
+	)
+}
@@ -0,0 +1,91 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"ratelimit-challenge/pkg/audit"
+	"ratelimit-challenge/pkg/ratelimiter"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// thresholdScript records the usage thresholds a user newly crossed, so each
+// is reported once until the record expires
+// ARGV is the usage as a percentage of the limit, the record's TTL in
+// milliseconds, then the thresholds
+// Returns the thresholds crossed since the last report
+const thresholdScript = `
+	local key = KEYS[1]
+	local usage = tonumber(ARGV[1])
+	local ttl_ms = tonumber(ARGV[2])
+
+	local last = tonumber(redis.call('GET', key) or '0')
+	local highest = last
+	local crossed = {}
+	for i = 3, #ARGV do
+		local threshold = tonumber(ARGV[i])
+		if threshold > last and threshold <= usage then
+			table.insert(crossed, threshold)
+			if threshold > highest then
+				highest = threshold
+			end
+		end
+	end
+
+	if highest > last then
+		redis.call('SET', key, highest, 'PX', ttl_ms)
+	end
+	return crossed
+`
+
+// thresholdLua is thresholdScript, sent by its SHA once Redis has it
+var thresholdLua = redis.NewScript(thresholdScript)
+
+// usageThresholdsEnabled reports whether crossing usage thresholds is reported
+func (s *Service) usageThresholdsEnabled() bool {
+	return len(s.config.UsageThresholds) > 0
+}
+
+// trackThresholds reports each configured usage threshold the user's usage
+// crossed with this request
+// A crossed threshold isn't reported again until a window passes without the
+// user crossing a higher one, so a user hovering near their limit isn't
+// notified on every request
+func (s *Service) trackThresholds(ctx context.Context, userID string, limiter ratelimiter.RateLimiter, key string, limit int, windowSize time.Duration) error {
+	if limit <= 0 {
+		return nil
+	}
+	remaining, err := limiter.GetRemaining(ctx, key, limit, windowSize)
+	if err != nil {
+		return fmt.Errorf("failed to get usage: %w", err)
+	}
+	return s.reportThresholds(ctx, userID, limit, (limit-remaining)*100/limit, windowSize)
+}
+
+// reportThresholds writes an event for each threshold newly crossed by a
+// usage of the given percentage of the limit
+func (s *Service) reportThresholds(ctx context.Context, userID string, limit, usage int, windowSize time.Duration) error {
+	key := s.key(fmt.Sprintf("rate_limit:threshold:%s", escapeIdentity(userID)))
+	args := make([]interface{}, 0, 2+len(s.config.UsageThresholds))
+	args = append(args, usage, windowSize.Milliseconds())
+	for _, threshold := range s.config.UsageThresholds {
+		args = append(args, threshold)
+	}
+
+	crossed, err := thresholdLua.Run(ctx, s.redisClient, []string{key}, args...).Int64Slice()
+	if err != nil {
+		return fmt.Errorf("failed to track usage thresholds: %w", err)
+	}
+
+	for _, threshold := range crossed {
+		s.writeEvent(audit.Event{
+			Type:      audit.EventThreshold,
+			UserID:    userID,
+			Limit:     limit,
+			Threshold: int(threshold),
+		})
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package ratelimiter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"ratelimit-challenge/pkg/audit"
+
+	"go.uber.org/zap"
+)
+
+// Tier is a limit over a window of the given number of seconds
+type Tier struct {
+	Limit  int `json:"limit"`
+	Window int `json:"window"`
+}
+
+// UserTiers holds a user's custom burst and sustained limits, both of which
+// must have room for a request to be allowed
+type UserTiers struct {
+	Burst     Tier `json:"burst"`
+	Sustained Tier `json:"sustained"`
+}
+
+// Validate checks that both tiers have a positive limit and window
+func (t UserTiers) Validate() error {
+	if t.Burst.Limit <= 0 || t.Burst.Window <= 0 {
+		return fmt.Errorf("burst limit and window must be greater than 0")
+	}
+	if t.Sustained.Limit <= 0 || t.Sustained.Window <= 0 {
+		return fmt.Errorf("sustained limit and window must be greater than 0")
+	}
+	return nil
+}
+
+// blockedValue is stored in place of a limit for users denied every request
+// A stored limit of 0 predates it and still means the default limit
+const blockedValue = "blocked"
+
+// userConfig is a user's stored limit: a single limit, tiers, or a block
+type userConfig struct {
+	limit   int
+	tiers   *UserTiers
+	blocked bool
+}
+
+// parseUserConfig parses a stored user limit
+// Values are either a bare integer, "blocked" or a JSON encoded UserTiers
+func parseUserConfig(val string) (userConfig, error) {
+	if val == blockedValue {
+		return userConfig{blocked: true}, nil
+	}
+	if limit, err := strconv.Atoi(val); err == nil {
+		return userConfig{limit: limit}, nil
+	}
+
+	var tiers UserTiers
+	if err := json.Unmarshal([]byte(val), &tiers); err != nil {
+		return userConfig{}, fmt.Errorf("invalid limit value %q", val)
+	}
+	if err := tiers.Validate(); err != nil {
+		return userConfig{}, fmt.Errorf("invalid limit tiers: %w", err)
+	}
+	return userConfig{tiers: &tiers}, nil
+}
+
+// SetUserTiers sets custom burst and sustained limits for a user, replacing
+// any single custom limit
+func (s *Service) SetUserTiers(ctx context.Context, userID string, tiers UserTiers) error {
+	userID = s.NormalizeIdentity(userID)
+	if err := tiers.Validate(); err != nil {
+		return err
+	}
+
+	val, err := json.Marshal(tiers)
+	if err != nil {
+		return fmt.Errorf("failed to encode user tiers: %w", err)
+	}
+
+	key := s.configKey(userID)
+	if err := s.configClient.Set(ctx, key, val, s.configTTL()).Err(); err != nil {
+		return fmt.Errorf("failed to set user tiers: %w", err)
+	}
+
+	s.cacheUserConfig(userID, userConfig{tiers: &tiers})
+
+	s.logger.Info("user rate limit tiers updated",
+		zap.String("user_id", userID),
+		zap.Int("burst_limit", tiers.Burst.Limit),
+		zap.Int("sustained_limit", tiers.Sustained.Limit),
+	)
+
+	return nil
+}
+
+// burstKey and sustainedKey return the limiter keys counting a user's tiers
+// Both are hash tagged with the user, so a Redis Cluster keeps them in one
+// slot for the composite check
+func burstKey(userID string) string     { return "{" + userID + "}:burst" }
+func sustainedKey(userID string) string { return "{" + userID + "}:sustained" }
+
+// tierChecks returns the composite checks enforcing the user's tiers
+func tierChecks(userID string, tiers *UserTiers) []Check {
+	return []Check{
+		{Key: burstKey(userID), Limit: tiers.Burst.Limit, Window: time.Duration(tiers.Burst.Window) * time.Second},
+		{Key: sustainedKey(userID), Limit: tiers.Sustained.Limit, Window: time.Duration(tiers.Sustained.Window) * time.Second},
+	}
+}
+
+// checkTiers enforces a user's burst and sustained limits together for a
+// request costing n slots
+// A request is only counted against the tiers if both allow it
+func (s *Service) checkTiers(ctx context.Context, userID string, tiers *UserTiers, n int) (Decision, error) {
+	allowed, _, err := s.composite.AllowAllN(ctx, tierChecks(userID, tiers), n)
+	if err != nil {
+		return Decision{Allowed: false, Code: failureCode(err), Reason: ReasonDegraded}, fmt.Errorf("rate limit check failed: %w", err)
+	}
+
+	if allowed {
+		return Decision{Allowed: true, Reason: ReasonUnderLimit}, nil
+	}
+
+	s.recordEvent(audit.EventDenied, userID, CodeRateLimited, tiers.Burst.Limit)
+	return Decision{Allowed: false, Code: CodeRateLimited, Reason: ReasonOverLimit}, nil
+}
+
+// tiersRetryAfter returns how long until both tiers admit another request
+func (s *Service) tiersRetryAfter(ctx context.Context, userID string, tiers *UserTiers) (time.Duration, error) {
+	var wait time.Duration
+	for _, check := range tierChecks(userID, tiers) {
+		d, err := s.composite.RetryAfter(ctx, check.Key, check.Limit, check.Window)
+		if err != nil {
+			return 0, err
+		}
+		if d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// tiersRemaining returns the remaining requests of the tighter tier, and
+// that tier's limit
+func (s *Service) tiersRemaining(ctx context.Context, userID string, tiers *UserTiers) (int, int, error) {
+	remaining, limit := -1, 0
+	for _, check := range tierChecks(userID, tiers) {
+		n, err := s.composite.GetRemaining(ctx, check.Key, check.Limit, check.Window)
+		if err != nil {
+			return 0, 0, err
+		}
+		if remaining < 0 || n < remaining {
+			remaining, limit = n, check.Limit
+		}
+	}
+	return remaining, limit, nil
+}
@@ -0,0 +1,42 @@
+package ratelimiter
+
+import (
+	"context"
+
+	"ratelimit-challenge/pkg/ratelimiter"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts the span of a check, as a child of the span in ctx (e.g.
+// the HTTP request's) if any; the limiter's own span nests under it
+func (s *Service) startSpan(ctx context.Context, userID string) (context.Context, trace.Span) {
+	tp := s.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(ratelimiter.TracerName).Start(ctx, "ratelimiter.RateLimit", trace.WithAttributes(
+		attribute.String("user_id", ratelimiter.HashUserID(userID)),
+		attribute.String("algorithm", s.algorithm(ctx)),
+	))
+}
+
+// endSpan records a check's decision on its span and ends it
+func endSpan(span trace.Span, decision Decision, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	attributes := []attribute.KeyValue{
+		attribute.Bool("allowed", decision.Allowed),
+		attribute.String("reason", string(decision.Reason)),
+	}
+	if decision.Result != nil {
+		attributes = append(attributes, attribute.Int("limit", decision.Result.Limit))
+	}
+	span.SetAttributes(attributes...)
+	span.End()
+}
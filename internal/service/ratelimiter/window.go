@@ -0,0 +1,60 @@
+package ratelimiter
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// SetWindowSize changes the window at runtime, e.g. when the configuration is
+// reloaded
+//
+// Requests are counted over whichever window is in effect when they are
+// checked, so switching straight to a shorter window would forget every
+// request older than it and let users burst all at once. Under the "gradual"
+// WindowChangePolicy (the default), a shorter window therefore only takes
+// effect once the old window has passed since the change, by when requests
+// counted under it have aged out. A longer window takes effect immediately:
+// nothing older than the old window was kept, so no user is suddenly denied
+// for requests they made before the change.
+//
+// Under "immediate" the new window applies at once in both directions
+func (s *Service) SetWindowSize(window time.Duration) {
+	s.windowMutex.Lock()
+	defer s.windowMutex.Unlock()
+
+	if window == s.windowSize {
+		return
+	}
+
+	s.logger.Info("rate limit window changed",
+		zap.Duration("old_window", s.windowSize),
+		zap.Duration("new_window", window),
+		zap.String("policy", s.config.WindowChangePolicy),
+	)
+
+	s.previousWindow = s.windowSize
+	s.windowChanged = time.Now()
+	s.windowSize = window
+	if s.config.WindowChangePolicy == "immediate" {
+		s.previousWindow = 0
+	}
+}
+
+// Window returns the window requests are counted over for this call
+func (s *Service) Window(ctx context.Context) time.Duration {
+	return s.window(ctx)
+}
+
+// configuredWindow returns the window in effect, which is the old window
+// while a gradual switch to a shorter one is still under way
+func (s *Service) configuredWindow() time.Duration {
+	s.windowMutex.RLock()
+	defer s.windowMutex.RUnlock()
+
+	if s.previousWindow > s.windowSize && time.Since(s.windowChanged) < s.previousWindow {
+		return s.previousWindow
+	}
+	return s.windowSize
+}
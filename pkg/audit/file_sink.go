@@ -0,0 +1,157 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileConfig contains file sink settings
+type FileConfig struct {
+	// Path of the active audit log file
+	Path string
+	// MaxSize in bytes after which the file is rotated (0 disables)
+	MaxSize int64
+	// MaxBackups is the number of rotated files kept as Path.1 ... Path.N
+	MaxBackups int
+	// RotateInterval after which the file is rotated regardless of size (0 disables)
+	RotateInterval time.Duration
+}
+
+// FileSink writes audit events as JSON lines to a rotating file
+// Writes are buffered; the buffer is flushed on rotation and on Close
+type FileSink struct {
+	config FileConfig
+
+	mu       sync.Mutex
+	file     *os.File
+	writer   *bufio.Writer
+	size     int64
+	openedAt time.Time
+}
+
+// NewFileSink opens (or creates) the audit log file for appending
+func NewFileSink(cfg FileConfig) (*FileSink, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("audit file path is required")
+	}
+
+	sink := &FileSink{config: cfg}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// Write appends the event to the file, rotating first if needed
+func (fs *FileSink) Write(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	line = append(line, '\n')
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
+		return fmt.Errorf("audit sink is closed")
+	}
+
+	if fs.shouldRotate(int64(len(line))) {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := fs.writer.Write(line)
+	fs.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	return nil
+}
+
+// Close flushes buffered events and closes the file
+func (fs *FileSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.closeFile()
+	fs.file = nil
+	return err
+}
+
+// shouldRotate reports whether writing n more bytes requires a new file
+// A file is never rotated while empty, so oversized events still get written
+func (fs *FileSink) shouldRotate(n int64) bool {
+	if fs.size == 0 {
+		return false
+	}
+	if fs.config.MaxSize > 0 && fs.size+n > fs.config.MaxSize {
+		return true
+	}
+	if fs.config.RotateInterval > 0 && time.Since(fs.openedAt) >= fs.config.RotateInterval {
+		return true
+	}
+	return false
+}
+
+// rotate shifts Path.N-1 -> Path.N ... Path -> Path.1 and opens a fresh file
+func (fs *FileSink) rotate() error {
+	if err := fs.closeFile(); err != nil {
+		return err
+	}
+
+	if fs.config.MaxBackups > 0 {
+		for i := fs.config.MaxBackups - 1; i >= 1; i-- {
+			from := fmt.Sprintf("%s.%d", fs.config.Path, i)
+			to := fmt.Sprintf("%s.%d", fs.config.Path, i+1)
+			if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to rotate audit file: %w", err)
+			}
+		}
+		if err := os.Rename(fs.config.Path, fs.config.Path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit file: %w", err)
+		}
+	} else if err := os.Remove(fs.config.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate audit file: %w", err)
+	}
+
+	return fs.open()
+}
+
+func (fs *FileSink) open() error {
+	file, err := os.OpenFile(fs.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit file: %w", err)
+	}
+
+	fs.file = file
+	fs.writer = bufio.NewWriter(file)
+	fs.size = info.Size()
+	fs.openedAt = time.Now()
+	return nil
+}
+
+func (fs *FileSink) closeFile() error {
+	if err := fs.writer.Flush(); err != nil {
+		fs.file.Close()
+		return fmt.Errorf("failed to flush audit file: %w", err)
+	}
+	if err := fs.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Producer publishes a batch of encoded events to a Kafka topic
+type Producer interface {
+	Publish(ctx context.Context, messages [][]byte) error
+	Close() error
+}
+
+// KafkaConfig contains Kafka sink settings
+type KafkaConfig struct {
+	// BatchSize is the maximum number of events published at once
+	BatchSize int
+	// FlushInterval after which a partial batch is published anyway
+	FlushInterval time.Duration
+	// BufferSize is the number of events queued before new ones are dropped
+	BufferSize int
+	// PublishTimeout bounds a single publish call
+	PublishTimeout time.Duration
+}
+
+// KafkaSink publishes audit events to Kafka in batches
+// Events are queued and delivered by a background goroutine, so Write never
+// blocks; when the queue is full or a publish fails the events are dropped
+// and counted instead
+type KafkaSink struct {
+	producer Producer
+	config   KafkaConfig
+	events   chan []byte
+	dropped  atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewKafkaSink creates a sink publishing through producer and starts delivery
+func NewKafkaSink(producer Producer, cfg KafkaConfig) *KafkaSink {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 10000
+	}
+	if cfg.PublishTimeout <= 0 {
+		cfg.PublishTimeout = 5 * time.Second
+	}
+
+	sink := &KafkaSink{
+		producer: producer,
+		config:   cfg,
+		events:   make(chan []byte, cfg.BufferSize),
+		done:     make(chan struct{}),
+	}
+	go sink.run()
+	return sink
+}
+
+// Write queues the event for delivery, dropping it if the queue is full
+func (ks *KafkaSink) Write(event Event) error {
+	message, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	if ks.closed {
+		return fmt.Errorf("audit sink is closed")
+	}
+
+	select {
+	case ks.events <- message:
+	default:
+		ks.dropped.Add(1)
+	}
+	return nil
+}
+
+// Dropped returns the number of events that were never delivered
+func (ks *KafkaSink) Dropped() uint64 {
+	return ks.dropped.Load()
+}
+
+// Close delivers the queued events and closes the producer
+func (ks *KafkaSink) Close() error {
+	ks.mu.Lock()
+	if ks.closed {
+		ks.mu.Unlock()
+		return nil
+	}
+	ks.closed = true
+	close(ks.events)
+	ks.mu.Unlock()
+
+	<-ks.done
+	return ks.producer.Close()
+}
+
+// run batches queued events and publishes them until the queue is closed
+func (ks *KafkaSink) run() {
+	defer close(ks.done)
+
+	ticker := time.NewTicker(ks.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([][]byte, 0, ks.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), ks.config.PublishTimeout)
+		defer cancel()
+		if err := ks.producer.Publish(ctx, batch); err != nil {
+			ks.dropped.Add(uint64(len(batch)))
+		}
+		batch = make([][]byte, 0, ks.config.BatchSize)
+	}
+
+	for {
+		select {
+		case message, ok := <-ks.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, message)
+			if len(batch) >= ks.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// kafkaProducer publishes through a kafka-go writer
+type kafkaProducer struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaProducer creates a Producer writing to topic on the given brokers
+func NewKafkaProducer(brokers []string, topic string) Producer {
+	return &kafkaProducer{
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Topic:                  topic,
+			Balancer:               &kafka.LeastBytes{},
+			AllowAutoTopicCreation: false,
+		},
+	}
+}
+
+// Publish writes the messages in a single request
+func (kp *kafkaProducer) Publish(ctx context.Context, messages [][]byte) error {
+	msgs := make([]kafka.Message, len(messages))
+	for i, message := range messages {
+		msgs[i] = kafka.Message{Value: message}
+	}
+	return kp.writer.WriteMessages(ctx, msgs...)
+}
+
+// Close closes the underlying writer
+func (kp *kafkaProducer) Close() error {
+	return kp.writer.Close()
+}
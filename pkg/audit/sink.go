@@ -0,0 +1,66 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Event types recorded by the rate limiter
+const (
+	// EventDenied is recorded when a request is denied
+	EventDenied = "denied"
+	// EventPenalty is recorded when a user is placed in the penalty box
+	EventPenalty = "penalty"
+	// EventThreshold is recorded when a user's usage crosses one of the
+	// configured percentages of their limit
+	EventThreshold = "threshold"
+)
+
+// Event describes a rate limiter decision worth keeping a record of
+type Event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	UserID string    `json:"user_id"`
+	Code   string    `json:"code,omitempty"`
+	Limit  int       `json:"limit,omitempty"`
+	// Threshold is the percentage of the limit crossed, for EventThreshold
+	Threshold int `json:"threshold,omitempty"`
+}
+
+// Sink receives audit events
+// Implementations must be safe for concurrent use and must not block the
+// request path for long, since events are written while serving requests
+type Sink interface {
+	// Write records an event
+	Write(event Event) error
+	// Close flushes any buffered events and releases resources
+	Close() error
+}
+
+// NopSink discards all events
+type NopSink struct{}
+
+// Write discards the event
+func (NopSink) Write(Event) error { return nil }
+
+// Close does nothing
+func (NopSink) Close() error { return nil }
+
+// Drain closes the sink, flushing its buffered events, and waits for it to
+// finish until ctx is done
+// Events still buffered when ctx ends are lost; Close carries on in the
+// background
+func Drain(ctx context.Context, sink Sink) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- sink.Close()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("audit sink drain interrupted: %w", ctx.Err())
+	}
+}
@@ -5,46 +5,118 @@ import (
 	"fmt"
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
+	"strings"
 	"time"
 )
 
+// Redis connection modes
+const (
+	// ModeSingle connects to one Redis server at Host:Port
+	ModeSingle = "single"
+	// ModeCluster connects to a Redis Cluster through the seed nodes in Addrs
+	ModeCluster = "cluster"
+	// ModeSentinel connects to the master named MasterName, found through the
+	// sentinels in Addrs, and follows it across failovers
+	ModeSentinel = "sentinel"
+)
+
 // RedisConfig contains Redis connection configuration
 type RedisConfig struct {
+	// Mode is ModeSingle (default when empty), ModeCluster or ModeSentinel
+	Mode     string
 	Host     string
 	Port     string
 	Password string
 	DB       int
+	// Addrs are the cluster's seed nodes or the sentinels, as host:port
+	Addrs []string
+	// MasterName is the master monitored by the sentinels
+	MasterName string
+	// SentinelPassword authenticates with the sentinels themselves
+	SentinelPassword string
 }
 
+// WithDB returns a copy of the config selecting database db instead, e.g. to
+// connect to a database set aside for one kind of data
+func (cfg RedisConfig) WithDB(db int) RedisConfig {
+	cfg.DB = db
+	return cfg
+}
+
+// RedisConnector creates a Redis client from cfg; NewRedis is the default,
+// replaced in tests
+type RedisConnector func(cfg RedisConfig, logger *zap.Logger) (redis.UniversalClient, error)
+
 // NewRedis creates a new Redis client with optimized settings for rate limiting
-func NewRedis(cfg RedisConfig, logger *zap.Logger) (*redis.Client, error) {
-	addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
-
-	client := redis.NewClient(&redis.Options{
-		Addr:         addr,
-		Password:     cfg.Password,
-		DB:           cfg.DB,
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-		// Optimized pool size for high concurrency rate limiting
-		PoolSize:     50,
-		MinIdleConns: 10,
-		// Connection pool settings for better performance
-		MaxRetries:      3,
-		MinRetryBackoff: 8 * time.Millisecond,
-		MaxRetryBackoff: 512 * time.Millisecond,
-	})
+// A cluster has no databases, so DB is ignored in ModeCluster
+func NewRedis(cfg RedisConfig, logger *zap.Logger) (redis.UniversalClient, error) {
+	var (
+		client redis.UniversalClient
+		addr   string
+	)
+	switch cfg.Mode {
+	case ModeCluster:
+		addr = strings.Join(cfg.Addrs, ",")
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        cfg.Addrs,
+			Password:     cfg.Password,
+			DialTimeout:  5 * time.Second,
+			ReadTimeout:  3 * time.Second,
+			WriteTimeout: 3 * time.Second,
+			// The pool size applies to each node
+			PoolSize:        50,
+			MinIdleConns:    10,
+			MaxRetries:      3,
+			MinRetryBackoff: 8 * time.Millisecond,
+			MaxRetryBackoff: 512 * time.Millisecond,
+		})
+	case ModeSentinel:
+		addr = cfg.MasterName + "@" + strings.Join(cfg.Addrs, ",")
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.MasterName,
+			SentinelAddrs:    cfg.Addrs,
+			SentinelPassword: cfg.SentinelPassword,
+			Password:         cfg.Password,
+			DB:               cfg.DB,
+			DialTimeout:      5 * time.Second,
+			ReadTimeout:      3 * time.Second,
+			WriteTimeout:     3 * time.Second,
+			PoolSize:         50,
+			MinIdleConns:     10,
+			MaxRetries:       3,
+			MinRetryBackoff:  8 * time.Millisecond,
+			MaxRetryBackoff:  512 * time.Millisecond,
+		})
+	default:
+		addr = fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
+		client = redis.NewClient(&redis.Options{
+			Addr:         addr,
+			Password:     cfg.Password,
+			DB:           cfg.DB,
+			DialTimeout:  5 * time.Second,
+			ReadTimeout:  3 * time.Second,
+			WriteTimeout: 3 * time.Second,
+			// Optimized pool size for high concurrency rate limiting
+			PoolSize:     50,
+			MinIdleConns: 10,
+			// Connection pool settings for better performance
+			MaxRetries:      3,
+			MinRetryBackoff: 8 * time.Millisecond,
+			MaxRetryBackoff: 512 * time.Millisecond,
+		})
+	}
 
 	// Test connection
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to connect to redis: %w", err)
 	}
 
 	logger.Info("connected to redis",
+		zap.String("mode", cfg.modeName()),
 		zap.String("addr", addr),
 		zap.Int("db", cfg.DB),
 		zap.Int("pool_size", 50),
@@ -52,3 +124,11 @@ func NewRedis(cfg RedisConfig, logger *zap.Logger) (*redis.Client, error) {
 
 	return client, nil
 }
+
+// modeName returns the connection mode, ModeSingle when unset
+func (cfg RedisConfig) modeName() string {
+	if cfg.Mode == "" {
+		return ModeSingle
+	}
+	return cfg.Mode
+}
@@ -0,0 +1,79 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// batchChunkSize is the most checks AllowEach sends in one pipeline, so a
+// large batch doesn't hold a single connection, or Redis, for long
+const batchChunkSize = 1000
+
+// AllowEach checks several sliding windows independently, sending the checks
+// in pipelines of up to 1000, so a batch takes one round trip per 1000 checks
+// Unlike AllowAll, each check consumes a slot whenever it has room, whatever
+// the others decide. Results are in the order of checks; a check that failed
+// has Err set and is not allowed. The error is only returned, alongside the
+// results, when none of the checks could be run
+func (sw *SlidingWindow) AllowEach(ctx context.Context, checks []Check) ([]Result, error) {
+	if len(checks) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	cmds := make([]*redis.Cmd, len(checks))
+	for offset := 0; offset < len(checks); offset += batchChunkSize {
+		end := min(offset+batchChunkSize, len(checks))
+		pipe := sw.client.Pipeline()
+		for i := offset; i < end; i++ {
+			check := checks[i]
+			cmds[i] = pipe.Eval(ctx, slidingWindowScript, []string{sw.keyPrefix + check.Key},
+				strconv.FormatInt(now.UnixMilli(), 10),
+				strconv.FormatInt(now.Add(-check.Window).UnixMilli(), 10),
+				strconv.Itoa(check.Limit),
+				strconv.FormatInt(sw.options.keyTTL(check.Window).Milliseconds(), 10),
+				sw.options.boundaryArg(),
+				"1",
+			)
+		}
+
+		start := time.Now()
+		// Exec only reports the first failure; each command carries its own
+		_, _ = pipe.Exec(ctx)
+		sw.options.observe("allow_batch", "sliding_window", start)
+	}
+
+	results := make([]Result, len(checks))
+	var lastErr error
+	failed := 0
+	for i, check := range checks {
+		reply, err := cmds[i].Result()
+		var capacity Capacity
+		if err == nil {
+			capacity, err = capacityFromReply(reply, 1, now)
+		} else {
+			err = backendError(sw.client, "rate limit check failed", err)
+		}
+		if err != nil {
+			sw.logger.Error("batched rate limit check failed",
+				zap.String("user_id", check.Key),
+				zap.String("backend", backendAddr(sw.client)),
+				zap.Error(err),
+			)
+			results[i] = Result{Key: check.Key, Limit: check.Limit, Err: err}
+			lastErr = err
+			failed++
+			continue
+		}
+		results[i] = capacity.Result(check.Key, check.Limit)
+	}
+
+	if failed == len(checks) {
+		return results, lastErr
+	}
+	return results, nil
+}
@@ -0,0 +1,95 @@
+package ratelimiter
+
+import (
+	"fmt"
+	"time"
+)
+
+// Capacity is the outcome of a request costing n units, as returned by CheckN
+type Capacity struct {
+	// Allowed reports whether all Requested units were consumed
+	Allowed bool
+	// Requested is the request's cost
+	Requested int
+	// Available is the number of units left before the request
+	Available int
+	// Shortfall is how many more units a denied request needed (0 if it was
+	// allowed), so Requested-Shortfall would have fit
+	Shortfall int
+	// RetryAfter is how long until a denied request would fit, assuming no
+	// further requests are made (0 if it was allowed, or can never fit)
+	RetryAfter time.Duration
+	// ResetAt is when every unit counted so far has been freed up (zero if
+	// none is counted)
+	ResetAt time.Time
+}
+
+// capacityFromReply parses the {allowed, available[, retry_at, reset_at]}
+// reply of an allow script for a request costing n units, made at now
+// retry_at and reset_at are Unix milliseconds, 0 when not applicable
+func capacityFromReply(reply interface{}, n int, now time.Time) (Capacity, error) {
+	values, ok := reply.([]interface{})
+	if !ok || (len(values) != 2 && len(values) != 4) {
+		return Capacity{}, fmt.Errorf("unexpected script reply %v", reply)
+	}
+	integers := make([]int64, len(values))
+	for i, value := range values {
+		integer, ok := value.(int64)
+		if !ok {
+			return Capacity{}, fmt.Errorf("unexpected script reply %v", reply)
+		}
+		integers[i] = integer
+	}
+
+	available := integers[1]
+	if available < 0 {
+		available = 0
+	}
+
+	capacity := Capacity{
+		Allowed:   integers[0] == 1,
+		Requested: n,
+		Available: int(available),
+	}
+	if !capacity.Allowed && n > capacity.Available {
+		capacity.Shortfall = n - capacity.Available
+	}
+	if len(integers) == 4 {
+		if retryAt := integers[2]; !capacity.Allowed && retryAt > 0 {
+			capacity.RetryAfter = untilMilli(retryAt, now)
+		}
+		if resetAt := integers[3]; resetAt > 0 {
+			capacity.ResetAt = time.UnixMilli(resetAt)
+		}
+	}
+	return capacity, nil
+}
+
+// untilMilli returns how long from now until the Unix millisecond ms, never
+// negative
+func untilMilli(ms int64, now time.Time) time.Duration {
+	if wait := time.UnixMilli(ms).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// Result reports the capacity found for key's request, under limit, as a
+// Result
+func (c Capacity) Result(key string, limit int) Result {
+	remaining := c.Available
+	if c.Allowed {
+		remaining -= c.Requested
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return Result{
+		Key:        key,
+		Limit:      limit,
+		Remaining:  remaining,
+		Allowed:    c.Allowed,
+		RetryAfter: c.RetryAfter,
+		ResetAt:    c.ResetAt,
+	}
+}
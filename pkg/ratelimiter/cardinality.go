@@ -0,0 +1,134 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// Cardinality limits how many distinct resources a user accesses per window,
+// e.g. at most 100 unique documents per hour
+// Repeated access to a resource already seen in the window is free
+// Each fixed window is a Redis set of the resource IDs accessed during it
+type Cardinality struct {
+	client    redis.UniversalClient
+	logger    *zap.Logger
+	keyPrefix string
+	options   options
+}
+
+// NewCardinality creates a new distinct resource limiter
+func NewCardinality(client redis.UniversalClient, logger *zap.Logger, opts ...Option) *Cardinality {
+	o := newOptions(opts)
+	return &Cardinality{
+		client:    client,
+		logger:    logger,
+		keyPrefix: o.keyPrefix("rate_limit:distinct:"),
+		options:   o,
+	}
+}
+
+// cardinalityScript records the resource in the window's set if it is
+// already there or the set is under the limit, all atomically
+// Returns 1 if the access is allowed and 0 otherwise
+const cardinalityScript = `
+	local key = KEYS[1]
+	local resource = ARGV[1]
+	local limit = tonumber(ARGV[2])
+	local ttl_ms = tonumber(ARGV[3])
+	local inclusive = tonumber(ARGV[4])
+
+	-- Resources already accessed in this window cost nothing
+	if redis.call('SISMEMBER', key, resource) == 1 then
+		return 1
+	end
+
+	if redis.call('SCARD', key) < limit + inclusive then
+		redis.call('SADD', key, resource)
+		redis.call('PEXPIRE', key, ttl_ms)
+		return 1
+	end
+	return 0
+`
+
+// Allow checks if the user may access the resource
+// Returns true if it was already accessed in the current window or the user
+// has accessed fewer than limit distinct resources in it
+func (cl *Cardinality) Allow(ctx context.Context, userID, resourceID string, limit int, windowSize time.Duration) (bool, error) {
+	key := cl.key(userID, windowSize, time.Now())
+
+	start := time.Now()
+	result, err := cl.options.runWithRetry(ctx, cl.client, cardinalityLua, []string{key},
+		resourceID,
+		strconv.Itoa(limit),
+		strconv.FormatInt(cl.options.keyTTL(windowSize).Milliseconds(), 10),
+		cl.options.boundaryArg(),
+	)
+	cl.options.observe("allow", "distinct", start)
+
+	if err != nil {
+		cl.logger.Error("distinct resource limit check failed",
+			zap.String("user_id", userID),
+			zap.String("backend", backendAddr(cl.client)),
+			zap.Error(err),
+		)
+		return false, backendError(cl.client, "rate limit check failed", err)
+	}
+
+	allowed := result.(int64) == 1
+
+	if !allowed {
+		cl.logger.Debug("distinct resource limit exceeded",
+			zap.String("user_id", userID),
+			zap.String("resource_id", resourceID),
+			zap.Int("limit", limit),
+		)
+	}
+
+	return allowed, nil
+}
+
+// GetRemaining returns how many more distinct resources the user may access
+// in the current window
+func (cl *Cardinality) GetRemaining(ctx context.Context, userID string, limit int, windowSize time.Duration) (int, error) {
+	key := cl.key(userID, windowSize, time.Now())
+
+	start := time.Now()
+	count, err := cl.client.SCard(ctx, key).Result()
+	cl.options.observe("remaining", "distinct", start)
+	if err != nil {
+		return 0, backendError(cl.client, "failed to get remaining requests", err)
+	}
+
+	remaining := cl.options.capacity(limit) - int(count)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return remaining, nil
+}
+
+// Reset clears the resources the user accessed in the current window
+func (cl *Cardinality) Reset(ctx context.Context, userID string, windowSize time.Duration) error {
+	key := cl.key(userID, windowSize, time.Now())
+
+	start := time.Now()
+	err := cl.client.Del(ctx, key).Err()
+	cl.options.observe("reset", "distinct", start)
+	if err != nil {
+		return backendError(cl.client, "failed to reset rate limit", err)
+	}
+	return nil
+}
+
+// key returns the key of the set for the window containing now
+func (cl *Cardinality) key(userID string, windowSize time.Duration, now time.Time) string {
+	window := int64(0)
+	if windowSize > 0 {
+		window = now.UnixMilli() / windowSize.Milliseconds()
+	}
+	return cl.keyPrefix + userID + ":" + strconv.FormatInt(window, 10)
+}
@@ -0,0 +1,94 @@
+package ratelimiter
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// BackendError is returned when a Redis command fails
+// It records which backend the command was sent to, so errors from
+// different instances can be told apart in logs
+type BackendError struct {
+	// Backend is the address of the Redis instance
+	Backend string
+	// Op describes what the limiter was doing
+	Op  string
+	Err error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("%s (backend %s): %v", e.Op, e.Backend, e.Err)
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// backendError wraps err with the identity of the client's backend
+func backendError(client redis.UniversalClient, op string, err error) error {
+	return &BackendError{
+		Backend: backendAddr(client),
+		Op:      op,
+		Err:     err,
+	}
+}
+
+// backendAddr returns the address of the client's backend, or the seed
+// addresses of a cluster, joined by commas
+func backendAddr(client redis.UniversalClient) string {
+	switch c := client.(type) {
+	case *redis.Client:
+		return c.Options().Addr
+	case *redis.ClusterClient:
+		return strings.Join(c.Options().Addrs, ",")
+	}
+	return "unknown"
+}
+
+// IsUnavailable reports whether err means Redis couldn't be reached, e.g.
+// the connection was refused, dropped or timed out, as opposed to Redis
+// answering with an error
+func IsUnavailable(err error) bool {
+	if err == nil || IsFatal(err) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, redis.ErrClosed) {
+		return true
+	}
+	for next := err; next != nil; next = errors.Unwrap(next) {
+		err = next
+	}
+	return err.Error() == "redis: connection pool timeout"
+}
+
+// fatalPrefixes start the replies Redis gives when the client is
+// misconfigured: bad credentials, or an ACL user lacking a permission
+var fatalPrefixes = []string{"NOAUTH ", "WRONGPASS ", "NOPERM "}
+
+// IsFatal reports whether err means the limiter is misconfigured rather than
+// briefly unavailable, e.g. a wrong password or an ACL denying EVAL
+// These never resolve on their own, so they shouldn't be retried or treated
+// like a connectivity blip
+func IsFatal(err error) bool {
+	// The reply from Redis is at the bottom of the chain
+	for next := err; next != nil; next = errors.Unwrap(next) {
+		err = next
+	}
+	if err == nil {
+		return false
+	}
+
+	msg := err.Error()
+	for _, prefix := range fatalPrefixes {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,265 @@
+package ratelimiter
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// FixedWindow implements a fixed window rate limiter using one Redis counter
+// per user and window
+// It is the cheapest algorithm in memory and CPU, but allows up to twice the
+// limit across a window boundary
+type FixedWindow struct {
+	client    redis.UniversalClient
+	logger    *zap.Logger
+	keyPrefix string
+	options   options
+}
+
+// NewFixedWindow creates a new fixed window rate limiter
+func NewFixedWindow(client redis.UniversalClient, logger *zap.Logger, opts ...Option) *FixedWindow {
+	o := newOptions(opts)
+	return &FixedWindow{
+		client:    client,
+		logger:    logger,
+		keyPrefix: o.keyPrefix("rate_limit:fixed:"),
+		options:   o,
+	}
+}
+
+// fixedWindowScript counts the request's cost against the window's counter,
+// starting its expiry on the window's first request, and takes it back if
+// it didn't fit so denied requests don't count
+// Returns {1, available} if the request is allowed and {0, available}
+// otherwise, with available the units left before the request
+const fixedWindowScript = `
+	local key = KEYS[1]
+	local limit = tonumber(ARGV[1])
+	local cost = tonumber(ARGV[2])
+	local inclusive = tonumber(ARGV[3])
+	local ttl_ms = tonumber(ARGV[4])
+
+	local count = redis.call('INCRBY', key, cost)
+	if count == cost then
+		redis.call('PEXPIRE', key, ttl_ms)
+	end
+
+	local available = limit + inclusive - (count - cost)
+	if cost > available then
+		redis.call('DECRBY', key, cost)
+		return {0, available}
+	end
+	return {1, available}
+`
+
+// fixedRefundScript takes one request back from the window's counter, never
+// taking it below zero
+// Returns 1 if a request was refunded
+const fixedRefundScript = `
+	local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+	if count <= 0 then
+		return 0
+	end
+	redis.call('DECR', KEYS[1])
+	return 1
+`
+
+// Allow checks if a request fits in the current window
+func (fw *FixedWindow) Allow(ctx context.Context, userID string, limit int, windowSize time.Duration) (bool, error) {
+	return fw.AllowN(ctx, userID, limit, 1, windowSize)
+}
+
+// AllowN is like Allow for a request costing n slots, consuming all n or none
+func (fw *FixedWindow) AllowN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (bool, error) {
+	capacity, err := fw.CheckN(ctx, userID, limit, n, windowSize)
+	return capacity.Allowed, err
+}
+
+// CheckN is like AllowN but also reports the units left in the current window
+func (fw *FixedWindow) CheckN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error) {
+	if n <= 0 {
+		return Capacity{Allowed: true, Requested: n}, nil
+	}
+
+	start := time.Now()
+	result, err := fw.options.runWithRetry(ctx, fw.client, fixedWindowLua, []string{fw.key(userID, start, windowSize)},
+		strconv.Itoa(limit),
+		strconv.Itoa(n),
+		fw.options.boundaryArg(),
+		strconv.FormatInt(fw.options.keyTTL(windowSize).Milliseconds(), 10),
+	)
+	fw.options.observe("allow", "fixed_window", start)
+
+	if err != nil {
+		fw.logger.Error("fixed window rate limit check failed",
+			zap.String("user_id", userID),
+			zap.String("backend", backendAddr(fw.client)),
+			zap.Error(err),
+		)
+		return Capacity{}, backendError(fw.client, "rate limit check failed", err)
+	}
+
+	capacity, err := capacityFromReply(result, n, start)
+	if err != nil {
+		return Capacity{}, err
+	}
+	// Every request counted in the window is freed when it ends
+	end := fixedWindowEnd(start, windowSize)
+	capacity.ResetAt = end
+	if !capacity.Allowed && n <= fw.options.capacity(limit) {
+		capacity.RetryAfter = end.Sub(start)
+	}
+	if !capacity.Allowed {
+		fw.logger.Debug("rate limit exceeded (fixed window)",
+			zap.String("user_id", userID),
+			zap.Int("limit", limit),
+			zap.Int("cost", n),
+			zap.Int("available", capacity.Available),
+		)
+	}
+
+	return capacity, nil
+}
+
+// AllowResult is like Allow but also reports the remaining requests and
+// when the user may retry, in the same round trip
+func (fw *FixedWindow) AllowResult(ctx context.Context, userID string, limit int, windowSize time.Duration) (Result, error) {
+	capacity, err := fw.CheckN(ctx, userID, limit, 1, windowSize)
+	if err != nil {
+		return Result{}, err
+	}
+	return capacity.Result(userID, limit), nil
+}
+
+// GetRemaining returns the number of requests left in the current window
+func (fw *FixedWindow) GetRemaining(ctx context.Context, userID string, limit int, windowSize time.Duration) (int, error) {
+	return fw.GetRemainingAt(ctx, userID, limit, windowSize, time.Now())
+}
+
+// GetRemainingAt returns the requests left at the given instant; a later
+// window starts with the full limit
+func (fw *FixedWindow) GetRemainingAt(ctx context.Context, userID string, limit int, windowSize time.Duration, at time.Time) (int, error) {
+	start := time.Now()
+	count, err := fw.count(ctx, fw.key(userID, at, windowSize))
+	fw.options.observe("remaining", "fixed_window", start)
+	if err != nil {
+		return 0, err
+	}
+	return fw.remaining(limit, count), nil
+}
+
+// GetStats returns the number of requests counted in the current window
+func (fw *FixedWindow) GetStats(ctx context.Context, userID string, limit int, windowSize time.Duration) (Stats, error) {
+	start := time.Now()
+	count, err := fw.count(ctx, fw.key(userID, start, windowSize))
+	fw.options.observe("stats", "fixed_window", start)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return Stats{
+		Algorithm: "fixed_window",
+		Limit:     limit,
+		Remaining: fw.remaining(limit, count),
+		Count:     count,
+	}, nil
+}
+
+// RetryAfter returns how long until the current window ends, if it is full
+func (fw *FixedWindow) RetryAfter(ctx context.Context, userID string, limit int, windowSize time.Duration) (time.Duration, error) {
+	now := time.Now()
+	count, err := fw.count(ctx, fw.key(userID, now, windowSize))
+	fw.options.observe("retry_after", "fixed_window", now)
+	if err != nil {
+		return 0, err
+	}
+	if fw.remaining(limit, count) > 0 {
+		return 0, nil
+	}
+
+	return fixedWindowEnd(now, windowSize).Sub(now), nil
+}
+
+// Refund returns one slot of the user's current window
+// A request counted just before the window rolled over isn't refunded,
+// its window having already been left behind
+func (fw *FixedWindow) Refund(ctx context.Context, userID string, windowSize time.Duration) error {
+	start := time.Now()
+	err := fixedRefundLua.Run(ctx, fw.client, []string{fw.key(userID, start, windowSize)}).Err()
+	fw.options.observe("refund", "fixed_window", start)
+	if err != nil {
+		return backendError(fw.client, "failed to refund request", err)
+	}
+	return nil
+}
+
+// Reset clears the rate limit for a user, deleting the counter of their
+// current window and of the previous one, which may not have expired yet
+func (fw *FixedWindow) Reset(ctx context.Context, userID string, windowSize time.Duration) error {
+	start := time.Now()
+	_, err := deleteKeys(ctx, fw.client,
+		fw.key(userID, start, windowSize),
+		fw.key(userID, start.Add(-fixedWindowDuration(windowSize)), windowSize),
+	)
+	fw.options.observe("reset", "fixed_window", start)
+	if err != nil {
+		return backendError(fw.client, "failed to reset rate limit", err)
+	}
+	return nil
+}
+
+// key returns the user's counter for the window containing t:
+// rate_limit:fixed:<user>:<window epoch>
+func (fw *FixedWindow) key(userID string, t time.Time, windowSize time.Duration) string {
+	return fw.keyPrefix + userID + ":" + strconv.FormatInt(fixedWindowEpoch(t, windowSize), 10)
+}
+
+// count returns the requests counted under key
+func (fw *FixedWindow) count(ctx context.Context, key string) (int, error) {
+	count, err := fw.client.Get(ctx, key).Int()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, backendError(fw.client, "failed to get window counter", err)
+	}
+	return count, nil
+}
+
+// remaining returns the requests left after count, never negative
+func (fw *FixedWindow) remaining(limit, count int) int {
+	remaining := fw.options.capacity(limit) - count
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// fixedWindowSeconds returns the window size in whole seconds, at least 1
+func fixedWindowSeconds(windowSize time.Duration) int64 {
+	if size := int64(windowSize / time.Second); size > 0 {
+		return size
+	}
+	return 1
+}
+
+// fixedWindowDuration returns the length of a fixed window, in whole
+// seconds like its epoch
+func fixedWindowDuration(windowSize time.Duration) time.Duration {
+	return time.Duration(fixedWindowSeconds(windowSize)) * time.Second
+}
+
+// fixedWindowEpoch returns the number of the fixed window containing t,
+// counted in windows since the Unix epoch
+func fixedWindowEpoch(t time.Time, windowSize time.Duration) int64 {
+	return t.Unix() / fixedWindowSeconds(windowSize)
+}
+
+// fixedWindowEnd returns when the fixed window containing t ends
+func fixedWindowEnd(t time.Time, windowSize time.Duration) time.Time {
+	return time.Unix((fixedWindowEpoch(t, windowSize)+1)*fixedWindowSeconds(windowSize), 0)
+}
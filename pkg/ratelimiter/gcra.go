@@ -0,0 +1,341 @@
+package ratelimiter
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"math"
+	"strconv"
+	"time"
+)
+
+// GCRA implements the generic cell rate algorithm using Redis
+// Like a leaky bucket used as a meter it spaces requests evenly, one per
+// emission interval (windowSize/limit), while admitting up to burst of them
+// back to back. It stores a single theoretical arrival time (TAT) per user
+// instead of timestamps.
+type GCRA struct {
+	client    redis.UniversalClient
+	logger    *zap.Logger
+	keyPrefix string
+	burst     int
+	options   options
+}
+
+// NewGCRA creates a new GCRA rate limiter admitting up to burst requests at
+// once; a burst below 1 admits the whole limit at once
+func NewGCRA(client redis.UniversalClient, logger *zap.Logger, burst int, opts ...Option) *GCRA {
+	o := newOptions(opts)
+	return &GCRA{
+		client:    client,
+		logger:    logger,
+		keyPrefix: o.keyPrefix("rate_limit:gcra:"),
+		burst:     burst,
+		options:   o,
+	}
+}
+
+// gcraScript advances the user's TAT by one emission interval per unit of
+// cost if the request conforms, all atomically
+// A request conforms when now >= tat - burst*interval, tat being the TAT
+// once the request is counted
+// The interval is kept alongside the TAT so Refund can step it back
+// Returns {allowed, available, retry_at, reset_at}: 1 if the request is
+// allowed and 0 otherwise, the whole units free before the request, when a
+// denied request would conform (0 if it was allowed or never conforms) and
+// when the TAT catches up with the clock, in Unix milliseconds
+const gcraScript = `
+	local key = KEYS[1]
+	local current_time = tonumber(ARGV[1])
+	local limit = tonumber(ARGV[2])
+	local window_size_ms = tonumber(ARGV[3])
+	local ttl_ms = tonumber(ARGV[4])
+	local burst = tonumber(ARGV[5])
+	local cost = tonumber(ARGV[6])
+	local interval = window_size_ms / limit
+	local tolerance = burst * interval
+
+	-- A TAT in the past means the user has been idle; start from now
+	local tat = tonumber(redis.call('HGET', key, 'tat'))
+	if not tat or tat < current_time then
+		tat = current_time
+	end
+
+	local available = math.floor((tolerance - (tat - current_time)) / interval)
+	local new_tat = tat + cost * interval
+	local allow_at = new_tat - tolerance
+
+	if current_time >= allow_at then
+		redis.call('HSET', key, 'tat', new_tat, 'interval', interval)
+		-- Keep the key at least until the TAT has passed
+		redis.call('PEXPIRE', key, math.max(ttl_ms, math.ceil(new_tat - current_time)))
+		return {1, available, 0, math.ceil(new_tat)}  -- Allowed
+	end
+
+	-- The request conforms once the clock reaches allow_at
+	local retry_at = 0
+	if cost <= burst then
+		retry_at = math.ceil(allow_at)
+	end
+	return {0, available, retry_at, math.ceil(tat)}  -- Denied
+`
+
+// Allow checks if a request conforms to the user's rate
+// Returns true if allowed, false if rate limit exceeded
+//
+// Algorithm:
+// 1. Use Redis key to store the user's theoretical arrival time (TAT)
+// 2. Each request pushes the TAT one emission interval (windowSize/limit)
+// further into the future
+// 3. If the TAT stays within burst intervals of now, allow the request
+// 4. Otherwise, deny the request and leave the TAT as it was
+//
+// Trade-offs:
+// - Lowest memory usage (one number per user)
+// - Smooth, evenly spaced admissions after the initial burst
+// - Limits the rate rather than a count per window
+func (g *GCRA) Allow(ctx context.Context, userID string, limit int, windowSize time.Duration) (bool, error) {
+	return g.AllowN(ctx, userID, limit, 1, windowSize)
+}
+
+// AllowN is like Allow for a request costing n emission intervals; it is
+// allowed only if all n conform
+func (g *GCRA) AllowN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (bool, error) {
+	capacity, err := g.CheckN(ctx, userID, limit, n, windowSize)
+	return capacity.Allowed, err
+}
+
+// CheckN is like AllowN but also reports the whole units that would have
+// conformed
+func (g *GCRA) CheckN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error) {
+	ctx, span := g.options.startSpan(ctx, "gcra", userID, limit)
+	capacity, err := g.checkN(ctx, userID, limit, n, windowSize)
+	endSpan(span, capacity.Allowed, err)
+	return capacity, err
+}
+
+// checkN runs the GCRA script for CheckN
+func (g *GCRA) checkN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error) {
+	if n <= 0 {
+		return Capacity{Allowed: true, Requested: n}, nil
+	}
+	// No rate at all admits nothing
+	if limit <= 0 || windowSize.Milliseconds() <= 0 {
+		return Capacity{Requested: n, Shortfall: n}, nil
+	}
+
+	key := g.keyPrefix + userID
+	now := time.Now()
+	currentTime := now.UnixMilli()
+
+	start := time.Now()
+	result, err := g.options.runWithRetry(ctx, g.client, gcraLua, []string{key},
+		strconv.FormatInt(currentTime, 10),
+		strconv.Itoa(limit),
+		strconv.FormatInt(windowSize.Milliseconds(), 10),
+		strconv.FormatInt(g.options.keyTTL(windowSize).Milliseconds(), 10),
+		strconv.Itoa(g.burstFor(limit)),
+		strconv.Itoa(n),
+	)
+	g.options.observe("allow", "gcra", start)
+
+	if err != nil {
+		g.logger.Error("gcra rate limit check failed",
+			zap.String("user_id", userID),
+			zap.String("backend", backendAddr(g.client)),
+			zap.Error(err),
+		)
+		return Capacity{}, backendError(g.client, "rate limit check failed", err)
+	}
+
+	capacity, err := capacityFromReply(result, n, now)
+	if err != nil {
+		return Capacity{}, err
+	}
+
+	if !capacity.Allowed {
+		g.logger.Debug("rate limit exceeded (gcra)",
+			zap.String("user_id", userID),
+			zap.Int("limit", limit),
+			zap.Int("cost", n),
+			zap.Int("available", capacity.Available),
+		)
+	}
+
+	return capacity, nil
+}
+
+// AllowResult is like Allow but also reports the remaining requests and
+// when the user may retry, in the same round trip
+func (g *GCRA) AllowResult(ctx context.Context, userID string, limit int, windowSize time.Duration) (Result, error) {
+	capacity, err := g.CheckN(ctx, userID, limit, 1, windowSize)
+	if err != nil {
+		return Result{}, err
+	}
+	return capacity.Result(userID, limit), nil
+}
+
+// GetRemaining returns the number of requests that would conform right now
+func (g *GCRA) GetRemaining(ctx context.Context, userID string, limit int, windowSize time.Duration) (int, error) {
+	return g.GetRemainingAt(ctx, userID, limit, windowSize, time.Now())
+}
+
+// GetRemainingAt returns the number of requests that would conform at the
+// given instant
+func (g *GCRA) GetRemainingAt(ctx context.Context, userID string, limit int, windowSize time.Duration, at time.Time) (int, error) {
+	start := time.Now()
+	backlog, err := g.backlogAt(ctx, userID, at)
+	g.options.observe("remaining", "gcra", start)
+	if err != nil {
+		return 0, err
+	}
+	return g.remaining(backlog, limit, windowSize), nil
+}
+
+// GetStats returns the requests still counted against the user, the burst
+// they may make at once, the sustained rate and how long until the TAT
+// catches up with the clock
+func (g *GCRA) GetStats(ctx context.Context, userID string, limit int, windowSize time.Duration) (Stats, error) {
+	start := time.Now()
+	backlog, err := g.backlogAt(ctx, userID, start)
+	g.options.observe("stats", "gcra", start)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	var level float64
+	if interval := emissionInterval(limit, windowSize); interval > 0 {
+		level = float64(backlog) / float64(interval)
+	}
+
+	return Stats{
+		Algorithm:   "gcra",
+		Limit:       limit,
+		Remaining:   g.remaining(backlog, limit, windowSize),
+		Level:       level,
+		Capacity:    g.burstFor(limit),
+		LeakRate:    leakRate(limit, windowSize) * 1000,
+		TimeToEmpty: backlog,
+	}, nil
+}
+
+// RetryAfter returns how long until another request conforms
+func (g *GCRA) RetryAfter(ctx context.Context, userID string, limit int, windowSize time.Duration) (time.Duration, error) {
+	interval := emissionInterval(limit, windowSize)
+	if interval <= 0 {
+		return 0, nil
+	}
+
+	start := time.Now()
+	backlog, err := g.backlogAt(ctx, userID, start)
+	g.options.observe("retry_after", "gcra", start)
+	if err != nil {
+		return 0, err
+	}
+
+	// One more request conforms once backlog + interval <= burst*interval
+	wait := backlog + interval - time.Duration(g.burstFor(limit))*interval
+	if wait <= 0 {
+		return 0, nil
+	}
+	return wait.Round(time.Millisecond), nil
+}
+
+// gcraRefundScript steps the TAT back by one emission interval, keeping the
+// read-modify-write atomic
+const gcraRefundScript = `
+	local key = KEYS[1]
+	local state = redis.call('HMGET', key, 'tat', 'interval')
+	if not state[1] or not state[2] then
+		return 0
+	end
+	redis.call('HSET', key, 'tat', tonumber(state[1]) - tonumber(state[2]))
+	return 1
+`
+
+// Refund steps the user's TAT back by one emission interval, returning one
+// request
+func (g *GCRA) Refund(ctx context.Context, userID string, windowSize time.Duration) error {
+	key := g.keyPrefix + userID
+
+	start := time.Now()
+	err := gcraRefundLua.Run(ctx, g.client, []string{key}).Err()
+	g.options.observe("refund", "gcra", start)
+	if err != nil {
+		return backendError(g.client, "failed to refund request", err)
+	}
+	return nil
+}
+
+// Reset clears the rate limit for a user
+func (g *GCRA) Reset(ctx context.Context, userID string, windowSize time.Duration) error {
+	key := g.keyPrefix + userID
+	start := time.Now()
+	err := g.client.Del(ctx, key).Err()
+	g.options.observe("reset", "gcra", start)
+	if err != nil {
+		return backendError(g.client, "failed to reset rate limit", err)
+	}
+	return nil
+}
+
+// backlogAt returns how far the user's TAT is ahead of the given instant
+// A missing or unreadable TAT, or one already passed, is no backlog
+func (g *GCRA) backlogAt(ctx context.Context, userID string, at time.Time) (time.Duration, error) {
+	key := g.keyPrefix + userID
+
+	value, err := g.client.HGet(ctx, key, "tat").Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, backendError(g.client, "failed to get arrival time", err)
+	}
+
+	tat, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, nil // If parsing fails, assume no backlog
+	}
+
+	backlog := tat - float64(at.UnixMilli())
+	if backlog <= 0 {
+		return 0, nil
+	}
+	return time.Duration(backlog * float64(time.Millisecond)), nil
+}
+
+// remaining returns how many requests conform given the TAT's backlog
+func (g *GCRA) remaining(backlog time.Duration, limit int, windowSize time.Duration) int {
+	interval := emissionInterval(limit, windowSize)
+	if interval <= 0 {
+		return 0
+	}
+	tolerance := time.Duration(g.burstFor(limit)) * interval
+	remaining := int(math.Floor(float64(tolerance-backlog) / float64(interval)))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// burstFor returns how many requests may be made back to back under limit:
+// the configured burst, else the whole limit, plus one in inclusive mode
+func (g *GCRA) burstFor(limit int) int {
+	burst := g.burst
+	if burst < 1 {
+		burst = limit
+	}
+	if g.options.inclusive {
+		burst++
+	}
+	return burst
+}
+
+// emissionInterval returns the spacing between requests at the sustained
+// rate of limit per window
+func emissionInterval(limit int, windowSize time.Duration) time.Duration {
+	if limit <= 0 || windowSize <= 0 {
+		return 0
+	}
+	return windowSize / time.Duration(limit)
+}
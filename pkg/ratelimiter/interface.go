@@ -11,9 +11,38 @@ type RateLimiter interface {
 	// Returns true if allowed, false if rate limit exceeded
 	Allow(ctx context.Context, userID string, limit int, windowSize time.Duration) (bool, error)
 
+	// AllowN checks if a request costing n slots is allowed, consuming all n
+	// or none of them
+	AllowN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (bool, error)
+
+	// CheckN is like AllowN but also reports the units that were available,
+	// so a denied caller can retry with a smaller n
+	CheckN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error)
+
+	// AllowResult is like Allow but also reports the remaining requests and
+	// when the user may retry, saving the round trips of asking for them
+	AllowResult(ctx context.Context, userID string, limit int, windowSize time.Duration) (Result, error)
+
 	// GetRemaining returns the number of remaining requests allowed
 	GetRemaining(ctx context.Context, userID string, limit int, windowSize time.Duration) (int, error)
 
+	// GetRemainingAt projects the remaining requests to the given instant,
+	// assuming no further requests are made until then
+	GetRemainingAt(ctx context.Context, userID string, limit int, windowSize time.Duration, at time.Time) (int, error)
+
+	// GetStats returns the user's current state for diagnostics
+	GetStats(ctx context.Context, userID string, limit int, windowSize time.Duration) (Stats, error)
+
+	// RetryAfter returns how long until the user may make another request,
+	// assuming no further requests are made until then (0 if they may now)
+	RetryAfter(ctx context.Context, userID string, limit int, windowSize time.Duration) (time.Duration, error)
+
+	// Refund returns the most recently consumed slot to the user
+	// Used when a request that was counted never completed
+	Refund(ctx context.Context, userID string, windowSize time.Duration) error
+
 	// Reset clears the rate limit for a user
-	Reset(ctx context.Context, userID string) error
+	// windowSize is the window they are limited over, which names the
+	// counters of algorithms keyed by window
+	Reset(ctx context.Context, userID string, windowSize time.Duration) error
 }
@@ -12,20 +12,89 @@ import (
 // LeakyBucket implements a leaky bucket rate limiter using Redis
 // This algorithm is memory-efficient and suitable for uniform traffic patterns
 type LeakyBucket struct {
-	client    *redis.Client
+	client    redis.UniversalClient
 	logger    *zap.Logger
 	keyPrefix string
+	options   options
 }
 
 // NewLeakyBucket creates a new leaky bucket rate limiter
-func NewLeakyBucket(client *redis.Client, logger *zap.Logger) *LeakyBucket {
+func NewLeakyBucket(client redis.UniversalClient, logger *zap.Logger, opts ...Option) *LeakyBucket {
+	o := newOptions(opts)
 	return &LeakyBucket{
 		client:    client,
 		logger:    logger,
-		keyPrefix: "rate_limit:leaky:",
+		keyPrefix: o.keyPrefix("rate_limit:leaky:"),
+		options:   o,
 	}
 }
 
+// leakyBucketScript leaks the bucket, then adds the request's cost if it
+// fits, all atomically
+// Returns {allowed, available, retry_at, reset_at}: 1 if the request is
+// allowed and 0 otherwise, the whole units free before the request, when a
+// denied request would fit (0 if it was allowed or never fits) and when the
+// bucket will be empty, in Unix milliseconds
+const leakyBucketScript = `
+	local key = KEYS[1]
+	local current_time = tonumber(ARGV[1])
+	local limit = tonumber(ARGV[2])
+	local window_size_ms = tonumber(ARGV[3])
+	local ttl_ms = tonumber(ARGV[4])
+	local inclusive = tonumber(ARGV[5])
+	local cost = tonumber(ARGV[6])
+	local leak_rate = limit / window_size_ms  -- requests per millisecond
+	
+	-- Get current bucket state
+	local bucket_data = redis.call('HMGET', key, 'level', 'last_update')
+	local level = 0
+	local last_update = current_time
+	
+	if bucket_data[1] then
+		level = tonumber(bucket_data[1])
+		last_update = tonumber(bucket_data[2])
+	end
+	
+	-- Calculate how much has leaked since last update
+	local elapsed = current_time - last_update
+	local leaked = elapsed * leak_rate
+	
+	-- Update bucket level (subtract leaked, ensure non-negative)
+	level = math.max(0, level - leaked)
+	
+	-- When a bucket at the given level will have leaked empty
+	local function empty_at(at_level)
+		if at_level <= 0 or leak_rate <= 0 then
+			return 0
+		end
+		return current_time + math.ceil(at_level / leak_rate)
+	end
+
+	-- Check if we can add the current request
+	-- In inclusive mode the bucket holds one request beyond the limit
+	local available = math.floor(limit + inclusive - level)
+	if level + cost <= limit + inclusive then
+		-- Add current request
+		level = level + cost
+		-- Update bucket state
+		redis.call('HMSET', key, 'level', level, 'last_update', current_time)
+		-- Expire the key once the window plus padding has passed
+		redis.call('PEXPIRE', key, ttl_ms)
+		return {1, available, 0, empty_at(level)}  -- Allowed
+	else
+		-- Persist the leaked level along with last_update even if the request
+		-- is denied, or the drain since the last update would be lost
+		redis.call('HMSET', key, 'level', level, 'last_update', current_time)
+		redis.call('PEXPIRE', key, ttl_ms)
+		-- The request fits once enough has leaked
+		local retry_at = 0
+		if cost <= limit + inclusive and leak_rate > 0 then
+			retry_at = current_time + math.ceil((level + cost - limit - inclusive) / leak_rate)
+		end
+		return {0, available, retry_at, empty_at(level)}  -- Denied
+	end
+`
+
 // Allow checks if a request is allowed based on the leaky bucket algorithm
 // Returns true if allowed, false if rate limit exceeded
 //
@@ -41,127 +110,279 @@ func NewLeakyBucket(client *redis.Client, logger *zap.Logger) *LeakyBucket {
 // - Less precise than sliding window
 // - May allow bursts if bucket is empty
 func (lb *LeakyBucket) Allow(ctx context.Context, userID string, limit int, windowSize time.Duration) (bool, error) {
+	return lb.AllowN(ctx, userID, limit, 1, windowSize)
+}
+
+// AllowN is like Allow for a request costing n units of the bucket; it is
+// allowed only if all n fit
+func (lb *LeakyBucket) AllowN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (bool, error) {
+	capacity, err := lb.CheckN(ctx, userID, limit, n, windowSize)
+	return capacity.Allowed, err
+}
+
+// CheckN is like AllowN but also reports the whole units that were free in
+// the bucket
+func (lb *LeakyBucket) CheckN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error) {
+	ctx, span := lb.options.startSpan(ctx, "leaky_bucket", userID, limit)
+	capacity, err := lb.checkN(ctx, userID, limit, n, windowSize)
+	endSpan(span, capacity.Allowed, err)
+	return capacity, err
+}
+
+// checkN runs the leaky bucket script for CheckN
+func (lb *LeakyBucket) checkN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error) {
+	if n <= 0 {
+		return Capacity{Allowed: true, Requested: n}, nil
+	}
+
 	key := lb.keyPrefix + userID
 	now := time.Now()
 	currentTime := now.UnixMilli()
 
-	// Lua script for atomic operation
-	// This ensures bucket level calculation and update happen atomically
-	script := `
-		local key = KEYS[1]
-		local current_time = tonumber(ARGV[1])
-		local limit = tonumber(ARGV[2])
-		local window_size_ms = tonumber(ARGV[3])
-		local leak_rate = limit / (window_size_ms / 1000)  -- requests per millisecond
-		
-		-- Get current bucket state
-		local bucket_data = redis.call('HMGET', key, 'level', 'last_update')
-		local level = 0
-		local last_update = current_time
-		
-		if bucket_data[1] then
-			level = tonumber(bucket_data[1])
-			last_update = tonumber(bucket_data[2])
-		end
-		
-		-- Calculate how much has leaked since last update
-		local elapsed = current_time - last_update
-		local leaked = elapsed * leak_rate
-		
-		-- Update bucket level (subtract leaked, ensure non-negative)
-		level = math.max(0, level - leaked)
-		
-		-- Check if we can add the current request
-		if level < limit then
-			-- Add current request
-			level = level + 1
-			-- Update bucket state
-			redis.call('HMSET', key, 'level', level, 'last_update', current_time)
-			-- Set expiration (window size + 1 second)
-			redis.call('EXPIRE', key, math.ceil(window_size_ms / 1000) + 1)
-			return 1  -- Allowed
-		else
-			-- Update last_update even if request is denied (for accurate leak calculation)
-			redis.call('HSET', key, 'last_update', current_time)
-			redis.call('EXPIRE', key, math.ceil(window_size_ms / 1000) + 1)
-			return 0  -- Denied
-		end
-	`
-
-	result, err := lb.client.Eval(ctx, script, []string{key},
+	start := time.Now()
+	result, err := lb.options.runWithRetry(ctx, lb.client, leakyBucketLua, []string{key},
 		strconv.FormatInt(currentTime, 10),
 		strconv.Itoa(limit),
 		strconv.FormatInt(windowSize.Milliseconds(), 10),
-	).Result()
+		strconv.FormatInt(lb.options.keyTTL(windowSize).Milliseconds(), 10),
+		lb.options.boundaryArg(),
+		strconv.Itoa(n),
+	)
+	lb.options.observe("allow", "leaky_bucket", start)
 
 	if err != nil {
 		lb.logger.Error("leaky bucket rate limit check failed",
 			zap.String("user_id", userID),
+			zap.String("backend", backendAddr(lb.client)),
 			zap.Error(err),
 		)
-		return false, fmt.Errorf("rate limit check failed: %w", err)
+		return Capacity{}, backendError(lb.client, "rate limit check failed", err)
 	}
 
-	allowed := result.(int64) == 1
+	capacity, err := capacityFromReply(result, n, now)
+	if err != nil {
+		return Capacity{}, err
+	}
 
-	if !allowed {
+	if !capacity.Allowed {
 		lb.logger.Debug("rate limit exceeded (leaky bucket)",
 			zap.String("user_id", userID),
 			zap.Int("limit", limit),
+			zap.Int("cost", n),
+			zap.Int("available", capacity.Available),
 		)
 	}
 
-	return allowed, nil
+	return capacity, nil
+}
+
+// AllowResult is like Allow but also reports the remaining requests and
+// when the user may retry, in the same round trip
+func (lb *LeakyBucket) AllowResult(ctx context.Context, userID string, limit int, windowSize time.Duration) (Result, error) {
+	capacity, err := lb.CheckN(ctx, userID, limit, 1, windowSize)
+	if err != nil {
+		return Result{}, err
+	}
+	return capacity.Result(userID, limit), nil
 }
 
 // GetRemaining returns the number of remaining requests allowed in the bucket
 func (lb *LeakyBucket) GetRemaining(ctx context.Context, userID string, limit int, windowSize time.Duration) (int, error) {
-	key := lb.keyPrefix + userID
-	now := time.Now()
-	currentTime := now.UnixMilli()
+	return lb.GetRemainingAt(ctx, userID, limit, windowSize, time.Now())
+}
+
+// GetRemainingAt returns the number of requests the bucket will allow at the
+// given instant, after leaking until then
+func (lb *LeakyBucket) GetRemainingAt(ctx context.Context, userID string, limit int, windowSize time.Duration, at time.Time) (int, error) {
+	start := time.Now()
+	remaining, _, err := lb.stateAt(ctx, userID, limit, windowSize, at)
+	lb.options.observe("remaining", "leaky_bucket", start)
+	if err != nil {
+		return 0, err
+	}
+	return remaining, nil
+}
 
-	// Get current bucket state
-	bucketData, err := lb.client.HMGet(ctx, key, "level", "last_update").Result()
+// GetStats returns the bucket's level, capacity, leak rate and how long it
+// takes to drain completely
+func (lb *LeakyBucket) GetStats(ctx context.Context, userID string, limit int, windowSize time.Duration) (Stats, error) {
+	start := time.Now()
+	remaining, level, err := lb.stateAt(ctx, userID, limit, windowSize, start)
+	lb.options.observe("stats", "leaky_bucket", start)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get bucket state: %w", err)
+		return Stats{}, err
 	}
 
-	// If bucket doesn't exist, full capacity is available
-	if bucketData[0] == nil || bucketData[1] == nil {
-		return limit, nil
+	// rate is in requests per millisecond
+	rate := leakRate(limit, windowSize)
+	var timeToEmpty time.Duration
+	if rate > 0 {
+		timeToEmpty = time.Duration(level / rate * float64(time.Millisecond))
 	}
 
-	level, err := strconv.ParseFloat(bucketData[0].(string), 64)
-	if err != nil {
-		return limit, nil // If parsing fails, assume full capacity
+	return Stats{
+		Algorithm:   "leaky_bucket",
+		Limit:       limit,
+		Remaining:   remaining,
+		Level:       level,
+		Capacity:    lb.options.capacity(limit),
+		LeakRate:    rate * 1000,
+		TimeToEmpty: timeToEmpty,
+	}, nil
+}
+
+// leakyRemainingScript leaks the bucket until the given time, without
+// changing it, and reports how many requests it would then allow
+// It applies the same leak and capacity arithmetic as leakyBucketScript, so
+// the two never disagree
+// Returns {remaining, level}, the level as a string to keep its fraction; a
+// missing or unreadable bucket is empty
+const leakyRemainingScript = `
+	local key = KEYS[1]
+	local current_time = tonumber(ARGV[1])
+	local limit = tonumber(ARGV[2])
+	local window_size_ms = tonumber(ARGV[3])
+	local inclusive = tonumber(ARGV[4])
+	local leak_rate = limit / window_size_ms  -- requests per millisecond
+
+	local bucket_data = redis.call('HMGET', key, 'level', 'last_update')
+	local level = tonumber(bucket_data[1])
+	local last_update = tonumber(bucket_data[2])
+	if not level or not last_update then
+		level = 0
+	else
+		local elapsed = math.max(0, current_time - last_update)
+		level = math.max(0, level - elapsed * leak_rate)
+	end
+
+	local remaining = math.max(0, math.floor(limit + inclusive - level))
+	return {remaining, tostring(level)}
+`
+
+// stateAt returns the requests the bucket allows at the given instant and
+// its level then, after leaking
+func (lb *LeakyBucket) stateAt(ctx context.Context, userID string, limit int, windowSize time.Duration, at time.Time) (int, float64, error) {
+	if windowSize.Milliseconds() <= 0 {
+		return lb.options.capacity(limit), 0, nil
 	}
+	key := lb.keyPrefix + userID
 
-	lastUpdate, err := strconv.ParseInt(bucketData[1].(string), 10, 64)
+	reply, err := leakyRemainingLua.Run(ctx, lb.client, []string{key},
+		strconv.FormatInt(at.UnixMilli(), 10),
+		strconv.Itoa(limit),
+		strconv.FormatInt(windowSize.Milliseconds(), 10),
+		lb.options.boundaryArg(),
+	).Slice()
+	if err != nil {
+		return 0, 0, backendError(lb.client, "failed to get bucket state", err)
+	}
+	if len(reply) != 2 {
+		return 0, 0, fmt.Errorf("unexpected script reply %v", reply)
+	}
+	remaining, ok := reply[0].(int64)
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected script reply %v", reply)
+	}
+	levelText, _ := reply[1].(string)
+	level, err := strconv.ParseFloat(levelText, 64)
 	if err != nil {
-		return limit, nil // If parsing fails, assume full capacity
+		return 0, 0, fmt.Errorf("unexpected script reply %v", reply)
+	}
+
+	return int(remaining), level, nil
+}
+
+// leakRate returns how many requests drain from the bucket per millisecond
+// A full bucket empties over one window
+func leakRate(limit int, windowSize time.Duration) float64 {
+	if windowSize.Milliseconds() <= 0 {
+		return 0
 	}
+	return float64(limit) / float64(windowSize.Milliseconds())
+}
 
-	// Calculate leaked amount
-	elapsed := currentTime - lastUpdate
-	leakRate := float64(limit) / (float64(windowSize.Milliseconds()) / 1000.0)
-	leaked := float64(elapsed) * leakRate
+// leakyRetryAfterScript returns the milliseconds until the bucket, leaking at
+// its current rate, has room for one more request
+// It only reads the bucket, so asking never changes it
+const leakyRetryAfterScript = `
+	local key = KEYS[1]
+	local current_time = tonumber(ARGV[1])
+	local limit = tonumber(ARGV[2])
+	local window_size_ms = tonumber(ARGV[3])
+	local inclusive = tonumber(ARGV[4])
+	local leak_rate = limit / window_size_ms  -- requests per millisecond
 
-	// Update level
-	currentLevel := level - leaked
-	if currentLevel < 0 {
-		currentLevel = 0
+	local bucket_data = redis.call('HMGET', key, 'level', 'last_update')
+	if not bucket_data[1] or not bucket_data[2] then
+		return 0
+	end
+
+	local elapsed = math.max(0, current_time - tonumber(bucket_data[2]))
+	local level = math.max(0, tonumber(bucket_data[1]) - elapsed * leak_rate)
+
+	-- A request fits once level + 1 <= limit + inclusive
+	local excess = level + 1 - (limit + inclusive)
+	if excess <= 0 or leak_rate <= 0 then
+		return 0
+	end
+	return math.ceil(excess / leak_rate)
+`
+
+// RetryAfter returns how long until the bucket has leaked enough to admit
+// another request
+func (lb *LeakyBucket) RetryAfter(ctx context.Context, userID string, limit int, windowSize time.Duration) (time.Duration, error) {
+	if windowSize.Milliseconds() <= 0 {
+		return 0, nil
 	}
+	key := lb.keyPrefix + userID
 
-	remaining := limit - int(currentLevel)
-	if remaining < 0 {
-		remaining = 0
+	start := time.Now()
+	ms, err := leakyRetryLua.Run(ctx, lb.client, []string{key},
+		strconv.FormatInt(start.UnixMilli(), 10),
+		strconv.Itoa(limit),
+		strconv.FormatInt(windowSize.Milliseconds(), 10),
+		lb.options.boundaryArg(),
+	).Int64()
+	lb.options.observe("retry_after", "leaky_bucket", start)
+	if err != nil {
+		return 0, backendError(lb.client, "failed to get retry after", err)
 	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
 
-	return remaining, nil
+// leakyRefundScript drains one request from the bucket, keeping the
+// read-modify-write atomic and never dropping below zero
+const leakyRefundScript = `
+	local key = KEYS[1]
+	local level = tonumber(redis.call('HGET', key, 'level'))
+	if not level then
+		return 0
+	end
+	redis.call('HSET', key, 'level', math.max(0, level - 1))
+	return 1
+`
+
+// Refund drains one request from the bucket, returning one slot
+func (lb *LeakyBucket) Refund(ctx context.Context, userID string, windowSize time.Duration) error {
+	key := lb.keyPrefix + userID
+
+	start := time.Now()
+	err := leakyRefundLua.Run(ctx, lb.client, []string{key}).Err()
+	lb.options.observe("refund", "leaky_bucket", start)
+	if err != nil {
+		return backendError(lb.client, "failed to refund request", err)
+	}
+	return nil
 }
 
 // Reset clears the rate limit for a user
-func (lb *LeakyBucket) Reset(ctx context.Context, userID string) error {
+func (lb *LeakyBucket) Reset(ctx context.Context, userID string, windowSize time.Duration) error {
 	key := lb.keyPrefix + userID
-	return lb.client.Del(ctx, key).Err()
+	start := time.Now()
+	err := lb.client.Del(ctx, key).Err()
+	lb.options.observe("reset", "leaky_bucket", start)
+	if err != nil {
+		return backendError(lb.client, "failed to reset rate limit", err)
+	}
+	return nil
 }
@@ -0,0 +1,274 @@
+package ratelimiter
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryLimiter implements a sliding window rate limiter in process memory
+// Each instance counts only the requests it saw itself, so it is meant as a
+// best-effort fallback while Redis is unreachable. It tracks at most
+// maxUsers users, forgetting the least recently seen one to make room.
+type MemoryLimiter struct {
+	mu       sync.Mutex
+	maxUsers int
+	// users holds a *memoryEntry per user, most recently seen first
+	users   *list.List
+	entries map[string]*list.Element
+	options options
+}
+
+// memoryEntry is a user's window, as kept in the LRU list
+type memoryEntry struct {
+	userID string
+	window memoryWindow
+}
+
+// memoryWindow is a ring of request times, oldest first
+type memoryWindow struct {
+	times []time.Time
+	head  int
+	size  int
+}
+
+// NewMemoryLimiter creates an in-memory sliding window rate limiter tracking
+// at most maxUsers users (at least 1)
+func NewMemoryLimiter(maxUsers int, opts ...Option) *MemoryLimiter {
+	if maxUsers < 1 {
+		maxUsers = 1
+	}
+	return &MemoryLimiter{
+		maxUsers: maxUsers,
+		users:    list.New(),
+		entries:  make(map[string]*list.Element),
+		options:  newOptions(opts),
+	}
+}
+
+// Allow checks if a request fits in the user's window
+func (ml *MemoryLimiter) Allow(ctx context.Context, userID string, limit int, windowSize time.Duration) (bool, error) {
+	return ml.AllowN(ctx, userID, limit, 1, windowSize)
+}
+
+// AllowN is like Allow for a request costing n slots, consuming all n or none
+func (ml *MemoryLimiter) AllowN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (bool, error) {
+	capacity, err := ml.CheckN(ctx, userID, limit, n, windowSize)
+	return capacity.Allowed, err
+}
+
+// CheckN is like AllowN but also reports the units left in the window
+func (ml *MemoryLimiter) CheckN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error) {
+	if n <= 0 {
+		return Capacity{Allowed: true, Requested: n}, nil
+	}
+
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	now := time.Now()
+	window := ml.window(userID)
+	window.prune(now.Add(-windowSize))
+
+	slots := ml.options.capacity(limit)
+	available := slots - window.size
+	if available < 0 {
+		available = 0
+	}
+	capacity := Capacity{
+		Allowed:   n <= available,
+		Requested: n,
+		Available: available,
+	}
+	if capacity.Allowed {
+		for i := 0; i < n; i++ {
+			window.push(now)
+		}
+	} else {
+		capacity.Shortfall = n - available
+		// The request fits once enough of the oldest entries have left
+		if n <= slots {
+			leaving := window.at(window.size + n - slots - 1)
+			capacity.RetryAfter = leaving.Add(windowSize).Sub(now)
+		}
+	}
+	if window.size > 0 {
+		capacity.ResetAt = window.at(window.size - 1).Add(windowSize)
+	}
+
+	return capacity, nil
+}
+
+// AllowResult is like Allow but also reports the remaining requests and
+// when the user may retry
+func (ml *MemoryLimiter) AllowResult(ctx context.Context, userID string, limit int, windowSize time.Duration) (Result, error) {
+	capacity, err := ml.CheckN(ctx, userID, limit, 1, windowSize)
+	if err != nil {
+		return Result{}, err
+	}
+	return capacity.Result(userID, limit), nil
+}
+
+// GetRemaining returns the number of requests left in the window
+func (ml *MemoryLimiter) GetRemaining(ctx context.Context, userID string, limit int, windowSize time.Duration) (int, error) {
+	return ml.GetRemainingAt(ctx, userID, limit, windowSize, time.Now())
+}
+
+// GetRemainingAt returns the requests left at the given instant
+func (ml *MemoryLimiter) GetRemainingAt(ctx context.Context, userID string, limit int, windowSize time.Duration, at time.Time) (int, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	remaining := ml.options.capacity(limit) - ml.count(userID, at.Add(-windowSize))
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, nil
+}
+
+// GetStats returns the number of requests in the window and the times of the
+// oldest and newest of them
+func (ml *MemoryLimiter) GetStats(ctx context.Context, userID string, limit int, windowSize time.Duration) (Stats, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	stats := Stats{
+		Algorithm: "memory",
+		Limit:     limit,
+	}
+	if element, ok := ml.entries[userID]; ok {
+		window := &element.Value.(*memoryEntry).window
+		window.prune(time.Now().Add(-windowSize))
+		stats.Count = window.size
+		if window.size > 0 {
+			stats.Oldest = window.at(0)
+			stats.Newest = window.at(window.size - 1)
+		}
+	}
+	stats.Remaining = ml.options.capacity(limit) - stats.Count
+	if stats.Remaining < 0 {
+		stats.Remaining = 0
+	}
+	return stats, nil
+}
+
+// RetryAfter returns how long until enough entries leave the window for
+// another request to fit
+func (ml *MemoryLimiter) RetryAfter(ctx context.Context, userID string, limit int, windowSize time.Duration) (time.Duration, error) {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	element, ok := ml.entries[userID]
+	if !ok {
+		return 0, nil
+	}
+	now := time.Now()
+	window := &element.Value.(*memoryEntry).window
+	window.prune(now.Add(-windowSize))
+
+	slots := ml.options.capacity(limit)
+	if window.size < slots || slots < 1 {
+		return 0, nil
+	}
+	if wait := window.at(window.size - slots).Add(windowSize).Sub(now); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// Refund removes the newest entry from the user's window, returning one slot
+func (ml *MemoryLimiter) Refund(ctx context.Context, userID string, windowSize time.Duration) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if element, ok := ml.entries[userID]; ok {
+		element.Value.(*memoryEntry).window.popNewest()
+	}
+	return nil
+}
+
+// Reset forgets the user's window
+func (ml *MemoryLimiter) Reset(ctx context.Context, userID string, windowSize time.Duration) error {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	if element, ok := ml.entries[userID]; ok {
+		ml.users.Remove(element)
+		delete(ml.entries, userID)
+	}
+	return nil
+}
+
+// window returns the user's window, creating it if needed, and marks the
+// user as the most recently seen, evicting the least recently seen user if
+// there are too many
+// The caller must hold mu
+func (ml *MemoryLimiter) window(userID string) *memoryWindow {
+	if element, ok := ml.entries[userID]; ok {
+		ml.users.MoveToFront(element)
+		return &element.Value.(*memoryEntry).window
+	}
+
+	element := ml.users.PushFront(&memoryEntry{userID: userID})
+	ml.entries[userID] = element
+	if ml.users.Len() > ml.maxUsers {
+		oldest := ml.users.Back()
+		ml.users.Remove(oldest)
+		delete(ml.entries, oldest.Value.(*memoryEntry).userID)
+	}
+	return &element.Value.(*memoryEntry).window
+}
+
+// count returns the user's requests made after cutoff, without marking them
+// as recently seen
+// The caller must hold mu
+func (ml *MemoryLimiter) count(userID string, cutoff time.Time) int {
+	element, ok := ml.entries[userID]
+	if !ok {
+		return 0
+	}
+	window := &element.Value.(*memoryEntry).window
+	count := 0
+	for i := 0; i < window.size; i++ {
+		if window.at(i).After(cutoff) {
+			count++
+		}
+	}
+	return count
+}
+
+// at returns the i-th oldest request time
+func (w *memoryWindow) at(i int) time.Time {
+	return w.times[(w.head+i)%len(w.times)]
+}
+
+// push records a request made at t, growing the ring if it is full
+func (w *memoryWindow) push(t time.Time) {
+	if w.size == len(w.times) {
+		grown := make([]time.Time, 2*len(w.times)+1)
+		for i := 0; i < w.size; i++ {
+			grown[i] = w.at(i)
+		}
+		w.times = grown
+		w.head = 0
+	}
+	w.times[(w.head+w.size)%len(w.times)] = t
+	w.size++
+}
+
+// prune drops the requests made at or before cutoff, which have left the
+// window
+func (w *memoryWindow) prune(cutoff time.Time) {
+	for w.size > 0 && !w.at(0).After(cutoff) {
+		w.head = (w.head + 1) % len(w.times)
+		w.size--
+	}
+}
+
+// popNewest drops the most recent request, if any
+func (w *memoryWindow) popNewest() {
+	if w.size > 0 {
+		w.size--
+	}
+}
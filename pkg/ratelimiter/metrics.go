@@ -0,0 +1,46 @@
+package ratelimiter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// redisLatencyBuckets span sub-millisecond to tens of milliseconds, in seconds
+var redisLatencyBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1,
+}
+
+// Metrics records the latency of the limiters' Redis calls
+type Metrics struct {
+	redisLatency *prometheus.HistogramVec
+}
+
+// NewMetrics creates the limiter metrics and registers them with reg
+func NewMetrics(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		redisLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "rate_limit_redis_duration_seconds",
+			Help:    "Latency of the rate limiters' Redis calls.",
+			Buckets: redisLatencyBuckets,
+		}, []string{"operation", "algorithm"}),
+	}
+	reg.MustRegister(m.redisLatency)
+	return m
+}
+
+// WithMetrics records the latency of every Redis call to m
+func WithMetrics(m *Metrics) Option {
+	return func(o *options) {
+		o.metrics = m
+	}
+}
+
+// observe records the latency of a Redis call that started at start
+// It is a no-op when no metrics are configured
+func (o options) observe(operation, algorithm string, start time.Time) {
+	if o.metrics == nil {
+		return
+	}
+	o.metrics.redisLatency.WithLabelValues(operation, algorithm).Observe(time.Since(start).Seconds())
+}
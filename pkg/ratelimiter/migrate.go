@@ -0,0 +1,60 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// MigrateKeyPrefix renames every key starting with oldPrefix so that it
+// starts with newPrefix instead, returning the number of keys renamed
+//
+// Keys are found with SCAN so Redis is never blocked, and each key is moved
+// with RENAMENX which is atomic per key, so counters carry over without
+// downtime. If a key already exists under the new prefix (e.g. the new
+// version started writing before the migration ran), it is left untouched
+// and the old key is skipped rather than overwriting fresher state.
+// Up to concurrency workers rename a batch of keys at once, each batch in
+// one pipeline.
+func MigrateKeyPrefix(ctx context.Context, client redis.UniversalClient, oldPrefix, newPrefix string, concurrency int) (int, error) {
+	if oldPrefix == "" || newPrefix == "" {
+		return 0, fmt.Errorf("both old and new prefix are required")
+	}
+	if oldPrefix == newPrefix {
+		return 0, nil
+	}
+
+	return forEachBatch(ctx, client, escapePattern(oldPrefix)+"*", concurrency, func(ctx context.Context, keys []string) (int, error) {
+		pipe := client.Pipeline()
+		cmds := make([]*redis.BoolCmd, len(keys))
+		for i, oldKey := range keys {
+			cmds[i] = pipe.RenameNX(ctx, oldKey, newPrefix+strings.TrimPrefix(oldKey, oldPrefix))
+		}
+		// Errors are checked per command below
+		_, _ = pipe.Exec(ctx)
+
+		renamed := 0
+		for i, cmd := range cmds {
+			ok, err := cmd.Result()
+			if err != nil && strings.HasPrefix(err.Error(), "ERR no such key") {
+				// Key expired between SCAN and RENAMENX
+				continue
+			}
+			if err != nil {
+				return renamed, fmt.Errorf("failed to rename %s: %w", keys[i], err)
+			}
+			if ok {
+				renamed++
+			}
+		}
+		return renamed, nil
+	})
+}
+
+// escapePattern escapes glob special characters so a prefix matches literally
+func escapePattern(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+	return replacer.Replace(s)
+}
@@ -0,0 +1,138 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Check is one dimension of a composite rate limit
+type Check struct {
+	// Key identifies the bucket, e.g. a user ID or "tenant:acme"
+	Key string
+	// Limit is the number of requests allowed in Window
+	Limit int
+	// Window is the length of the sliding window
+	Window time.Duration
+}
+
+// Result is the outcome of a single Check, or of AllowResult
+type Result struct {
+	Key       string
+	Limit     int
+	Remaining int
+	// Allowed reports whether this bucket had room for the request
+	Allowed bool
+	// RetryAfter is how long until a denied request would fit, and ResetAt
+	// when every request counted so far has left the window; both are only
+	// reported by AllowResult
+	RetryAfter time.Duration
+	ResetAt    time.Time
+	// Err is why the check could not be run; only AllowEach reports failed
+	// checks as results
+	Err error
+}
+
+// multiScript checks every bucket and consumes the request's cost from all
+// of them only if every one has room for it
+// KEYS are the buckets; ARGV is current_time and cost, then (window_start,
+// limit, ttl_ms) per key
+// Returns {allowed, count_1, ..., count_n} with counts taken before consuming
+const multiScript = addEntriesScript + `
+	local current_time = tonumber(ARGV[1])
+	local cost = tonumber(ARGV[2])
+	local counts = {}
+	local allowed = 1
+
+	for i, key in ipairs(KEYS) do
+		local window_start = tonumber(ARGV[(i - 1) * 3 + 3])
+		local limit = tonumber(ARGV[(i - 1) * 3 + 4])
+
+		redis.call('ZREMRANGEBYSCORE', key, '-inf', window_start)
+		local count = redis.call('ZCARD', key)
+		counts[i] = count
+		if count + cost > limit then
+			allowed = 0
+		end
+	end
+
+	if allowed == 1 then
+		for i, key in ipairs(KEYS) do
+			local ttl_ms = tonumber(ARGV[(i - 1) * 3 + 5])
+			add_entries(key, current_time, cost)
+			redis.call('PEXPIRE', key, ttl_ms)
+		end
+	end
+
+	table.insert(counts, 1, allowed)
+	return counts
+`
+
+// AllowAll checks several sliding windows in one atomic step
+// The request is allowed only if every check passes, in which case a slot is
+// consumed from each of them; otherwise nothing is consumed
+func (sw *SlidingWindow) AllowAll(ctx context.Context, checks []Check) (bool, []Result, error) {
+	return sw.AllowAllN(ctx, checks, 1)
+}
+
+// AllowAllN is like AllowAll for a request costing n slots in each window;
+// it is allowed only if all n fit in every one
+func (sw *SlidingWindow) AllowAllN(ctx context.Context, checks []Check, n int) (bool, []Result, error) {
+	if len(checks) == 0 || n <= 0 {
+		return true, nil, nil
+	}
+
+	now := time.Now()
+	keys := make([]string, len(checks))
+	args := make([]interface{}, 0, 2+len(checks)*3)
+	args = append(args, strconv.FormatInt(now.UnixMilli(), 10), strconv.Itoa(n))
+	for i, check := range checks {
+		keys[i] = sw.keyPrefix + check.Key
+		args = append(args,
+			strconv.FormatInt(now.Add(-check.Window).UnixMilli(), 10),
+			strconv.Itoa(check.Limit),
+			strconv.FormatInt(sw.options.keyTTL(check.Window).Milliseconds(), 10),
+		)
+	}
+
+	start := time.Now()
+	reply, err := sw.options.runWithRetry(ctx, sw.client, multiLua, keys, args...)
+	sw.options.observe("allow", "sliding_window", start)
+	if err != nil {
+		sw.logger.Error("composite rate limit check failed",
+			zap.Int("checks", len(checks)),
+			zap.String("backend", backendAddr(sw.client)),
+			zap.Error(err),
+		)
+		return false, nil, backendError(sw.client, "rate limit check failed", err)
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(checks)+1 {
+		return false, nil, fmt.Errorf("unexpected script reply: %v", reply)
+	}
+
+	allowed := values[0].(int64) == 1
+	results := make([]Result, len(checks))
+	for i, check := range checks {
+		count := int(values[i+1].(int64))
+		remaining := check.Limit - count
+		if allowed {
+			remaining -= n
+		}
+		if remaining < 0 {
+			remaining = 0
+		}
+		results[i] = Result{
+			Key:       check.Key,
+			Limit:     check.Limit,
+			Remaining: remaining,
+			Allowed:   count+n <= check.Limit,
+		}
+	}
+
+	return allowed, results, nil
+}
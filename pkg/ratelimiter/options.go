@@ -0,0 +1,141 @@
+package ratelimiter
+
+import (
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// minTTLPadding is the smallest padding used when none is configured
+const minTTLPadding = 100 * time.Millisecond
+
+// Boundary modes decide how many requests a limit of N allows per window
+const (
+	// BoundaryStrict allows exactly N requests: a request is allowed while
+	// fewer than N are counted
+	BoundaryStrict = "strict"
+	// BoundaryInclusive allows N+1 requests: a request is allowed while at
+	// most N are counted, i.e. N is the last count still accepted
+	BoundaryInclusive = "inclusive"
+)
+
+// Option configures a rate limiter
+type Option func(*options)
+
+type options struct {
+	ttlPadding    time.Duration
+	ttl           time.Duration
+	retryAttempts int
+	retryBackoff  time.Duration
+	metrics       *Metrics
+	inclusive     bool
+	// pruneOnRead makes the sliding window's GetRemaining delete expired
+	// entries
+	pruneOnRead bool
+	// tracerProvider records spans, the global one if nil
+	tracerProvider trace.TracerProvider
+	// namespace prefixes every key, so apps sharing a Redis don't collide
+	namespace string
+}
+
+// WithTTLPadding sets how long keys outlive their window
+// Zero (the default) pads by a tenth of the window, but at least 100ms
+func WithTTLPadding(padding time.Duration) Option {
+	return func(o *options) {
+		o.ttlPadding = padding
+	}
+}
+
+// WithKeyTTL sets how long keys are kept after their last write, e.g. so a
+// leaky bucket of a user with sparse traffic outlives long gaps between
+// requests
+// Zero (the default) keeps them for the window plus padding; a TTL shorter
+// than that is raised to it, so state never expires while it still counts
+func WithKeyTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithBoundary sets the boundary mode, BoundaryStrict (the default) or
+// BoundaryInclusive
+func WithBoundary(mode string) Option {
+	return func(o *options) {
+		o.inclusive = mode == BoundaryInclusive
+	}
+}
+
+// WithPruneOnRead makes the sliding window's GetRemaining delete the entries
+// that have left the window, as Allow does, rather than just counting the
+// ones inside it
+// Off by default: reads don't change state, and key expiry and Allow clean
+// up anyway
+func WithPruneOnRead(prune bool) Option {
+	return func(o *options) {
+		o.pruneOnRead = prune
+	}
+}
+
+// WithKeyNamespace prefixes the limiter's keys with namespace, e.g.
+// "billing:rate_limit:sliding:<user>", so several applications can share one
+// Redis without counting each other's requests
+// Empty (the default) keeps the bare prefixes
+func WithKeyNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// NamespacedKey returns key under namespace, or key itself if namespace is
+// empty
+func NamespacedKey(namespace, key string) string {
+	if namespace == "" {
+		return key
+	}
+	return namespace + ":" + key
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// keyTTL returns how long a key should be kept after its last write
+func (o options) keyTTL(windowSize time.Duration) time.Duration {
+	padding := o.ttlPadding
+	if padding <= 0 {
+		padding = windowSize / 10
+		if padding < minTTLPadding {
+			padding = minTTLPadding
+		}
+	}
+	if ttl := windowSize + padding; ttl > o.ttl {
+		return ttl
+	}
+	return o.ttl
+}
+
+// keyPrefix returns the limiter's key prefix under the configured namespace
+func (o options) keyPrefix(prefix string) string {
+	return NamespacedKey(o.namespace, prefix)
+}
+
+// boundaryArg returns the boundary mode as passed to the Lua scripts: how many
+// requests beyond the limit are still allowed
+func (o options) boundaryArg() string {
+	if o.inclusive {
+		return "1"
+	}
+	return "0"
+}
+
+// capacity returns how many requests a limit allows per window
+func (o options) capacity(limit int) int {
+	if o.inclusive {
+		return limit + 1
+	}
+	return limit
+}
@@ -0,0 +1,14 @@
+package ratelimiter
+
+// RemainingPercent returns remaining as a whole percentage of limit
+// The value is rounded down so a client never sees more quota than it has,
+// and a non-positive limit reports 0
+func RemainingPercent(remaining, limit int) int {
+	if limit <= 0 || remaining <= 0 {
+		return 0
+	}
+	if remaining >= limit {
+		return 100
+	}
+	return remaining * 100 / limit
+}
@@ -0,0 +1,46 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ReadAll reads every key starting with prefix, e.g. "rate_limit:config:" to
+// read every user's custom limit, handing each key and its value to fn, and
+// returns the number of keys read
+//
+// Keys are found with SCAN so Redis is never blocked, and read a batch at a
+// time, each batch in one pipeline, by up to concurrency workers at once, so
+// fn may be called concurrently. Keys deleted before they are read are
+// skipped.
+func ReadAll(ctx context.Context, client redis.UniversalClient, prefix string, concurrency int, fn func(key, value string)) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("a key prefix is required")
+	}
+
+	return forEachBatch(ctx, client, escapePattern(prefix)+"*", concurrency, func(ctx context.Context, keys []string) (int, error) {
+		pipe := client.Pipeline()
+		cmds := make([]*redis.StringCmd, len(keys))
+		for i, key := range keys {
+			cmds[i] = pipe.Get(ctx, key)
+		}
+		// Errors are checked per command below
+		_, _ = pipe.Exec(ctx)
+
+		read := 0
+		for i, cmd := range cmds {
+			value, err := cmd.Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				return read, fmt.Errorf("failed to read %s: %w", keys[i], err)
+			}
+			fn(keys[i], value)
+			read++
+		}
+		return read, nil
+	})
+}
@@ -0,0 +1,366 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// defaultRegion names the region of a RegionalCounter created without one
+const defaultRegion = "local"
+
+// RegionalCounter implements an approximate limit shared by several regions,
+// each with its own Redis, without cross-region calls on the request path
+//
+// Requests are counted in fixed windows. Each user's hash holds a counter
+// per window and region, "<window start>:<region>", plus one of the requests
+// that region refunded, "<window start>:<region>:refunds". A region only ever
+// increments its own counters, so copies of the hash merge by keeping the
+// larger value of every field (a grow-only CRDT counter), and the user's
+// usage is the sum of all regions' counters less their refunds.
+//
+// Merge pulls the peers' counters into the local Redis. Between merges a
+// region can't see what the others admitted, so the limit is only enforced
+// approximately: see the README for the over-admission bound.
+type RegionalCounter struct {
+	client    redis.UniversalClient
+	logger    *zap.Logger
+	region    string
+	keyPrefix string
+	options   options
+}
+
+// NewRegionalCounter creates a regional counter for the named region
+// Region names must be unique across the regions sharing limits and must not
+// contain ':'; an empty name means "local"
+func NewRegionalCounter(client redis.UniversalClient, logger *zap.Logger, region string, opts ...Option) *RegionalCounter {
+	if region == "" {
+		region = defaultRegion
+	}
+	o := newOptions(opts)
+	return &RegionalCounter{
+		client:    client,
+		logger:    logger,
+		region:    region,
+		keyPrefix: o.keyPrefix("rate_limit:regional:"),
+		options:   o,
+	}
+}
+
+// regionalAllowScript sums the current window's counters of every region,
+// then counts the request against this region's if it fits
+// Counters of past windows are dropped along the way
+// Returns {1, available} if the request is allowed and {0, available}
+// otherwise, with available the units left before the request
+const regionalAllowScript = `
+	local key = KEYS[1]
+	local region = ARGV[1]
+	local window_start = tonumber(ARGV[2])
+	local limit = tonumber(ARGV[3])
+	local cost = tonumber(ARGV[4])
+	local inclusive = tonumber(ARGV[5])
+	local ttl_ms = tonumber(ARGV[6])
+
+	local used = 0
+	local fields = redis.call('HGETALL', key)
+	for i = 1, #fields, 2 do
+		local start, counter = string.match(fields[i], '^(%d+):(.+)$')
+		if start and tonumber(start) == window_start then
+			if string.sub(counter, -8) == ':refunds' then
+				used = used - tonumber(fields[i + 1])
+			else
+				used = used + tonumber(fields[i + 1])
+			end
+		elseif start and tonumber(start) < window_start then
+			redis.call('HDEL', key, fields[i])
+		end
+	end
+
+	local available = limit + inclusive - used
+	if cost > available then
+		return {0, available}
+	end
+
+	redis.call('HINCRBY', key, ARGV[2] .. ':' .. region, cost)
+	redis.call('PEXPIRE', key, ttl_ms)
+	return {1, available}
+`
+
+// regionalRefundScript counts a refund against this region's latest window,
+// never refunding more requests than the region admitted in it
+// Returns 1 if a request was refunded
+const regionalRefundScript = `
+	local key = KEYS[1]
+	local region = ARGV[1]
+
+	local latest = nil
+	local fields = redis.call('HGETALL', key)
+	for i = 1, #fields, 2 do
+		local start, counter = string.match(fields[i], '^(%d+):(.+)$')
+		if start and counter == region and (not latest or tonumber(start) > tonumber(latest)) then
+			latest = start
+		end
+	end
+	if not latest then
+		return 0
+	end
+
+	local admitted = tonumber(redis.call('HGET', key, latest .. ':' .. region))
+	local refunded = tonumber(redis.call('HGET', key, latest .. ':' .. region .. ':refunds') or '0')
+	if refunded >= admitted then
+		return 0
+	end
+	redis.call('HINCRBY', key, latest .. ':' .. region .. ':refunds', 1)
+	return 1
+`
+
+// regionalMergeScript merges a peer's copy of a hash, given as field/value
+// pairs after the TTL, keeping the larger value of every field
+// The key is kept at least as long as the peer keeps its copy
+const regionalMergeScript = `
+	local key = KEYS[1]
+	local ttl_ms = tonumber(ARGV[1])
+
+	for i = 2, #ARGV, 2 do
+		local current = tonumber(redis.call('HGET', key, ARGV[i]) or '0')
+		if tonumber(ARGV[i + 1]) > current then
+			redis.call('HSET', key, ARGV[i], ARGV[i + 1])
+		end
+	end
+
+	if ttl_ms > 0 and redis.call('PTTL', key) < ttl_ms then
+		redis.call('PEXPIRE', key, ttl_ms)
+	end
+	return 1
+`
+
+// Allow checks if a request fits in the limit shared by all regions
+func (rc *RegionalCounter) Allow(ctx context.Context, userID string, limit int, windowSize time.Duration) (bool, error) {
+	return rc.AllowN(ctx, userID, limit, 1, windowSize)
+}
+
+// AllowN is like Allow for a request costing n slots, consuming all n or none
+func (rc *RegionalCounter) AllowN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (bool, error) {
+	capacity, err := rc.CheckN(ctx, userID, limit, n, windowSize)
+	return capacity.Allowed, err
+}
+
+// CheckN is like AllowN but also reports the units that were left across
+// all regions, as far as this region knows
+func (rc *RegionalCounter) CheckN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error) {
+	if n <= 0 {
+		return Capacity{Allowed: true, Requested: n}, nil
+	}
+
+	start := time.Now()
+	result, err := rc.options.runWithRetry(ctx, rc.client, regionalAllowLua, []string{rc.keyPrefix + userID},
+		rc.region,
+		strconv.FormatInt(windowStart(start, windowSize), 10),
+		strconv.Itoa(limit),
+		strconv.Itoa(n),
+		rc.options.boundaryArg(),
+		strconv.FormatInt(rc.options.keyTTL(windowSize).Milliseconds(), 10),
+	)
+	rc.options.observe("allow", "regional", start)
+
+	if err != nil {
+		rc.logger.Error("regional rate limit check failed",
+			zap.String("user_id", userID),
+			zap.String("backend", backendAddr(rc.client)),
+			zap.Error(err),
+		)
+		return Capacity{}, backendError(rc.client, "rate limit check failed", err)
+	}
+
+	capacity, err := capacityFromReply(result, n, start)
+	if err != nil {
+		return Capacity{}, err
+	}
+	// Every request counted in the window is freed when it ends
+	end := time.UnixMilli(windowStart(start, windowSize)).Add(windowSize)
+	capacity.ResetAt = end
+	if !capacity.Allowed && n <= rc.options.capacity(limit) {
+		capacity.RetryAfter = end.Sub(start)
+	}
+	return capacity, nil
+}
+
+// AllowResult is like Allow but also reports the remaining requests and
+// when the user may retry, as far as this region knows, in the same round
+// trip
+func (rc *RegionalCounter) AllowResult(ctx context.Context, userID string, limit int, windowSize time.Duration) (Result, error) {
+	capacity, err := rc.CheckN(ctx, userID, limit, 1, windowSize)
+	if err != nil {
+		return Result{}, err
+	}
+	return capacity.Result(userID, limit), nil
+}
+
+// GetRemaining returns the requests left in the current window across all
+// regions, as far as this region knows
+func (rc *RegionalCounter) GetRemaining(ctx context.Context, userID string, limit int, windowSize time.Duration) (int, error) {
+	return rc.GetRemainingAt(ctx, userID, limit, windowSize, time.Now())
+}
+
+// GetRemainingAt returns the requests left at the given instant; a later
+// window starts with the full limit
+func (rc *RegionalCounter) GetRemainingAt(ctx context.Context, userID string, limit int, windowSize time.Duration, at time.Time) (int, error) {
+	start := time.Now()
+	used, err := rc.usage(ctx, userID, windowStart(at, windowSize))
+	rc.options.observe("remaining", "regional", start)
+	if err != nil {
+		return 0, err
+	}
+	return rc.remaining(limit, used), nil
+}
+
+// GetStats returns the requests counted in the current window across all
+// regions
+func (rc *RegionalCounter) GetStats(ctx context.Context, userID string, limit int, windowSize time.Duration) (Stats, error) {
+	start := time.Now()
+	used, err := rc.usage(ctx, userID, windowStart(start, windowSize))
+	rc.options.observe("stats", "regional", start)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return Stats{
+		Algorithm: "regional",
+		Limit:     limit,
+		Remaining: rc.remaining(limit, used),
+		Count:     used,
+	}, nil
+}
+
+// RetryAfter returns how long until the current window ends, if it is full
+func (rc *RegionalCounter) RetryAfter(ctx context.Context, userID string, limit int, windowSize time.Duration) (time.Duration, error) {
+	now := time.Now()
+	start := windowStart(now, windowSize)
+	used, err := rc.usage(ctx, userID, start)
+	rc.options.observe("retry_after", "regional", now)
+	if err != nil {
+		return 0, err
+	}
+	if rc.remaining(limit, used) > 0 {
+		return 0, nil
+	}
+	return time.UnixMilli(start).Add(windowSize).Sub(now), nil
+}
+
+// Refund returns one of the requests this region admitted in its latest
+// window
+func (rc *RegionalCounter) Refund(ctx context.Context, userID string, windowSize time.Duration) error {
+	start := time.Now()
+	err := regionalRefundLua.Run(ctx, rc.client, []string{rc.keyPrefix + userID}, rc.region).Err()
+	rc.options.observe("refund", "regional", start)
+	if err != nil {
+		return backendError(rc.client, "failed to refund request", err)
+	}
+	return nil
+}
+
+// Reset clears the user's counters in this region
+// Peers keep theirs, and the next merge brings their counts back
+func (rc *RegionalCounter) Reset(ctx context.Context, userID string, windowSize time.Duration) error {
+	start := time.Now()
+	err := rc.client.Del(ctx, rc.keyPrefix+userID).Err()
+	rc.options.observe("reset", "regional", start)
+	if err != nil {
+		return backendError(rc.client, "failed to reset rate limit", err)
+	}
+	return nil
+}
+
+// Merge pulls every user's counters from a peer region's Redis into this
+// region's, returning the number of hashes merged
+// Merging is idempotent and order-independent, so it is safe to repeat and
+// to run concurrently in every region; keys are read and merged a batch at
+// a time by up to concurrency workers
+func (rc *RegionalCounter) Merge(ctx context.Context, peer redis.UniversalClient, concurrency int) (int, error) {
+	return forEachBatch(ctx, peer, escapePattern(rc.keyPrefix)+"*", concurrency, func(ctx context.Context, keys []string) (int, error) {
+		read := peer.Pipeline()
+		hashes := make([]*redis.StringStringMapCmd, len(keys))
+		ttls := make([]*redis.DurationCmd, len(keys))
+		for i, key := range keys {
+			hashes[i] = read.HGetAll(ctx, key)
+			ttls[i] = read.PTTL(ctx, key)
+		}
+		if _, err := read.Exec(ctx); err != nil && err != redis.Nil {
+			return 0, backendError(peer, "failed to read peer counters", err)
+		}
+
+		merge := rc.client.Pipeline()
+		merged := 0
+		for i, key := range keys {
+			fields := hashes[i].Val()
+			if len(fields) == 0 {
+				continue
+			}
+			args := make([]interface{}, 0, 1+2*len(fields))
+			args = append(args, ttls[i].Val().Milliseconds())
+			for field, value := range fields {
+				args = append(args, field, value)
+			}
+			merge.Eval(ctx, regionalMergeScript, []string{key}, args...)
+			merged++
+		}
+		if merged == 0 {
+			return 0, nil
+		}
+		if _, err := merge.Exec(ctx); err != nil {
+			return 0, backendError(rc.client, "failed to merge peer counters", err)
+		}
+		return merged, nil
+	})
+}
+
+// usage sums the user's counters for the window starting at start
+func (rc *RegionalCounter) usage(ctx context.Context, userID string, start int64) (int, error) {
+	fields, err := rc.client.HGetAll(ctx, rc.keyPrefix+userID).Result()
+	if err != nil {
+		return 0, backendError(rc.client, "failed to get regional counters", err)
+	}
+
+	prefix := strconv.FormatInt(start, 10) + ":"
+	used := 0
+	for field, value := range fields {
+		if !strings.HasPrefix(field, prefix) {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid regional counter %s: %w", field, err)
+		}
+		if strings.HasSuffix(field, ":refunds") {
+			used -= n
+		} else {
+			used += n
+		}
+	}
+	return used, nil
+}
+
+// remaining returns the requests left after used, never negative
+func (rc *RegionalCounter) remaining(limit, used int) int {
+	remaining := rc.options.capacity(limit) - used
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// windowStart returns the start, in Unix milliseconds, of the fixed window
+// containing t
+func windowStart(t time.Time, windowSize time.Duration) int64 {
+	ms := t.UnixMilli()
+	size := windowSize.Milliseconds()
+	if size <= 0 {
+		return ms
+	}
+	return ms - ms%size
+}
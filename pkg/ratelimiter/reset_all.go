@@ -0,0 +1,28 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ResetAll deletes every key starting with prefix, e.g. "rate_limit:sliding:"
+// to reset every user's window, returning the number of keys deleted
+//
+// Keys are found with SCAN so Redis is never blocked, and deleted a batch at
+// a time by up to concurrency workers at once, which bounds the load put on
+// Redis however many keys there are.
+func ResetAll(ctx context.Context, client redis.UniversalClient, prefix string, concurrency int) (int, error) {
+	if prefix == "" {
+		return 0, fmt.Errorf("a key prefix is required")
+	}
+
+	return forEachBatch(ctx, client, escapePattern(prefix)+"*", concurrency, func(ctx context.Context, keys []string) (int, error) {
+		deleted, err := deleteKeys(ctx, client, keys...)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to delete keys: %w", err)
+		}
+		return deleted, nil
+	})
+}
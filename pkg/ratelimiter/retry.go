@@ -0,0 +1,61 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// WithRetry retries a failed check up to attempts more times, doubling the
+// backoff after each try
+// Only errors raised before Redis ran the script are retried, so a retried
+// request is never counted twice
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.retryAttempts = attempts
+		o.retryBackoff = backoff
+	}
+}
+
+// runWithRetry runs a Lua script by its SHA1, retrying on transient errors
+func (o options) runWithRetry(ctx context.Context, client redis.UniversalClient, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	backoff := o.retryBackoff
+	for attempt := 0; ; attempt++ {
+		result, err := script.Run(ctx, client, keys, args...).Result()
+		if err == nil || attempt >= o.retryAttempts || !isTransient(err) {
+			return result, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isTransient reports whether err means the script never ran
+// Failures after the command was sent (e.g. read timeouts) are not transient,
+// since the script may have consumed a slot
+func isTransient(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+
+	msg := err.Error()
+	if msg == "redis: connection pool timeout" {
+		return true
+	}
+	for _, prefix := range []string{"LOADING ", "BUSY ", "TRYAGAIN ", "CLUSTERDOWN "} {
+		if strings.HasPrefix(msg, prefix) {
+			return true
+		}
+	}
+	return false
+}
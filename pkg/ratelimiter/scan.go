@@ -0,0 +1,153 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// scanBatchSize is the number of keys asked of each SCAN call, and the most
+// keys one worker handles at a time
+const scanBatchSize = 100
+
+// forEachBatch scans the keys matching pattern and hands them, in batches of
+// at most scanBatchSize, to concurrency workers, summing the counts they
+// report
+//
+// Only the calling goroutine advances the SCAN cursor, so the iteration
+// keeps SCAN's guarantee that every key present for the whole scan is seen
+// while the workers modify keys. The first error stops the scan. A cluster
+// is scanned one master at a time, since each holds only its own slots' keys.
+func forEachBatch(ctx context.Context, client redis.UniversalClient, pattern string, concurrency int, process func(ctx context.Context, keys []string) (int, error)) (int, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		total    int64
+		failOnce sync.Once
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	fail := func(err error) {
+		failOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	batches := make(chan []string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for keys := range batches {
+				n, err := process(ctx, keys)
+				atomic.AddInt64(&total, int64(n))
+				if err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	for _, node := range scanNodes(ctx, client, fail) {
+		if !scanNode(ctx, node, pattern, batches, fail) {
+			break
+		}
+	}
+	close(batches)
+	wg.Wait()
+
+	if firstErr != nil {
+		return int(total), firstErr
+	}
+	if err := parent.Err(); err != nil {
+		return int(total), err
+	}
+	return int(total), nil
+}
+
+// scanNodes returns the clients to SCAN: every master of a cluster, or the
+// client itself
+func scanNodes(ctx context.Context, client redis.UniversalClient, fail func(error)) []redis.UniversalClient {
+	cluster, ok := client.(*redis.ClusterClient)
+	if !ok {
+		return []redis.UniversalClient{client}
+	}
+
+	var (
+		mu    sync.Mutex
+		nodes []redis.UniversalClient
+	)
+	err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+		mu.Lock()
+		nodes = append(nodes, master)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		fail(fmt.Errorf("failed to list cluster masters: %w", err))
+		return nil
+	}
+	return nodes
+}
+
+// scanNode sends the keys of one node matching pattern to batches, returning
+// false if the scan was stopped
+func scanNode(ctx context.Context, node redis.UniversalClient, pattern string, batches chan<- []string, fail func(error)) bool {
+	var cursor uint64
+	for {
+		keys, next, err := node.Scan(ctx, cursor, pattern, scanBatchSize).Result()
+		if err != nil {
+			fail(fmt.Errorf("failed to scan keys: %w", err))
+			return false
+		}
+		// COUNT is only a hint, so large replies are split up
+		for len(keys) > 0 {
+			n := scanBatchSize
+			if n > len(keys) {
+				n = len(keys)
+			}
+			select {
+			case batches <- keys[:n]:
+			case <-ctx.Done():
+				return false
+			}
+			keys = keys[n:]
+		}
+		if next == 0 {
+			return true
+		}
+		cursor = next
+	}
+}
+
+// deleteKeys deletes keys, returning how many existed
+// A cluster refuses multi-key commands spanning hash slots, so there each key
+// is deleted on its own, all in one pipeline
+func deleteKeys(ctx context.Context, client redis.UniversalClient, keys ...string) (int, error) {
+	if _, ok := client.(*redis.ClusterClient); !ok {
+		deleted, err := client.Del(ctx, keys...).Result()
+		return int(deleted), err
+	}
+
+	pipe := client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Del(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	deleted := 0
+	for _, cmd := range cmds {
+		deleted += int(cmd.Val())
+	}
+	return deleted, err
+}
@@ -0,0 +1,79 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ScriptVersion identifies the revision of the Lua scripts shipped with this
+// package; bump it whenever a script body changes
+const ScriptVersion = "1.10.0"
+
+// The scripts the limiters run on their own, hashed once when the package
+// loads so each call sends EVALSHA with the 40-byte SHA1 instead of the whole
+// body; the first call on a Redis that hasn't seen a script yet, e.g. after a
+// restart or SCRIPT FLUSH, gets NOSCRIPT and falls back to EVAL, which caches
+// it again
+// Pipelined calls (RateLimitBatch, the regional merge) still use EVAL, since
+// a NOSCRIPT reply only surfaces once the whole pipeline has run
+var (
+	slidingWindowLua  = redis.NewScript(slidingWindowScript)
+	multiLua          = redis.NewScript(multiScript)
+	fixedWindowLua    = redis.NewScript(fixedWindowScript)
+	fixedRefundLua    = redis.NewScript(fixedRefundScript)
+	leakyBucketLua    = redis.NewScript(leakyBucketScript)
+	leakyRefundLua    = redis.NewScript(leakyRefundScript)
+	leakyRetryLua     = redis.NewScript(leakyRetryAfterScript)
+	leakyRemainingLua = redis.NewScript(leakyRemainingScript)
+	regionalAllowLua  = redis.NewScript(regionalAllowScript)
+	regionalRefundLua = redis.NewScript(regionalRefundScript)
+	cardinalityLua    = redis.NewScript(cardinalityScript)
+	gcraLua           = redis.NewScript(gcraScript)
+	gcraRefundLua     = redis.NewScript(gcraRefundScript)
+)
+
+// Scripts returns the Lua scripts run by the limiters, keyed by name
+func Scripts() map[string]string {
+	return map[string]string{
+		"sliding_window":  slidingWindowScript,
+		"multi":           multiScript,
+		"fixed_window":    fixedWindowScript,
+		"fixed_refund":    fixedRefundScript,
+		"leaky_bucket":    leakyBucketScript,
+		"leaky_refund":    leakyRefundScript,
+		"leaky_retry":     leakyRetryAfterScript,
+		"leaky_remaining": leakyRemainingScript,
+		"regional":        regionalAllowScript,
+		"regional_merge":  regionalMergeScript,
+		"regional_refund": regionalRefundScript,
+		"distinct":        cardinalityScript,
+		"gcra":            gcraScript,
+		"gcra_refund":     gcraRefundScript,
+	}
+}
+
+// LoadScripts loads each script with SCRIPT LOAD, which makes Redis compile
+// it without running it
+// Meant to run at startup, so an invalid script fails fast instead of on the
+// first request that evaluates it
+// Returns the SHA1 Redis reported for each script, keyed by name
+func LoadScripts(ctx context.Context, client redis.UniversalClient, scripts map[string]string) (map[string]string, error) {
+	names := make([]string, 0, len(scripts))
+	for name := range scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	shas := make(map[string]string, len(names))
+	for _, name := range names {
+		sha, err := client.ScriptLoad(ctx, scripts[name]).Result()
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s script: %w", name, backendError(client, "failed to load script", err))
+		}
+		shas[name] = sha
+	}
+	return shas, nil
+}
@@ -2,9 +2,9 @@ package ratelimiter
 
 import (
 	"context"
-	"fmt"
 	"github.com/go-redis/redis/v8"
 	"go.uber.org/zap"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -12,20 +12,94 @@ import (
 // SlidingWindow implements a sliding window rate limiter using Redis Sorted Sets
 // This algorithm provides high precision and prevents burst traffic exploitation
 type SlidingWindow struct {
-	client    *redis.Client
+	client    redis.UniversalClient
 	logger    *zap.Logger
 	keyPrefix string
+	options   options
 }
 
 // NewSlidingWindow creates a new sliding window rate limiter
-func NewSlidingWindow(client *redis.Client, logger *zap.Logger) *SlidingWindow {
+func NewSlidingWindow(client redis.UniversalClient, logger *zap.Logger, opts ...Option) *SlidingWindow {
+	o := newOptions(opts)
 	return &SlidingWindow{
 		client:    client,
 		logger:    logger,
-		keyPrefix: "rate_limit:sliding:",
+		keyPrefix: o.keyPrefix("rate_limit:sliding:"),
+		options:   o,
 	}
 }
 
+// addEntriesScript defines add_entries, which records cost entries scored
+// current_time in a sorted set
+// Members must be unique, or requests made in the same millisecond would
+// overwrite each other and be undercounted: the first entry of a millisecond
+// is the millisecond itself, later ones are numbered after it, skipping any
+// number still taken
+const addEntriesScript = `
+	local function add_entries(key, current_time, cost)
+		local n = redis.call('ZCOUNT', key, current_time, current_time)
+		for i = 1, cost do
+			n = n + 1
+			local member = tostring(current_time)
+			if n > 1 then
+				member = current_time .. ':' .. n
+			end
+			while redis.call('ZADD', key, 'NX', current_time, member) == 0 do
+				n = n + 1
+				member = current_time .. ':' .. n
+			end
+		end
+	end
+`
+
+// slidingWindowScript trims the window, then records the request's cost in
+// slots if they all fit under the limit, all atomically
+// Returns {allowed, available, retry_at, reset_at}: 1 if the request is
+// allowed and 0 otherwise, the slots left before the request, when a denied
+// request would fit (0 if it was allowed or never fits) and when the newest
+// entry leaves the window, in Unix milliseconds
+const slidingWindowScript = addEntriesScript + `
+	local key = KEYS[1]
+	local current_time = tonumber(ARGV[1])
+	local window_start = tonumber(ARGV[2])
+	local limit = tonumber(ARGV[3])
+	local ttl_ms = tonumber(ARGV[4])
+	local inclusive = tonumber(ARGV[5])
+	local cost = tonumber(ARGV[6])
+	
+	-- Remove all entries outside the current window
+	redis.call('ZREMRANGEBYSCORE', key, '-inf', window_start)
+	
+	-- Count current requests in the window
+	local count = redis.call('ZCARD', key)
+	
+	-- If the cost fits under the limit, add an entry per slot and return 1
+	-- (allowed), otherwise return 0 (denied)
+	-- In inclusive mode a count equal to the limit is still under it
+	local available = limit + inclusive - count
+	local window_ms = current_time - window_start
+	if cost <= available then
+		add_entries(key, current_time, cost)
+		-- Expire the key once the window plus padding has passed
+		redis.call('PEXPIRE', key, ttl_ms)
+		return {1, available, 0, current_time + window_ms}
+	end
+
+	-- The request fits once enough of the oldest entries have left
+	local retry_at = 0
+	local index = count + cost - (limit + inclusive) - 1
+	if index < count then
+		local entry = redis.call('ZRANGE', key, index, index, 'WITHSCORES')
+		retry_at = tonumber(entry[2]) + window_ms
+	end
+	local newest = redis.call('ZRANGE', key, -1, -1, 'WITHSCORES')
+	local reset_at = 0
+	if #newest > 0 then
+		reset_at = tonumber(newest[2]) + window_ms
+	end
+	return {0, available, retry_at, reset_at}
+`
+
 // Allow checks if a request is allowed based on the sliding window algorithm
 // Returns true if allowed, false if rate limit exceeded
 //
@@ -41,83 +115,109 @@ func NewSlidingWindow(client *redis.Client, logger *zap.Logger) *SlidingWindow {
 // - Fairness: prevents burst traffic from exploiting fixed windows
 // - Atomicity: uses Lua script for atomic operations
 func (sw *SlidingWindow) Allow(ctx context.Context, userID string, limit int, windowSize time.Duration) (bool, error) {
+	return sw.AllowN(ctx, userID, limit, 1, windowSize)
+}
+
+// AllowN is like Allow for a request costing n slots, e.g. a batch of n
+// operations; it is allowed only if all n fit in the window
+func (sw *SlidingWindow) AllowN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (bool, error) {
+	capacity, err := sw.CheckN(ctx, userID, limit, n, windowSize)
+	return capacity.Allowed, err
+}
+
+// CheckN is like AllowN but also reports the slots that were left in the
+// window
+func (sw *SlidingWindow) CheckN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error) {
+	ctx, span := sw.options.startSpan(ctx, "sliding_window", userID, limit)
+	capacity, err := sw.checkN(ctx, userID, limit, n, windowSize)
+	endSpan(span, capacity.Allowed, err)
+	return capacity, err
+}
+
+// checkN runs the sliding window script for CheckN
+func (sw *SlidingWindow) checkN(ctx context.Context, userID string, limit, n int, windowSize time.Duration) (Capacity, error) {
+	if n <= 0 {
+		return Capacity{Allowed: true, Requested: n}, nil
+	}
+
 	key := sw.keyPrefix + userID
 	now := time.Now()
 	currentTime := now.UnixMilli()
 	windowStart := now.Add(-windowSize).UnixMilli()
 
-	// Lua script for atomic operation
-	// This ensures all operations happen atomically in Redis
-	script := `
-		local key = KEYS[1]
-		local current_time = tonumber(ARGV[1])
-		local window_start = tonumber(ARGV[2])
-		local limit = tonumber(ARGV[3])
-		local window_size_ms = tonumber(ARGV[4])
-		
-		-- Remove all entries outside the current window
-		redis.call('ZREMRANGEBYSCORE', key, '-inf', window_start)
-		
-		-- Count current requests in the window
-		local count = redis.call('ZCARD', key)
-		
-		-- If under limit, add current request and return 1 (allowed)
-		-- Otherwise return 0 (denied)
-		if count < limit then
-			redis.call('ZADD', key, current_time, current_time)
-			-- Set expiration to window size + 1 second for cleanup
-			redis.call('EXPIRE', key, math.ceil(window_size_ms / 1000) + 1)
-			return 1
-		else
-			return 0
-		end
-	`
-
-	result, err := sw.client.Eval(ctx, script, []string{key},
+	start := time.Now()
+	result, err := sw.options.runWithRetry(ctx, sw.client, slidingWindowLua, []string{key},
 		strconv.FormatInt(currentTime, 10),
 		strconv.FormatInt(windowStart, 10),
 		strconv.Itoa(limit),
-		strconv.FormatInt(windowSize.Milliseconds(), 10),
-	).Result()
+		strconv.FormatInt(sw.options.keyTTL(windowSize).Milliseconds(), 10),
+		sw.options.boundaryArg(),
+		strconv.Itoa(n),
+	)
+	sw.options.observe("allow", "sliding_window", start)
 
 	if err != nil {
 		sw.logger.Error("sliding window rate limit check failed",
 			zap.String("user_id", userID),
+			zap.String("backend", backendAddr(sw.client)),
 			zap.Error(err),
 		)
-		return false, fmt.Errorf("rate limit check failed: %w", err)
+		return Capacity{}, backendError(sw.client, "rate limit check failed", err)
 	}
 
-	allowed := result.(int64) == 1
+	capacity, err := capacityFromReply(result, n, now)
+	if err != nil {
+		return Capacity{}, err
+	}
 
-	if !allowed {
+	if !capacity.Allowed {
 		sw.logger.Debug("rate limit exceeded",
 			zap.String("user_id", userID),
 			zap.Int("limit", limit),
+			zap.Int("cost", n),
+			zap.Int("available", capacity.Available),
 		)
 	}
 
-	return allowed, nil
+	return capacity, nil
+}
+
+// AllowResult is like Allow but also reports the remaining requests and
+// when the user may retry, in the same round trip
+func (sw *SlidingWindow) AllowResult(ctx context.Context, userID string, limit int, windowSize time.Duration) (Result, error) {
+	capacity, err := sw.CheckN(ctx, userID, limit, 1, windowSize)
+	if err != nil {
+		return Result{}, err
+	}
+	return capacity.Result(userID, limit), nil
 }
 
 // GetRemaining returns the number of remaining requests allowed in the current window
+// It only counts the entries inside the window, leaving the set as it was,
+// unless the limiter was created WithPruneOnRead
 func (sw *SlidingWindow) GetRemaining(ctx context.Context, userID string, limit int, windowSize time.Duration) (int, error) {
-	key := sw.keyPrefix + userID
 	now := time.Now()
+	if !sw.options.pruneOnRead {
+		return sw.GetRemainingAt(ctx, userID, limit, windowSize, now)
+	}
+
+	key := sw.keyPrefix + userID
 	windowStart := now.Add(-windowSize).UnixMilli()
 
 	// Remove old entries and get count
 	pipe := sw.client.Pipeline()
 	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(windowStart, 10))
 	pipe.ZCard(ctx, key)
+	start := time.Now()
 	results, err := pipe.Exec(ctx)
+	sw.options.observe("remaining", "sliding_window", start)
 
 	if err != nil {
-		return 0, fmt.Errorf("failed to get remaining requests: %w", err)
+		return 0, backendError(sw.client, "failed to get remaining requests", err)
 	}
 
 	count := results[1].(*redis.IntCmd).Val()
-	remaining := limit - int(count)
+	remaining := sw.options.capacity(limit) - int(count)
 	if remaining < 0 {
 		remaining = 0
 	}
@@ -125,8 +225,150 @@ func (sw *SlidingWindow) GetRemaining(ctx context.Context, userID string, limit
 	return remaining, nil
 }
 
+// GetRemainingAt returns the number of requests that will be allowed at the
+// given instant, counting only the entries still inside the window then
+func (sw *SlidingWindow) GetRemainingAt(ctx context.Context, userID string, limit int, windowSize time.Duration, at time.Time) (int, error) {
+	key := sw.keyPrefix + userID
+	windowStart := at.Add(-windowSize).UnixMilli()
+
+	start := time.Now()
+	count, err := sw.client.ZCount(ctx, key, "("+strconv.FormatInt(windowStart, 10), "+inf").Result()
+	sw.options.observe("remaining", "sliding_window", start)
+	if err != nil {
+		return 0, backendError(sw.client, "failed to get remaining requests", err)
+	}
+
+	remaining := sw.options.capacity(limit) - int(count)
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return remaining, nil
+}
+
+// GetStats returns the number of requests in the window and the times of the
+// oldest and newest of them
+func (sw *SlidingWindow) GetStats(ctx context.Context, userID string, limit int, windowSize time.Duration) (Stats, error) {
+	key := sw.keyPrefix + userID
+	windowStart := time.Now().Add(-windowSize).UnixMilli()
+	min := "(" + strconv.FormatInt(windowStart, 10)
+
+	pipe := sw.client.Pipeline()
+	count := pipe.ZCount(ctx, key, min, "+inf")
+	oldest := pipe.ZRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: min, Max: "+inf", Count: 1})
+	newest := pipe.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{Min: min, Max: "+inf", Count: 1})
+	start := time.Now()
+	_, err := pipe.Exec(ctx)
+	sw.options.observe("stats", "sliding_window", start)
+	if err != nil {
+		return Stats{}, backendError(sw.client, "failed to get window stats", err)
+	}
+
+	stats := Stats{
+		Algorithm: "sliding_window",
+		Limit:     limit,
+		Count:     int(count.Val()),
+	}
+	stats.Remaining = sw.options.capacity(limit) - stats.Count
+	if stats.Remaining < 0 {
+		stats.Remaining = 0
+	}
+	if entries := oldest.Val(); len(entries) > 0 {
+		stats.Oldest = time.UnixMilli(int64(entries[0].Score))
+	}
+	if entries := newest.Val(); len(entries) > 0 {
+		stats.Newest = time.UnixMilli(int64(entries[0].Score))
+	}
+
+	return stats, nil
+}
+
+// Dump returns the times of the requests in the user's window, oldest first
+func (sw *SlidingWindow) Dump(ctx context.Context, userID string, windowSize time.Duration) ([]time.Time, error) {
+	times, _, err := sw.DumpN(ctx, userID, windowSize, 0)
+	return times, err
+}
+
+// DumpN is like Dump but returns at most n of the oldest requests (all of
+// them if n is 0 or less), along with how many the window holds in total
+func (sw *SlidingWindow) DumpN(ctx context.Context, userID string, windowSize time.Duration, n int) ([]time.Time, int, error) {
+	key := sw.keyPrefix + userID
+	min := "(" + strconv.FormatInt(time.Now().Add(-windowSize).UnixMilli(), 10)
+	rangeBy := &redis.ZRangeBy{Min: min, Max: "+inf"}
+	if n > 0 {
+		rangeBy.Count = int64(n)
+	}
+
+	pipe := sw.client.Pipeline()
+	count := pipe.ZCount(ctx, key, min, "+inf")
+	entries := pipe.ZRangeByScoreWithScores(ctx, key, rangeBy)
+	start := time.Now()
+	_, err := pipe.Exec(ctx)
+	sw.options.observe("dump", "sliding_window", start)
+	if err != nil {
+		return nil, 0, backendError(sw.client, "failed to dump window", err)
+	}
+
+	times := make([]time.Time, 0, len(entries.Val()))
+	for _, entry := range entries.Val() {
+		times = append(times, time.UnixMilli(int64(entry.Score)))
+	}
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+	return times, int(count.Val()), nil
+}
+
+// RetryAfter returns how long until enough entries leave the window for
+// another request to fit
+func (sw *SlidingWindow) RetryAfter(ctx context.Context, userID string, limit int, windowSize time.Duration) (time.Duration, error) {
+	key := sw.keyPrefix + userID
+	now := time.Now()
+	min := "(" + strconv.FormatInt(now.Add(-windowSize).UnixMilli(), 10)
+
+	// A request fits once the capacity-th newest entry has left the window
+	start := time.Now()
+	entries, err := sw.client.ZRevRangeByScoreWithScores(ctx, key, &redis.ZRangeBy{
+		Min:    min,
+		Max:    "+inf",
+		Offset: int64(sw.options.capacity(limit) - 1),
+		Count:  1,
+	}).Result()
+	sw.options.observe("retry_after", "sliding_window", start)
+	if err != nil {
+		return 0, backendError(sw.client, "failed to get retry after", err)
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	leaves := time.UnixMilli(int64(entries[0].Score)).Add(windowSize)
+	if wait := leaves.Sub(now); wait > 0 {
+		return wait, nil
+	}
+	return 0, nil
+}
+
+// Refund removes the newest entry from the user's window, returning one slot
+func (sw *SlidingWindow) Refund(ctx context.Context, userID string, windowSize time.Duration) error {
+	key := sw.keyPrefix + userID
+	start := time.Now()
+	err := sw.client.ZPopMax(ctx, key, 1).Err()
+	sw.options.observe("refund", "sliding_window", start)
+	if err != nil && err != redis.Nil {
+		return backendError(sw.client, "failed to refund request", err)
+	}
+	return nil
+}
+
 // Reset clears the rate limit for a user
-func (sw *SlidingWindow) Reset(ctx context.Context, userID string) error {
+func (sw *SlidingWindow) Reset(ctx context.Context, userID string, windowSize time.Duration) error {
 	key := sw.keyPrefix + userID
-	return sw.client.Del(ctx, key).Err()
+	start := time.Now()
+	err := sw.client.Del(ctx, key).Err()
+	sw.options.observe("reset", "sliding_window", start)
+	if err != nil {
+		return backendError(sw.client, "failed to reset rate limit", err)
+	}
+	return nil
 }
@@ -0,0 +1,25 @@
+package ratelimiter
+
+import "time"
+
+// Stats describes a user's current rate limit state
+// Algorithm, Limit and Remaining are always set; the other fields are
+// specific to the algorithm that produced them
+type Stats struct {
+	Algorithm string
+	Limit     int
+	Remaining int
+
+	// Sliding window: requests in the window and when the oldest and newest
+	// of them were made (zero when the window is empty)
+	Count  int
+	Oldest time.Time
+	Newest time.Time
+
+	// Leaky bucket: current level, capacity, requests drained per second and
+	// how long until the bucket is empty
+	Level       float64
+	Capacity    int
+	LeakRate    float64
+	TimeToEmpty time.Duration
+}
@@ -0,0 +1,56 @@
+package ratelimiter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation name the limiters' spans are recorded
+// under
+const TracerName = "ratelimit-challenge/pkg/ratelimiter"
+
+// WithTracerProvider records a span for each check through tp
+// By default spans go to the globally registered provider, which discards
+// them unless the application registered one with otel.SetTracerProvider
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// HashUserID returns the SHA-256 of a user ID, as recorded on spans so
+// traces don't carry the ID itself
+func HashUserID(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSpan starts the span of a check, as a child of the span in ctx if any
+func (o options) startSpan(ctx context.Context, algorithm, userID string, limit int) (context.Context, trace.Span) {
+	tp := o.tracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(TracerName).Start(ctx, "ratelimiter.Allow", trace.WithAttributes(
+		attribute.String("user_id", HashUserID(userID)),
+		attribute.String("algorithm", algorithm),
+		attribute.Int("limit", limit),
+	))
+}
+
+// endSpan records the outcome of a check on its span and ends it
+func endSpan(span trace.Span, allowed bool, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	} else {
+		span.SetAttributes(attribute.Bool("allowed", allowed))
+	}
+	span.End()
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"ratelimit-challenge/internal/app/server"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/fx"
+)
+
+// closeRecorder counts the times the client is closed, noting whether the
+// HTTP server still accepted connections at the time
+type closeRecorder struct {
+	redis.UniversalClient
+	addr string
+
+	mu             sync.Mutex
+	closes         int
+	serverListened bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closes++
+	if conn, err := net.Dial("tcp", c.addr); err == nil {
+		conn.Close()
+		c.serverListened = true
+	}
+	return c.UniversalClient.Close()
+}
+
+// freePort returns a port nothing is listening on
+func freePort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// TestApp_ShutdownClosesRedis checks that shutting down closes Redis exactly
+// once, after the HTTP server has stopped
+// This is an integration test that requires Redis to be running
+func TestApp_ShutdownClosesRedis(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	port := strconv.Itoa(freePort(t))
+	t.Setenv("API_HOST", "127.0.0.1")
+	t.Setenv("API_PORT", port)
+
+	recorder := &closeRecorder{addr: net.JoinHostPort("127.0.0.1", port)}
+	app, err := server.NewApp(fx.Decorate(func(client redis.UniversalClient) redis.UniversalClient {
+		recorder.UniversalClient = client
+		return recorder
+	}))
+	if err != nil {
+		t.Fatalf("failed to create app: %v", err)
+	}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- app.Start()
+	}()
+
+	// Wait for the server to accept connections
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		conn, err := net.Dial("tcp", recorder.addr)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := app.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("unexpected shutdown error: %v", err)
+	}
+	// Shutting down again must not close Redis twice
+	if err := app.Shutdown(shutdownCtx); err != nil {
+		t.Fatalf("unexpected error shutting down twice: %v", err)
+	}
+
+	if err := <-errChan; err != http.ErrServerClosed {
+		t.Errorf("expected the server to stop with %v, got %v", http.ErrServerClosed, err)
+	}
+
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if recorder.closes != 1 {
+		t.Errorf("expected Redis to be closed once, got %d", recorder.closes)
+	}
+	if recorder.serverListened {
+		t.Error("expected Redis to be closed after the server stopped")
+	}
+}
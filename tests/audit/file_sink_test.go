@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"ratelimit-challenge/pkg/audit"
+	"testing"
+	"time"
+)
+
+// readEvents decodes all JSON line events in a file
+func readEvents(t *testing.T, path string) []audit.Event {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+
+	var events []audit.Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event audit.Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid audit line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestFileSink_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := audit.NewFileSink(audit.FileConfig{Path: path})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, userID := range []string{"user1", "user2", "user3"} {
+		if err := sink.Write(audit.Event{Time: time.Now(), Type: audit.EventDenied, UserID: userID, Limit: 10}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// Writes are buffered until the sink is closed
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Errorf("expected events to be buffered, file has %d bytes", info.Size())
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	events := readEvents(t, path)
+	if len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	if events[1].UserID != "user2" || events[1].Type != audit.EventDenied || events[1].Limit != 10 {
+		t.Errorf("unexpected event: %+v", events[1])
+	}
+}
+
+func TestFileSink_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	event := audit.Event{Time: time.Now(), Type: audit.EventDenied, UserID: "user_rotate", Limit: 10}
+	line, _ := json.Marshal(event)
+	lineSize := int64(len(line) + 1)
+
+	// Room for exactly two events per file
+	sink, err := audit.NewFileSink(audit.FileConfig{
+		Path:       path,
+		MaxSize:    2 * lineSize,
+		MaxBackups: 2,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i := 0; i < 7; i++ {
+		if err := sink.Write(event); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 7 events: audit.log.2 (2), audit.log.1 (2), audit.log (1); the oldest file was dropped
+	for file, expected := range map[string]int{path: 1, path + ".1": 2, path + ".2": 2} {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", file, err)
+		}
+		if info.Size() > 2*lineSize {
+			t.Errorf("expected %s to be at most %d bytes, got %d", file, 2*lineSize, info.Size())
+		}
+		if n := len(readEvents(t, file)); n != expected {
+			t.Errorf("expected %d events in %s, got %d", expected, file, n)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected no more than MaxBackups rotated files")
+	}
+}
@@ -0,0 +1,154 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"ratelimit-challenge/pkg/audit"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeProducer records published batches, optionally failing every publish
+type fakeProducer struct {
+	mu      sync.Mutex
+	batches [][][]byte
+	err     error
+	closed  bool
+}
+
+func (p *fakeProducer) Publish(ctx context.Context, messages [][]byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	p.batches = append(p.batches, messages)
+	return nil
+}
+
+func (p *fakeProducer) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func TestKafkaSink_Publish(t *testing.T) {
+	producer := &fakeProducer{}
+	sink := audit.NewKafkaSink(producer, audit.KafkaConfig{
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+
+	for _, userID := range []string{"user1", "user2", "user3"} {
+		if err := sink.Write(audit.Event{Type: audit.EventDenied, UserID: userID}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !producer.closed {
+		t.Error("expected producer to be closed")
+	}
+	// One full batch, then the remainder flushed on Close
+	if len(producer.batches) != 2 || len(producer.batches[0]) != 2 || len(producer.batches[1]) != 1 {
+		t.Fatalf("expected batches of 2 and 1, got %d batches", len(producer.batches))
+	}
+
+	var event audit.Event
+	if err := json.Unmarshal(producer.batches[1][0], &event); err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	if event.UserID != "user3" {
+		t.Errorf("expected user3, got %q", event.UserID)
+	}
+	if sink.Dropped() != 0 {
+		t.Errorf("expected no dropped events, got %d", sink.Dropped())
+	}
+}
+
+func TestKafkaSink_BrokerUnavailable(t *testing.T) {
+	producer := &fakeProducer{err: errors.New("broker unavailable")}
+	sink := audit.NewKafkaSink(producer, audit.KafkaConfig{BatchSize: 10})
+
+	for i := 0; i < 5; i++ {
+		if err := sink.Write(audit.Event{Type: audit.EventDenied, UserID: "user1"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if sink.Dropped() != 5 {
+		t.Errorf("expected 5 dropped events, got %d", sink.Dropped())
+	}
+}
+
+// blockingProducer holds every publish until released
+type blockingProducer struct {
+	fakeProducer
+	release chan struct{}
+}
+
+func (p *blockingProducer) Publish(ctx context.Context, messages [][]byte) error {
+	<-p.release
+	return p.fakeProducer.Publish(ctx, messages)
+}
+
+func TestDrain(t *testing.T) {
+	t.Run("buffered events are flushed", func(t *testing.T) {
+		producer := &fakeProducer{}
+		sink := audit.NewKafkaSink(producer, audit.KafkaConfig{
+			BatchSize:     10,
+			FlushInterval: time.Hour,
+		})
+		for _, userID := range []string{"user1", "user2", "user3"} {
+			if err := sink.Write(audit.Event{Type: audit.EventDenied, UserID: userID}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := audit.Drain(ctx, sink); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		producer.mu.Lock()
+		defer producer.mu.Unlock()
+		if len(producer.batches) != 1 || len(producer.batches[0]) != 3 {
+			t.Fatalf("expected the 3 buffered events in one batch, got %d batches", len(producer.batches))
+		}
+		if !producer.closed {
+			t.Error("expected producer to be closed")
+		}
+	})
+
+	t.Run("gives up after the timeout", func(t *testing.T) {
+		producer := &blockingProducer{release: make(chan struct{})}
+		defer close(producer.release)
+		sink := audit.NewKafkaSink(producer, audit.KafkaConfig{
+			BatchSize:     10,
+			FlushInterval: time.Hour,
+		})
+		if err := sink.Write(audit.Event{Type: audit.EventDenied, UserID: "user1"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		err := audit.Drain(ctx, sink)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected the drain to time out, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected the drain to stop at its timeout, took %v", elapsed)
+		}
+	})
+}
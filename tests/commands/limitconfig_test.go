@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"ratelimit-challenge/cmd/commands/limitconfig"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redismock/v8"
+	"go.uber.org/zap"
+)
+
+// runConfig runs the config command with args against a service backed by
+// mock, returning its output
+func runConfig(t *testing.T, args ...string) (redismock.ClientMock, func() (string, error)) {
+	t.Helper()
+	db, mock := redismock.NewClientMock()
+	cfg := &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   60,
+		Algorithm:    "sliding_window",
+	}
+	closed := false
+	t.Cleanup(func() {
+		if !closed {
+			t.Error("expected the service to be closed")
+		}
+	})
+	newService := func(ctx context.Context) (*ratelimiter.Service, func(context.Context) error, error) {
+		stop := func(context.Context) error {
+			closed = true
+			return nil
+		}
+		return ratelimiter.NewService(db, cfg, zap.NewNop()), stop, nil
+	}
+
+	return mock, func() (string, error) {
+		var out bytes.Buffer
+		cmd := limitconfig.NewCommandWith(newService)
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+}
+
+func TestConfigCommand_Set(t *testing.T) {
+	mock, run := runConfig(t, "set", "user:1", "500")
+	mock.ExpectSet("rate_limit:config:user%3A1", 500, 0).SetVal("OK")
+
+	out, err := run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "set rate_limit:config:user%3A1 to 500\n" {
+		t.Errorf("expected a confirmation naming the key, got %q", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConfigCommand_SetFails(t *testing.T) {
+	mock, run := runConfig(t, "set", "user1", "500")
+	mock.ExpectSet("rate_limit:config:user1", 500, 0).SetErr(errors.New("connection refused"))
+
+	if _, err := run(); err == nil || !strings.Contains(err.Error(), "failed to set user limit") {
+		t.Errorf("expected the write to fail, got %v", err)
+	}
+}
+
+func TestConfigCommand_SetInvalidLimit(t *testing.T) {
+	for _, limit := range []string{"0", "-5", "ten", "1.5"} {
+		t.Run(limit, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			cmd := limitconfig.NewCommandWith(func(ctx context.Context) (*ratelimiter.Service, func(context.Context) error, error) {
+				t.Fatal("expected no service to be built for an invalid limit")
+				return ratelimiter.NewService(db, &config.RateLimitConfig{}, zap.NewNop()), nil, nil
+			})
+			cmd.SetOut(&bytes.Buffer{})
+			cmd.SetErr(&bytes.Buffer{})
+			cmd.SetArgs([]string{"set", "user1", "--", limit})
+
+			err := cmd.Execute()
+			if err == nil || !strings.Contains(err.Error(), "limit must be a positive integer") {
+				t.Errorf("expected the limit to be rejected, got %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestConfigCommand_Reset(t *testing.T) {
+	mock, run := runConfig(t, "reset", "user1")
+	mock.ExpectDel("rate_limit:config:user1").SetVal(1)
+	mock.ExpectDel("rate_limit:sliding:user1").SetVal(1)
+	mock.ExpectDel("rate_limit:sliding:{user1}:burst").SetVal(0)
+	mock.ExpectDel("rate_limit:sliding:{user1}:sustained").SetVal(0)
+
+	out, err := run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out != "deleted rate_limit:config:user1 and reset the window\n" {
+		t.Errorf("expected a confirmation naming the key, got %q", out)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"ratelimit-challenge/cmd/commands/importlimits"
+	"ratelimit-challenge/cmd/commands/migrate"
+	"ratelimit-challenge/cmd/commands/resetall"
+	"ratelimit-challenge/pkg/connections"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+)
+
+func TestCommands_ConnectPerRedisMode(t *testing.T) {
+	t.Setenv("REDIS_MODE", "cluster")
+	t.Setenv("REDIS_ADDRS", "redis-0:6379,redis-1:6379")
+	t.Setenv("REDIS_PASSWORD", "secret")
+
+	errStop := errors.New("stop before touching redis")
+	commands := []struct {
+		name string
+		new  func(connections.RedisConnector) *cobra.Command
+		args []string
+	}{
+		{name: "reset-all", new: resetall.NewCommandWith, args: []string{"--prefix", "rate_limit:sliding:"}},
+		{name: "import-limits", new: importlimits.NewCommandWith, args: []string{"--file", "-"}},
+		{name: "migrate-prefix", new: migrate.NewCommandWith, args: []string{"--from", "rate_limit:", "--to", "rl:"}},
+	}
+	for _, tc := range commands {
+		t.Run(tc.name, func(t *testing.T) {
+			var got connections.RedisConfig
+			cmd := tc.new(func(cfg connections.RedisConfig, logger *zap.Logger) (redis.UniversalClient, error) {
+				got = cfg
+				return nil, errStop
+			})
+			cmd.SetArgs(tc.args)
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+
+			if err := cmd.Execute(); !errors.Is(err, errStop) {
+				t.Fatalf("expected the connection error, got %v", err)
+			}
+			if got.Mode != connections.ModeCluster {
+				t.Errorf("expected cluster mode, got %q", got.Mode)
+			}
+			if want := []string{"redis-0:6379", "redis-1:6379"}; !reflect.DeepEqual(got.Addrs, want) {
+				t.Errorf("expected addrs %v, got %v", want, got.Addrs)
+			}
+			if got.Password != "secret" {
+				t.Errorf("expected the password to carry over, got %q", got.Password)
+			}
+		})
+	}
+}
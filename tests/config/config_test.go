@@ -0,0 +1,151 @@
+package config
+
+import (
+	"ratelimit-challenge/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.RateLimit.Algorithm != "sliding_window" {
+		t.Errorf("expected default algorithm sliding_window, got %q", cfg.RateLimit.Algorithm)
+	}
+}
+
+func TestLoadConfig_ReportsAllValidationErrors(t *testing.T) {
+	t.Setenv("RATE_LIMIT_DEFAULT_LIMIT", "0")
+	t.Setenv("RATE_LIMIT_ALGORITHM", "token_bucket")
+	t.Setenv("RATE_LIMIT_DISCONNECT_POLICY", "drop")
+	t.Setenv("RATE_LIMIT_TRUSTED_UPSTREAMS", "gateway.internal")
+	t.Setenv("RATE_LIMIT_ALLOWLIST_CIDRS", "10.0.0.0/33")
+	t.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "lb.internal")
+	t.Setenv("RATE_LIMIT_BURST", "-1")
+	t.Setenv("RATE_LIMIT_KEY_NAMESPACE", "{billing}")
+	t.Setenv("RATE_LIMIT_MAX_BATCH_SIZE", "0")
+	t.Setenv("RATE_LIMIT_DEBOUNCE_INTERVAL", "300ms")
+	t.Setenv("RATE_LIMIT_DEBOUNCE_MAX_BODY", "0")
+
+	_, err := config.LoadConfig()
+	if err == nil {
+		t.Fatal("expected an error for invalid config")
+	}
+
+	for _, field := range []string{
+		"rate_limit.default_limit",
+		"rate_limit.algorithm",
+		"rate_limit.disconnect_policy",
+		"rate_limit.trusted_upstreams",
+		"rate_limit.allowlist_cidrs",
+		"rate_limit.trusted_proxies",
+		"rate_limit.burst",
+		"rate_limit.key_namespace",
+		"rate_limit.max_batch_size",
+		"rate_limit.debounce_max_body",
+	} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("expected error to mention %s, got: %v", field, err)
+		}
+	}
+}
+
+func TestLoadConfig_Middleware(t *testing.T) {
+	t.Run("custom order", func(t *testing.T) {
+		t.Setenv("API_MIDDLEWARE", "rate_limit,cors")
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(cfg.API.Middleware) != 2 || cfg.API.Middleware[0] != "rate_limit" || cfg.API.Middleware[1] != "cors" {
+			t.Errorf("expected [rate_limit cors], got %v", cfg.API.Middleware)
+		}
+	})
+
+	t.Run("unknown middleware", func(t *testing.T) {
+		t.Setenv("API_MIDDLEWARE", "cors,gzip")
+
+		_, err := config.LoadConfig()
+		if err == nil || !strings.Contains(err.Error(), `unknown middleware "gzip"`) {
+			t.Errorf("expected unknown middleware error, got %v", err)
+		}
+	})
+}
+
+func TestLoadConfig_RedisDatabases(t *testing.T) {
+	t.Run("default to redis.db", func(t *testing.T) {
+		t.Setenv("REDIS_DB", "2")
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Redis.LimiterDatabase() != 2 || cfg.Redis.ConfigDatabase() != 2 {
+			t.Errorf("expected both databases to be 2, got limiter %d and config %d", cfg.Redis.LimiterDatabase(), cfg.Redis.ConfigDatabase())
+		}
+	})
+
+	t.Run("configured independently", func(t *testing.T) {
+		t.Setenv("REDIS_DB", "2")
+		t.Setenv("REDIS_LIMITER_DB", "5")
+		t.Setenv("REDIS_CONFIG_DB", "0")
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if cfg.Redis.LimiterDatabase() != 5 || cfg.Redis.ConfigDatabase() != 0 {
+			t.Errorf("expected limiter database 5 and config database 0, got %d and %d", cfg.Redis.LimiterDatabase(), cfg.Redis.ConfigDatabase())
+		}
+	})
+
+	t.Run("invalid database", func(t *testing.T) {
+		t.Setenv("REDIS_LIMITER_DB", "-2")
+
+		_, err := config.LoadConfig()
+		if err == nil || !strings.Contains(err.Error(), "redis.limiter_db") {
+			t.Errorf("expected an invalid database error, got %v", err)
+		}
+	})
+}
+
+func TestLoadConfig_RedisMode(t *testing.T) {
+	t.Run("cluster", func(t *testing.T) {
+		t.Setenv("REDIS_MODE", "cluster")
+		t.Setenv("REDIS_ADDRS", "redis-0:6379,redis-1:6379")
+
+		cfg, err := config.LoadConfig()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(cfg.Redis.Addrs) != 2 || cfg.Redis.Addrs[1] != "redis-1:6379" {
+			t.Errorf("expected two seed nodes, got %v", cfg.Redis.Addrs)
+		}
+	})
+
+	for _, tt := range []struct {
+		name  string
+		env   map[string]string
+		field string
+	}{
+		{name: "cluster without addrs", env: map[string]string{"REDIS_MODE": "cluster"}, field: "redis.addrs"},
+		{name: "sentinel without addrs", env: map[string]string{"REDIS_MODE": "sentinel", "REDIS_MASTER_NAME": "mymaster"}, field: "redis.addrs"},
+		{name: "sentinel without master", env: map[string]string{"REDIS_MODE": "sentinel", "REDIS_ADDRS": "sentinel:26379"}, field: "redis.master_name"},
+		{name: "cluster with a database", env: map[string]string{"REDIS_MODE": "cluster", "REDIS_ADDRS": "redis-0:6379", "REDIS_DB": "1"}, field: "redis.db"},
+		{name: "unknown mode", env: map[string]string{"REDIS_MODE": "ring"}, field: "redis.mode"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			_, err := config.LoadConfig()
+			if err == nil || !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("expected an error mentioning %s, got %v", tt.field, err)
+			}
+		})
+	}
+}
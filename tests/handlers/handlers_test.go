@@ -0,0 +1,669 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/server/handlers"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	ratelimiterpkg "ratelimit-challenge/pkg/ratelimiter"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// newTestServer registers the API routes against the given Redis client
+func newTestServer(client *redis.Client, cfg *config.RateLimitConfig) *echo.Echo {
+	e := echo.New()
+	service := ratelimiter.NewService(client, cfg, zap.NewNop())
+	handlers.RegisterRoutes(e.Group("/api/v1"), service, zap.NewNop())
+	return e
+}
+
+// newTestConfig returns a sliding window config without local caching
+func newTestConfig() *config.RateLimitConfig {
+	return &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       1,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+}
+
+// serve performs a request and decodes the JSON response body
+func serve(t *testing.T, e *echo.Echo, req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to decode response body %q: %v", rec.Body.String(), err)
+	}
+	return rec, body
+}
+
+func TestHandler_GetRemaining_Percent(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+
+	tests := []struct {
+		name            string
+		limit           int
+		customLimit     string
+		count           int64
+		expectedPercent float64
+	}{
+		{name: "rounds down", limit: 30, count: 10, expectedPercent: 66},
+		{name: "full quota", limit: 20, count: 0, expectedPercent: 100},
+		{name: "exhausted", limit: 20, count: 20, expectedPercent: 0},
+		{name: "zero limit", limit: 0, count: 0, expectedPercent: 0},
+		// 30 of the user's own 40, not of the 20 asked for
+		{name: "custom limit", limit: 20, customLimit: "40", count: 10, expectedPercent: 75},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A fresh server so the default limit isn't served from cache
+			e := newTestServer(db, newTestConfig())
+
+			if tt.customLimit != "" {
+				mock.ExpectGet("rate_limit:config:user555").SetVal(tt.customLimit)
+			} else {
+				mock.ExpectGet("rate_limit:config:user555").RedisNil()
+				mock.ExpectGet("rate_limit:default").RedisNil()
+			}
+			mock.Regexp().ExpectZCount("rate_limit:sliding:user555", `\(\d+`, `\+inf`).SetVal(tt.count)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user555/remaining?limit="+strconv.Itoa(tt.limit), nil)
+			rec, body := serve(t, e, req.WithContext(context.Background()))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", rec.Code)
+			}
+			if body["remaining_percent"] != tt.expectedPercent {
+				t.Errorf("expected remaining_percent %v, got %v", tt.expectedPercent, body["remaining_percent"])
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestHandler_BlockUser(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	cfg := newTestConfig()
+	cfg.EnableLocalCache = true
+	e := newTestServer(db, cfg)
+
+	mock.ExpectSet("rate_limit:config:user666", "blocked", 0).SetVal("OK")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/rate-limit/user666", strings.NewReader(`{"blocked": true}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec, body := serve(t, e, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %v", rec.Code, body)
+	}
+	if body["blocked"] != true {
+		t.Errorf("expected the user blocked, got %v", body)
+	}
+
+	// The block is cached, so warming reports it after a single lookup
+	mock.ExpectGet("rate_limit:config:user666").SetVal("blocked")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/rate-limit/user666/warm", nil)
+	if _, body := serve(t, e, req); body["blocked"] != true || body["custom"] != true {
+		t.Errorf("expected warming to report the block, got %v", body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestHandler_DeleteUserLimit checks that deleting a user's config removes
+// only their custom limit, and succeeds when they have none
+func TestHandler_DeleteUserLimit(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	e := newTestServer(db, newTestConfig())
+
+	// Only the config is deleted, never the counter
+	mock.ExpectDel("rate_limit:config:user555").SetVal(1)
+	mock.ExpectDel("rate_limit:config:user556").SetVal(0)
+
+	for _, userID := range []string{"user555", "user556"} {
+		req := httptest.NewRequest(http.MethodDelete, "/api/v1/rate-limit/"+userID+"/config", nil)
+		rec, body := serve(t, e, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %v", userID, rec.Code, body)
+		}
+		if body["user_id"] != userID {
+			t.Errorf("expected user_id %s, got %v", userID, body["user_id"])
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandler_WarmUserLimit(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	cfg := newTestConfig()
+	cfg.EnableLocalCache = true
+	e := newTestServer(db, cfg)
+
+	// Populates the cache with the user's current limit
+	mock.ExpectGet("rate_limit:config:user777").SetVal("5")
+	mock.Regexp().ExpectZCount("rate_limit:sliding:user777", `\(\d+`, `\+inf`).SetVal(0)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user777/remaining", nil)
+	if _, body := serve(t, e, req); body["remaining"] != float64(5) {
+		t.Fatalf("expected remaining 5, got %v", body["remaining"])
+	}
+
+	// The limit changed elsewhere; warming reloads it despite the cached entry
+	mock.ExpectGet("rate_limit:config:user777").SetVal("8")
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/rate-limit/user777/warm", nil)
+	rec, body := serve(t, e, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	if body["limit"] != float64(8) || body["custom"] != true {
+		t.Errorf("expected the warmed limit 8, got %v", body)
+	}
+
+	// The refreshed limit is served from the cache without another lookup
+	mock.Regexp().ExpectZCount("rate_limit:sliding:user777", `\(\d+`, `\+inf`).SetVal(0)
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user777/remaining", nil)
+	if _, body := serve(t, e, req); body["remaining"] != float64(8) {
+		t.Errorf("expected remaining 8 from the warmed cache, got %v", body["remaining"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandler_GetRemaining_Window(t *testing.T) {
+	t.Run("counts over the given window", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		cfg := newTestConfig()
+		cfg.LimitUnit = "per_second"
+		e := newTestServer(db, cfg)
+
+		mock.ExpectGet("rate_limit:config:user888").RedisNil()
+		mock.ExpectGet("rate_limit:default").RedisNil()
+		// Only entries inside the requested 30 second window are counted
+		mock.CustomMatch(func(expected, actual []interface{}) error {
+			windowStart, err := strconv.ParseInt(strings.TrimPrefix(actual[2].(string), "("), 10, 64)
+			if err != nil {
+				return err
+			}
+			if age := time.Since(time.UnixMilli(windowStart)); age < 29*time.Second || age > 31*time.Second {
+				return fmt.Errorf("expected a window start 30s ago, got %v ago", age)
+			}
+			return nil
+		}).ExpectZCount("rate_limit:sliding:user888", "", "").SetVal(15)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user888/remaining?limit=2&window=30", nil)
+		rec, body := serve(t, e, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %v", rec.Code, body)
+		}
+		// 2 requests per second over 30 seconds, 15 of them used
+		if body["remaining"] != float64(45) {
+			t.Errorf("expected remaining 45, got %v", body["remaining"])
+		}
+		if body["window"] != float64(30) {
+			t.Errorf("expected window 30, got %v", body["window"])
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	for _, window := range []string{"0", "-5", "abc"} {
+		t.Run("rejects window "+window, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			e := newTestServer(db, newTestConfig())
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user888/remaining?window="+window, nil)
+			rec, _ := serve(t, e, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", rec.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestHandler_GetStats_Source(t *testing.T) {
+	lookupErr := errors.New("connection refused")
+
+	tests := []struct {
+		name           string
+		query          string
+		versionLimits  map[string]int
+		expect         func(mock redismock.ClientMock)
+		key            string
+		expectedLimit  float64
+		expectedSource string
+		// Of the limit, with 2 requests counted
+		expectedPercent float64
+	}{
+		{
+			name: "user",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:config:user999").SetVal("5")
+			},
+			key:             "rate_limit:sliding:user999",
+			expectedLimit:   5,
+			expectedPercent: 60,
+			expectedSource:  "user",
+		},
+		{
+			name:          "api version",
+			query:         "&api_version=v2",
+			versionLimits: map[string]int{"v2": 7},
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:config:user999").RedisNil()
+			},
+			key:             "rate_limit:sliding:user999:v2",
+			expectedLimit:   7,
+			expectedPercent: 71,
+			expectedSource:  "api_version",
+		},
+		{
+			name: "default",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:config:user999").RedisNil()
+				mock.ExpectGet("rate_limit:default").SetVal("50")
+			},
+			key:             "rate_limit:sliding:user999",
+			expectedLimit:   50,
+			expectedPercent: 96,
+			expectedSource:  "default",
+		},
+		{
+			name: "fallback",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:config:user999").RedisNil()
+				mock.ExpectGet("rate_limit:default").RedisNil()
+			},
+			key:             "rate_limit:sliding:user999",
+			expectedLimit:   20,
+			expectedPercent: 90,
+			expectedSource:  "fallback",
+		},
+		{
+			name: "fallback when the user's limit can't be looked up",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:config:user999").SetErr(lookupErr)
+			},
+			key:             "rate_limit:sliding:user999",
+			expectedLimit:   20,
+			expectedPercent: 90,
+			expectedSource:  "fallback",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			cfg := newTestConfig()
+			cfg.APIVersionLimits = tt.versionLimits
+			e := newTestServer(db, cfg)
+
+			tt.expect(mock)
+			rangeBy := &redis.ZRangeBy{Min: `\(\d+`, Max: `\+inf`, Count: 1}
+			mock.Regexp().ExpectZCount(tt.key, `\(\d+`, `\+inf`).SetVal(2)
+			mock.Regexp().ExpectZRangeByScoreWithScores(tt.key, rangeBy).SetVal(nil)
+			mock.Regexp().ExpectZRevRangeByScoreWithScores(tt.key, rangeBy).SetVal(nil)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user999/stats?limit=20"+tt.query, nil)
+			rec, body := serve(t, e, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status 200, got %d: %v", rec.Code, body)
+			}
+			if body["limit"] != tt.expectedLimit {
+				t.Errorf("expected limit %v, got %v", tt.expectedLimit, body["limit"])
+			}
+			if body["source"] != tt.expectedSource {
+				t.Errorf("expected source %q, got %v", tt.expectedSource, body["source"])
+			}
+			if body["remaining_percent"] != tt.expectedPercent {
+				t.Errorf("expected remaining_percent %v, got %v", tt.expectedPercent, body["remaining_percent"])
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestHandler_GetWindow(t *testing.T) {
+	t.Run("lists the requests in the window", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		e := newTestServer(db, newTestConfig())
+
+		now := time.Now().UnixMilli()
+		mock.Regexp().ExpectZCount("rate_limit:sliding:user777", `\(\d+`, `\+inf`).SetVal(2)
+		mock.Regexp().ExpectZRangeByScoreWithScores("rate_limit:sliding:user777", &redis.ZRangeBy{Min: `\(\d+`, Max: `\+inf`, Count: 1000}).SetVal([]redis.Z{
+			{Score: float64(now - 100), Member: "a"},
+			{Score: float64(now - 50), Member: "b"},
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user777/window", nil)
+		rec, body := serve(t, e, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %v", rec.Code, body)
+		}
+		if body["count"] != float64(2) {
+			t.Errorf("expected count 2, got %v", body["count"])
+		}
+		if entries, _ := body["entries"].([]interface{}); len(entries) != 2 {
+			t.Errorf("expected 2 entries, got %v", body["entries"])
+		}
+		if body["truncated"] != false {
+			t.Errorf("expected truncated false, got %v", body["truncated"])
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("rejects algorithms without a log", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		cfg := newTestConfig()
+		cfg.Algorithm = "fixed_window"
+		e := newTestServer(db, cfg)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user777/window", nil)
+		rec, _ := serve(t, e, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", rec.Code)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestHandler_GetDiagnostics checks that diagnostics report the SHA1 Redis
+// assigns to the current scripts
+// This is an integration test that requires Redis to be running
+func TestHandler_GetDiagnostics(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	e := newTestServer(client, newTestConfig())
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/diagnostics", nil)
+	rec, body := serve(t, e, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %v", rec.Code, body)
+	}
+	if body["script_version"] != ratelimiterpkg.ScriptVersion {
+		t.Errorf("expected script version %s, got %v", ratelimiterpkg.ScriptVersion, body["script_version"])
+	}
+
+	scripts, ok := body["scripts"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected a scripts object, got %v", body["scripts"])
+	}
+	sum := sha1.Sum([]byte(ratelimiterpkg.Scripts()["sliding_window"]))
+	if expected := hex.EncodeToString(sum[:]); scripts["sliding_window"] != expected {
+		t.Errorf("expected sliding_window sha %s, got %v", expected, scripts["sliding_window"])
+	}
+	for _, name := range []string{"campaign", "counter", "egress", "penalty"} {
+		if _, ok := scripts[name]; !ok {
+			t.Errorf("expected the %s script to be reported", name)
+		}
+	}
+}
+
+// TestHandler_GetUserLimit checks that a user's configured limit is
+// returned, or the default for users without one
+func TestHandler_GetUserLimit(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	e := newTestServer(db, newTestConfig())
+
+	mock.ExpectGet("rate_limit:config:user555").SetVal("40")
+	mock.ExpectGet("rate_limit:config:user556").RedisNil()
+	mock.ExpectGet("rate_limit:default").RedisNil()
+
+	for _, tt := range []struct {
+		userID     string
+		limit      float64
+		configured bool
+	}{
+		{userID: "user555", limit: 40, configured: true},
+		{userID: "user556", limit: 10, configured: false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/"+tt.userID, nil)
+		rec, body := serve(t, e, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for %s, got %d: %v", tt.userID, rec.Code, body)
+		}
+		if len(body) != 3 || body["user_id"] != tt.userID || body["limit"] != tt.limit || body["configured"] != tt.configured {
+			t.Errorf("expected %s with limit %v, configured %v, got %v", tt.userID, tt.limit, tt.configured, body)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestHandler_GetOverview(t *testing.T) {
+	t.Run("idle user", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		e := newTestServer(db, newTestConfig())
+
+		mock.ExpectPTTL("rate_limit:penalty:user999").SetVal(-2)
+		mock.ExpectHGetAll("rate_limit:campaign").SetVal(map[string]string{})
+		mock.ExpectGet("{rate_limit:campaign}:used").RedisNil()
+		mock.ExpectGet("rate_limit:config:user999").RedisNil()
+		mock.ExpectGet("rate_limit:default").RedisNil()
+		rangeBy := &redis.ZRangeBy{Min: `\(\d+`, Max: `\+inf`, Count: 1}
+		mock.Regexp().ExpectZCount("rate_limit:sliding:user999", `\(\d+`, `\+inf`).SetVal(0)
+		mock.Regexp().ExpectZRangeByScoreWithScores("rate_limit:sliding:user999", rangeBy).SetVal(nil)
+		mock.Regexp().ExpectZRevRangeByScoreWithScores("rate_limit:sliding:user999", rangeBy).SetVal(nil)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user999/overview?limit=20", nil)
+		rec, body := serve(t, e, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %v", rec.Code, body)
+		}
+		for _, field := range []string{"user_id", "algorithm", "limit", "remaining", "used", "reset_at", "source", "configured", "penalty_until", "campaign"} {
+			if _, ok := body[field]; !ok {
+				t.Errorf("expected field %q in %v", field, body)
+			}
+		}
+		if body["limit"] != float64(20) || body["remaining"] != float64(20) || body["used"] != float64(0) {
+			t.Errorf("expected 20 of 20 remaining with none used, got %v", body)
+		}
+		if body["source"] != "fallback" || body["configured"] != false {
+			t.Errorf("expected source fallback with no configured limit, got %v", body)
+		}
+		if body["reset_at"] != nil || body["penalty_until"] != nil || body["campaign"] != nil {
+			t.Errorf("expected no reset, penalty or campaign, got %v", body)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("active user", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		e := newTestServer(db, newTestConfig())
+
+		oldest := time.Now().Add(-500 * time.Millisecond).Truncate(time.Millisecond)
+		endsAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+
+		mock.ExpectPTTL("rate_limit:penalty:user999").SetVal(30 * time.Second)
+		mock.ExpectHGetAll("rate_limit:campaign").SetVal(map[string]string{
+			"name":    "launch",
+			"total":   "1000",
+			"ends_at": strconv.FormatInt(endsAt.UnixMilli(), 10),
+		})
+		mock.ExpectGet("{rate_limit:campaign}:used").SetVal("12")
+		mock.ExpectGet("rate_limit:config:user999").SetVal("5")
+		rangeBy := &redis.ZRangeBy{Min: `\(\d+`, Max: `\+inf`, Count: 1}
+		mock.Regexp().ExpectZCount("rate_limit:sliding:user999", `\(\d+`, `\+inf`).SetVal(3)
+		mock.Regexp().ExpectZRangeByScoreWithScores("rate_limit:sliding:user999", rangeBy).
+			SetVal([]redis.Z{{Score: float64(oldest.UnixMilli()), Member: "a"}})
+		mock.Regexp().ExpectZRevRangeByScoreWithScores("rate_limit:sliding:user999", rangeBy).
+			SetVal([]redis.Z{{Score: float64(time.Now().UnixMilli()), Member: "c"}})
+
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/rate-limit/user999/overview?limit=20", nil)
+		rec, body := serve(t, e, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %v", rec.Code, body)
+		}
+		if body["source"] != "user" || body["limit"] != float64(5) || body["configured"] != true {
+			t.Errorf("expected the user's configured limit of 5, got %v", body)
+		}
+		used, _ := body["used"].(float64)
+		remaining, _ := body["remaining"].(float64)
+		if used != 3 || used+remaining != 5 {
+			t.Errorf("expected 3 used and used + remaining = 5, got %v used and %v remaining", used, remaining)
+		}
+
+		resetAt, err := time.Parse(time.RFC3339Nano, fmt.Sprint(body["reset_at"]))
+		if err != nil {
+			t.Fatalf("expected reset_at to be a time, got %v", body["reset_at"])
+		}
+		if expected := oldest.Add(time.Second); !resetAt.Equal(expected) {
+			t.Errorf("expected reset_at %v (oldest request plus the window), got %v", expected, resetAt)
+		}
+
+		penaltyUntil, err := time.Parse(time.RFC3339Nano, fmt.Sprint(body["penalty_until"]))
+		if err != nil {
+			t.Fatalf("expected penalty_until to be a time, got %v", body["penalty_until"])
+		}
+		if left := time.Until(penaltyUntil); left <= 25*time.Second || left > 30*time.Second {
+			t.Errorf("expected the penalty to end in about 30s, got %v", left)
+		}
+
+		campaign, ok := body["campaign"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("expected a campaign object, got %v", body["campaign"])
+		}
+		if campaign["name"] != "launch" || campaign["used"] != float64(12) || campaign["total"] != float64(1000) {
+			t.Errorf("unexpected campaign %v", campaign)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestHandler_RateLimitBatch checks that batches are checked in one request
+// and that batches over rate_limit.max_batch_size are rejected untouched
+func TestHandler_RateLimitBatch(t *testing.T) {
+	newBatchServer := func(db *redis.Client) *echo.Echo {
+		cfg := newTestConfig()
+		cfg.MaxBatchSize = 2
+		return newTestServer(db, cfg)
+	}
+	post := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/rate-limit/batch", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		return req
+	}
+
+	t.Run("within the maximum", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		e := newBatchServer(db)
+
+		match := mock.CustomMatch(func(expected, actual []interface{}) error {
+			return nil
+		})
+		match.ExpectEval("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(4)})
+		match.ExpectEval("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(0), int64(5)})
+
+		rec, body := serve(t, e, post(`{"checks": [{"key": "user123", "limit": 5, "window": 60}, {"key": "ip:10.0.0.1", "limit": 5, "window": 60}]}`))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %v", rec.Code, body)
+		}
+		if body["allowed"] != false {
+			t.Errorf("expected the batch denied as one check was, got %v", body)
+		}
+		results, _ := body["results"].([]interface{})
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %v", body["results"])
+		}
+		first, _ := results[0].(map[string]interface{})
+		if first["key"] != "user123" || first["allowed"] != true {
+			t.Errorf("expected user123 allowed, got %v", first)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("over the maximum", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		e := newBatchServer(db)
+
+		rec, body := serve(t, e, post(`{"checks": [{"key": "a"}, {"key": "b"}, {"key": "c"}]}`))
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d: %v", rec.Code, body)
+		}
+		// Rejected before anything is sent to Redis
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		db, _ := redismock.NewClientMock()
+		e := newBatchServer(db)
+
+		for _, body := range []string{`{}`, `{"checks": [{"limit": 5}]}`, `{"checks": [{"key": "a", "window": -1}]}`} {
+			if rec, resp := serve(t, e, post(body)); rec.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %s, got %d: %v", body, rec.Code, resp)
+			}
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"ratelimit-challenge/internal/server/handlers"
+	"testing"
+
+	"github.com/go-redis/redismock/v8"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestHealth_Ready(t *testing.T) {
+	tests := []struct {
+		name           string
+		pingErr        error
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "redis answers", expectedStatus: http.StatusOK, expectedBody: "ok"},
+		{name: "redis down", pingErr: errors.New("connection refused"), expectedStatus: http.StatusServiceUnavailable, expectedBody: "unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, mock := redismock.NewClientMock()
+			if tt.pingErr != nil {
+				mock.ExpectPing().SetErr(tt.pingErr)
+			} else {
+				mock.ExpectPing().SetVal("PONG")
+			}
+
+			e := echo.New()
+			e.GET("/health/ready", handlers.NewHealth(client, zap.NewNop()).Ready)
+
+			rec, body := serve(t, e, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if body["status"] != tt.expectedBody {
+				t.Errorf("expected status %q, got %v", tt.expectedBody, body["status"])
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestHealth_Live(t *testing.T) {
+	// Liveness never touches Redis, so a mock with no expectations suffices
+	client, mock := redismock.NewClientMock()
+
+	e := echo.New()
+	e.GET("/health", handlers.NewHealth(client, zap.NewNop()).Live)
+
+	rec, body := serve(t, e, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if rec.Code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("expected 200 ok, got %d %v", rec.Code, body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unexpected Redis calls: %v", err)
+	}
+}
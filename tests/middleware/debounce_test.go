@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/server/middleware"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestDebounce(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+		DebounceInterval: 200 * time.Millisecond,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+	userID := "test_user_debounce"
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+
+	e := echo.New()
+	e.Use(middleware.DebounceWithConfig(middleware.DebounceConfig{
+		Service: service,
+		Logger:  zap.NewNop(),
+	}))
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:      service,
+		Logger:       zap.NewNop(),
+		DefaultLimit: 10,
+	}))
+	// Echoes the body, which must survive being hashed
+	e.POST("/submit", func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, string(body))
+	})
+
+	submit := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(body))
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		// Entries are keyed by millisecond, keep requests apart
+		time.Sleep(5 * time.Millisecond)
+		return rec
+	}
+	expectRemaining := func(expected int) {
+		t.Helper()
+		remaining, err := service.GetRemaining(ctx, userID, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != expected {
+			t.Errorf("expected %d remaining, got %d", expected, remaining)
+		}
+	}
+
+	if rec := submit(`{"order":1}`); rec.Code != http.StatusOK || rec.Body.String() != `{"order":1}` {
+		t.Fatalf("expected the first request to be served with its body, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := submit(`{"order":1}`); rec.Code != http.StatusConflict {
+		t.Errorf("expected the duplicate to be rejected with 409, got %d", rec.Code)
+	}
+	// The two identical requests consumed a single unit
+	expectRemaining(9)
+
+	// A different body is a different request
+	if rec := submit(`{"order":2}`); rec.Code != http.StatusOK {
+		t.Errorf("expected a different request to be served, got %d", rec.Code)
+	}
+	expectRemaining(8)
+
+	// Once the interval has passed, the same request counts again
+	time.Sleep(250 * time.Millisecond)
+	if rec := submit(`{"order":1}`); rec.Code != http.StatusOK {
+		t.Errorf("expected the request to be served after the interval, got %d", rec.Code)
+	}
+	expectRemaining(7)
+}
+
+// TestDebounce_MaxBodySize tests that requests with bodies over the cap are
+// never rejected as duplicates, and still reach the handler in full
+func TestDebounce_MaxBodySize(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+		DebounceInterval: time.Second,
+	}
+	service := newTestService(t, cfg)
+
+	e := echo.New()
+	e.Use(middleware.DebounceWithConfig(middleware.DebounceConfig{
+		Service:     service,
+		Logger:      zap.NewNop(),
+		MaxBodySize: 16,
+	}))
+	e.POST("/submit", func(c echo.Context) error {
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return err
+		}
+		return c.String(http.StatusOK, string(body))
+	})
+
+	submit := func(body string, knownLength bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/submit", strings.NewReader(body))
+		req.Header.Set("X-User-ID", "test_user_debounce_max_body")
+		if !knownLength {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Over the cap by Content-Length, or once read without one
+	large := strings.Repeat("x", 100)
+	for _, knownLength := range []bool{true, false} {
+		for i := 0; i < 2; i++ {
+			if rec := submit(large, knownLength); rec.Code != http.StatusOK || rec.Body.String() != large {
+				t.Errorf("expected a large body to be served in full, got %d with %d bytes", rec.Code, rec.Body.Len())
+			}
+		}
+	}
+
+	// Bodies within the cap are still debounced
+	small := `{"order":"` + strconv.FormatInt(time.Now().UnixNano()%1000, 10) + `"}`
+	if rec := submit(small, false); rec.Code != http.StatusOK || rec.Body.String() != small {
+		t.Fatalf("expected the first request to be served with its body, got %d %q", rec.Code, rec.Body.String())
+	}
+	if rec := submit(small, false); rec.Code != http.StatusConflict {
+		t.Errorf("expected the duplicate to be rejected with 409, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/server/middleware"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+// TestEgressLimiter tests that the egress budget depletes by response size
+func TestEgressLimiter(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     100,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+		EgressLimit:      1000,
+		EgressWindow:     60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	newEcho := func(handler echo.HandlerFunc) *echo.Echo {
+		e := echo.New()
+		e.Use(middleware.EgressLimiterWithConfig(middleware.EgressLimiterConfig{
+			Service: service,
+			Logger:  zap.NewNop(),
+		}))
+		e.GET("/test", handler)
+		return e
+	}
+
+	get := func(e *echo.Echo, userID string) int {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("budget depletes by response size", func(t *testing.T) {
+		userID := "test_user_egress"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		e := newEcho(func(c echo.Context) error {
+			return c.String(http.StatusOK, strings.Repeat("x", 400))
+		})
+
+		// 400, 800 and 1200 bytes sent after each request
+		for i := 0; i < 3; i++ {
+			if code := get(e, userID); code != http.StatusOK {
+				t.Fatalf("expected request %d to be allowed, got %d", i+1, code)
+			}
+		}
+		sent, err := service.GetEgress(ctx, userID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sent != 1200 {
+			t.Errorf("expected 1200 bytes counted, got %d", sent)
+		}
+		if code := get(e, userID); code != http.StatusTooManyRequests {
+			t.Errorf("expected request once the budget is spent to be denied, got %d", code)
+		}
+	})
+
+	t.Run("streaming responses count as they go", func(t *testing.T) {
+		userID := "test_user_egress_stream"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		chunk := strings.Repeat("x", 100*1024)
+		var sentMidStream int64
+		e := newEcho(func(c echo.Context) error {
+			c.Response().WriteHeader(http.StatusOK)
+			for i := 0; i < 3; i++ {
+				if _, err := c.Response().Write([]byte(chunk)); err != nil {
+					return err
+				}
+				c.Response().Flush()
+			}
+			sentMidStream, _ = service.GetEgress(ctx, userID)
+			return nil
+		})
+
+		if code := get(e, userID); code != http.StatusOK {
+			t.Fatalf("expected the stream to be allowed, got %d", code)
+		}
+		if sentMidStream != int64(3*len(chunk)) {
+			t.Errorf("expected %d bytes counted before the stream ended, got %d", 3*len(chunk), sentMidStream)
+		}
+		if code := get(e, userID); code != http.StatusTooManyRequests {
+			t.Errorf("expected request after the stream to be denied, got %d", code)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/server/middleware"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	"testing"
+
+	"github.com/go-redis/redismock/v8"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+)
+
+func TestMaintenance(t *testing.T) {
+	// newServer serves /test and the exempt /exempt behind the middleware
+	newServer := func(service *ratelimiter.Service) *echo.Echo {
+		e := echo.New()
+		e.Use(middleware.MaintenanceWithConfig(middleware.MaintenanceConfig{
+			Skipper: func(c echo.Context) bool { return c.Path() == "/exempt" },
+			Service: service,
+			Logger:  zap.NewNop(),
+		}))
+		for _, path := range []string{"/test", "/exempt"} {
+			e.GET(path, func(c echo.Context) error {
+				return c.String(http.StatusOK, "ok")
+			})
+		}
+		return e
+	}
+	cfg := &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   1,
+		Algorithm:    "sliding_window",
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		expect     func(mock redismock.ClientMock)
+		expected   int
+		retryAfter string
+	}{
+		{
+			name: "enabled",
+			path: "/test",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:maintenance").SetVal("30")
+			},
+			expected:   http.StatusServiceUnavailable,
+			retryAfter: "30",
+		},
+		{
+			name: "disabled",
+			path: "/test",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:maintenance").RedisNil()
+			},
+			expected: http.StatusOK,
+		},
+		{
+			name:     "exempt route",
+			path:     "/exempt",
+			expect:   func(mock redismock.ClientMock) {},
+			expected: http.StatusOK,
+		},
+		{
+			name: "redis error fails open",
+			path: "/test",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:maintenance").SetErr(errors.New("connection refused"))
+			},
+			expected: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			e := newServer(ratelimiter.NewService(db, cfg, zap.NewNop()))
+			tt.expect(mock)
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.expected {
+				t.Errorf("expected status %d, got %d", tt.expected, rec.Code)
+			}
+			if rec.Header().Get("Retry-After") != tt.retryAfter {
+				t.Errorf("expected Retry-After %q, got %q", tt.retryAfter, rec.Header().Get("Retry-After"))
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	t.Run("toggled", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, cfg, zap.NewNop())
+		e := newServer(service)
+		ctx := context.Background()
+
+		// The instance toggling maintenance sees it at once, without a lookup
+		mock.ExpectSet("rate_limit:maintenance", 60, 0).SetVal("OK")
+		if err := service.StartMaintenance(ctx, 60); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503 during maintenance, got %d", rec.Code)
+		}
+
+		mock.ExpectDel("rate_limit:maintenance").SetVal(1)
+		if err := service.EndMaintenance(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rec = httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected status 200 after maintenance, got %d", rec.Code)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
@@ -0,0 +1,2076 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/server/middleware"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	echoMiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newTestService creates a rate limiter service backed by a real Redis instance
+// Tests using it are skipped when Redis is not available
+func newTestService(t *testing.T, cfg *config.RateLimitConfig) *ratelimiter.Service {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	t.Cleanup(func() { client.Close() })
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	return ratelimiter.NewService(client, cfg, zap.NewNop())
+}
+
+func TestRateLimiterMiddleware_DisconnectPolicy(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     5,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+	limit := 5
+
+	// serveCancelled sends a request whose client disconnects while the handler runs
+	serveCancelled := func(policy, userID string) {
+		e := echo.New()
+		e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+			Service:          service,
+			Logger:           zap.NewNop(),
+			DefaultLimit:     limit,
+			DisconnectPolicy: policy,
+		}))
+
+		reqCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		e.GET("/test", func(c echo.Context) error {
+			cancel()
+			return c.NoContent(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/test", nil).WithContext(reqCtx)
+		req.Header.Set("X-User-ID", userID)
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	t.Run("refund returns the slot", func(t *testing.T) {
+		userID := "test_user_disconnect_refund"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		serveCancelled("refund", userID)
+
+		remaining, err := service.GetRemaining(ctx, userID, limit)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != limit {
+			t.Errorf("expected remaining %d after refund, got %d", limit, remaining)
+		}
+	})
+
+	t.Run("count keeps the slot consumed", func(t *testing.T) {
+		userID := "test_user_disconnect_count"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		serveCancelled("count", userID)
+
+		remaining, err := service.GetRemaining(ctx, userID, limit)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != limit-1 {
+			t.Errorf("expected remaining %d, got %d", limit-1, remaining)
+		}
+	})
+}
+
+func TestRateLimiterMiddleware_ErrorCodes(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:      1,
+		WindowSize:        10,
+		Algorithm:         "sliding_window",
+		EnableLocalCache:  false,
+		LocalCacheTTL:     60,
+		PenaltyMultiplier: 100,
+		PenaltyDuration:   10,
+	}
+	service := newTestService(t, cfg)
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer client.Close()
+	ctx := context.Background()
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddleware(service, zap.NewNop(), 1))
+	e.GET("/test", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	// exhaust sends requests until one is denied and returns the denial
+	exhaust := func(setIdentity func(*http.Request)) *httptest.ResponseRecorder {
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			setIdentity(req)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code == http.StatusTooManyRequests {
+				return rec
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatal("expected a request to be denied")
+		return nil
+	}
+
+	assertCode := func(t *testing.T, rec *httptest.ResponseRecorder, expected ratelimiter.ErrorCode) {
+		t.Helper()
+		if got := rec.Header().Get("X-RateLimit-Code"); got != string(expected) {
+			t.Errorf("expected X-RateLimit-Code %q, got %q", expected, got)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("failed to decode body: %v", err)
+		}
+		if body["code"] != string(expected) {
+			t.Errorf("expected code %q in body, got %v", expected, body["code"])
+		}
+	}
+
+	t.Run("user over limit", func(t *testing.T) {
+		userID := "test_user_code_rate_limited"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		rec := exhaust(func(r *http.Request) { r.Header.Set("X-User-ID", userID) })
+		assertCode(t, rec, ratelimiter.CodeRateLimited)
+	})
+
+	t.Run("ip over limit", func(t *testing.T) {
+		ip := "203.0.113.7"
+		_ = service.Reset(ctx, ip)
+		defer service.Reset(ctx, ip)
+
+		rec := exhaust(func(r *http.Request) { r.Header.Set("X-Real-IP", ip) })
+		assertCode(t, rec, ratelimiter.CodeIPLimited)
+	})
+
+	t.Run("user in penalty box", func(t *testing.T) {
+		userID := "test_user_code_blocked"
+		client.Set(ctx, "rate_limit:penalty:"+userID, 1, 10*time.Second)
+		defer client.Del(ctx, "rate_limit:penalty:"+userID)
+
+		rec := exhaust(func(r *http.Request) { r.Header.Set("X-User-ID", userID) })
+		assertCode(t, rec, ratelimiter.CodeBlocked)
+	})
+}
+
+func TestRateLimiterMiddleware_AlgorithmOverride(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer client.Close()
+	ctx := context.Background()
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:           service,
+		Logger:            zap.NewNop(),
+		DefaultLimit:      10,
+		TrustedIdentities: []string{"test_user_trusted"},
+	}))
+	e.GET("/test", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	tests := []struct {
+		name        string
+		userID      string
+		algorithm   string
+		expectedKey string
+	}{
+		{name: "trusted caller is routed", userID: "test_user_trusted", algorithm: "leaky_bucket", expectedKey: "rate_limit:leaky:"},
+		{name: "untrusted caller is ignored", userID: "test_user_untrusted", algorithm: "leaky_bucket", expectedKey: "rate_limit:sliding:"},
+		{name: "unknown algorithm is ignored", userID: "test_user_trusted", algorithm: "token_bucket", expectedKey: "rate_limit:sliding:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := []string{"rate_limit:sliding:" + tt.userID, "rate_limit:leaky:" + tt.userID}
+			client.Del(ctx, keys...)
+			defer client.Del(ctx, keys...)
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("X-User-ID", tt.userID)
+			req.Header.Set("X-RateLimit-Algorithm", tt.algorithm)
+			e.ServeHTTP(httptest.NewRecorder(), req)
+
+			for _, key := range keys {
+				expected := int64(0)
+				if key == tt.expectedKey+tt.userID {
+					expected = 1
+				}
+				if n := client.Exists(ctx, key).Val(); n != expected {
+					t.Errorf("expected %s to exist %d time(s), got %d", key, expected, n)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddleware_EndpointAlgorithms(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     2,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	defer client.Close()
+	ctx := context.Background()
+
+	userID := "test_user_endpoint_algorithms"
+	keys := []string{"rate_limit:sliding:" + userID, "rate_limit:leaky:" + userID}
+	client.Del(ctx, keys...)
+	defer client.Del(ctx, keys...)
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:      service,
+		Logger:       zap.NewNop(),
+		DefaultLimit: 2,
+		// Config keys arrive lower-cased, so the route matches regardless
+		EndpointAlgorithms: map[string]string{"/upload/:id": "leaky_bucket"},
+	}))
+	e.POST("/upload/:ID", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/data", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	request := func(method, path string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		// Requests in the same millisecond share a sliding window entry
+		time.Sleep(5 * time.Millisecond)
+		return rec.Code
+	}
+
+	// Uploads fill the user's leaky bucket...
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := request(http.MethodPost, "/upload/1"); code != expected {
+			t.Errorf("upload %d: expected status %d, got %d", i+1, expected, code)
+		}
+	}
+	// ...leaving their sliding window for other routes untouched
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := request(http.MethodGet, "/data"); code != expected {
+			t.Errorf("data request %d: expected status %d, got %d", i+1, expected, code)
+		}
+	}
+
+	if n := client.ZCard(ctx, "rate_limit:sliding:"+userID).Val(); n != 2 {
+		t.Errorf("expected 2 requests in the sliding window, got %d", n)
+	}
+	if n := client.Exists(ctx, "rate_limit:leaky:"+userID).Val(); n != 1 {
+		t.Errorf("expected the leaky bucket to exist, got %d", n)
+	}
+}
+
+func TestRateLimiterMiddleware_IdentityPolicy(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     100,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+	secret := []byte("test-secret")
+
+	token := func(subject string) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: subject}).SignedString(secret)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	tests := []struct {
+		name             string
+		policy           string
+		header           string
+		subject          string
+		expectedStatus   int
+		expectedIdentity string
+	}{
+		{name: "prefer_jwt match", policy: "prefer_jwt", header: "test_user_id_a", subject: "test_user_id_a", expectedStatus: http.StatusOK, expectedIdentity: "test_user_id_a"},
+		{name: "prefer_jwt mismatch", policy: "prefer_jwt", header: "test_user_id_a", subject: "test_user_id_b", expectedStatus: http.StatusOK, expectedIdentity: "test_user_id_b"},
+		{name: "prefer_jwt header only", policy: "prefer_jwt", header: "test_user_id_a", expectedStatus: http.StatusOK, expectedIdentity: "test_user_id_a"},
+		{name: "prefer_header mismatch", policy: "prefer_header", header: "test_user_id_a", subject: "test_user_id_b", expectedStatus: http.StatusOK, expectedIdentity: "test_user_id_a"},
+		{name: "prefer_header jwt only", policy: "prefer_header", subject: "test_user_id_b", expectedStatus: http.StatusOK, expectedIdentity: "test_user_id_b"},
+		{name: "require_match match", policy: "require_match", header: "test_user_id_a", subject: "test_user_id_a", expectedStatus: http.StatusOK, expectedIdentity: "test_user_id_a"},
+		{name: "require_match mismatch", policy: "require_match", header: "test_user_id_a", subject: "test_user_id_b", expectedStatus: http.StatusUnauthorized},
+		{name: "require_match header only", policy: "require_match", header: "test_user_id_a", expectedStatus: http.StatusOK, expectedIdentity: "test_user_id_a"},
+		{name: "require_match jwt only", policy: "require_match", subject: "test_user_id_b", expectedStatus: http.StatusOK, expectedIdentity: "test_user_id_b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+				Service:        service,
+				Logger:         zap.NewNop(),
+				DefaultLimit:   100,
+				IdentityPolicy: tt.policy,
+				JWTSecret:      secret,
+			}))
+			e.GET("/test", func(c echo.Context) error {
+				return c.String(http.StatusOK, c.Get(middleware.IdentityContextKey).(string))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			if tt.header != "" {
+				req.Header.Set("X-User-ID", tt.header)
+			}
+			if tt.subject != "" {
+				req.Header.Set("Authorization", "Bearer "+token(tt.subject))
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedStatus == http.StatusOK && rec.Body.String() != tt.expectedIdentity {
+				t.Errorf("expected identity %q, got %q", tt.expectedIdentity, rec.Body.String())
+			}
+		})
+	}
+
+	_ = service.Reset(ctx, "test_user_id_a")
+	_ = service.Reset(ctx, "test_user_id_b")
+}
+
+// TestRateLimiterMiddleware_LimitOverride tests that only trusted upstreams
+// may set the request's limit
+func TestRateLimiterMiddleware_LimitOverride(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     100,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	// httptest requests come from 192.0.2.1
+	tests := []struct {
+		name          string
+		upstreams     []string
+		override      string
+		mTLS          bool
+		expectedLimit string
+		expectedDeny  bool
+	}{
+		{name: "trusted CIDR", upstreams: []string{"192.0.2.0/24"}, override: "2", expectedLimit: "2", expectedDeny: true},
+		{name: "trusted IP", upstreams: []string{"192.0.2.1"}, override: "2", expectedLimit: "2", expectedDeny: true},
+		{name: "trusted client certificate", override: "2", mTLS: true, expectedLimit: "2", expectedDeny: true},
+		{name: "untrusted source", upstreams: []string{"10.0.0.0/8"}, override: "2", expectedLimit: "100"},
+		{name: "above the maximum", upstreams: []string{"192.0.2.0/24"}, override: "5000", expectedLimit: "100"},
+		{name: "not a number", upstreams: []string{"192.0.2.0/24"}, override: "lots", expectedLimit: "100"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := "test_user_override_" + strconv.Itoa(i)
+			_ = service.Reset(ctx, userID)
+			defer service.Reset(ctx, userID)
+
+			e := echo.New()
+			e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+				Service:          service,
+				Logger:           zap.NewNop(),
+				DefaultLimit:     100,
+				TrustedUpstreams: tt.upstreams,
+				MaxLimitOverride: 1000,
+			}))
+			e.GET("/test", func(c echo.Context) error {
+				return c.String(http.StatusOK, "ok")
+			})
+
+			var codes []int
+			for j := 0; j < 3; j++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set("X-User-ID", userID)
+				req.Header.Set(middleware.LimitOverrideHeader, tt.override)
+				if tt.mTLS {
+					req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+				}
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				codes = append(codes, rec.Code)
+
+				if j == 0 && rec.Header().Get("X-RateLimit-Limit") != tt.expectedLimit {
+					t.Errorf("expected limit %s, got %q", tt.expectedLimit, rec.Header().Get("X-RateLimit-Limit"))
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			denied := codes[2] == http.StatusTooManyRequests
+			if codes[0] != http.StatusOK || codes[1] != http.StatusOK || denied != tt.expectedDeny {
+				t.Errorf("expected third request denied=%v, got statuses %v", tt.expectedDeny, codes)
+			}
+		})
+	}
+}
+
+// deniedEvalHook rejects EVAL and EVALSHA commands as an ACL lacking the
+// permission would
+type deniedEvalHook struct{}
+
+func (deniedEvalHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "eval" || cmd.Name() == "evalsha" {
+		return ctx, errors.New("NOPERM this user has no permissions to run the 'eval' command")
+	}
+	return ctx, nil
+}
+
+func (deniedEvalHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (deniedEvalHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (deniedEvalHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// TestRateLimiterMiddleware_FatalErrorPolicy tests that a misconfigured
+// limiter fails closed unless configured to fail open
+func TestRateLimiterMiddleware_FatalErrorPolicy(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+	client.AddHook(deniedEvalHook{})
+
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit: 100,
+		WindowSize:   10,
+		Algorithm:    "sliding_window",
+	}, zap.NewNop())
+
+	tests := []struct {
+		policy         string
+		expectedStatus int
+	}{
+		{policy: "", expectedStatus: http.StatusServiceUnavailable},
+		{policy: "fail_closed", expectedStatus: http.StatusServiceUnavailable},
+		{policy: "fail_open", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			e := echo.New()
+			e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+				Service:          service,
+				Logger:           zap.NewNop(),
+				DefaultLimit:     100,
+				FatalErrorPolicy: tt.policy,
+			}))
+			e.GET("/test", func(c echo.Context) error {
+				return c.String(http.StatusOK, "ok")
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("X-User-ID", "test_user_noperm")
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedStatus == http.StatusServiceUnavailable && rec.Header().Get("X-RateLimit-Code") != string(ratelimiter.CodeMisconfigured) {
+				t.Errorf("expected code %s, got %q", ratelimiter.CodeMisconfigured, rec.Header().Get("X-RateLimit-Code"))
+			}
+		})
+	}
+}
+
+// TestRateLimiterMiddleware_FailClosed tests that a failed check rejects the
+// request with 503 when failing closed and lets it through otherwise, logging
+// either way
+func TestRateLimiterMiddleware_FailClosed(t *testing.T) {
+	tests := []struct {
+		name           string
+		failClosed     bool
+		expectedStatus int
+		expectedLog    string
+	}{
+		{name: "fail open", expectedStatus: http.StatusOK, expectedLog: "rate limit check failed, allowing request"},
+		{name: "fail closed", failClosed: true, expectedStatus: http.StatusServiceUnavailable, expectedLog: "rate limit check failed, rejecting request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Redis is unreachable: the check fails to decide
+			db, mock := redismock.NewClientMock()
+			mock.ExpectHGet("rate_limit:campaign", "ends_at").RedisNil()
+			mock.ExpectGet("rate_limit:config:test_user_fail_closed").RedisNil()
+			mock.ExpectGet("rate_limit:default").RedisNil()
+			mock.CustomMatch(func(expected, actual []interface{}) error {
+				return nil
+			}).ExpectEvalSha("", []string{""}, make([]interface{}, 6)...).SetErr(errors.New("connection refused"))
+			service := ratelimiter.NewService(db, &config.RateLimitConfig{
+				DefaultLimit:  10,
+				WindowSize:    10,
+				Algorithm:     "sliding_window",
+				LocalCacheTTL: 60,
+			}, zap.NewNop())
+
+			core, logs := observer.New(zapcore.WarnLevel)
+			e := echo.New()
+			e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+				Service:      service,
+				Logger:       zap.New(core),
+				DefaultLimit: 10,
+				FailClosed:   tt.failClosed,
+			}))
+			e.GET("/test", func(c echo.Context) error {
+				return c.String(http.StatusOK, "ok")
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("X-User-ID", "test_user_fail_closed")
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+			if tt.failClosed {
+				var body map[string]interface{}
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatalf("expected a JSON body: %v", err)
+				}
+				if body["code"] != string(ratelimiter.CodeDegraded) {
+					t.Errorf("expected code %s, got %v", ratelimiter.CodeDegraded, body["code"])
+				}
+			}
+			if logs.FilterMessage(tt.expectedLog).Len() != 1 {
+				t.Errorf("expected %q to be logged at warn level, got %v", tt.expectedLog, logs.All())
+			}
+		})
+	}
+}
+
+// TestRateLimiterMiddleware_CoarseRemaining tests that the remaining count
+// reported to clients is coarsened while enforcement stays exact
+func TestRateLimiterMiddleware_CoarseRemaining(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     25,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	userID := "test_user_coarse_remaining"
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:              service,
+		Logger:               zap.NewNop(),
+		DefaultLimit:         25,
+		RemainingGranularity: 10,
+		RemainingFloor:       5,
+	}))
+	e.GET("/test", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	// Reported remaining after each request; "" means the header is hidden
+	expected := map[int]string{1: "20", 4: "20", 5: "20", 6: "10", 15: "10", 16: "0", 20: "0", 21: "", 25: ""}
+
+	for i := 1; i <= 26; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if i <= 25 && rec.Code != http.StatusOK {
+			t.Fatalf("expected request %d to be allowed, got %d", i, rec.Code)
+		}
+		if i == 26 {
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("expected request %d to be denied, got %d", i, rec.Code)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+			if _, ok := body["remaining"]; ok {
+				t.Errorf("expected remaining to be hidden below the floor, got %v", body["remaining"])
+			}
+		}
+		if want, ok := expected[i]; ok && rec.Header().Get("X-RateLimit-Remaining") != want {
+			t.Errorf("request %d: expected reported remaining %q, got %q", i, want, rec.Header().Get("X-RateLimit-Remaining"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// TestRateLimiterMiddleware_RemainingPercent tests that the remaining
+// percentage is of the user's own limit rather than the middleware's default
+func TestRateLimiterMiddleware_RemainingPercent(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	userID := "test_user_remaining_percent"
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+	if err := service.SetUserLimit(ctx, userID, 40); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer service.DeleteUserLimit(ctx, userID)
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:      service,
+		Logger:       zap.NewNop(),
+		DefaultLimit: 10,
+	}))
+	e.GET("/test", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("X-User-ID", userID)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	// 39 of 40 left; against the default of 10 it would read 100
+	if rec.Header().Get("X-RateLimit-Remaining") != "39" {
+		t.Errorf("expected 39 remaining, got %q", rec.Header().Get("X-RateLimit-Remaining"))
+	}
+	if percent := rec.Header().Get("X-RateLimit-Remaining-Percent"); percent != "97" {
+		t.Errorf("expected 97 percent remaining, got %q", percent)
+	}
+	// The limit reported is the user's own, in either header style
+	for _, header := range []string{"X-RateLimit-Limit", "RateLimit-Limit"} {
+		if got := rec.Header().Get(header); got != "40" {
+			t.Errorf("expected %s of 40, got %q", header, got)
+		}
+	}
+
+	// Denials report the user's own limit too
+	if err := service.SetUserLimit(ctx, userID, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("RateLimit-Limit"); got != "1" {
+		t.Errorf("expected RateLimit-Limit of 1 on denial, got %q", got)
+	}
+}
+
+// TestRateLimiterMiddleware_APIVersion tests that each API version keeps its
+// own counter for the same user, and that per-version limits apply
+func TestRateLimiterMiddleware_APIVersion(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     2,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+		APIVersionLimits: map[string]int{"v3": 1},
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	userID := "test_user_api_version"
+	for _, version := range []string{"v1", "v2", "v3"} {
+		versionCtx := ratelimiter.WithAPIVersion(ctx, version)
+		_ = service.Reset(versionCtx, userID)
+		defer service.Reset(versionCtx, userID)
+	}
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:           service,
+		Logger:            zap.NewNop(),
+		DefaultLimit:      2,
+		ScopeByAPIVersion: true,
+	}))
+	for _, path := range []string{"/api/v1/test", "/api/v2/test", "/api/v3/test"} {
+		e.GET(path, func(c echo.Context) error {
+			return c.String(http.StatusOK, "ok")
+		})
+	}
+
+	request := func(path string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		time.Sleep(5 * time.Millisecond)
+		return rec.Code
+	}
+
+	// Exhaust v1
+	for i := 0; i < 2; i++ {
+		if code := request("/api/v1/test"); code != http.StatusOK {
+			t.Fatalf("expected v1 request %d to be allowed, got %d", i+1, code)
+		}
+	}
+	if code := request("/api/v1/test"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected v1 to be limited, got %d", code)
+	}
+
+	// v2 is counted separately, so it still has its full limit
+	for i := 0; i < 2; i++ {
+		if code := request("/api/v2/test"); code != http.StatusOK {
+			t.Fatalf("expected v2 request %d to be allowed, got %d", i+1, code)
+		}
+	}
+	if code := request("/api/v2/test"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected v2 to be limited, got %d", code)
+	}
+
+	remaining, err := service.GetRemaining(ratelimiter.WithAPIVersion(ctx, "v1"), userID, 2)
+	if err != nil {
+		t.Fatalf("failed to get v1 remaining: %v", err)
+	}
+	if remaining != 0 {
+		t.Errorf("expected no v1 requests remaining, got %d", remaining)
+	}
+
+	// v3 has its own limit of 1
+	if code := request("/api/v3/test"); code != http.StatusOK {
+		t.Fatalf("expected first v3 request to be allowed, got %d", code)
+	}
+	if code := request("/api/v3/test"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected v3 to be limited after its own limit, got %d", code)
+	}
+}
+
+// TestRateLimiterMiddleware_IdentityNormalization tests that user IDs differing
+// only in case or whitespace share a bucket when normalized
+func TestRateLimiterMiddleware_IdentityNormalization(t *testing.T) {
+	tests := []struct {
+		name          string
+		normalization []string
+		ids           []string
+		expected      []int
+	}{
+		{
+			name:          "trim and lowercase",
+			normalization: []string{"trim", "lowercase"},
+			ids:           []string{"Test_User_Norm", " test_user_norm", "TEST_USER_NORM "},
+			expected:      []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:          "trim only",
+			normalization: []string{"trim"},
+			ids:           []string{"test_user_norm ", " test_user_norm", "Test_User_Norm"},
+			expected:      []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:          "canonical",
+			normalization: []string{"canonical"},
+			ids:           []string{"test_user_norm", "ｔｅｓｔ_user_norm", "test_user_norm"},
+			expected:      []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := newTestService(t, &config.RateLimitConfig{
+				DefaultLimit:          2,
+				WindowSize:            10,
+				Algorithm:             "sliding_window",
+				EnableLocalCache:      false,
+				LocalCacheTTL:         60,
+				IdentityNormalization: tt.normalization,
+			})
+			ctx := context.Background()
+			for _, id := range []string{"test_user_norm", "Test_User_Norm"} {
+				_ = service.Reset(ctx, id)
+				defer service.Reset(ctx, id)
+			}
+
+			e := echo.New()
+			e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+				Service:      service,
+				Logger:       zap.NewNop(),
+				DefaultLimit: 2,
+			}))
+			e.GET("/test", func(c echo.Context) error {
+				return c.String(http.StatusOK, "ok")
+			})
+
+			for i, id := range tt.ids {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set("X-User-ID", id)
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+
+				if rec.Code != tt.expected[i] {
+					t.Errorf("request %d as %q: expected %d, got %d", i+1, id, tt.expected[i], rec.Code)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// TestRateLimiterMiddleware_OversizedIdentity tests that identities over the
+// maximum length are rejected, or limited by their hash, per the policy
+func TestRateLimiterMiddleware_OversizedIdentity(t *testing.T) {
+	oversized := strings.Repeat("a", 65)
+	sum := sha256.Sum256([]byte(oversized))
+	hashedKey := "rate_limit:sliding:sha256%3A" + hex.EncodeToString(sum[:])
+
+	t.Run("reject", func(t *testing.T) {
+		// Any Redis command would be unexpected
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, &config.RateLimitConfig{
+			DefaultLimit:  2,
+			WindowSize:    10,
+			Algorithm:     "sliding_window",
+			LocalCacheTTL: 60,
+		}, zap.NewNop())
+
+		e := echo.New()
+		e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+			Service:           service,
+			Logger:            zap.NewNop(),
+			DefaultLimit:      2,
+			MaxIdentityLength: 64,
+		}))
+		e.GET("/test", func(c echo.Context) error {
+			return c.String(http.StatusOK, "ok")
+		})
+
+		for _, tt := range []struct {
+			id       string
+			expected int
+		}{
+			{id: oversized, expected: http.StatusBadRequest},
+			{id: oversized[:64], expected: http.StatusOK},
+		} {
+			if tt.expected == http.StatusOK {
+				mock.ExpectHGet("rate_limit:campaign", "ends_at").RedisNil()
+				mock.ExpectGet("rate_limit:config:" + tt.id).RedisNil()
+				mock.ExpectGet("rate_limit:default").RedisNil()
+				mock.CustomMatch(func(expected, actual []interface{}) error {
+					return nil
+				}).ExpectEvalSha("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(2)})
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("X-User-ID", tt.id)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expected {
+				t.Errorf("identity of %d bytes: expected %d, got %d", len(tt.id), tt.expected, rec.Code)
+			}
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		service := newTestService(t, &config.RateLimitConfig{
+			DefaultLimit:     2,
+			WindowSize:       10,
+			Algorithm:        "sliding_window",
+			EnableLocalCache: false,
+			LocalCacheTTL:    60,
+		})
+		client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+		defer client.Close()
+		ctx := context.Background()
+		client.Del(ctx, hashedKey)
+		defer client.Del(ctx, hashedKey)
+
+		e := echo.New()
+		e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+			Service:                 service,
+			Logger:                  zap.NewNop(),
+			DefaultLimit:            2,
+			MaxIdentityLength:       64,
+			OversizedIdentityPolicy: middleware.OversizedIdentityHash,
+		}))
+		e.GET("/test", func(c echo.Context) error {
+			return c.String(http.StatusOK, "ok")
+		})
+
+		// The user is still limited, under a fixed-length key
+		for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("X-User-ID", oversized)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != expected {
+				t.Errorf("request %d: expected %d, got %d", i+1, expected, rec.Code)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		if n := client.ZCard(ctx, hashedKey).Val(); n != 2 {
+			t.Errorf("expected 2 requests counted under %s, got %d", hashedKey, n)
+		}
+	})
+}
+
+// TestRateLimiterMiddleware_HeadersOnError tests that quota headers are sent
+// even when the handler fails
+func TestRateLimiterMiddleware_HeadersOnError(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	userID := "test_user_headers_on_error"
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:      service,
+		Logger:       zap.NewNop(),
+		DefaultLimit: 10,
+	}))
+	e.Use(echoMiddleware.Recover())
+	e.GET("/written", func(c echo.Context) error {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "boom"})
+	})
+	e.GET("/http-error", func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusInternalServerError, "boom")
+	})
+	e.GET("/error", func(c echo.Context) error {
+		return errors.New("boom")
+	})
+	e.GET("/panic", func(c echo.Context) error {
+		panic("boom")
+	})
+
+	for i, path := range []string{"/written", "/http-error", "/error", "/panic"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: expected status 500, got %d", path, rec.Code)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "10" {
+			t.Errorf("%s: expected X-RateLimit-Limit 10, got %q", path, rec.Header().Get("X-RateLimit-Limit"))
+		}
+		if expected := strconv.Itoa(10 - (i + 1)); rec.Header().Get("X-RateLimit-Remaining") != expected {
+			t.Errorf("%s: expected X-RateLimit-Remaining %s, got %q", path, expected, rec.Header().Get("X-RateLimit-Remaining"))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRateLimiterMiddleware_AccumulatedCost(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:      service,
+		Logger:       zap.NewNop(),
+		DefaultLimit: 10,
+	}))
+	// Each sub-step adds its cost; nothing is consumed until the handler is done
+	e.GET("/steps", func(c echo.Context) error {
+		for _, cost := range []int{1, 2, 2} {
+			if !ratelimiter.AddCost(c.Request().Context(), cost) {
+				t.Error("expected the request to carry a cost accumulator")
+			}
+		}
+		remaining, err := service.GetRemaining(c.Request().Context(), c.Request().Header.Get("X-User-ID"), 10)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if remaining != 9 {
+			t.Errorf("expected the cost to be consumed after the handler, got %d remaining", remaining)
+		}
+		// Entries are keyed by millisecond, keep the commit apart from the request
+		time.Sleep(5 * time.Millisecond)
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/fails", func(c echo.Context) error {
+		ratelimiter.AddCost(c.Request().Context(), 5)
+		return echo.NewHTTPError(http.StatusInternalServerError, "sub-step failed")
+	})
+	e.GET("/costly", func(c echo.Context) error {
+		ratelimiter.AddCost(c.Request().Context(), 20)
+		time.Sleep(5 * time.Millisecond)
+		return c.NoContent(http.StatusOK)
+	})
+
+	serve := func(path, userID string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	t.Run("commits the total once", func(t *testing.T) {
+		userID := "test_user_cost_commit"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		serve("/steps", userID)
+
+		// The request itself plus the 5 accumulated
+		remaining, err := service.GetRemaining(ctx, userID, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != 4 {
+			t.Errorf("expected 4 remaining, got %d", remaining)
+		}
+	})
+
+	t.Run("rolls back on error", func(t *testing.T) {
+		userID := "test_user_cost_rollback"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		serve("/fails", userID)
+
+		remaining, err := service.GetRemaining(ctx, userID, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != 9 {
+			t.Errorf("expected only the request itself to count, got %d remaining", remaining)
+		}
+	})
+
+	t.Run("overdraws the remaining limit", func(t *testing.T) {
+		userID := "test_user_cost_overdraw"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		// The cost of 20 exceeds the 9 left after the request itself
+		if code := serve("/costly", userID); code != http.StatusOK {
+			t.Fatalf("expected the request to be served, got %d", code)
+		}
+
+		remaining, err := service.GetRemaining(ctx, userID, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != 0 {
+			t.Errorf("expected the window to be drained, got %d remaining", remaining)
+		}
+		if code := serve("/steps", userID); code != http.StatusTooManyRequests {
+			t.Errorf("expected the next request to be denied, got %d", code)
+		}
+	})
+}
+
+// TestRateLimiterMiddleware_RetryAfter tests that denied requests are told
+// when the window or bucket will have room again, rather than a fixed second
+func TestRateLimiterMiddleware_RetryAfter(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		expected  int
+	}{
+		// The oldest of two rapid requests leaves the 10 second window just
+		// under 10 seconds later, rounded up
+		{algorithm: "sliding_window", expected: 10},
+		// Two requests fill the bucket, which drains one every 5 seconds
+		{algorithm: "leaky_bucket", expected: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			cfg := &config.RateLimitConfig{
+				DefaultLimit:     2,
+				WindowSize:       10,
+				Algorithm:        tt.algorithm,
+				EnableLocalCache: false,
+				LocalCacheTTL:    60,
+			}
+			service := newTestService(t, cfg)
+			ctx := context.Background()
+
+			userID := "test_user_retry_after"
+			_ = service.Reset(ctx, userID)
+			defer service.Reset(ctx, userID)
+
+			e := echo.New()
+			e.Use(middleware.RateLimiterMiddleware(service, zap.NewNop(), 2))
+			e.GET("/test", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+
+			var rec *httptest.ResponseRecorder
+			for i := 0; i < 3; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set("X-User-ID", userID)
+				rec = httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				// Requests in the same millisecond share a sliding window entry
+				time.Sleep(5 * time.Millisecond)
+			}
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("expected the third request to be denied, got %d", rec.Code)
+			}
+
+			if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(tt.expected) {
+				t.Errorf("expected Retry-After %d, got %q", tt.expected, got)
+			}
+			var body map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed to decode body: %v", err)
+			}
+			if body["retry_after"] != float64(tt.expected) {
+				t.Errorf("expected retry_after %d in body, got %v", tt.expected, body["retry_after"])
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddleware_DebugTrace(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     2,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+	secret := []byte("test-secret")
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.StandardClaims{Subject: "test_user_debug_jwt"}).SignedString(secret)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	type trace struct {
+		IdentitySource string `json:"identity_source"`
+		Limit          int    `json:"limit"`
+		LimitSource    string `json:"limit_source"`
+		Algorithm      string `json:"algorithm"`
+		CountBefore    int    `json:"count_before"`
+		CountAfter     int    `json:"count_after"`
+		Allowed        bool   `json:"allowed"`
+		Reason         string `json:"reason"`
+		Code           string `json:"code"`
+	}
+
+	tests := []struct {
+		name   string
+		debug  bool
+		userID string
+		token  string
+		// expected lists the trace of each request, nil when none should be
+		// reported
+		expected []*trace
+	}{
+		{
+			name:     "debug off",
+			debug:    false,
+			userID:   "test_user_debug_off",
+			expected: []*trace{nil, nil, nil},
+		},
+		{
+			name:   "debug on",
+			debug:  true,
+			userID: "test_user_debug_on",
+			expected: []*trace{
+				{IdentitySource: "header", Limit: 2, LimitSource: "fallback", Algorithm: "sliding_window", CountBefore: 0, CountAfter: 1, Allowed: true, Reason: "under_limit"},
+				{IdentitySource: "header", Limit: 2, LimitSource: "fallback", Algorithm: "sliding_window", CountBefore: 1, CountAfter: 2, Allowed: true, Reason: "under_limit"},
+				{IdentitySource: "header", Limit: 2, LimitSource: "fallback", Algorithm: "sliding_window", CountBefore: 2, CountAfter: 2, Allowed: false, Reason: "over_limit", Code: "RATE_LIMITED"},
+			},
+		},
+		{
+			name:   "debug on with jwt",
+			debug:  true,
+			userID: "test_user_debug_jwt",
+			token:  signed,
+			expected: []*trace{
+				{IdentitySource: "jwt", Limit: 2, LimitSource: "fallback", Algorithm: "sliding_window", CountBefore: 0, CountAfter: 1, Allowed: true, Reason: "under_limit"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_ = service.Reset(ctx, tt.userID)
+			defer service.Reset(ctx, tt.userID)
+
+			e := echo.New()
+			e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+				Service:      service,
+				Logger:       zap.NewNop(),
+				DefaultLimit: 2,
+				JWTSecret:    secret,
+				Debug:        tt.debug,
+			}))
+			e.GET("/test", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+
+			for i, expected := range tt.expected {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				if tt.token != "" {
+					req.Header.Set("Authorization", "Bearer "+tt.token)
+				} else {
+					req.Header.Set("X-User-ID", tt.userID)
+				}
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				// Requests in the same millisecond share a sliding window entry
+				time.Sleep(5 * time.Millisecond)
+
+				header := rec.Header().Get(middleware.DebugHeader)
+				if expected == nil {
+					if header != "" {
+						t.Errorf("request %d: expected no debug header, got %q", i+1, header)
+					}
+					continue
+				}
+
+				var got trace
+				if err := json.Unmarshal([]byte(header), &got); err != nil {
+					t.Fatalf("request %d: invalid debug header %q: %v", i+1, header, err)
+				}
+				if got != *expected {
+					t.Errorf("request %d: expected trace %+v, got %+v", i+1, *expected, got)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddleware_RequestCost(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     5,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	// httptest requests come from 192.0.2.1
+	tests := []struct {
+		name      string
+		upstreams []string
+		// costs lists the X-Request-Cost of each request, "" for none
+		costs    []string
+		expected []int
+	}{
+		{
+			name:      "cost-5 request denied with 3 slots left",
+			upstreams: []string{"192.0.2.0/24"},
+			costs:     []string{"", "", "5", "3", ""},
+			expected:  []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "untrusted source costs 1",
+			costs:    []string{"5", "5", "5", "5", "5", "5"},
+			expected: []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:      "invalid cost costs 1",
+			upstreams: []string{"192.0.2.0/24"},
+			costs:     []string{"0", "-2", "lots"},
+			expected:  []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := "test_user_request_cost_" + strconv.Itoa(i)
+			_ = service.Reset(ctx, userID)
+			defer service.Reset(ctx, userID)
+
+			e := echo.New()
+			e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+				Service:          service,
+				Logger:           zap.NewNop(),
+				DefaultLimit:     5,
+				TrustedUpstreams: tt.upstreams,
+			}))
+			e.GET("/test", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+
+			for j, cost := range tt.costs {
+				req := httptest.NewRequest(http.MethodGet, "/test", nil)
+				req.Header.Set("X-User-ID", userID)
+				if cost != "" {
+					req.Header.Set(middleware.RequestCostHeader, cost)
+				}
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				// Requests in the same millisecond share a sliding window entry
+				time.Sleep(5 * time.Millisecond)
+
+				if rec.Code != tt.expected[j] {
+					t.Errorf("request %d (cost %q): expected status %d, got %d", j+1, cost, tt.expected[j], rec.Code)
+				}
+			}
+		})
+	}
+}
+
+// commandHook records the name of every command sent to Redis
+type commandHook struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (h *commandHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	h.mu.Lock()
+	h.commands = append(h.commands, cmd.Name())
+	h.mu.Unlock()
+	return ctx, nil
+}
+
+func (h *commandHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *commandHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	h.mu.Lock()
+	for _, cmd := range cmds {
+		h.commands = append(h.commands, cmd.Name())
+	}
+	h.mu.Unlock()
+	return ctx, nil
+}
+
+func (h *commandHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// take returns the commands recorded since the last call
+func (h *commandHook) take() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	commands := h.commands
+	h.commands = nil
+	return commands
+}
+
+// TestRateLimiterMiddleware_SingleRoundTrip tests that the remaining count
+// and Retry-After come with the rate limit decision instead of being looked
+// up separately
+func TestRateLimiterMiddleware_SingleRoundTrip(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+	hook := &commandHook{}
+	client.AddHook(hook)
+
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:  2,
+		WindowSize:    10,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}, zap.NewNop())
+	userID := "test_user_single_round_trip"
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:      service,
+		Logger:       zap.NewNop(),
+		DefaultLimit: 2,
+	}))
+	e.GET("/test", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+
+	tests := []struct {
+		expectedStatus    int
+		expectedRemaining string
+	}{
+		{expectedStatus: http.StatusOK, expectedRemaining: "1"},
+		{expectedStatus: http.StatusOK, expectedRemaining: "0"},
+		{expectedStatus: http.StatusTooManyRequests},
+	}
+	hook.take()
+	for i, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		// Requests in the same millisecond share a sliding window entry
+		time.Sleep(5 * time.Millisecond)
+
+		if rec.Code != tt.expectedStatus {
+			t.Fatalf("request %d: expected status %d, got %d", i+1, tt.expectedStatus, rec.Code)
+		}
+		if tt.expectedStatus == http.StatusOK && rec.Header().Get("X-RateLimit-Remaining") != tt.expectedRemaining {
+			t.Errorf("request %d: expected %s remaining, got %q", i+1, tt.expectedRemaining, rec.Header().Get("X-RateLimit-Remaining"))
+		}
+		if tt.expectedStatus == http.StatusTooManyRequests {
+			retryAfter, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+			if err != nil || retryAfter < 1 || retryAfter > 10 {
+				t.Errorf("request %d: expected Retry-After within the window, got %q", i+1, rec.Header().Get("Retry-After"))
+			}
+		}
+
+		// The window is only read by the limiter's script
+		for _, command := range hook.take() {
+			if command == "zcard" || command == "zrevrangebyscore" || command == "zcount" {
+				t.Errorf("request %d: expected no separate lookup of the window, got %s", i+1, command)
+			}
+		}
+	}
+}
+
+// TestRateLimiterMiddleware_RouteLimit checks that a route wrapped with a
+// limit of its own is held to it in a window apart from the global one
+func TestRateLimiterMiddleware_RouteLimit(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:  5,
+		WindowSize:    10,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+	userID := "test_user_route_limit"
+	loginCtx := ratelimiter.WithRoute(ctx, "/api/v1/login")
+	_ = service.Reset(ctx, userID)
+	_ = service.Reset(loginCtx, userID)
+	defer service.Reset(ctx, userID)
+	defer service.Reset(loginCtx, userID)
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddleware(service, zap.NewNop(), 5))
+	api := e.Group("/api/v1")
+	api.POST("/login", func(c echo.Context) error {
+		limit, ok := middleware.RouteLimit(c)
+		if !ok {
+			return c.String(http.StatusInternalServerError, "no route limit")
+		}
+		return c.String(http.StatusOK, strconv.Itoa(limit))
+	}, middleware.RateLimiterMiddlewareWithLimit(service, zap.NewNop(), 2))
+	api.GET("/resource", func(c echo.Context) error {
+		if _, ok := middleware.RouteLimit(c); ok {
+			return c.String(http.StatusInternalServerError, "unexpected route limit")
+		}
+		return c.String(http.StatusOK, "ok")
+	})
+
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		// Space requests out so each gets its own timestamp in the window
+		time.Sleep(5 * time.Millisecond)
+		return rec
+	}
+
+	// Login is held to 2 requests although the global limit allows 5
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		rec := serve(http.MethodPost, "/api/v1/login")
+		if rec.Code != expected {
+			t.Fatalf("login %d: expected status %d, got %d", i+1, expected, rec.Code)
+		}
+		if expected != http.StatusOK {
+			if limit := rec.Header().Get("X-RateLimit-Limit"); limit != "" {
+				t.Errorf("login %d: expected the global limit not to be reported, got %s", i+1, limit)
+			}
+			continue
+		}
+		if limit := rec.Header().Get("X-RateLimit-Limit"); limit != "2" {
+			t.Errorf("login %d: expected the route's limit of 2 to be reported, got %s", i+1, limit)
+		}
+		if rec.Body.String() != "2" {
+			t.Errorf("login %d: expected the handler to see the route limit, got %q", i+1, rec.Body.String())
+		}
+	}
+
+	// Other routes are still allowed: the login window is the route's own,
+	// though logins count towards the global limit too
+	rec := serve(http.MethodGet, "/api/v1/resource")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected other routes to be allowed, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if limit := rec.Header().Get("X-RateLimit-Limit"); limit != "5" {
+		t.Errorf("expected the global limit of 5 to be reported, got %s", limit)
+	}
+	if remaining := rec.Header().Get("X-RateLimit-Remaining"); remaining != "1" {
+		t.Errorf("expected 1 request left in the global window, got %s", remaining)
+	}
+}
+
+// TestRateLimiterMiddleware_RouteWindow checks that a route wrapped with a
+// window of its own counts its requests over that window, while the global
+// limit keeps the service's
+func TestRateLimiterMiddleware_RouteWindow(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:  5,
+		WindowSize:    10,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+	userID := "test_user_route_window"
+	loginCtx := ratelimiter.WithRoute(ctx, "/api/v1/login")
+	_ = service.Reset(ctx, userID)
+	_ = service.Reset(loginCtx, userID)
+	defer service.Reset(ctx, userID)
+	defer service.Reset(loginCtx, userID)
+
+	loginWindow := 300 * time.Millisecond
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddleware(service, zap.NewNop(), 5))
+	api := e.Group("/api/v1")
+	api.POST("/login", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	}, middleware.RateLimiterMiddlewareWithLimitAndWindow(service, zap.NewNop(), 2, loginWindow))
+
+	serve := func() int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/login", nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		time.Sleep(5 * time.Millisecond)
+		return rec.Code
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := serve(); code != expected {
+			t.Fatalf("login %d: expected status %d, got %d", i+1, expected, code)
+		}
+	}
+
+	// The login window has passed, though the global one hasn't
+	time.Sleep(loginWindow + 100*time.Millisecond)
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("expected login to be allowed once its window passed, got %d", code)
+	}
+
+	// Every login, denied or not, still counts in the global window
+	remaining, err := service.GetRemaining(ctx, userID, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remaining != 1 {
+		t.Errorf("expected 1 request left in the global window, got %d", remaining)
+	}
+}
+
+// TestRateLimiterMiddleware_ScopeByRoute checks that each route draws from a
+// budget of its own, so exhausting writes leaves reads untouched
+func TestRateLimiterMiddleware_ScopeByRoute(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:  5,
+		WindowSize:    10,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+	userID := "test_user_scope_by_route"
+	readRoute, writeRoute := "/api/v1/orders/:id", "/api/v1/orders"
+	for _, route := range []string{readRoute, writeRoute} {
+		_ = service.ResetScoped(ctx, userID, route)
+		defer service.ResetScoped(ctx, userID, route)
+	}
+
+	// Writes are held to 2, reads keep the default of 5
+	if err := service.SetScopedLimit(ctx, userID, writeRoute, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer service.DeleteUserLimit(ratelimiter.WithScope(ctx, writeRoute), userID)
+
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:      service,
+		Logger:       zap.NewNop(),
+		DefaultLimit: 5,
+		ScopeByRoute: true,
+	}))
+	handler := func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	}
+	e.GET(readRoute, handler)
+	e.POST(writeRoute, handler)
+
+	serve := func(method, path string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		time.Sleep(5 * time.Millisecond)
+		return rec.Code
+	}
+
+	for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		if code := serve(http.MethodPost, writeRoute); code != expected {
+			t.Fatalf("write %d: expected status %d, got %d", i+1, expected, code)
+		}
+	}
+
+	// Reads of any order share the route's budget, untouched by the writes
+	for i, path := range []string{"/api/v1/orders/1", "/api/v1/orders/2"} {
+		if code := serve(http.MethodGet, path); code != http.StatusOK {
+			t.Fatalf("read %d: expected status 200, got %d", i+1, code)
+		}
+	}
+	remaining, err := service.GetRemainingScoped(ctx, userID, readRoute, 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remaining != 3 {
+		t.Errorf("expected 3 reads left, got %d", remaining)
+	}
+}
+
+// TestRateLimiterMiddleware_Allowlist checks that allowlisted clients are
+// never limited, and that only trusted proxies can name the client's IP
+func TestRateLimiterMiddleware_Allowlist(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:  2,
+		WindowSize:    10,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	proxies, err := middleware.TrustedProxyExtractor([]string{"192.0.2.1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	e := echo.New()
+	e.IPExtractor = proxies
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:        service,
+		Logger:         zap.NewNop(),
+		DefaultLimit:   2,
+		AllowlistCIDRs: []string{"10.0.0.0/8", "198.51.100.7"},
+	}))
+	e.GET("/test", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	// serve sends requests from remoteAddr, forwarded for forwardedFor if
+	// set, returning the last status
+	serve := func(remoteAddr, forwardedFor string, requests int) *httptest.ResponseRecorder {
+		var rec *httptest.ResponseRecorder
+		for i := 0; i < requests; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.RemoteAddr = remoteAddr + ":51234"
+			if forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", forwardedFor)
+			}
+			rec = httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			time.Sleep(5 * time.Millisecond)
+		}
+		return rec
+	}
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		forwardedFor   string
+		limitedAs      string
+		expectedStatus int
+	}{
+		{name: "allowlisted range", remoteAddr: "10.1.2.3", expectedStatus: http.StatusOK},
+		{name: "allowlisted address", remoteAddr: "198.51.100.7", expectedStatus: http.StatusOK},
+		{name: "allowlisted behind trusted proxy", remoteAddr: "192.0.2.1", forwardedFor: "10.1.2.3", expectedStatus: http.StatusOK},
+		{name: "not allowlisted", remoteAddr: "203.0.113.7", limitedAs: "203.0.113.7", expectedStatus: http.StatusTooManyRequests},
+		{name: "spoofed forwarding header", remoteAddr: "203.0.113.8", forwardedFor: "10.1.2.3", limitedAs: "203.0.113.8", expectedStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.limitedAs != "" {
+				_ = service.Reset(ctx, tt.limitedAs)
+				defer service.Reset(ctx, tt.limitedAs)
+			}
+
+			rec := serve(tt.remoteAddr, tt.forwardedFor, 5)
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d after 5 requests, got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedStatus == http.StatusOK && rec.Header().Get("X-RateLimit-Limit") != "" {
+				t.Error("expected allowlisted requests to skip the limiter")
+			}
+		})
+	}
+}
+
+// TestRateLimiterMiddleware_HeaderStyle checks that the IETF draft's quota
+// headers are sent on allowed and denied responses, alongside or instead of
+// the legacy ones
+func TestRateLimiterMiddleware_HeaderStyle(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:  2,
+		WindowSize:    10,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+
+	standard := []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset"}
+
+	tests := []struct {
+		name           string
+		style          string
+		expectStandard bool
+		expectLegacy   bool
+	}{
+		{name: "default", style: "", expectStandard: true, expectLegacy: true},
+		{name: "both", style: "both", expectStandard: true, expectLegacy: true},
+		{name: "standard", style: "standard", expectStandard: true},
+		{name: "legacy", style: "legacy", expectLegacy: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := "test_user_header_style_" + tt.name
+			_ = service.Reset(ctx, userID)
+			defer service.Reset(ctx, userID)
+
+			e := echo.New()
+			e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+				Service:      service,
+				Logger:       zap.NewNop(),
+				DefaultLimit: 2,
+				HeaderStyle:  tt.style,
+			}))
+			e.GET("/resource", func(c echo.Context) error {
+				return c.String(http.StatusOK, "ok")
+			})
+
+			for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+				req := httptest.NewRequest(http.MethodGet, "/resource", nil)
+				req.Header.Set("X-User-ID", userID)
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, req)
+				time.Sleep(5 * time.Millisecond)
+
+				if rec.Code != expected {
+					t.Fatalf("request %d: expected status %d, got %d", i+1, expected, rec.Code)
+				}
+				for _, header := range standard {
+					value := rec.Header().Get(header)
+					if !tt.expectStandard {
+						if value != "" {
+							t.Errorf("request %d: expected no %s header, got %q", i+1, header, value)
+						}
+						continue
+					}
+					if _, err := strconv.Atoi(value); err != nil {
+						t.Errorf("request %d: expected a numeric %s header, got %q", i+1, header, value)
+					}
+				}
+				if legacy := rec.Header().Get("X-RateLimit-Limit") != ""; expected == http.StatusOK && legacy != tt.expectLegacy {
+					t.Errorf("request %d: expected legacy headers %v, got %v", i+1, tt.expectLegacy, legacy)
+				}
+			}
+		})
+	}
+
+	t.Run("values", func(t *testing.T) {
+		userID := "test_user_header_style_values"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		e := echo.New()
+		e.Use(middleware.RateLimiterMiddleware(service, zap.NewNop(), 2))
+		e.GET("/resource", func(c echo.Context) error {
+			return c.String(http.StatusOK, "ok")
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/resource", nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		if limit := rec.Header().Get("RateLimit-Limit"); limit != "2" {
+			t.Errorf("expected RateLimit-Limit 2, got %q", limit)
+		}
+		if remaining := rec.Header().Get("RateLimit-Remaining"); remaining != "1" {
+			t.Errorf("expected RateLimit-Remaining 1, got %q", remaining)
+		}
+		// The request just counted leaves the 10 second window in 10 seconds
+		if reset := rec.Header().Get("RateLimit-Reset"); reset != "10" {
+			t.Errorf("expected RateLimit-Reset 10, got %q", reset)
+		}
+	})
+}
+
+// TestRateLimiterMiddleware_KeyExtractor checks that a custom extractor
+// decides who requests are limited as, and that its failures follow
+// FailClosed
+func TestRateLimiterMiddleware_KeyExtractor(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:  2,
+		WindowSize:    10,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}
+	service := newTestService(t, cfg)
+	ctx := context.Background()
+	secret := []byte("test-secret")
+	tenant := "test_user_tenant_acme"
+	_ = service.Reset(ctx, tenant)
+	defer service.Reset(ctx, tenant)
+
+	// Requests are limited per tenant, a custom claim of their bearer token
+	tenantExtractor := func(c echo.Context) (string, error) {
+		auth := c.Request().Header.Get(echo.HeaderAuthorization)
+		if !strings.HasPrefix(auth, "Bearer ") {
+			return "", errors.New("missing bearer token")
+		}
+		claims := jwt.MapClaims{}
+		if _, err := jwt.ParseWithClaims(strings.TrimPrefix(auth, "Bearer "), claims, func(*jwt.Token) (interface{}, error) {
+			return secret, nil
+		}); err != nil {
+			return "", err
+		}
+		id, _ := claims["tenant"].(string)
+		return id, nil
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"tenant": tenant}).SignedString(secret)
+	if err != nil {
+		t.Fatalf("failed to sign token: %v", err)
+	}
+
+	newServer := func(failClosed bool) *echo.Echo {
+		e := echo.New()
+		e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+			Service:      service,
+			Logger:       zap.NewNop(),
+			DefaultLimit: 2,
+			KeyExtractor: tenantExtractor,
+			FailClosed:   failClosed,
+		}))
+		e.GET("/test", func(c echo.Context) error {
+			identity, _ := c.Get(middleware.IdentityContextKey).(string)
+			return c.String(http.StatusOK, identity)
+		})
+		return e
+	}
+
+	t.Run("limits by the claim", func(t *testing.T) {
+		e := newServer(false)
+		// Different X-User-ID headers don't matter: both users are the tenant
+		for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("X-User-ID", "test_user_extractor_"+strconv.Itoa(i))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			time.Sleep(5 * time.Millisecond)
+
+			if rec.Code != expected {
+				t.Fatalf("request %d: expected status %d, got %d", i+1, expected, rec.Code)
+			}
+			if expected == http.StatusOK && rec.Body.String() != tenant {
+				t.Errorf("request %d: expected to be limited as %q, got %q", i+1, tenant, rec.Body.String())
+			}
+		}
+	})
+
+	tests := []struct {
+		name           string
+		failClosed     bool
+		expectedStatus int
+	}{
+		{name: "failure fails open", expectedStatus: http.StatusOK},
+		{name: "failure fails closed", failClosed: true, expectedStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newServer(tt.failClosed)
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if rec.Header().Get("X-RateLimit-Limit") != "" {
+				t.Error("expected an unidentified request not to be counted")
+			}
+		})
+	}
+}
+
+// TestRateLimiterMiddleware_DryRun checks that dry-run mode reports and
+// counts would-be denials but still lets the requests through
+func TestRateLimiterMiddleware_DryRun(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:  2,
+		WindowSize:    10,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}, zap.NewNop(), ratelimiter.WithDecisionMetrics(ratelimiter.NewDecisionMetrics(registry)))
+	userID := "test_user_dry_run"
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	e := echo.New()
+	e.Use(middleware.RateLimiterMiddlewareWithConfig(middleware.RateLimiterConfig{
+		Service:      service,
+		Logger:       zap.New(core),
+		DefaultLimit: 2,
+		DryRun:       true,
+	}))
+	e.GET("/test", func(c echo.Context) error {
+		return c.String(http.StatusOK, "ok")
+	})
+
+	for i, decision := range []string{"allowed", "allowed", "denied"} {
+		req := httptest.NewRequest(http.MethodGet, "/test", nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		time.Sleep(5 * time.Millisecond)
+
+		if rec.Code != http.StatusOK || rec.Body.String() != "ok" {
+			t.Errorf("request %d: expected the handler's 200, got %d %q", i+1, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get(middleware.DryRunDecisionHeader); got != decision {
+			t.Errorf("request %d: expected decision %q, got %q", i+1, decision, got)
+		}
+		if rec.Header().Get("Retry-After") != "" {
+			t.Errorf("request %d: expected no Retry-After on a request let through", i+1)
+		}
+	}
+
+	if n := logs.FilterMessage("rate limit would deny request (dry run)").Len(); n != 1 {
+		t.Errorf("expected 1 would-be denial to be logged, got %d", n)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	counts := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			counts[metric.GetLabel()[0].GetValue()] = metric.GetCounter().GetValue()
+		}
+	}
+	if counts[string(ratelimiter.ReasonUnderLimit)] != 2 || counts[string(ratelimiter.ReasonOverLimit)] != 1 {
+		t.Errorf("expected 2 under-limit and 1 over-limit decisions, got %v", counts)
+	}
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/server/middleware"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestRequestLogger_Sampling(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+
+	e := echo.New()
+	e.Use(middleware.RequestLogger(zap.New(core), config.LoggerConfig{RequestSampleRate: 5}))
+	e.GET("/ok", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/fail", func(c echo.Context) error {
+		return errors.New("boom")
+	})
+	e.GET("/missing", func(c echo.Context) error {
+		return c.NoContent(http.StatusNotFound)
+	})
+
+	serve := func(path string) {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	t.Run("successful requests are sampled", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			serve("/ok")
+		}
+		if n := logs.FilterField(zap.Int("status", http.StatusOK)).Len(); n != 2 {
+			t.Errorf("expected 2 of 10 successful requests logged, got %d", n)
+		}
+	})
+
+	t.Run("failed requests are always logged", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			serve("/fail")
+			serve("/missing")
+		}
+		if n := logs.FilterFieldKey("error").Len(); n != 3 {
+			t.Errorf("expected all 3 errored requests logged, got %d", n)
+		}
+		if n := logs.FilterField(zap.Int("status", http.StatusNotFound)).Len(); n != 3 {
+			t.Errorf("expected all 3 non-2xx requests logged, got %d", n)
+		}
+	})
+
+	t.Run("zero rate logs only failed requests", func(t *testing.T) {
+		core, logs := observer.New(zapcore.InfoLevel)
+		e := echo.New()
+		e.Use(middleware.RequestLogger(zap.New(core), config.LoggerConfig{RequestSampleRate: 0}))
+		e.GET("/ok", func(c echo.Context) error {
+			return c.NoContent(http.StatusOK)
+		})
+
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+		if n := logs.Len(); n != 1 {
+			t.Errorf("expected only the failed request logged, got %d entries", n)
+		}
+	})
+}
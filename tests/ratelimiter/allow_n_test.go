@@ -0,0 +1,182 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// TestAllowN tests that a request costing several slots consumes all of them
+// or none
+// This is an integration test that requires Redis to be running
+func TestAllowN(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	logger := zap.NewNop()
+	limit := 10
+	limiters := map[string]ratelimiter.RateLimiter{
+		"sliding_window": ratelimiter.NewSlidingWindow(client, logger),
+		"leaky_bucket":   ratelimiter.NewLeakyBucket(client, logger),
+	}
+
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			userID := "test_user_allow_n"
+			_ = limiter.Reset(ctx, userID, time.Hour)
+			defer limiter.Reset(ctx, userID, time.Hour)
+
+			// A long window, so the leaky bucket barely drains meanwhile
+			// The denied cost consumes nothing, leaving room for the last one
+			steps := []struct {
+				cost            int
+				expectedAllowed bool
+			}{
+				{cost: 7, expectedAllowed: true},
+				{cost: 4, expectedAllowed: false},
+				{cost: 3, expectedAllowed: true},
+				{cost: 1, expectedAllowed: false},
+			}
+			for _, step := range steps {
+				allowed, err := limiter.AllowN(ctx, userID, limit, step.cost, time.Hour)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if allowed != step.expectedAllowed {
+					t.Errorf("cost %d: expected allowed %v, got %v", step.cost, step.expectedAllowed, allowed)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// TestCheckN tests that a denied request reports the units that were
+// available and how many more it needed
+// This is an integration test that requires Redis to be running
+func TestCheckN(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	logger := zap.NewNop()
+	limit := 10
+	limiters := map[string]ratelimiter.RateLimiter{
+		"sliding_window": ratelimiter.NewSlidingWindow(client, logger),
+		"leaky_bucket":   ratelimiter.NewLeakyBucket(client, logger),
+		"fixed_window":   ratelimiter.NewFixedWindow(client, logger),
+		"regional":       ratelimiter.NewRegionalCounter(client, logger, "local"),
+	}
+
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			userID := "test_user_check_n"
+			_ = limiter.Reset(ctx, userID, time.Hour)
+			defer limiter.Reset(ctx, userID, time.Hour)
+
+			// A long window, so the leaky bucket barely drains meanwhile
+			steps := []ratelimiter.Capacity{
+				{Allowed: true, Requested: 7, Available: 10},
+				{Allowed: false, Requested: 5, Available: 3, Shortfall: 2},
+				{Allowed: true, Requested: 3, Available: 3},
+				{Allowed: false, Requested: 1, Available: 0, Shortfall: 1},
+			}
+			for _, expected := range steps {
+				capacity, err := limiter.CheckN(ctx, userID, limit, expected.Requested, time.Hour)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				// Timings are covered by TestAllowResult
+				capacity.RetryAfter, capacity.ResetAt = 0, time.Time{}
+				if capacity != expected {
+					t.Errorf("cost %d: expected %+v, got %+v", expected.Requested, expected, capacity)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// TestAllowResult tests that a single call reports the remaining requests,
+// and when a denied user may retry
+// This is an integration test that requires Redis to be running
+func TestAllowResult(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	logger := zap.NewNop()
+	limit := 2
+	windowSize := 10 * time.Second
+	limiters := map[string]ratelimiter.RateLimiter{
+		"sliding_window": ratelimiter.NewSlidingWindow(client, logger),
+		"leaky_bucket":   ratelimiter.NewLeakyBucket(client, logger),
+		"fixed_window":   ratelimiter.NewFixedWindow(client, logger),
+		"regional":       ratelimiter.NewRegionalCounter(client, logger, "local"),
+	}
+
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			userID := "test_user_allow_result"
+			_ = limiter.Reset(ctx, userID, windowSize)
+			defer limiter.Reset(ctx, userID, windowSize)
+
+			// Fixed windows must not end mid-test
+			if wait := time.Until(time.Now().Truncate(windowSize).Add(windowSize)); wait < time.Second {
+				time.Sleep(wait)
+			}
+
+			steps := []struct {
+				allowed   bool
+				remaining int
+			}{
+				{allowed: true, remaining: 1},
+				{allowed: true, remaining: 0},
+				{allowed: false, remaining: 0},
+			}
+			for i, step := range steps {
+				start := time.Now()
+				result, err := limiter.AllowResult(ctx, userID, limit, windowSize)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if result.Allowed != step.allowed || result.Remaining != step.remaining || result.Limit != limit {
+					t.Errorf("request %d: expected allowed %v with %d of %d remaining, got %+v", i+1, step.allowed, step.remaining, limit, result)
+				}
+				if step.allowed && result.RetryAfter != 0 {
+					t.Errorf("request %d: expected no retry after for an allowed request, got %v", i+1, result.RetryAfter)
+				}
+				if !step.allowed && (result.RetryAfter <= 0 || result.RetryAfter > windowSize) {
+					t.Errorf("request %d: expected retry after within the window, got %v", i+1, result.RetryAfter)
+				}
+				if result.ResetAt.Before(start) || result.ResetAt.After(start.Add(windowSize+time.Second)) {
+					t.Errorf("request %d: expected reset within the window, got %v", i+1, result.ResetAt)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
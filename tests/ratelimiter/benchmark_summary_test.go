@@ -0,0 +1,160 @@
+package ratelimiter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchmarkSummaryEnv names a file to write a JSON summary of the benchmarks
+// run to, e.g. for CI to track performance over time
+// Relative paths are resolved against this package's directory; nothing is
+// written when it is unset or no benchmark ran
+const benchmarkSummaryEnv = "BENCHMARK_SUMMARY"
+
+// benchmarkResult is one benchmark's throughput and latency
+type benchmarkResult struct {
+	Name       string  `json:"name"`
+	Iterations int     `json:"iterations"`
+	NsPerOp    float64 `json:"ns_per_op"`
+	OpsPerSec  float64 `json:"ops_per_sec"`
+}
+
+// benchmarkSummary collects the results of the benchmarks run
+type benchmarkSummary struct {
+	mu      sync.Mutex
+	results map[string]benchmarkResult
+}
+
+// benchmarks collects the results of this package's benchmarks
+var benchmarks = newBenchmarkSummary()
+
+func newBenchmarkSummary() *benchmarkSummary {
+	return &benchmarkSummary{results: make(map[string]benchmarkResult)}
+}
+
+// recordBenchmark records b's result once it is done, meant to be deferred
+// at the start of a benchmark
+func recordBenchmark(b *testing.B) {
+	benchmarks.record(b.Name(), b.N, b.Elapsed())
+}
+
+// record stores a benchmark's result
+// A benchmark runs several times with growing iteration counts, and only its
+// last, longest run is kept
+func (s *benchmarkSummary) record(name string, iterations int, elapsed time.Duration) {
+	if iterations <= 0 || elapsed <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results[name] = benchmarkResult{
+		Name:       name,
+		Iterations: iterations,
+		NsPerOp:    float64(elapsed.Nanoseconds()) / float64(iterations),
+		OpsPerSec:  float64(iterations) / elapsed.Seconds(),
+	}
+}
+
+// writeIfRequested writes the summary to the file named by
+// benchmarkSummaryEnv, if set
+func (s *benchmarkSummary) writeIfRequested() error {
+	path := os.Getenv(benchmarkSummaryEnv)
+	if path == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	results := make([]benchmarkResult, 0, len(s.results))
+	for _, result := range s.results {
+		results = append(results, result)
+	}
+	s.mu.Unlock()
+	if len(results) == 0 {
+		return nil
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"generated_at": time.Now().UTC(),
+		"go_version":   runtime.Version(),
+		"benchmarks":   results,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if err := benchmarks.writeIfRequested(); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write benchmark summary: %v\n", err)
+		if code == 0 {
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+func TestBenchmarkSummary(t *testing.T) {
+	summary := newBenchmarkSummary()
+	// Only the last run of a benchmark is kept
+	summary.record("BenchmarkSlidingWindow_Allow", 100, time.Millisecond)
+	summary.record("BenchmarkSlidingWindow_Allow", 1000, 2*time.Millisecond)
+	summary.record("BenchmarkLeakyBucket_Allow", 500, time.Millisecond)
+
+	t.Run("not written without the flag", func(t *testing.T) {
+		t.Setenv(benchmarkSummaryEnv, "")
+		if err := summary.writeIfRequested(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("written with the flag", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bench", "summary.json")
+		t.Setenv(benchmarkSummaryEnv, path)
+
+		if err := summary.writeIfRequested(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("expected the summary to be written: %v", err)
+		}
+		var written struct {
+			GeneratedAt time.Time         `json:"generated_at"`
+			GoVersion   string            `json:"go_version"`
+			Benchmarks  []benchmarkResult `json:"benchmarks"`
+		}
+		if err := json.Unmarshal(data, &written); err != nil {
+			t.Fatalf("invalid summary %q: %v", data, err)
+		}
+
+		if written.GeneratedAt.IsZero() || written.GoVersion == "" {
+			t.Errorf("expected generated_at and go_version to be set, got %s", data)
+		}
+		expected := []benchmarkResult{
+			{Name: "BenchmarkLeakyBucket_Allow", Iterations: 500, NsPerOp: 2000, OpsPerSec: 500000},
+			{Name: "BenchmarkSlidingWindow_Allow", Iterations: 1000, NsPerOp: 2000, OpsPerSec: 500000},
+		}
+		if len(written.Benchmarks) != len(expected) {
+			t.Fatalf("expected %d benchmarks, got %s", len(expected), data)
+		}
+		for i, result := range written.Benchmarks {
+			if result != expected[i] {
+				t.Errorf("expected %+v, got %+v", expected[i], result)
+			}
+		}
+	})
+}
@@ -13,6 +13,8 @@ import (
 
 // BenchmarkSlidingWindow_Allow benchmarks the sliding window rate limiter
 func BenchmarkSlidingWindow_Allow(b *testing.B) {
+	defer recordBenchmark(b)
+
 	// Note: This requires a real Redis instance
 	// For production benchmarks, use a real Redis connection
 	client := redis.NewClient(&redis.Options{
@@ -37,6 +39,8 @@ func BenchmarkSlidingWindow_Allow(b *testing.B) {
 
 // BenchmarkLeakyBucket_Allow benchmarks the leaky bucket rate limiter
 func BenchmarkLeakyBucket_Allow(b *testing.B) {
+	defer recordBenchmark(b)
+
 	client := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
 	})
@@ -57,8 +61,45 @@ func BenchmarkLeakyBucket_Allow(b *testing.B) {
 	})
 }
 
+// BenchmarkSlidingWindow_Script compares shipping the sliding window script
+// with every EVAL against running it by SHA1 with EVALSHA, as the limiters do
+func BenchmarkSlidingWindow_Script(b *testing.B) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	body := ratelimiterpkg.Scripts()["sliding_window"]
+	script := redis.NewScript(body)
+	keys := []string{"rate_limit:sliding:bench_script_user"}
+	// args are current_time, window_start, limit, ttl, inclusive and cost
+	args := func() []interface{} {
+		now := time.Now().UnixMilli()
+		return []interface{}{now, now - 1000, 1000, 1100, 0, 1}
+	}
+
+	b.Run("eval", func(b *testing.B) {
+		defer recordBenchmark(b)
+		for i := 0; i < b.N; i++ {
+			_ = client.Eval(ctx, body, keys, args()...).Err()
+		}
+	})
+
+	b.Run("evalsha", func(b *testing.B) {
+		defer recordBenchmark(b)
+		for i := 0; i < b.N; i++ {
+			_ = script.Run(ctx, client, keys, args()...).Err()
+		}
+	})
+
+	client.Del(ctx, keys...)
+}
+
 // BenchmarkService_RateLimit benchmarks the rate limiter service
 func BenchmarkService_RateLimit(b *testing.B) {
+	defer recordBenchmark(b)
+
 	client := redis.NewClient(&redis.Options{
 		Addr: "localhost:6379",
 	})
@@ -88,6 +129,8 @@ func BenchmarkService_RateLimit(b *testing.B) {
 
 // BenchmarkService_RateLimit_Concurrent benchmarks concurrent rate limiting
 func BenchmarkService_RateLimit_Concurrent(b *testing.B) {
+	defer recordBenchmark(b)
+
 	client := redis.NewClient(&redis.Options{
 		Addr:     "localhost:6379",
 		PoolSize: 50,
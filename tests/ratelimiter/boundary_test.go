@@ -0,0 +1,75 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// TestBoundary pins how many requests a limit allows under each boundary mode
+// This is an integration test that requires Redis to be running
+func TestBoundary(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	logger := zap.NewNop()
+	limit := 5
+
+	tests := []struct {
+		mode            string
+		expectedAllowed int
+	}{
+		{mode: ratelimiter.BoundaryStrict, expectedAllowed: 5},
+		{mode: ratelimiter.BoundaryInclusive, expectedAllowed: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			limiters := map[string]ratelimiter.RateLimiter{
+				"sliding_window": ratelimiter.NewSlidingWindow(client, logger, ratelimiter.WithBoundary(tt.mode)),
+				"leaky_bucket":   ratelimiter.NewLeakyBucket(client, logger, ratelimiter.WithBoundary(tt.mode)),
+			}
+
+			for name, limiter := range limiters {
+				userID := "test_user_boundary_" + tt.mode
+				_ = limiter.Reset(ctx, userID, time.Minute)
+				defer limiter.Reset(ctx, userID, time.Minute)
+
+				// A long window, so the leaky bucket barely drains meanwhile
+				remaining, err := limiter.GetRemaining(ctx, userID, limit, time.Minute)
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", name, err)
+				}
+				if remaining != tt.expectedAllowed {
+					t.Errorf("%s: expected %d remaining before any request, got %d", name, tt.expectedAllowed, remaining)
+				}
+
+				allowed := 0
+				for i := 0; i < limit+3; i++ {
+					ok, err := limiter.Allow(ctx, userID, limit, time.Minute)
+					if err != nil {
+						t.Fatalf("%s: unexpected error: %v", name, err)
+					}
+					if ok {
+						allowed++
+					}
+					time.Sleep(5 * time.Millisecond)
+				}
+				if allowed != tt.expectedAllowed {
+					t.Errorf("%s: expected %d requests allowed, got %d", name, tt.expectedAllowed, allowed)
+				}
+			}
+		})
+	}
+}
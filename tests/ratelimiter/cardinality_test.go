@@ -0,0 +1,112 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/internal/config"
+	service "ratelimit-challenge/internal/service/ratelimiter"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// TestCardinality_Allow tests that only new resources consume quota
+// This is an integration test that requires Redis to be running
+func TestCardinality_Allow(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	limiter := ratelimiter.NewCardinality(client, zap.NewNop())
+	userID := "test_user_distinct"
+	limit := 2
+	window := time.Hour
+	_ = limiter.Reset(ctx, userID, window)
+	defer limiter.Reset(ctx, userID, window)
+
+	steps := []struct {
+		resource  string
+		allowed   bool
+		remaining int
+	}{
+		{resource: "doc1", allowed: true, remaining: 1},
+		// Repeated access is free
+		{resource: "doc1", allowed: true, remaining: 1},
+		{resource: "doc1", allowed: true, remaining: 1},
+		{resource: "doc2", allowed: true, remaining: 0},
+		// A third distinct resource is over the limit
+		{resource: "doc3", allowed: false, remaining: 0},
+		// Resources already accessed are still allowed
+		{resource: "doc2", allowed: true, remaining: 0},
+	}
+
+	for i, step := range steps {
+		allowed, err := limiter.Allow(ctx, userID, step.resource, limit, window)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allowed != step.allowed {
+			t.Errorf("step %d (%s): expected allowed to be %v, got %v", i+1, step.resource, step.allowed, allowed)
+		}
+
+		remaining, err := limiter.GetRemaining(ctx, userID, limit, window)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != step.remaining {
+			t.Errorf("step %d (%s): expected %d remaining, got %d", i+1, step.resource, step.remaining, remaining)
+		}
+	}
+}
+
+// TestService_CheckResource tests distinct resource limiting through the service
+// This is an integration test that requires Redis to be running
+func TestService_CheckResource(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	svc := service.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:   10,
+		WindowSize:     1,
+		Algorithm:      "sliding_window",
+		DistinctLimit:  1,
+		DistinctWindow: 3600,
+	}, zap.NewNop())
+
+	userID := "test_user_distinct_service"
+	_ = svc.Reset(ctx, userID)
+	defer svc.Reset(ctx, userID)
+
+	for i := 0; i < 3; i++ {
+		decision, err := svc.CheckResource(ctx, userID, "doc1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed {
+			t.Fatalf("expected repeated access %d to be allowed, got %+v", i+1, decision)
+		}
+	}
+
+	decision, err := svc.CheckResource(ctx, userID, "doc2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decision.Allowed || decision.Code != service.CodeRateLimited {
+		t.Errorf("expected a new resource over the limit to be denied with %s, got %+v", service.CodeRateLimited, decision)
+	}
+}
@@ -0,0 +1,73 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	"ratelimit-challenge/pkg/connections"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// TestService_SeparateDatabases tests that counters and user limits are kept
+// in the databases the clients were connected to
+// This is an integration test that requires Redis to be running
+func TestService_SeparateDatabases(t *testing.T) {
+	base := connections.RedisConfig{Host: "localhost", Port: "6379"}
+	limiterClient, err := connections.NewRedis(base.WithDB(14), zap.NewNop())
+	if err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+	defer limiterClient.Close()
+	configClient, err := connections.NewRedis(base.WithDB(15), zap.NewNop())
+	if err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+	defer configClient.Close()
+
+	if db := limiterClient.(*redis.Client).Options().DB; db != 14 {
+		t.Fatalf("expected the limiter client to select database 14, got %d", db)
+	}
+
+	ctx := context.Background()
+	userID := "test_user_databases"
+	counterKey := "rate_limit:sliding:" + userID
+	configKey := "rate_limit:config:" + userID
+	cleanup := func() {
+		limiterClient.Del(ctx, counterKey, configKey)
+		configClient.Del(ctx, counterKey, configKey)
+	}
+	cleanup()
+	defer cleanup()
+
+	service := ratelimiter.NewService(limiterClient, &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}, zap.NewNop(), ratelimiter.WithConfigClient(configClient))
+
+	if err := service.SetUserLimit(ctx, userID, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if configClient.Exists(ctx, configKey).Val() != 1 || limiterClient.Exists(ctx, configKey).Val() != 0 {
+		t.Errorf("expected the user limit to be stored in the config database only")
+	}
+
+	// The limit read from the config database applies to the counter
+	for i, expected := range []bool{true, false} {
+		allowed, err := service.RateLimit(ctx, userID, 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allowed != expected {
+			t.Errorf("request %d: expected allowed %v, got %v", i+1, expected, allowed)
+		}
+	}
+	if limiterClient.Exists(ctx, counterKey).Val() != 1 || configClient.Exists(ctx, counterKey).Val() != 0 {
+		t.Errorf("expected the counter to be kept in the limiter database only")
+	}
+}
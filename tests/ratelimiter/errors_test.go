@@ -0,0 +1,159 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"ratelimit-challenge/internal/config"
+	service "ratelimit-challenge/internal/service/ratelimiter"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+func TestBackendError(t *testing.T) {
+	// Nothing listens on this port, so every command fails
+	addr := "127.0.0.1:1"
+	client := redis.NewClient(&redis.Options{
+		Addr:       addr,
+		MaxRetries: -1,
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	logger := zap.NewNop()
+
+	limiters := map[string]ratelimiter.RateLimiter{
+		"sliding_window": ratelimiter.NewSlidingWindow(client, logger),
+		"leaky_bucket":   ratelimiter.NewLeakyBucket(client, logger),
+	}
+
+	for name, limiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			_, err := limiter.Allow(ctx, "test_user_backend", 5, time.Second)
+			if err == nil {
+				t.Fatal("expected an error from an unreachable backend")
+			}
+
+			var backendErr *ratelimiter.BackendError
+			if !errors.As(err, &backendErr) {
+				t.Fatalf("expected a BackendError, got %T: %v", err, err)
+			}
+			if backendErr.Backend != addr {
+				t.Errorf("expected backend %q, got %q", addr, backendErr.Backend)
+			}
+			if !strings.Contains(err.Error(), addr) {
+				t.Errorf("expected error message to include %q, got %q", addr, err.Error())
+			}
+		})
+	}
+}
+
+func TestIsFatal(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "missing permission", err: errors.New("NOPERM this user has no permissions to run the 'eval' command"), expected: true},
+		{name: "missing password", err: errors.New("NOAUTH Authentication required."), expected: true},
+		{name: "wrong password", err: errors.New("WRONGPASS invalid username-password pair or user is disabled."), expected: true},
+		{name: "wrapped", err: &ratelimiter.BackendError{Backend: "localhost:6379", Op: "rate limit check failed", Err: errors.New("NOPERM no permissions")}, expected: true},
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}},
+		{name: "loading", err: errors.New("LOADING Redis is loading the dataset in memory")},
+		{name: "nil", err: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ratelimiter.IsFatal(tt.err); got != tt.expected {
+				t.Errorf("expected IsFatal to be %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIsUnavailable(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, expected: true},
+		{name: "connection dropped", err: &ratelimiter.BackendError{Backend: "localhost:6379", Op: "rate limit check failed", Err: io.EOF}, expected: true},
+		{name: "client closed", err: redis.ErrClosed, expected: true},
+		{name: "pool timeout", err: errors.New("redis: connection pool timeout"), expected: true},
+		{name: "misconfigured", err: errors.New("NOPERM no permissions")},
+		{name: "script error", err: errors.New("ERR Error running script")},
+		{name: "nil", err: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ratelimiter.IsUnavailable(tt.err); got != tt.expected {
+				t.Errorf("expected IsUnavailable to be %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// deniedEvalHook rejects EVAL and EVALSHA commands as an ACL lacking the
+// permission would
+type deniedEvalHook struct{}
+
+func (deniedEvalHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "eval" || cmd.Name() == "evalsha" {
+		return ctx, errors.New("NOPERM this user has no permissions to run the 'eval' command")
+	}
+	return ctx, nil
+}
+
+func (deniedEvalHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (deniedEvalHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (deniedEvalHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// TestService_FatalBackendError tests that a permission error is reported as
+// a misconfiguration rather than a transient failure
+// This is an integration test that requires Redis to be running
+func TestService_FatalBackendError(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+	client.AddHook(deniedEvalHook{})
+
+	svc := service.NewService(client, &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   1,
+		Algorithm:    "sliding_window",
+	}, zap.NewNop())
+
+	decision, err := svc.Check(ctx, "test_user_noperm", 10)
+	if err == nil {
+		t.Fatal("expected an error when EVAL is not permitted")
+	}
+	if !ratelimiter.IsFatal(err) {
+		t.Errorf("expected a fatal error, got %v", err)
+	}
+	if decision.Allowed || decision.Code != service.CodeMisconfigured {
+		t.Errorf("expected a %s denial, got %+v", service.CodeMisconfigured, decision)
+	}
+}
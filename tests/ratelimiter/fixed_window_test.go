@@ -0,0 +1,157 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"go.uber.org/zap"
+)
+
+// TestFixedWindow_Allow tests the fixed window counter using a real Redis
+// instance
+func TestFixedWindow_Allow(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	fw := ratelimiter.NewFixedWindow(client, zap.NewNop())
+	userID := "test_user_fixed_allow"
+	limit := 5
+	// Long enough that the test never crosses into the next window
+	windowSize := time.Hour
+	key := "rate_limit:fixed:" + userID + ":" + strconv.FormatInt(time.Now().Unix()/3600, 10)
+
+	_ = fw.Reset(ctx, userID, windowSize)
+	defer fw.Reset(ctx, userID, windowSize)
+
+	for i := 0; i < limit; i++ {
+		allowed, err := fw.Allow(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("Allow failed: %v", err)
+		}
+		if !allowed {
+			t.Fatalf("request %d should be allowed", i+1)
+		}
+	}
+
+	allowed, err := fw.Allow(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("Allow failed: %v", err)
+	}
+	if allowed {
+		t.Error("request over the limit should be denied")
+	}
+
+	// Denied requests don't count
+	if count, err := client.Get(ctx, key).Int(); err != nil || count != limit {
+		t.Errorf("expected counter %s to be %d, got %d (%v)", key, limit, count, err)
+	}
+	if ttl := client.PTTL(ctx, key).Val(); ttl <= 0 || ttl > windowSize+windowSize/10 {
+		t.Errorf("expected the counter to expire within the padded window, got TTL %v", ttl)
+	}
+
+	remaining, err := fw.GetRemaining(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("GetRemaining failed: %v", err)
+	}
+	if remaining != 0 {
+		t.Errorf("expected 0 remaining, got %d", remaining)
+	}
+
+	// The next window starts with the full limit
+	remaining, err = fw.GetRemainingAt(ctx, userID, limit, windowSize, time.Now().Add(windowSize))
+	if err != nil {
+		t.Fatalf("GetRemainingAt failed: %v", err)
+	}
+	if remaining != limit {
+		t.Errorf("expected %d remaining in the next window, got %d", limit, remaining)
+	}
+
+	wait, err := fw.RetryAfter(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("RetryAfter failed: %v", err)
+	}
+	if wait <= 0 || wait > windowSize {
+		t.Errorf("expected to wait until the window ends, got %v", wait)
+	}
+}
+
+// TestFixedWindow_RefundAndReset tests that Refund returns a slot and Reset
+// clears the current window
+func TestFixedWindow_RefundAndReset(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	fw := ratelimiter.NewFixedWindow(client, zap.NewNop())
+	userID := "test_user_fixed_refund"
+	limit := 3
+	windowSize := time.Hour
+
+	_ = fw.Reset(ctx, userID, windowSize)
+	defer fw.Reset(ctx, userID, windowSize)
+
+	if allowed, err := fw.AllowN(ctx, userID, limit, limit, windowSize); err != nil || !allowed {
+		t.Fatalf("expected the full limit to be allowed, got %v (%v)", allowed, err)
+	}
+
+	if err := fw.Refund(ctx, userID, windowSize); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if remaining, _ := fw.GetRemaining(ctx, userID, limit, windowSize); remaining != 1 {
+		t.Errorf("expected 1 remaining after a refund, got %d", remaining)
+	}
+
+	if err := fw.Reset(ctx, userID, windowSize); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if remaining, _ := fw.GetRemaining(ctx, userID, limit, windowSize); remaining != limit {
+		t.Errorf("expected %d remaining after a reset, got %d", limit, remaining)
+	}
+
+	// Refunding an empty window does nothing
+	if err := fw.Refund(ctx, userID, windowSize); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+	if allowed, err := fw.AllowN(ctx, userID, limit, limit+1, windowSize); err != nil || allowed {
+		t.Errorf("expected no more than the limit to be allowed after refunding an empty window, got %v (%v)", allowed, err)
+	}
+}
+
+// TestFixedWindow_ResetKeys tests that Reset deletes the user's current and
+// previous window counters by name, without scanning the keyspace
+func TestFixedWindow_ResetKeys(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	fw := ratelimiter.NewFixedWindow(db, zap.NewNop())
+
+	windowSize := time.Hour
+	epoch := time.Now().Unix() / int64(windowSize/time.Second)
+	mock.ExpectDel(
+		"rate_limit:fixed:user123:"+strconv.FormatInt(epoch, 10),
+		"rate_limit:fixed:user123:"+strconv.FormatInt(epoch-1, 10),
+	).SetVal(1)
+
+	if err := fw.Reset(context.Background(), "user123", windowSize); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
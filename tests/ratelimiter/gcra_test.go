@@ -0,0 +1,129 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// TestGCRA tests the GCRA limiter using a real Redis instance
+func TestGCRA(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	// 10 requests per second is one every 100ms
+	limit := 10
+	windowSize := time.Second
+
+	t.Run("admits the burst then a steady rate", func(t *testing.T) {
+		g := ratelimiter.NewGCRA(client, zap.NewNop(), 3)
+		userID := "test_user_gcra_burst"
+		_ = g.Reset(ctx, userID, windowSize)
+		defer g.Reset(ctx, userID, windowSize)
+
+		for i, expected := range []bool{true, true, true, false} {
+			allowed, err := g.Allow(ctx, userID, limit, windowSize)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if allowed != expected {
+				t.Errorf("request %d: expected allowed=%v, got %v", i+1, expected, allowed)
+			}
+		}
+
+		// One more request conforms per emission interval
+		time.Sleep(110 * time.Millisecond)
+		if allowed, _ := g.Allow(ctx, userID, limit, windowSize); !allowed {
+			t.Error("expected a request to be allowed after one interval")
+		}
+		if allowed, _ := g.Allow(ctx, userID, limit, windowSize); allowed {
+			t.Error("expected a second request within the interval to be denied")
+		}
+	})
+
+	t.Run("burst defaults to the limit", func(t *testing.T) {
+		g := ratelimiter.NewGCRA(client, zap.NewNop(), 0)
+		userID := "test_user_gcra_default"
+		_ = g.Reset(ctx, userID, windowSize)
+		defer g.Reset(ctx, userID, windowSize)
+
+		allowed, err := g.AllowN(ctx, userID, limit, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !allowed {
+			t.Error("expected the whole limit to be admitted at once")
+		}
+		if allowed, _ := g.Allow(ctx, userID, limit, windowSize); allowed {
+			t.Error("expected a request beyond the limit to be denied")
+		}
+	})
+
+	t.Run("denial reports when to retry", func(t *testing.T) {
+		g := ratelimiter.NewGCRA(client, zap.NewNop(), 2)
+		userID := "test_user_gcra_retry"
+		_ = g.Reset(ctx, userID, windowSize)
+		defer g.Reset(ctx, userID, windowSize)
+
+		g.AllowN(ctx, userID, limit, 2, windowSize)
+		capacity, err := g.CheckN(ctx, userID, limit, 1, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if capacity.Allowed || capacity.Available != 0 {
+			t.Errorf("expected the request to be denied with nothing available, got %+v", capacity)
+		}
+		if capacity.RetryAfter <= 0 || capacity.RetryAfter > 100*time.Millisecond {
+			t.Errorf("expected to retry within one interval, got %v", capacity.RetryAfter)
+		}
+
+		retryAfter, err := g.RetryAfter(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+			t.Errorf("expected RetryAfter within one interval, got %v", retryAfter)
+		}
+	})
+
+	t.Run("remaining, stats and refund", func(t *testing.T) {
+		g := ratelimiter.NewGCRA(client, zap.NewNop(), 5)
+		userID := "test_user_gcra_stats"
+		_ = g.Reset(ctx, userID, windowSize)
+		defer g.Reset(ctx, userID, windowSize)
+
+		g.AllowN(ctx, userID, limit, 3, windowSize)
+		if remaining, _ := g.GetRemaining(ctx, userID, limit, windowSize); remaining != 2 {
+			t.Errorf("expected 2 remaining, got %d", remaining)
+		}
+
+		stats, err := g.GetStats(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stats.Algorithm != "gcra" || stats.Capacity != 5 || stats.LeakRate != 10 {
+			t.Errorf("expected gcra stats with burst 5 at 10 per second, got %+v", stats)
+		}
+		if stats.TimeToEmpty <= 200*time.Millisecond || stats.TimeToEmpty > 300*time.Millisecond {
+			t.Errorf("expected the arrival time about 300ms ahead, got %v", stats.TimeToEmpty)
+		}
+
+		if err := g.Refund(ctx, userID, windowSize); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining, _ := g.GetRemaining(ctx, userID, limit, windowSize); remaining != 3 {
+			t.Errorf("expected 3 remaining after a refund, got %d", remaining)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	"strings"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// pipelineSizeHook records the largest pipeline sent to Redis
+type pipelineSizeHook struct {
+	max int
+}
+
+func (h *pipelineSizeHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *pipelineSizeHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *pipelineSizeHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if len(cmds) > h.max {
+		h.max = len(cmds)
+	}
+	return ctx, nil
+}
+
+func (h *pipelineSizeHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// TestService_ImportUserLimits tests streaming imports using a real Redis instance
+func TestService_ImportUserLimits(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       1,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := ratelimiter.NewService(client, cfg, zap.NewNop())
+
+	entries := 25000
+
+	// deleteImported removes every key written by the test
+	deleteImported := func() {
+		pipe := client.Pipeline()
+		for i := 0; i < entries; i++ {
+			pipe.Del(ctx, fmt.Sprintf("rate_limit:config:test_import_%d", i))
+		}
+		pipe.Del(ctx, "rate_limit:config:test_import_ok", "rate_limit:config:test_import_bad")
+		_, _ = pipe.Exec(ctx)
+	}
+	deleteImported()
+	defer deleteImported()
+
+	// Added after the cleanup so only the import's pipelines are recorded
+	hook := &pipelineSizeHook{}
+	client.AddHook(hook)
+
+	t.Run("large stream is written in bounded batches", func(t *testing.T) {
+
+		// Generate the input lazily so the test itself never holds all of it
+		reader, writer := io.Pipe()
+		go func() {
+			for i := 0; i < entries; i++ {
+				fmt.Fprintf(writer, "{\"user_id\": \"test_import_%d\", \"limit\": %d}\n", i, i%50+1)
+			}
+			writer.Close()
+		}()
+
+		lastProgress := 0
+		imported, err := service.ImportUserLimits(ctx, reader, func(n int) { lastProgress = n })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if imported != entries || lastProgress != entries {
+			t.Errorf("expected %d entries imported and reported, got %d and %d", entries, imported, lastProgress)
+		}
+		if hook.max > 1000 {
+			t.Errorf("expected pipelines of at most 1000 commands, got %d", hook.max)
+		}
+
+		for _, i := range []int{0, 12345, entries - 1} {
+			val, err := client.Get(ctx, fmt.Sprintf("rate_limit:config:test_import_%d", i)).Int()
+			if err != nil || val != i%50+1 {
+				t.Errorf("expected limit %d for entry %d, got %d (%v)", i%50+1, i, val, err)
+			}
+		}
+	})
+
+	t.Run("invalid entry stops the import", func(t *testing.T) {
+		input := strings.NewReader(`{"user_id": "test_import_ok", "limit": 5}
+{"user_id": "test_import_bad", "limit": 0}
+`)
+		imported, err := service.ImportUserLimits(ctx, input, nil)
+		if err == nil || !strings.Contains(err.Error(), "entry 2") {
+			t.Errorf("expected an error for entry 2, got %v", err)
+		}
+		if imported != 0 {
+			t.Errorf("expected nothing imported before the failing batch, got %d", imported)
+		}
+	})
+}
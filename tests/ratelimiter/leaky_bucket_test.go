@@ -0,0 +1,221 @@
+package ratelimiter
+
+import (
+	"context"
+	"math"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// TestLeakyBucket_GetStats tests the leaky bucket stats using a real Redis instance
+func TestLeakyBucket_GetStats(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	lb := ratelimiter.NewLeakyBucket(client, zap.NewNop())
+	userID := "test_user_leaky_stats"
+	limit := 10
+	windowSize := 10 * time.Second
+
+	_ = lb.Reset(ctx, userID, windowSize)
+	defer lb.Reset(ctx, userID, windowSize)
+
+	t.Run("empty bucket", func(t *testing.T) {
+		stats, err := lb.GetStats(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stats.Level != 0 || stats.TimeToEmpty != 0 || stats.Remaining != limit {
+			t.Errorf("expected an empty bucket, got %+v", stats)
+		}
+	})
+
+	t.Run("partially full bucket", func(t *testing.T) {
+		client.HSet(ctx, "rate_limit:leaky:"+userID, "level", 4, "last_update", time.Now().UnixMilli())
+
+		stats, err := lb.GetStats(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if stats.Algorithm != "leaky_bucket" {
+			t.Errorf("expected algorithm leaky_bucket, got %q", stats.Algorithm)
+		}
+		if stats.Capacity != limit {
+			t.Errorf("expected capacity %d, got %d", limit, stats.Capacity)
+		}
+		// A full bucket of 10 drains over 10 seconds
+		if stats.LeakRate != 1 {
+			t.Errorf("expected leak rate 1 per second, got %v", stats.LeakRate)
+		}
+		if math.Abs(stats.Level-4) > 0.1 {
+			t.Errorf("expected level close to 4, got %v", stats.Level)
+		}
+		if d := stats.TimeToEmpty - 4*time.Second; d > 0 || d < -100*time.Millisecond {
+			t.Errorf("expected time to empty close to 4s, got %v", stats.TimeToEmpty)
+		}
+		if stats.Remaining != 6 {
+			t.Errorf("expected remaining 6, got %d", stats.Remaining)
+		}
+	})
+}
+
+// TestLeakyBucket_Allow tests that the bucket fills up and drains over one window
+func TestLeakyBucket_Allow(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	lb := ratelimiter.NewLeakyBucket(client, zap.NewNop())
+	userID := "test_user_leaky_allow"
+	limit := 5
+	windowSize := 500 * time.Millisecond
+
+	_ = lb.Reset(ctx, userID, windowSize)
+	defer lb.Reset(ctx, userID, windowSize)
+
+	for i := 0; i < limit; i++ {
+		allowed, err := lb.Allow(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !allowed {
+			t.Fatalf("expected request %d to be allowed", i+1)
+		}
+	}
+
+	allowed, err := lb.Allow(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if allowed {
+		t.Error("expected request over the limit to be denied")
+	}
+
+	// One request drains every 100ms
+	time.Sleep(150 * time.Millisecond)
+	allowed, err = lb.Allow(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !allowed {
+		t.Error("expected a request to be allowed once the bucket drained")
+	}
+}
+
+// TestLeakyBucket_DeniedRequestsKeepDraining tests that denied requests don't
+// stop the bucket from draining: a client retrying a full bucket is admitted
+// again at the leak rate instead of being locked out
+func TestLeakyBucket_DeniedRequestsKeepDraining(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	lb := ratelimiter.NewLeakyBucket(client, zap.NewNop())
+	userID := "test_user_leaky_denied_drain"
+	limit := 5
+	windowSize := 500 * time.Millisecond
+
+	_ = lb.Reset(ctx, userID, windowSize)
+	defer lb.Reset(ctx, userID, windowSize)
+
+	if allowed, err := lb.AllowN(ctx, userID, limit, limit, windowSize); err != nil || !allowed {
+		t.Fatalf("expected the bucket to fill, got allowed=%v err=%v", allowed, err)
+	}
+
+	// Retry every 20ms, well within the 100ms it takes one request to drain,
+	// and count admissions over three drain intervals
+	admitted := 0
+	deadline := time.Now().Add(350 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		allowed, err := lb.Allow(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allowed {
+			admitted++
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// One request drains every 100ms, so about 3 are admitted
+	if admitted < 2 || admitted > 4 {
+		t.Errorf("expected about 3 requests admitted at the leak rate, got %d", admitted)
+	}
+}
+
+// TestLeakyBucket_GetRemainingMatchesAllow tests that remaining requests are
+// counted exactly as Allow decides them: one fewer per allowed request
+func TestLeakyBucket_GetRemainingMatchesAllow(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	lb := ratelimiter.NewLeakyBucket(client, zap.NewNop())
+	userID := "test_user_leaky_remaining"
+	limit := 10
+	// Slow enough that nothing drains during the test
+	windowSize := time.Minute
+
+	_ = lb.Reset(ctx, userID, windowSize)
+	defer lb.Reset(ctx, userID, windowSize)
+
+	for i := 0; i < limit; i++ {
+		remaining, err := lb.GetRemaining(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != limit-i {
+			t.Fatalf("before request %d: expected %d remaining, got %d", i+1, limit-i, remaining)
+		}
+
+		allowed, err := lb.Allow(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !allowed {
+			t.Fatalf("expected request %d to be allowed with %d remaining", i+1, remaining)
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	remaining, err := lb.GetRemaining(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remaining != 0 {
+		t.Errorf("expected none remaining, got %d", remaining)
+	}
+	if allowed, _ := lb.Allow(ctx, userID, limit, windowSize); allowed {
+		t.Error("expected a request with none remaining to be denied")
+	}
+}
@@ -0,0 +1,89 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter(t *testing.T) {
+	ctx := context.Background()
+	window := 100 * time.Millisecond
+
+	t.Run("enforces the limit per user", func(t *testing.T) {
+		limiter := ratelimiter.NewMemoryLimiter(10)
+		for i, expected := range []bool{true, true, true, false} {
+			allowed, err := limiter.Allow(ctx, "user1", 3, window)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if allowed != expected {
+				t.Errorf("request %d: expected allowed=%v, got %v", i+1, expected, allowed)
+			}
+		}
+		if allowed, _ := limiter.Allow(ctx, "user2", 3, window); !allowed {
+			t.Error("expected another user to have their own window")
+		}
+
+		// The window slides, freeing the slots
+		time.Sleep(window + 10*time.Millisecond)
+		if allowed, _ := limiter.Allow(ctx, "user1", 3, window); !allowed {
+			t.Error("expected a request to be allowed once the window slid past")
+		}
+	})
+
+	t.Run("costs and retry after", func(t *testing.T) {
+		limiter := ratelimiter.NewMemoryLimiter(10)
+		capacity, _ := limiter.CheckN(ctx, "user1", 5, 4, time.Minute)
+		if !capacity.Allowed || capacity.Available != 5 {
+			t.Fatalf("expected 4 of 5 units to be consumed, got %+v", capacity)
+		}
+
+		capacity, _ = limiter.CheckN(ctx, "user1", 5, 2, time.Minute)
+		if capacity.Allowed || capacity.Available != 1 || capacity.Shortfall != 1 {
+			t.Errorf("expected 2 units to be denied with 1 available, got %+v", capacity)
+		}
+		if capacity.RetryAfter <= 59*time.Second || capacity.RetryAfter > time.Minute {
+			t.Errorf("expected to retry once the first request leaves in about a minute, got %v", capacity.RetryAfter)
+		}
+		if remaining, _ := limiter.GetRemaining(ctx, "user1", 5, time.Minute); remaining != 1 {
+			t.Errorf("expected 1 remaining, got %d", remaining)
+		}
+	})
+
+	t.Run("refund and reset", func(t *testing.T) {
+		limiter := ratelimiter.NewMemoryLimiter(10)
+		limiter.AllowN(ctx, "user1", 2, 2, time.Minute)
+
+		if err := limiter.Refund(ctx, "user1", time.Minute); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining, _ := limiter.GetRemaining(ctx, "user1", 2, time.Minute); remaining != 1 {
+			t.Errorf("expected 1 remaining after a refund, got %d", remaining)
+		}
+
+		if err := limiter.Reset(ctx, "user1", time.Minute); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		stats, _ := limiter.GetStats(ctx, "user1", 2, time.Minute)
+		if stats.Count != 0 || stats.Remaining != 2 {
+			t.Errorf("expected an empty window after a reset, got %+v", stats)
+		}
+	})
+
+	t.Run("forgets the least recently seen user", func(t *testing.T) {
+		limiter := ratelimiter.NewMemoryLimiter(2)
+		limiter.Allow(ctx, "user1", 1, time.Minute)
+		limiter.Allow(ctx, "user2", 1, time.Minute)
+		// user1 is seen again, so user2 is the one evicted for user3
+		limiter.Allow(ctx, "user1", 1, time.Minute)
+		limiter.Allow(ctx, "user3", 1, time.Minute)
+
+		for userID, expected := range map[string]int{"user1": 0, "user2": 1, "user3": 0} {
+			if remaining, _ := limiter.GetRemaining(ctx, userID, 1, time.Minute); remaining != expected {
+				t.Errorf("expected %s to have %d remaining, got %d", userID, expected, remaining)
+			}
+		}
+	})
+}
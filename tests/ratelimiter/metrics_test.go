@@ -0,0 +1,75 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// TestMetrics_RedisLatency checks that Redis calls are observed in the
+// latency histogram, labelled by operation and algorithm
+// This is an integration test that requires Redis to be running
+func TestMetrics_RedisLatency(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	metrics := ratelimiter.NewMetrics(registry)
+	sw := ratelimiter.NewSlidingWindow(client, zap.NewNop(), ratelimiter.WithMetrics(metrics))
+	lb := ratelimiter.NewLeakyBucket(client, zap.NewNop(), ratelimiter.WithMetrics(metrics))
+
+	userID := "test_user_metrics"
+	for _, limiter := range []ratelimiter.RateLimiter{sw, lb} {
+		for i := 0; i < 2; i++ {
+			if _, err := limiter.Allow(ctx, userID, 10, time.Second); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if _, err := limiter.GetRemaining(ctx, userID, 10, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := limiter.Reset(ctx, userID, time.Second); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	counts := make(map[string]uint64)
+	for _, family := range families {
+		if family.GetName() != "rate_limit_redis_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			counts[labels["algorithm"]+"/"+labels["operation"]] = metric.GetHistogram().GetSampleCount()
+		}
+	}
+
+	for _, algorithm := range []string{"sliding_window", "leaky_bucket"} {
+		expected := map[string]uint64{"allow": 2, "remaining": 1, "reset": 1}
+		for operation, count := range expected {
+			if got := counts[algorithm+"/"+operation]; got != count {
+				t.Errorf("expected %d %s observations for %s, got %d", count, operation, algorithm, got)
+			}
+		}
+	}
+}
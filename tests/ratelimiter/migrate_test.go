@@ -0,0 +1,58 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TestMigrateKeyPrefix tests renaming keys to a new prefix
+// This is an integration test that requires Redis to be running
+func TestMigrateKeyPrefix(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	oldPrefix := "test_migrate_old:"
+	newPrefix := "test_migrate_new:"
+	cleanup := func() {
+		client.Del(ctx, oldPrefix+"a", oldPrefix+"b", oldPrefix+"c", newPrefix+"a", newPrefix+"b", newPrefix+"c")
+	}
+	cleanup()
+	defer cleanup()
+
+	client.ZAdd(ctx, oldPrefix+"a", &redis.Z{Score: 1, Member: "1"})
+	client.HSet(ctx, oldPrefix+"b", "level", "3")
+	client.Set(ctx, oldPrefix+"c", "old", 0)
+	// Already written under the new prefix, must not be overwritten
+	client.Set(ctx, newPrefix+"c", "new", 0)
+
+	renamed, err := ratelimiter.MigrateKeyPrefix(ctx, client, oldPrefix, newPrefix, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if renamed != 2 {
+		t.Errorf("expected 2 keys renamed, got %d", renamed)
+	}
+
+	if n := client.Exists(ctx, newPrefix+"a", newPrefix+"b").Val(); n != 2 {
+		t.Errorf("expected both keys under the new prefix, got %d", n)
+	}
+	if n := client.Exists(ctx, oldPrefix+"a", oldPrefix+"b").Val(); n != 0 {
+		t.Errorf("expected no keys left under the old prefix, got %d", n)
+	}
+	if level := client.HGet(ctx, newPrefix+"b", "level").Val(); level != "3" {
+		t.Errorf("expected bucket level to carry over, got %q", level)
+	}
+	if val := client.Get(ctx, newPrefix+"c").Val(); val != "new" {
+		t.Errorf("expected existing key under the new prefix to be kept, got %q", val)
+	}
+}
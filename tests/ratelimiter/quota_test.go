@@ -0,0 +1,133 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+func TestQuotaSchedule_Bounds(t *testing.T) {
+	date := func(year int, month time.Month, day, hour int) time.Time {
+		return time.Date(year, month, day, hour, 0, 0, 0, time.UTC)
+	}
+	monthly := ratelimiter.QuotaSchedule{Limit: 10, Period: ratelimiter.QuotaMonthly, Anchor: date(2024, time.January, 31, 9)}
+	daily := ratelimiter.QuotaSchedule{Limit: 10, Period: ratelimiter.QuotaDaily, Anchor: date(2024, time.March, 5, 14)}
+
+	tests := []struct {
+		name          string
+		schedule      ratelimiter.QuotaSchedule
+		now           time.Time
+		expectedStart time.Time
+		expectedEnd   time.Time
+	}{
+		{name: "monthly into a short month", schedule: monthly, now: date(2025, time.February, 15, 12), expectedStart: date(2025, time.January, 31, 9), expectedEnd: date(2025, time.February, 28, 9)},
+		{name: "monthly after a short month", schedule: monthly, now: date(2025, time.March, 1, 0), expectedStart: date(2025, time.February, 28, 9), expectedEnd: date(2025, time.March, 31, 9)},
+		{name: "monthly just before the anniversary", schedule: monthly, now: date(2025, time.January, 31, 8), expectedStart: date(2024, time.December, 31, 9), expectedEnd: date(2025, time.January, 31, 9)},
+		{name: "monthly at the anniversary", schedule: monthly, now: date(2025, time.January, 31, 9), expectedStart: date(2025, time.January, 31, 9), expectedEnd: date(2025, time.February, 28, 9)},
+		{name: "daily before the reset time", schedule: daily, now: date(2025, time.June, 10, 10), expectedStart: date(2025, time.June, 9, 14), expectedEnd: date(2025, time.June, 10, 14)},
+		{name: "daily after the reset time", schedule: daily, now: date(2025, time.June, 10, 15), expectedStart: date(2025, time.June, 10, 14), expectedEnd: date(2025, time.June, 11, 14)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.schedule.Bounds(tt.now)
+			if !start.Equal(tt.expectedStart) || !end.Equal(tt.expectedEnd) {
+				t.Errorf("expected period %v to %v, got %v to %v", tt.expectedStart, tt.expectedEnd, start, end)
+			}
+		})
+	}
+}
+
+// TestService_QuotaSchedule tests that each user's quota resets at their own
+// scheduled time
+// This is an integration test that requires Redis to be running
+func TestService_QuotaSchedule(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:     100,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+		EnableQuotas:     true,
+	}, zap.NewNop())
+
+	// Daily quotas, one resetting in a second and one in an hour
+	now := time.Now()
+	schedules := map[string]time.Time{
+		"test_user_quota_soon":  now.Add(time.Second),
+		"test_user_quota_later": now.Add(time.Hour),
+	}
+	for userID, anchor := range schedules {
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+		defer client.Del(ctx, "rate_limit:quota_schedule:"+userID)
+		defer func(userID string) {
+			keys, _ := client.Keys(ctx, "rate_limit:quota:"+userID+":*").Result()
+			if len(keys) > 0 {
+				client.Del(ctx, keys...)
+			}
+		}(userID)
+
+		err := service.SetQuotaSchedule(ctx, userID, ratelimiter.QuotaSchedule{
+			Limit:  2,
+			Period: ratelimiter.QuotaDaily,
+			Anchor: anchor,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	check := func(userID string) ratelimiter.Decision {
+		t.Helper()
+		decision, err := service.Check(ctx, userID, 100)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		return decision
+	}
+
+	for userID := range schedules {
+		for i := 1; i <= 2; i++ {
+			if decision := check(userID); !decision.Allowed {
+				t.Fatalf("%s: expected request %d to be allowed, got %+v", userID, i, decision)
+			}
+		}
+		if decision := check(userID); decision.Allowed || decision.Code != ratelimiter.CodeQuotaExceeded {
+			t.Fatalf("%s: expected the quota to be exhausted, got %+v", userID, decision)
+		}
+	}
+
+	// Only the first user's reset time has passed
+	time.Sleep(time.Until(now.Add(1100 * time.Millisecond)))
+	if decision := check("test_user_quota_soon"); !decision.Allowed {
+		t.Errorf("expected the quota to reset at the user's scheduled time, got %+v", decision)
+	}
+	if decision := check("test_user_quota_later"); decision.Allowed {
+		t.Errorf("expected the other user's quota to stay exhausted until their own reset")
+	}
+
+	usage, err := service.GetQuota(ctx, "test_user_quota_soon")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage.Used != 1 || usage.Limit != 2 {
+		t.Errorf("expected 1 of 2 used after the reset, got %+v", usage)
+	}
+}
@@ -0,0 +1,172 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// regionClients connects to two databases standing in for the Redis of two
+// regions, skipping the test if Redis isn't available
+func regionClients(t *testing.T) (*redis.Client, *redis.Client) {
+	t.Helper()
+
+	us := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 12})
+	eu := redis.NewClient(&redis.Options{Addr: "localhost:6379", DB: 13})
+	t.Cleanup(func() {
+		us.Close()
+		eu.Close()
+	})
+
+	if err := us.Ping(context.Background()).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+	return us, eu
+}
+
+// TestRegionalCounter_Merge checks that regions admit independently until
+// they merge, and that the aggregate limit is enforced afterwards
+// This is an integration test that requires Redis to be running
+func TestRegionalCounter_Merge(t *testing.T) {
+	us, eu := regionClients(t)
+	ctx := context.Background()
+
+	userID := "test_user_regional_merge"
+	key := "rate_limit:regional:" + userID
+	limit := 10
+	// Long enough that the test never crosses into the next window
+	windowSize := time.Hour
+	defer us.Del(ctx, key)
+	defer eu.Del(ctx, key)
+	us.Del(ctx, key)
+	eu.Del(ctx, key)
+
+	usCounter := ratelimiter.NewRegionalCounter(us, zap.NewNop(), "us")
+	euCounter := ratelimiter.NewRegionalCounter(eu, zap.NewNop(), "eu")
+
+	// Partitioned, each region admits 6 requests seeing only its own
+	for i := 0; i < 6; i++ {
+		for _, counter := range []*ratelimiter.RegionalCounter{usCounter, euCounter} {
+			allowed, err := counter.Allow(ctx, userID, limit, windowSize)
+			if err != nil {
+				t.Fatalf("Allow failed: %v", err)
+			}
+			if !allowed {
+				t.Fatalf("request %d should be allowed before the regions merge", i+1)
+			}
+		}
+	}
+
+	// Merging in both directions gives both regions the aggregate of 12
+	for _, merge := range []struct {
+		into *ratelimiter.RegionalCounter
+		peer *redis.Client
+	}{{usCounter, eu}, {euCounter, us}} {
+		merged, err := merge.into.Merge(ctx, merge.peer, 2)
+		if err != nil {
+			t.Fatalf("Merge failed: %v", err)
+		}
+		if merged != 1 {
+			t.Errorf("expected 1 hash merged, got %d", merged)
+		}
+	}
+
+	for name, counter := range map[string]*ratelimiter.RegionalCounter{"us": usCounter, "eu": euCounter} {
+		stats, err := counter.GetStats(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("GetStats failed: %v", err)
+		}
+		if stats.Count != 12 || stats.Remaining != 0 {
+			t.Errorf("%s: expected count 12 and 0 remaining, got %d and %d", name, stats.Count, stats.Remaining)
+		}
+
+		allowed, err := counter.Allow(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("Allow failed: %v", err)
+		}
+		if allowed {
+			t.Errorf("%s: request should be denied once the aggregate exceeds the limit", name)
+		}
+
+		retryAfter, err := counter.RetryAfter(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("RetryAfter failed: %v", err)
+		}
+		if retryAfter <= 0 || retryAfter > windowSize {
+			t.Errorf("%s: expected a wait within the window, got %v", name, retryAfter)
+		}
+	}
+
+	// Merging again changes nothing
+	if _, err := usCounter.Merge(ctx, eu, 2); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	stats, err := usCounter.GetStats(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if stats.Count != 12 {
+		t.Errorf("expected merging to be idempotent, got count %d", stats.Count)
+	}
+}
+
+// TestRegionalCounter_Refund checks that a refund in one region frees a slot
+// in the other once merged
+// This is an integration test that requires Redis to be running
+func TestRegionalCounter_Refund(t *testing.T) {
+	us, eu := regionClients(t)
+	ctx := context.Background()
+
+	userID := "test_user_regional_refund"
+	key := "rate_limit:regional:" + userID
+	limit := 4
+	windowSize := time.Hour
+	defer us.Del(ctx, key)
+	defer eu.Del(ctx, key)
+	us.Del(ctx, key)
+	eu.Del(ctx, key)
+
+	usCounter := ratelimiter.NewRegionalCounter(us, zap.NewNop(), "us")
+	euCounter := ratelimiter.NewRegionalCounter(eu, zap.NewNop(), "eu")
+
+	for i := 0; i < 2; i++ {
+		if _, err := usCounter.Allow(ctx, userID, limit, windowSize); err != nil {
+			t.Fatalf("Allow failed: %v", err)
+		}
+		if _, err := euCounter.Allow(ctx, userID, limit, windowSize); err != nil {
+			t.Fatalf("Allow failed: %v", err)
+		}
+	}
+	if err := usCounter.Refund(ctx, userID, windowSize); err != nil {
+		t.Fatalf("Refund failed: %v", err)
+	}
+
+	if _, err := euCounter.Merge(ctx, us, 1); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	remaining, err := euCounter.GetRemaining(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("GetRemaining failed: %v", err)
+	}
+	if remaining != 1 {
+		t.Errorf("expected 1 remaining after the refund is merged, got %d", remaining)
+	}
+
+	// A region never refunds more than it admitted
+	for i := 0; i < 3; i++ {
+		if err := usCounter.Refund(ctx, userID, windowSize); err != nil {
+			t.Fatalf("Refund failed: %v", err)
+		}
+	}
+	remaining, err = usCounter.GetRemaining(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("GetRemaining failed: %v", err)
+	}
+	if remaining != limit {
+		t.Errorf("expected %d remaining with nothing counted, got %d", limit, remaining)
+	}
+}
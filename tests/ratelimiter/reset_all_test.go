@@ -0,0 +1,120 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// concurrencyHook tracks how many DEL commands are in flight at once
+type concurrencyHook struct {
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (h *concurrencyHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() == "del" {
+		h.mu.Lock()
+		h.inFlight++
+		if h.inFlight > h.max {
+			h.max = h.inFlight
+		}
+		h.mu.Unlock()
+		// Holds the delete open so overlapping workers are observed
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ctx, nil
+}
+
+func (h *concurrencyHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if cmd.Name() == "del" {
+		h.mu.Lock()
+		h.inFlight--
+		h.mu.Unlock()
+	}
+	return nil
+}
+
+func (h *concurrencyHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *concurrencyHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// TestResetAll tests deleting every key under a prefix with several workers
+// This is an integration test that requires Redis to be running
+func TestResetAll(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	prefix := "test_reset_all:"
+	other := "test_reset_all_other"
+	defer client.Del(ctx, other)
+
+	// Enough keys for many SCAN batches
+	const keys = 1050
+	pipe := client.Pipeline()
+	for i := 0; i < keys; i++ {
+		pipe.Set(ctx, fmt.Sprintf("%s%d", prefix, i), i, time.Minute)
+	}
+	pipe.Set(ctx, other, "kept", time.Minute)
+	if _, err := pipe.Exec(ctx); err != nil {
+		t.Fatalf("failed to create keys: %v", err)
+	}
+
+	const concurrency = 3
+	hook := &concurrencyHook{}
+	client.AddHook(hook)
+
+	deleted, err := ratelimiter.ResetAll(ctx, client, prefix, concurrency)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deleted != keys {
+		t.Errorf("expected %d keys deleted, got %d", keys, deleted)
+	}
+
+	remaining, err := client.Keys(ctx, prefix+"*").Result()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("expected no keys left under the prefix, got %d", len(remaining))
+	}
+	if client.Exists(ctx, other).Val() != 1 {
+		t.Errorf("expected keys outside the prefix to be kept")
+	}
+
+	if hook.max > concurrency {
+		t.Errorf("expected at most %d deletes at once, got %d", concurrency, hook.max)
+	}
+	if hook.max < 2 {
+		t.Errorf("expected deletes to run concurrently, got at most %d at once", hook.max)
+	}
+}
+
+func TestResetAll_RequiresPrefix(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	if _, err := ratelimiter.ResetAll(context.Background(), client, "", 1); err == nil {
+		t.Error("expected an error for an empty prefix")
+	}
+}
@@ -0,0 +1,121 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// TestLeakyBucket_RetryAfter checks that the wait matches the time the
+// bucket takes to drain enough for one request
+// This is an integration test that requires Redis to be running
+func TestLeakyBucket_RetryAfter(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	userID := "test_user_leaky_retry_after"
+	key := "rate_limit:leaky:" + userID
+	limit := 10
+	// A full bucket of 10 drains at 1 request per second
+	windowSize := 10 * time.Second
+	defer client.Del(ctx, key)
+
+	tests := []struct {
+		name     string
+		opts     []ratelimiter.Option
+		level    float64
+		expected time.Duration
+	}{
+		{name: "empty bucket", level: -1, expected: 0},
+		{name: "room left", level: 9, expected: 0},
+		{name: "full bucket", level: 10, expected: time.Second},
+		{name: "overfull bucket", level: 15, expected: 6 * time.Second},
+		{name: "inclusive boundary", opts: []ratelimiter.Option{ratelimiter.WithBoundary(ratelimiter.BoundaryInclusive)}, level: 10.5, expected: 500 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lb := ratelimiter.NewLeakyBucket(client, zap.NewNop(), tt.opts...)
+			client.Del(ctx, key)
+			if tt.level >= 0 {
+				client.HSet(ctx, key, "level", tt.level, "last_update", time.Now().UnixMilli())
+			}
+
+			wait, err := lb.RetryAfter(ctx, userID, limit, windowSize)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// The bucket keeps leaking between setting it and asking
+			if wait > tt.expected || wait < tt.expected-50*time.Millisecond {
+				t.Errorf("expected a wait of about %v, got %v", tt.expected, wait)
+			}
+
+			// Asking must not change the bucket
+			if tt.level >= 0 {
+				level, _ := client.HGet(ctx, key, "level").Float64()
+				if level != tt.level {
+					t.Errorf("expected the level to stay %v, got %v", tt.level, level)
+				}
+			}
+		})
+	}
+}
+
+// TestSlidingWindow_RetryAfter checks that the wait lasts until the entry
+// blocking the next request leaves the window
+// This is an integration test that requires Redis to be running
+func TestSlidingWindow_RetryAfter(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	sw := ratelimiter.NewSlidingWindow(client, zap.NewNop())
+	userID := "test_user_sliding_retry_after"
+	key := "rate_limit:sliding:" + userID
+	limit := 3
+	windowSize := 10 * time.Second
+	defer client.Del(ctx, key)
+
+	now := time.Now()
+	client.Del(ctx, key)
+	for i, age := range []time.Duration{4 * time.Second, 2 * time.Second} {
+		score := float64(now.Add(-age).UnixMilli())
+		client.ZAdd(ctx, key, &redis.Z{Score: score, Member: i})
+	}
+
+	wait, err := sw.RetryAfter(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wait != 0 {
+		t.Errorf("expected no wait with room left, got %v", wait)
+	}
+
+	client.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixMilli()), Member: 2})
+
+	// The oldest of the 3 entries, 4s old, leaves the window in 6s
+	wait, err = sw.RetryAfter(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if wait > 6*time.Second || wait < 6*time.Second-50*time.Millisecond {
+		t.Errorf("expected a wait of about 6s, got %v", wait)
+	}
+}
@@ -0,0 +1,101 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"net"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// flakyHook fails the first failures EVALSHA commands, which the limiters
+// send once per attempt
+// With afterRun set the script still runs and only the reply is lost,
+// like a read timeout; otherwise the command never reaches Redis
+type flakyHook struct {
+	failures int
+	afterRun bool
+	evals    int
+}
+
+func (h *flakyHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if cmd.Name() != "evalsha" {
+		return ctx, nil
+	}
+	h.evals++
+	if !h.afterRun && h.evals <= h.failures {
+		return ctx, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+	return ctx, nil
+}
+
+func (h *flakyHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	if cmd.Name() == "evalsha" && h.afterRun && h.evals <= h.failures {
+		return errors.New("i/o timeout")
+	}
+	return nil
+}
+
+func (h *flakyHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *flakyHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// TestSlidingWindow_Retry tests retries of the check script using a real Redis instance
+func TestSlidingWindow_Retry(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		hook          *flakyHook
+		expectErr     bool
+		expectedEvals int
+	}{
+		{name: "transient error is retried once", hook: &flakyHook{failures: 1}, expectedEvals: 2},
+		{name: "error after the script ran is not retried", hook: &flakyHook{failures: 1, afterRun: true}, expectErr: true, expectedEvals: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flaky := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+			defer flaky.Close()
+			flaky.AddHook(tt.hook)
+
+			sw := ratelimiter.NewSlidingWindow(flaky, zap.NewNop(), ratelimiter.WithRetry(2, time.Millisecond))
+			userID := "test_user_retry"
+			_ = sw.Reset(ctx, userID, time.Second)
+			defer sw.Reset(ctx, userID, time.Second)
+
+			allowed, err := sw.Allow(ctx, userID, 5, time.Second)
+			if tt.expectErr && err == nil {
+				t.Fatal("expected an error")
+			}
+			if !tt.expectErr && (err != nil || !allowed) {
+				t.Fatalf("expected request to be allowed, got %v, %v", allowed, err)
+			}
+			if tt.hook.evals != tt.expectedEvals {
+				t.Errorf("expected %d script runs, got %d", tt.expectedEvals, tt.hook.evals)
+			}
+
+			// Either way the request was counted exactly once
+			if n := client.ZCard(ctx, "rate_limit:sliding:"+userID).Val(); n != 1 {
+				t.Errorf("expected 1 entry in the window, got %d", n)
+			}
+		})
+	}
+}
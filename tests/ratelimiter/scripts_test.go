@@ -0,0 +1,166 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"ratelimit-challenge/internal/config"
+	service "ratelimit-challenge/internal/service/ratelimiter"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"go.uber.org/zap"
+)
+
+// TestLoadScripts checks that scripts are compiled by Redis up front
+// This is an integration test that requires Redis to be running
+func TestLoadScripts(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	t.Run("limiter scripts are valid", func(t *testing.T) {
+		svc := service.NewService(client, &config.RateLimitConfig{
+			DefaultLimit: 10,
+			WindowSize:   1,
+			Algorithm:    "sliding_window",
+		}, zap.NewNop())
+
+		if err := svc.ValidateScripts(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("broken script fails with its name", func(t *testing.T) {
+		scripts := ratelimiter.Scripts()
+		scripts["broken"] = `
+			local count = redis.call('ZCARD', KEYS[1]
+			return count
+		`
+
+		_, err := ratelimiter.LoadScripts(ctx, client, scripts)
+		if err == nil {
+			t.Fatal("expected an error for a broken script")
+		}
+		if !strings.Contains(err.Error(), "invalid broken script") {
+			t.Errorf("expected the error to name the broken script, got %v", err)
+		}
+	})
+}
+
+// commandHook records the names of the commands run
+type commandHook struct {
+	names []string
+}
+
+func (h *commandHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	h.names = append(h.names, cmd.Name())
+	return ctx, nil
+}
+
+func (h *commandHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *commandHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *commandHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// TestScripts_NoScriptFallback checks that limiters run their scripts by
+// SHA1, and still run them once Redis has forgotten them, e.g. after a
+// restart or SCRIPT FLUSH
+// This is an integration test that requires Redis to be running
+func TestScripts_NoScriptFallback(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	hook := &commandHook{}
+	client.AddHook(hook)
+
+	sw := ratelimiter.NewSlidingWindow(client, zap.NewNop())
+	userID := "test_user_noscript"
+	_ = sw.Reset(ctx, userID, time.Minute)
+	defer sw.Reset(ctx, userID, time.Minute)
+
+	if err := client.ScriptFlush(ctx).Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// allow makes a request, returning the commands it ran
+	allow := func(expected bool) []string {
+		t.Helper()
+		hook.names = nil
+		allowed, err := sw.Allow(ctx, userID, 1, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allowed != expected {
+			t.Errorf("expected allowed=%v, got %v", expected, allowed)
+		}
+		return hook.names
+	}
+
+	// The flushed script is sent again once, then run by its SHA1
+	if names := allow(true); strings.Join(names, ",") != "evalsha,eval" {
+		t.Errorf("expected EVALSHA to fall back to EVAL after the flush, got %v", names)
+	}
+	if names := allow(false); strings.Join(names, ",") != "evalsha" {
+		t.Errorf("expected the cached script to run with EVALSHA alone, got %v", names)
+	}
+}
+
+// TestScripts_ServiceRunsBySHA checks that the service's own scripts, like
+// the limiters', are sent by their SHA1 rather than in full
+func TestScripts_ServiceRunsBySHA(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	svc := service.NewService(db, &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   1,
+		Algorithm:    "sliding_window",
+		ResetLimit:   3,
+		ResetWindow:  3600,
+		EgressLimit:  1024,
+		EgressWindow: 60,
+	}, zap.NewNop())
+	ctx := context.Background()
+
+	// Everything but the SHA1 itself must match
+	bySHA := mock.CustomMatch(func(expected, actual []interface{}) error {
+		if fmt.Sprint(expected[2:]) != fmt.Sprint(actual[2:]) {
+			return fmt.Errorf("expected %v, got %v", expected, actual)
+		}
+		return nil
+	})
+	bySHA.ExpectEvalSha("", []string{"rate_limit:resets:user1"}, "3600000").SetVal(int64(1))
+	bySHA.ExpectEvalSha("", []string{"rate_limit:egress:user1"}, "512", "60000").SetVal(int64(512))
+
+	if allowed, err := svc.AllowReset(ctx, "user1"); err != nil || !allowed {
+		t.Errorf("expected the reset to be allowed, got %v (%v)", allowed, err)
+	}
+	if err := svc.ConsumeEgress(ctx, "user1", 512); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
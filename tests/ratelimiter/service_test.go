@@ -2,15 +2,26 @@ package ratelimiter
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
 	"ratelimit-challenge/internal/config"
 	"ratelimit-challenge/internal/service/ratelimiter"
+	"ratelimit-challenge/pkg/audit"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/go-redis/redismock/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 // TestService_RateLimit tests the RateLimit function using a real Redis instance
@@ -94,6 +105,68 @@ func TestService_RateLimit(t *testing.T) {
 	_ = service.Reset(ctx, userID)
 }
 
+// TestService_ReservedIdentities checks that user IDs can't produce the keys
+// of reserved names or of other users' counters
+// This is an integration test that requires Redis to be running
+func TestService_ReservedIdentities(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   1,
+		Algorithm:    "sliding_window",
+	}, zap.NewNop())
+
+	t.Run("reserved name is namespaced", func(t *testing.T) {
+		defer client.Del(ctx, "rate_limit:sliding:%global", "rate_limit:config:%global")
+
+		if err := service.SetUserLimit(ctx, "global", 3); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := service.RateLimit(ctx, "global", 10); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for key, expected := range map[string]int64{
+			"rate_limit:sliding:%global": 1,
+			"rate_limit:config:%global":  1,
+			"rate_limit:sliding:global":  0,
+			"rate_limit:config:global":   0,
+		} {
+			if n := client.Exists(ctx, key).Val(); n != expected {
+				t.Errorf("expected %s to exist %d time(s), got %d", key, expected, n)
+			}
+		}
+	})
+
+	t.Run("separators are escaped", func(t *testing.T) {
+		userID := "test_escape_user"
+		defer client.Del(ctx, "rate_limit:sliding:"+userID+":v2", "rate_limit:sliding:"+userID+"%3Av2")
+
+		// A user whose ID looks like another user's versioned key
+		allowed, err := service.RateLimit(ctx, userID+":v2", 1)
+		if err != nil || !allowed {
+			t.Fatalf("expected the first request to be allowed, got %v, %v", allowed, err)
+		}
+
+		allowed, err = service.RateLimit(ratelimiter.WithAPIVersion(ctx, "v2"), userID, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !allowed {
+			t.Error("expected the v2 counter to be isolated from user " + userID + ":v2")
+		}
+	})
+}
+
 func TestService_SetUserLimit(t *testing.T) {
 	db, mock := redismock.NewClientMock()
 	logger := zap.NewNop()
@@ -112,7 +185,7 @@ func TestService_SetUserLimit(t *testing.T) {
 		userID := "user789"
 		limit := 50
 
-		mock.ExpectSet("rate_limit:config:user789", limit, 60*time.Second).SetVal("OK")
+		mock.ExpectSet("rate_limit:config:user789", limit, 0).SetVal("OK")
 
 		err := service.SetUserLimit(ctx, userID, limit)
 		if err != nil {
@@ -125,6 +198,307 @@ func TestService_SetUserLimit(t *testing.T) {
 	})
 }
 
+// TestService_SetUserLimit_Persistence checks that custom limits outlive the
+// local cache TTL
+func TestService_SetUserLimit_Persistence(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("configured ttl", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, &config.RateLimitConfig{
+			DefaultLimit:  10,
+			WindowSize:    1,
+			Algorithm:     "sliding_window",
+			LocalCacheTTL: 60,
+			ConfigTTL:     86400,
+		}, zap.NewNop())
+
+		mock.ExpectSet("rate_limit:config:user789", 50, 24*time.Hour).SetVal("OK")
+
+		if err := service.SetUserLimit(ctx, "user789", 50); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	// This is an integration test that requires Redis to be running
+	t.Run("persistent by default", func(t *testing.T) {
+		client := redis.NewClient(&redis.Options{
+			Addr: "localhost:6379",
+		})
+		defer client.Close()
+
+		if err := client.Ping(ctx).Err(); err != nil {
+			t.Skipf("Skipping integration test: Redis not available: %v", err)
+		}
+
+		userID := "test_persistent_limit"
+		key := "rate_limit:config:" + userID
+		defer client.Del(ctx, key)
+
+		service := ratelimiter.NewService(client, &config.RateLimitConfig{
+			DefaultLimit:  10,
+			WindowSize:    1,
+			Algorithm:     "sliding_window",
+			LocalCacheTTL: 1,
+		}, zap.NewNop())
+
+		if err := service.SetUserLimit(ctx, userID, 50); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ttl, err := client.TTL(ctx, key).Result()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ttl != -1 {
+			t.Errorf("expected the limit to have no expiry, got ttl %v", ttl)
+		}
+
+		// Outlive the local cache, so the limit is read back from Redis
+		time.Sleep(1100 * time.Millisecond)
+		stats, err := service.GetStats(ctx, userID, 0)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stats.Limit != 50 {
+			t.Errorf("expected the custom limit 50 after the cache expired, got %d", stats.Limit)
+		}
+	})
+}
+
+// TestService_GetUserLimit checks that a configured limit is read back,
+// that a missing one reports the default instead of an error, and that the
+// local cache is consulted before Redis
+func TestService_GetUserLimit(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:  10,
+		WindowSize:    1,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}
+
+	tests := []struct {
+		name       string
+		expect     func(mock redismock.ClientMock)
+		limit      int
+		configured bool
+		wantErr    bool
+	}{
+		{
+			name: "configured",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:config:user1").SetVal("50")
+			},
+			limit:      50,
+			configured: true,
+		},
+		{
+			name: "not configured",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:config:user1").RedisNil()
+				mock.ExpectGet("rate_limit:default").RedisNil()
+			},
+			limit: 10,
+		},
+		{
+			name: "redis error",
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectGet("rate_limit:config:user1").SetErr(errors.New("connection reset"))
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+			tt.expect(mock)
+			limit, configured, err := service.GetUserLimit(ctx, "user1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if limit != tt.limit || configured != tt.configured {
+				t.Errorf("expected limit %d configured %v, got %d %v", tt.limit, tt.configured, limit, configured)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		cached := *cfg
+		cached.EnableLocalCache = true
+		service := ratelimiter.NewService(db, &cached, zap.NewNop())
+
+		// Only the first lookup reaches Redis
+		mock.ExpectGet("rate_limit:config:user1").SetVal("50")
+		for i := 0; i < 2; i++ {
+			limit, configured, err := service.GetUserLimit(ctx, "user1")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if limit != 50 || !configured {
+				t.Errorf("lookup %d: expected configured limit 50, got %d %v", i+1, limit, configured)
+			}
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestService_DeleteUserLimit checks that deleting a custom limit removes it
+// from Redis and the local cache, and that deleting a missing one succeeds
+func TestService_DeleteUserLimit(t *testing.T) {
+	ctx := context.Background()
+	db, mock := redismock.NewClientMock()
+	service := ratelimiter.NewService(db, &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       1,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: true,
+		LocalCacheTTL:    60,
+	}, zap.NewNop())
+
+	mock.ExpectSet("rate_limit:config:user1", 50, 0).SetVal("OK")
+	if err := service.SetUserLimit(ctx, "user1", 50); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mock.ExpectDel("rate_limit:config:user1").SetVal(1)
+	if err := service.DeleteUserLimit(ctx, "user1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The cached limit is gone, so it is looked up again
+	mock.ExpectGet("rate_limit:config:user1").RedisNil()
+	mock.ExpectGet("rate_limit:default").RedisNil()
+	limit, configured, err := service.GetUserLimit(ctx, "user1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if limit != 10 || configured {
+		t.Errorf("expected the default limit 10 after deleting, got %d configured %v", limit, configured)
+	}
+
+	mock.ExpectDel("rate_limit:config:user2").SetVal(0)
+	if err := service.DeleteUserLimit(ctx, "user2"); err != nil {
+		t.Errorf("expected deleting a missing limit to succeed, got %v", err)
+	}
+
+	mock.ExpectDel("rate_limit:config:user3").SetErr(errors.New("connection reset"))
+	if err := service.DeleteUserLimit(ctx, "user3"); err == nil {
+		t.Error("expected an error when Redis fails")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestService_BlockedUser checks that a blocked user is denied every request
+// while a user without a custom limit, or a stored limit of 0, gets the default
+// This is an integration test that requires Redis to be running
+func TestService_BlockedUser(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:  2,
+		WindowSize:    10,
+		Algorithm:     "sliding_window",
+		LocalCacheTTL: 60,
+	}, zap.NewNop())
+
+	check := func(t *testing.T, userID string, want ...bool) ratelimiter.Decision {
+		t.Helper()
+		var decision ratelimiter.Decision
+		for i, expected := range want {
+			var err error
+			decision, err = service.Check(ctx, userID, 2)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Allowed != expected {
+				t.Errorf("request %d: expected allowed=%v, got %+v", i+1, expected, decision)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return decision
+	}
+
+	t.Run("blocked user", func(t *testing.T) {
+		userID := "test_user_blocked"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+		defer client.Del(ctx, "rate_limit:config:"+userID)
+
+		if err := service.BlockUser(ctx, userID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if val := client.Get(ctx, "rate_limit:config:"+userID).Val(); val != "blocked" {
+			t.Errorf("expected the block stored as %q, got %q", "blocked", val)
+		}
+
+		decision := check(t, userID, false)
+		if decision.Code != ratelimiter.CodeBlocked || decision.Reason != ratelimiter.ReasonBlocked {
+			t.Errorf("expected a blocked decision, got %+v", decision)
+		}
+		if allowed, err := service.RateLimitN(ctx, userID, 2, 1); err != nil || allowed {
+			t.Errorf("expected RateLimitN to deny a blocked user, got %v, %v", allowed, err)
+		}
+		if remaining, err := service.GetRemaining(ctx, userID, 2); err != nil || remaining != 0 {
+			t.Errorf("expected 0 remaining, got %d, %v", remaining, err)
+		}
+		if blocked, err := service.IsBlocked(ctx, userID); err != nil || !blocked {
+			t.Errorf("expected IsBlocked to report the block, got %v, %v", blocked, err)
+		}
+
+		// Setting a limit lifts the block
+		if err := service.SetUserLimit(ctx, userID, 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		check(t, userID, true, false)
+	})
+
+	t.Run("no custom limit", func(t *testing.T) {
+		userID := "test_user_not_blocked"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		check(t, userID, true, true, false)
+		if blocked, err := service.IsBlocked(ctx, userID); err != nil || blocked {
+			t.Errorf("expected IsBlocked to be false, got %v, %v", blocked, err)
+		}
+	})
+
+	t.Run("stored limit of 0", func(t *testing.T) {
+		userID := "test_user_zero_limit"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+		defer client.Del(ctx, "rate_limit:config:"+userID)
+
+		if err := service.SetUserLimit(ctx, userID, 0); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		check(t, userID, true, true, false)
+	})
+}
+
 func TestService_GetRemaining(t *testing.T) {
 	db, mock := redismock.NewClientMock()
 	logger := zap.NewNop()
@@ -145,12 +519,15 @@ func TestService_GetRemaining(t *testing.T) {
 
 		// Mock: Check for user limit
 		mock.ExpectGet("rate_limit:config:user999").RedisNil()
+		mock.ExpectGet("rate_limit:default").RedisNil()
 
-		// Mock: Get remaining (pipeline)
+		// Mock: Get remaining, counting the entries inside the window
+		// The service computes the window start a moment later, possibly in
+		// the next millisecond
 		now := time.Now()
 		windowStart := now.Add(-1 * time.Second).UnixMilli()
-		mock.ExpectZRemRangeByScore("rate_limit:sliding:user999", "-inf", strconv.FormatInt(windowStart, 10)).SetVal(0)
-		mock.ExpectZCard("rate_limit:sliding:user999").SetVal(5)
+		windowStartPattern := "^\\((" + strconv.FormatInt(windowStart, 10) + "|" + strconv.FormatInt(windowStart+1, 10) + ")$"
+		mock.Regexp().ExpectZCount("rate_limit:sliding:user999", windowStartPattern, `\+inf`).SetVal(5)
 
 		remaining, err := service.GetRemaining(ctx, userID, limit)
 		if err != nil {
@@ -185,6 +562,8 @@ func TestService_Reset(t *testing.T) {
 		userID := "user111"
 
 		mock.ExpectDel("rate_limit:sliding:user111").SetVal(1)
+		mock.ExpectDel("rate_limit:sliding:{user111}:burst").SetVal(0)
+		mock.ExpectDel("rate_limit:sliding:{user111}:sustained").SetVal(0)
 
 		err := service.Reset(ctx, userID)
 		if err != nil {
@@ -195,4 +574,2237 @@ func TestService_Reset(t *testing.T) {
 			t.Error(err)
 		}
 	})
+
+	// Braces in an ID are escaped, so it can't choose its cluster hash slot
+	t.Run("hash tag in user id", func(t *testing.T) {
+		mock.ExpectDel("rate_limit:sliding:%7Bvip%7D").SetVal(1)
+		mock.ExpectDel("rate_limit:sliding:{%7Bvip%7D}:burst").SetVal(0)
+		mock.ExpectDel("rate_limit:sliding:{%7Bvip%7D}:sustained").SetVal(0)
+
+		if err := service.Reset(ctx, "{vip}"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestService_ResetCounterOnly tests that a counter-only reset keeps when the
+// user was first seen, and their custom limit, while Reset forgets the former
+// This is an integration test that requires Redis to be running
+func TestService_ResetCounterOnly(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:    2,
+		WindowSize:      10,
+		Algorithm:       "sliding_window",
+		LocalCacheTTL:   60,
+		OnboardingGrace: true,
+	}, zap.NewNop())
+
+	userID := "test_user_reset_counter_only"
+	onboardedKey := "rate_limit:onboarded:" + userID
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+	defer service.DeleteUserLimit(ctx, userID)
+
+	if err := service.SetUserLimit(ctx, userID, 3); err != nil {
+		t.Fatalf("SetUserLimit failed: %v", err)
+	}
+	// The first request is the onboarding grace, the rest use up the limit
+	for i := 0; i < 4; i++ {
+		if allowed, err := service.RateLimit(ctx, userID, 2); err != nil || !allowed {
+			t.Fatalf("expected request %d to be allowed, got %v (%v)", i+1, allowed, err)
+		}
+	}
+
+	if err := service.ResetCounterOnly(ctx, userID); err != nil {
+		t.Fatalf("ResetCounterOnly failed: %v", err)
+	}
+	if n := client.Exists(ctx, onboardedKey).Val(); n != 1 {
+		t.Errorf("expected first-seen to survive a counter-only reset, got %d keys", n)
+	}
+	if limit, _, err := service.GetUserLimit(ctx, userID); err != nil || limit != 3 {
+		t.Errorf("expected the custom limit to survive a counter-only reset, got %d (%v)", limit, err)
+	}
+	if remaining, _ := service.GetRemaining(ctx, userID, 2); remaining != 3 {
+		t.Errorf("expected the counter cleared, got %d remaining", remaining)
+	}
+
+	if err := service.Reset(ctx, userID); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if n := client.Exists(ctx, onboardedKey).Val(); n != 0 {
+		t.Errorf("expected Reset to forget first-seen, got %d keys", n)
+	}
+	if limit, _, err := service.GetUserLimit(ctx, userID); err != nil || limit != 3 {
+		t.Errorf("expected the custom limit to survive a reset, got %d (%v)", limit, err)
+	}
+}
+
+// TestService_PenaltyBox tests that heavy overage triggers a timed hard deny
+// This is an integration test that requires Redis to be running
+func TestService_PenaltyBox(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:      2,
+		WindowSize:        1,
+		Algorithm:         "sliding_window",
+		EnableLocalCache:  false,
+		LocalCacheTTL:     60,
+		PenaltyMultiplier: 2,
+		PenaltyDuration:   2,
+	}
+	service := ratelimiter.NewService(client, cfg, zap.NewNop())
+
+	userID := "test_user_penalty"
+	limit := 2
+	penaltyKey := "rate_limit:penalty:" + userID
+	_ = service.Reset(ctx, userID)
+	client.Del(ctx, penaltyKey, "{rate_limit:penalty:"+userID+"}:overage")
+	defer client.Del(ctx, penaltyKey, "{rate_limit:penalty:"+userID+"}:overage")
+	defer service.Reset(ctx, userID)
+
+	// Use up the limit, then exceed it by PenaltyMultiplier times the limit
+	for i := 0; i < limit+limit*cfg.PenaltyMultiplier; i++ {
+		if _, err := service.RateLimit(ctx, userID, limit); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		// Small delay to keep request timestamps distinct
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if n := client.Exists(ctx, penaltyKey).Val(); n != 1 {
+		t.Fatal("expected user to be placed in the penalty box")
+	}
+
+	// The window has passed, but the penalty is still in effect
+	time.Sleep(1100 * time.Millisecond)
+	allowed, err := service.RateLimit(ctx, userID, limit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if allowed {
+		t.Error("expected request to be denied while in the penalty box")
+	}
+
+	// Once the penalty expires normal limiting resumes
+	time.Sleep(1100 * time.Millisecond)
+	allowed, err = service.RateLimit(ctx, userID, limit)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !allowed {
+		t.Error("expected request to be allowed after the penalty expired")
+	}
+}
+
+func TestService_AllowAll(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := ratelimiter.NewService(client, cfg, zap.NewNop())
+
+	checks := []ratelimiter.Check{
+		{Key: "test_allow_all_user", Limit: 5, Window: 10 * time.Second},
+		{Key: "test_allow_all_tenant", Limit: 1, Window: 10 * time.Second},
+		{Key: "test_allow_all_global", Limit: 100, Window: 10 * time.Second},
+	}
+	keys := make([]string, len(checks))
+	for i, check := range checks {
+		keys[i] = "rate_limit:sliding:" + check.Key
+	}
+	client.Del(ctx, keys...)
+	defer client.Del(ctx, keys...)
+
+	t.Run("all pass consumes from every bucket", func(t *testing.T) {
+		allowed, results, err := service.AllowAll(ctx, checks)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !allowed {
+			t.Fatal("expected request to be allowed")
+		}
+		for i, result := range results {
+			if result.Remaining != checks[i].Limit-1 {
+				t.Errorf("expected %s remaining %d, got %d", result.Key, checks[i].Limit-1, result.Remaining)
+			}
+		}
+	})
+
+	time.Sleep(5 * time.Millisecond)
+
+	t.Run("one failing check consumes from none", func(t *testing.T) {
+		allowed, results, err := service.AllowAll(ctx, checks)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allowed {
+			t.Fatal("expected request to be denied")
+		}
+		if results[1].Allowed {
+			t.Error("expected the tenant check to report the denial")
+		}
+		for i, key := range keys {
+			if n := client.ZCard(ctx, key).Val(); n != 1 {
+				t.Errorf("expected check %d to hold 1 entry, got %d", i, n)
+			}
+		}
+	})
+}
+
+// TestService_DynamicDefaultLimit tests the Redis-backed default limit using mock Redis
+func TestService_DynamicDefaultLimit(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	defer db.Close()
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       1,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := ratelimiter.NewService(db, cfg, zap.NewNop())
+	ctx := context.Background()
+
+	t.Run("dynamic default overrides config and is cached", func(t *testing.T) {
+		// The first lookup reads the dynamic default from Redis
+		mock.ExpectGet("rate_limit:config:user222").RedisNil()
+		mock.ExpectGet("rate_limit:default").SetVal("25")
+		mock.Regexp().ExpectZCount("rate_limit:sliding:user222", `\(\d+`, `\+inf`).SetVal(5)
+
+		// The second one is served from the local cache
+		mock.ExpectGet("rate_limit:config:user222").RedisNil()
+		mock.Regexp().ExpectZCount("rate_limit:sliding:user222", `\(\d+`, `\+inf`).SetVal(5)
+
+		for i := 0; i < 2; i++ {
+			remaining, err := service.GetRemaining(ctx, "user222", cfg.DefaultLimit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if remaining != 20 {
+				t.Errorf("expected remaining 20, got %d", remaining)
+			}
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestService_LimitUnit tests how the limit is scaled by the window using mock Redis
+func TestService_LimitUnit(t *testing.T) {
+	tests := []struct {
+		name              string
+		limitUnit         string
+		expectedRemaining int
+	}{
+		{name: "per window", limitUnit: "per_window", expectedRemaining: 2},
+		{name: "per second", limitUnit: "per_second", expectedRemaining: 47},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			defer db.Close()
+
+			cfg := &config.RateLimitConfig{
+				DefaultLimit:     5,
+				WindowSize:       10,
+				LimitUnit:        tt.limitUnit,
+				Algorithm:        "sliding_window",
+				EnableLocalCache: false,
+				LocalCacheTTL:    60,
+			}
+			service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+			mock.ExpectGet("rate_limit:config:user333").RedisNil()
+			mock.ExpectGet("rate_limit:default").RedisNil()
+			mock.Regexp().ExpectZCount("rate_limit:sliding:user333", `\(\d+`, `\+inf`).SetVal(3)
+
+			remaining, err := service.GetRemaining(context.Background(), "user333", cfg.DefaultLimit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if remaining != tt.expectedRemaining {
+				t.Errorf("expected remaining %d, got %d", tt.expectedRemaining, remaining)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestService_ShadowAlgorithm tests shadow mode using a real Redis instance
+func TestService_ShadowAlgorithm(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     2,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		ShadowAlgorithm:  "leaky_bucket",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := ratelimiter.NewService(client, cfg, zap.New(core))
+
+	userID := "test_user_shadow"
+	keys := []string{"rate_limit:sliding:" + userID, "rate_limit:leaky:" + userID}
+	client.Del(ctx, keys...)
+	defer client.Del(ctx, keys...)
+
+	// A full shadow bucket makes the shadow algorithm deny every request
+	client.HSet(ctx, "rate_limit:leaky:"+userID, "level", 100, "last_update", time.Now().UnixMilli())
+
+	allowed, err := service.RateLimit(ctx, userID, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !allowed {
+		t.Error("expected the enforced algorithm to allow the request")
+	}
+
+	entries := logs.FilterMessage("shadow rate limit decision").All()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 shadow decision to be recorded, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["algorithm"] != "leaky_bucket" || fields["shadow_allowed"] != false || fields["agrees"] != false {
+		t.Errorf("unexpected shadow decision fields: %v", fields)
+	}
+}
+
+// TestService_GetRemainingAt tests projecting remaining requests using a real Redis instance
+func TestService_GetRemainingAt(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     5,
+		WindowSize:       1,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := ratelimiter.NewService(client, cfg, zap.NewNop())
+
+	userID := "test_user_remaining_at"
+	key := "rate_limit:sliding:" + userID
+	client.Del(ctx, key)
+	defer client.Del(ctx, key)
+
+	// Three requests made 800ms, 500ms and 0ms ago
+	now := time.Now()
+	for _, age := range []time.Duration{800 * time.Millisecond, 500 * time.Millisecond, 0} {
+		ts := now.Add(-age).UnixMilli()
+		client.ZAdd(ctx, key, &redis.Z{Score: float64(ts), Member: ts})
+	}
+
+	tests := []struct {
+		name              string
+		after             time.Duration
+		expectedRemaining int
+	}{
+		{name: "now", after: 0, expectedRemaining: 2},
+		{name: "oldest aged out", after: 300 * time.Millisecond, expectedRemaining: 3},
+		{name: "two aged out", after: 600 * time.Millisecond, expectedRemaining: 4},
+		{name: "all aged out", after: 1100 * time.Millisecond, expectedRemaining: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			remaining, err := service.GetRemainingAt(ctx, userID, 5, time.Second, now.Add(tt.after))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if remaining != tt.expectedRemaining {
+				t.Errorf("expected remaining %d, got %d", tt.expectedRemaining, remaining)
+			}
+		})
+	}
+}
+
+// TestService_Campaign tests the shared campaign pool using a real Redis instance
+func TestService_Campaign(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     100,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := ratelimiter.NewService(client, cfg, zap.NewNop())
+
+	users := []string{"test_user_campaign_a", "test_user_campaign_b"}
+	for _, userID := range users {
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+	}
+	defer service.EndCampaign(ctx)
+
+	err := service.StartCampaign(ctx, ratelimiter.Campaign{
+		Name:   "launch",
+		Total:  3,
+		EndsAt: time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("pool depletes across users", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			decision, err := service.Check(ctx, users[i%2], 100)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !decision.Allowed {
+				t.Errorf("expected request %d to be allowed", i+1)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		campaign, err := service.GetCampaign(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if campaign == nil || campaign.Used != 3 {
+			t.Errorf("expected 3 requests used from the pool, got %+v", campaign)
+		}
+	})
+
+	t.Run("exhausted pool denies everyone", func(t *testing.T) {
+		for _, userID := range users {
+			decision, err := service.Check(ctx, userID, 100)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Allowed || decision.Code != ratelimiter.CodeGlobalLimited {
+				t.Errorf("expected %s to be denied with %s, got %+v", userID, ratelimiter.CodeGlobalLimited, decision)
+			}
+		}
+	})
+
+	t.Run("ending the campaign lifts the denial", func(t *testing.T) {
+		if err := service.EndCampaign(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		decision, err := service.Check(ctx, users[0], 100)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed {
+			t.Error("expected request to be allowed after the campaign ended")
+		}
+	})
+}
+
+// TestService_UserTiers tests that a user's burst and sustained limits are
+// both enforced
+// This is an integration test that requires Redis to be running
+func TestService_UserTiers(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     100,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	service := ratelimiter.NewService(client, cfg, zap.NewNop())
+
+	check := func(t *testing.T, userID string, want ...bool) {
+		t.Helper()
+		for i, expected := range want {
+			decision, err := service.Check(ctx, userID, 100)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Allowed != expected {
+				t.Errorf("request %d: expected allowed=%v, got %+v", i+1, expected, decision)
+			}
+			if !expected && decision.Code != ratelimiter.CodeRateLimited {
+				t.Errorf("request %d: expected code %s, got %s", i+1, ratelimiter.CodeRateLimited, decision.Code)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	t.Run("burst and sustained tiers", func(t *testing.T) {
+		userID := "test_user_tiers"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+		defer client.Del(ctx, "rate_limit:config:"+userID)
+
+		err := service.SetUserTiers(ctx, userID, ratelimiter.UserTiers{
+			Burst:     ratelimiter.Tier{Limit: 3, Window: 1},
+			Sustained: ratelimiter.Tier{Limit: 5, Window: 60},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The burst tier denies the fourth request within a second
+		check(t, userID, true, true, true, false)
+
+		// Once the burst window passes, the sustained tier caps the total
+		time.Sleep(1100 * time.Millisecond)
+		check(t, userID, true, true, false)
+
+		remaining, err := service.GetRemaining(ctx, userID, 100)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != 0 {
+			t.Errorf("expected 0 remaining, got %d", remaining)
+		}
+	})
+
+	t.Run("tiers stored as JSON", func(t *testing.T) {
+		userID := "test_user_tiers_json"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+		defer client.Del(ctx, "rate_limit:config:"+userID)
+
+		tiers := `{"burst":{"limit":2,"window":1},"sustained":{"limit":10,"window":60}}`
+		if err := client.Set(ctx, "rate_limit:config:"+userID, tiers, time.Minute).Err(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		check(t, userID, true, true, false)
+	})
+
+	t.Run("bare integer limit", func(t *testing.T) {
+		userID := "test_user_tiers_int"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+		defer client.Del(ctx, "rate_limit:config:"+userID)
+
+		if err := service.SetUserLimit(ctx, userID, 2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		check(t, userID, true, true, false)
+	})
+
+	t.Run("invalid tiers are rejected", func(t *testing.T) {
+		err := service.SetUserTiers(ctx, "test_user_tiers_invalid", ratelimiter.UserTiers{
+			Burst: ratelimiter.Tier{Limit: 3, Window: 1},
+		})
+		if err == nil {
+			t.Error("expected an error for a missing sustained tier")
+		}
+	})
+}
+
+// TestService_OnboardingGrace tests that a new user's first request is allowed
+// even when their window is already full
+// This is an integration test that requires Redis to be running
+func TestService_OnboardingGrace(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := config.RateLimitConfig{
+		DefaultLimit:     2,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}
+	// Shares the window with the service under test, but has no grace
+	filler := ratelimiter.NewService(client, &cfg, zap.NewNop())
+	graceCfg := cfg
+	graceCfg.OnboardingGrace = true
+	service := ratelimiter.NewService(client, &graceCfg, zap.NewNop())
+
+	userID := "test_user_onboarding"
+	onboardedKey := "rate_limit:onboarded:" + userID
+	_ = service.Reset(ctx, userID)
+	client.Del(ctx, onboardedKey)
+	defer service.Reset(ctx, userID)
+	defer client.Del(ctx, onboardedKey)
+
+	// Fill the window, e.g. as a shared IP bucket would be
+	for i := 0; i < 2; i++ {
+		if _, err := filler.Check(ctx, userID, 2); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Run("first request is allowed", func(t *testing.T) {
+		decision, err := service.Check(ctx, userID, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !decision.Allowed {
+			t.Errorf("expected the first request to be allowed, got %+v", decision)
+		}
+	})
+
+	t.Run("later requests obey the limit", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			decision, err := service.Check(ctx, userID, 2)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if decision.Allowed || decision.Code != ratelimiter.CodeRateLimited {
+				t.Errorf("expected request %d to be rate limited, got %+v", i+2, decision)
+			}
+		}
+	})
+}
+
+// TestService_OnboardingGrace_Blocked tests that the grace never lets a
+// blocked user through, and that users are only looked up once per instance
+func TestService_OnboardingGrace_Blocked(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:    10,
+		WindowSize:      1,
+		Algorithm:       "sliding_window",
+		LocalCacheTTL:   60,
+		OnboardingGrace: true,
+	}
+
+	t.Run("blocked new user", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+		expectNoCampaign(mock)
+		mock.ExpectGet("rate_limit:config:user1").SetVal("blocked")
+
+		decision, err := service.Check(context.Background(), "user1", 10)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if decision.Allowed || decision.Code != ratelimiter.CodeBlocked {
+			t.Errorf("expected a blocked new user to be denied, got %+v", decision)
+		}
+		// Their grace is left unused
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("onboarded user", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+		check := mock.CustomMatch(func(expected, actual []interface{}) error {
+			return nil
+		})
+		expectNoCampaign(mock)
+		mock.ExpectGet("rate_limit:config:user1").RedisNil()
+		mock.ExpectSetNX("rate_limit:onboarded:user1", 1, 30*24*time.Hour).SetVal(false)
+		mock.ExpectGet("rate_limit:default").RedisNil()
+		check.ExpectEvalSha("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(9)})
+		// The second request doesn't ask whether they're onboarded again
+		mock.ExpectGet("rate_limit:config:user1").RedisNil()
+		check.ExpectEvalSha("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(8)})
+
+		for i := 0; i < 2; i++ {
+			decision, err := service.Check(context.Background(), "user1", 10)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !decision.Allowed || decision.Reason != ratelimiter.ReasonUnderLimit {
+				t.Errorf("request %d: expected to be allowed under the limit, got %+v", i+1, decision)
+			}
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestService_CacheMetrics tests that local cache lookups of custom limits
+// are counted
+// This is an integration test that requires Redis to be running
+func TestService_CacheMetrics(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       1,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: true,
+		LocalCacheTTL:    60,
+	}
+	registry := prometheus.NewRegistry()
+	service := ratelimiter.NewService(client, cfg, zap.NewNop(),
+		ratelimiter.WithCacheMetrics(ratelimiter.NewCacheMetrics(registry)),
+	)
+
+	customUser := "test_user_cache_custom"
+	defaultUser := "test_user_cache_default"
+	client.Del(ctx, "rate_limit:config:"+defaultUser)
+	if err := client.Set(ctx, "rate_limit:config:"+customUser, 5, time.Minute).Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer client.Del(ctx, "rate_limit:config:"+customUser)
+
+	// The first lookup of each user misses; repeated ones are served locally
+	for _, lookup := range []string{customUser, customUser, customUser, defaultUser, defaultUser} {
+		if _, err := service.GetRemaining(ctx, lookup, 10); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := family.GetName()
+			for _, label := range metric.GetLabel() {
+				name += "/" + label.GetValue()
+			}
+			if metric.GetCounter() != nil {
+				values[name] = metric.GetCounter().GetValue()
+			} else {
+				values[name] = metric.GetGauge().GetValue()
+			}
+		}
+	}
+
+	expected := map[string]float64{
+		"rate_limit_user_limit_cache_lookups_total/hit":          2,
+		"rate_limit_user_limit_cache_lookups_total/miss":         2,
+		"rate_limit_user_limit_cache_lookups_total/negative_hit": 1,
+		"rate_limit_user_limit_cache_hit_ratio":                  0.6,
+	}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("expected %s to be %v, got %v", name, value, values[name])
+		}
+	}
+}
+
+// TestService_CacheEviction checks that the local cache holds at most
+// LocalCacheMaxEntries user limits, evicting the least recently used
+func TestService_CacheEviction(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:         10,
+		WindowSize:           1,
+		Algorithm:            "sliding_window",
+		EnableLocalCache:     true,
+		LocalCacheTTL:        60,
+		LocalCacheMaxEntries: 3,
+	}
+	registry := prometheus.NewRegistry()
+	service := ratelimiter.NewService(db, cfg, zap.NewNop(),
+		ratelimiter.WithCacheMetrics(ratelimiter.NewCacheMetrics(registry)),
+	)
+	ctx := context.Background()
+
+	setLimit := func(userID string, limit int) {
+		t.Helper()
+		mock.ExpectSet("rate_limit:config:"+userID, limit, 0).SetVal("OK")
+		if err := service.SetUserLimit(ctx, userID, limit); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// cached checks the user's limit is served without a Redis lookup
+	cached := func(userID string, expected int) {
+		t.Helper()
+		if limit, _, err := service.GetUserLimit(ctx, userID); err != nil || limit != expected {
+			t.Errorf("expected %s's limit of %d from the cache, got %d, %v", userID, expected, limit, err)
+		}
+	}
+
+	for i := 1; i <= 3; i++ {
+		setLimit("user"+strconv.Itoa(i), i)
+	}
+	// Using user1 makes user2 the least recently used
+	cached("user1", 1)
+	setLimit("user4", 4)
+	cached("user1", 1)
+	cached("user3", 3)
+	cached("user4", 4)
+
+	// user2 was evicted, so it is looked up again, evicting user1
+	mock.ExpectGet("rate_limit:config:user2").SetVal("2")
+	if limit, _, err := service.GetUserLimit(ctx, "user2"); err != nil || limit != 2 {
+		t.Errorf("expected user2's limit of 2 from Redis, got %d, %v", limit, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// However many users are seen, the cache stays at its bound
+	for i := 5; i <= 100; i++ {
+		setLimit("user"+strconv.Itoa(i), i)
+	}
+	cached("user100", 100)
+
+	const expected = `
+# HELP rate_limit_user_limit_cache_entries Custom user limits held in the local cache.
+# TYPE rate_limit_user_limit_cache_entries gauge
+rate_limit_user_limit_cache_entries 3
+# HELP rate_limit_user_limit_cache_evictions_total Custom user limits evicted from the full local cache to make room for others.
+# TYPE rate_limit_user_limit_cache_evictions_total counter
+rate_limit_user_limit_cache_evictions_total 98
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "rate_limit_user_limit_cache_entries", "rate_limit_user_limit_cache_evictions_total"); err != nil {
+		t.Error(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	// Entries still expire, however recently they were used
+	expiring := *cfg
+	expiring.LocalCacheTTL = 0
+	service = ratelimiter.NewService(db, &expiring, zap.NewNop())
+	setLimit("user1", 1)
+	mock.ExpectGet("rate_limit:config:user1").SetVal("1")
+	if limit, _, err := service.GetUserLimit(ctx, "user1"); err != nil || limit != 1 {
+		t.Errorf("expected user1's limit of 1 from Redis, got %d, %v", limit, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// expectNoCampaign expects a service's first check to look up the active
+// campaign and find none, so it skips the campaign script for a while
+func expectNoCampaign(mock redismock.ClientMock) {
+	mock.ExpectHGet("rate_limit:campaign", "ends_at").RedisNil()
+}
+
+// expectCampaign is like expectNoCampaign for a campaign ending in an hour
+func expectCampaign(mock redismock.ClientMock) {
+	endsAt := time.Now().Add(time.Hour).UnixMilli()
+	mock.ExpectHGet("rate_limit:campaign", "ends_at").SetVal(strconv.FormatInt(endsAt, 10))
+}
+
+// TestService_DecisionMetrics checks that each decision reports its reason
+// and counts it under that reason's label
+func TestService_DecisionMetrics(t *testing.T) {
+	// evalShaReturns expects the next EVALSHA of a script taking that many
+	// keys and arguments, whatever their values
+	evalShaReturns := func(mock redismock.ClientMock, keys, args int, result interface{}, err error) {
+		expectation := mock.CustomMatch(func(expected, actual []interface{}) error {
+			return nil
+		}).ExpectEvalSha("", make([]string, keys), make([]interface{}, args)...)
+		if err != nil {
+			expectation.SetErr(err)
+		} else {
+			expectation.SetVal(result)
+		}
+	}
+	noCustomLimit := func(mock redismock.ClientMock) {
+		mock.ExpectGet("rate_limit:config:user1").RedisNil()
+		mock.ExpectGet("rate_limit:default").RedisNil()
+	}
+
+	tests := []struct {
+		name    string
+		cfg     func(cfg *config.RateLimitConfig)
+		expect  func(mock redismock.ClientMock)
+		allowed bool
+		reason  ratelimiter.Reason
+	}{
+		{
+			name: "under limit",
+			expect: func(mock redismock.ClientMock) {
+				expectNoCampaign(mock)
+				noCustomLimit(mock)
+				evalShaReturns(mock, 1, 6, []interface{}{int64(1), int64(10)}, nil)
+			},
+			allowed: true,
+			reason:  ratelimiter.ReasonUnderLimit,
+		},
+		{
+			name: "over limit",
+			expect: func(mock redismock.ClientMock) {
+				expectNoCampaign(mock)
+				noCustomLimit(mock)
+				evalShaReturns(mock, 1, 6, []interface{}{int64(0), int64(0)}, nil)
+			},
+			reason: ratelimiter.ReasonOverLimit,
+		},
+		{
+			name: "blocked",
+			cfg:  func(cfg *config.RateLimitConfig) { cfg.PenaltyMultiplier = 2 },
+			expect: func(mock redismock.ClientMock) {
+				mock.ExpectExists("rate_limit:penalty:user1").SetVal(1)
+			},
+			reason: ratelimiter.ReasonBlocked,
+		},
+		{
+			name: "global limit",
+			expect: func(mock redismock.ClientMock) {
+				expectCampaign(mock)
+				evalShaReturns(mock, 2, 0, int64(0), nil)
+			},
+			reason: ratelimiter.ReasonGlobalLimit,
+		},
+		{
+			name: "degraded",
+			expect: func(mock redismock.ClientMock) {
+				expectNoCampaign(mock)
+				noCustomLimit(mock)
+				evalShaReturns(mock, 1, 6, nil, errors.New("connection reset"))
+			},
+			reason: ratelimiter.ReasonDegraded,
+		},
+		{
+			name: "bypassed",
+			cfg:  func(cfg *config.RateLimitConfig) { cfg.OnboardingGrace = true },
+			expect: func(mock redismock.ClientMock) {
+				expectNoCampaign(mock)
+				mock.ExpectGet("rate_limit:config:user1").RedisNil()
+				mock.ExpectSetNX("rate_limit:onboarded:user1", 1, 30*24*time.Hour).SetVal(true)
+			},
+			allowed: true,
+			reason:  ratelimiter.ReasonBypassed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			cfg := &config.RateLimitConfig{
+				DefaultLimit: 10,
+				WindowSize:   1,
+				Algorithm:    "sliding_window",
+			}
+			if tt.cfg != nil {
+				tt.cfg(cfg)
+			}
+			registry := prometheus.NewRegistry()
+			service := ratelimiter.NewService(db, cfg, zap.NewNop(),
+				ratelimiter.WithDecisionMetrics(ratelimiter.NewDecisionMetrics(registry)),
+			)
+
+			tt.expect(mock)
+			decision, _ := service.Check(context.Background(), "user1", 10)
+			if decision.Allowed != tt.allowed || decision.Reason != tt.reason {
+				t.Errorf("expected allowed=%v reason=%s, got allowed=%v reason=%s", tt.allowed, tt.reason, decision.Allowed, decision.Reason)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("failed to gather metrics: %v", err)
+			}
+			counts := make(map[string]float64)
+			for _, family := range families {
+				for _, metric := range family.GetMetric() {
+					counts[metric.GetLabel()[0].GetValue()] = metric.GetCounter().GetValue()
+				}
+			}
+			for _, reason := range ratelimiter.Reasons {
+				expected := 0.0
+				if reason == tt.reason {
+					expected = 1
+				}
+				if counts[string(reason)] != expected {
+					t.Errorf("expected rate_limit_decisions_total{reason=%q} to be %v, got %v", reason, expected, counts[string(reason)])
+				}
+			}
+		})
+	}
+}
+
+// TestService_CheckMetrics checks that RateLimit counts allowed and denied
+// requests and failed checks, and times every check
+func TestService_CheckMetrics(t *testing.T) {
+	evalShaReturns := func(mock redismock.ClientMock, keys, args int, result interface{}, err error) {
+		expectation := mock.CustomMatch(func(expected, actual []interface{}) error {
+			return nil
+		}).ExpectEvalSha("", make([]string, keys), make([]interface{}, args)...)
+		if err != nil {
+			expectation.SetErr(err)
+		} else {
+			expectation.SetVal(result)
+		}
+	}
+	check := func(mock redismock.ClientMock, result interface{}, err error) {
+		evalShaReturns(mock, 1, 6, result, err)
+	}
+
+	db, mock := redismock.NewClientMock()
+	cfg := &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   1,
+		Algorithm:    "sliding_window",
+	}
+	registry := prometheus.NewRegistry()
+	service := ratelimiter.NewService(db, cfg, zap.NewNop(),
+		ratelimiter.WithCheckMetrics(ratelimiter.NewCheckMetrics(registry)),
+	)
+
+	// The series exist before the first check
+	const before = `
+# HELP rate_limit_errors_total Rate limit checks that failed to reach a decision.
+# TYPE rate_limit_errors_total counter
+rate_limit_errors_total 0
+# HELP rate_limit_requests_total Rate limit checks that reached a decision, by whether the request was allowed or denied.
+# TYPE rate_limit_requests_total counter
+rate_limit_requests_total{decision="allowed"} 0
+rate_limit_requests_total{decision="denied"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(before), "rate_limit_requests_total", "rate_limit_errors_total"); err != nil {
+		t.Error(err)
+	}
+
+	ctx := context.Background()
+	// The user's limit and the campaign are looked up on the first check
+	// only, then cached
+	expectNoCampaign(mock)
+	mock.ExpectGet("rate_limit:config:user1").RedisNil()
+	mock.ExpectGet("rate_limit:default").RedisNil()
+	evalShaReturns(mock, 1, 6, []interface{}{int64(1), int64(10)}, nil)
+	check(mock, []interface{}{int64(1), int64(9)}, nil)
+	check(mock, []interface{}{int64(0), int64(0)}, nil)
+	check(mock, nil, errors.New("connection reset"))
+	for i := 0; i < 4; i++ {
+		service.RateLimit(ctx, "user1", 10)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	const after = `
+# HELP rate_limit_errors_total Rate limit checks that failed to reach a decision.
+# TYPE rate_limit_errors_total counter
+rate_limit_errors_total 1
+# HELP rate_limit_requests_total Rate limit checks that reached a decision, by whether the request was allowed or denied.
+# TYPE rate_limit_requests_total counter
+rate_limit_requests_total{decision="allowed"} 2
+rate_limit_requests_total{decision="denied"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(after), "rate_limit_requests_total", "rate_limit_errors_total"); err != nil {
+		t.Error(err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() != "rate_limit_check_duration_seconds" {
+			continue
+		}
+		if count := family.GetMetric()[0].GetHistogram().GetSampleCount(); count != 4 {
+			t.Errorf("expected 4 timed checks, got %d", count)
+		}
+		return
+	}
+	t.Error("expected rate_limit_check_duration_seconds to be registered")
+}
+
+// TestService_SoftOverages tests that a few requests over the limit pass
+// flagged before requests are denied
+// This is an integration test that requires Redis to be running
+func TestService_SoftOverages(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:      2,
+		WindowSize:        1,
+		Algorithm:         "sliding_window",
+		EnableLocalCache:  false,
+		LocalCacheTTL:     60,
+		SoftOverages:      2,
+		SoftOverageWindow: 10,
+	}, zap.NewNop())
+
+	userID := "test_user_soft_overage"
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+
+	check := func() ratelimiter.Decision {
+		t.Helper()
+		decision, err := service.Check(ctx, userID, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		time.Sleep(5 * time.Millisecond)
+		return decision
+	}
+
+	// Within the limit, requests aren't flagged
+	for i := 1; i <= 2; i++ {
+		if decision := check(); !decision.Allowed || decision.SoftOverage {
+			t.Fatalf("expected request %d to be allowed unflagged, got %+v", i, decision)
+		}
+	}
+
+	// The first 2 overages pass, flagged
+	for i := 1; i <= 2; i++ {
+		if decision := check(); !decision.Allowed || !decision.SoftOverage {
+			t.Fatalf("expected overage %d to be allowed and flagged, got %+v", i, decision)
+		}
+	}
+
+	// The 3rd in a row is denied
+	if decision := check(); decision.Allowed || decision.Code != ratelimiter.CodeRateLimited {
+		t.Fatalf("expected overage 3 to be denied with %s, got %+v", ratelimiter.CodeRateLimited, decision)
+	}
+
+	t.Run("allowed request ends the run", func(t *testing.T) {
+		// Wait for the window to pass, so the next request is within the limit
+		time.Sleep(1100 * time.Millisecond)
+		for i := 1; i <= 2; i++ {
+			if decision := check(); !decision.Allowed || decision.SoftOverage {
+				t.Fatalf("expected request %d to be allowed unflagged, got %+v", i, decision)
+			}
+		}
+		if decision := check(); !decision.Allowed || !decision.SoftOverage {
+			t.Errorf("expected a new run of overages to be tolerated, got %+v", decision)
+		}
+	})
+}
+
+// TestService_ResetLimit tests that resetting a user's counter too often is
+// refused, logged and counted
+// This is an integration test that requires Redis to be running
+func TestService_ResetLimit(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	core, logs := observer.New(zapcore.WarnLevel)
+	registry := prometheus.NewRegistry()
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       1,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+		ResetLimit:       2,
+		ResetWindow:      60,
+	}, zap.New(core),
+		ratelimiter.WithResetMetrics(ratelimiter.NewResetMetrics(registry)),
+	)
+
+	userID := "test_user_reset_limit"
+	resetsKey := "rate_limit:resets:" + userID
+	client.Del(ctx, resetsKey)
+	defer client.Del(ctx, resetsKey)
+
+	for i := 1; i <= 3; i++ {
+		allowed, err := service.AllowReset(ctx, userID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expected := i <= 2; allowed != expected {
+			t.Errorf("reset %d: expected allowed to be %v, got %v", i, expected, allowed)
+		}
+	}
+
+	warnings := logs.FilterMessage("user counter reset too often, refusing").All()
+	if len(warnings) != 1 {
+		t.Fatalf("expected one warning for the refused reset, got %d", len(warnings))
+	}
+	if warnings[0].ContextMap()["user_id"] != userID {
+		t.Errorf("expected the warning to name %s, got %v", userID, warnings[0].ContextMap()["user_id"])
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+	if len(families) != 1 || families[0].GetName() != "rate_limit_resets_limited_total" {
+		t.Fatalf("expected rate_limit_resets_limited_total, got %v", families)
+	}
+	if value := families[0].GetMetric()[0].GetCounter().GetValue(); value != 1 {
+		t.Errorf("expected 1 refused reset, got %v", value)
+	}
+
+	// Other users' resets aren't affected
+	if allowed, err := service.AllowReset(ctx, userID+"_other"); err != nil || !allowed {
+		t.Errorf("expected another user's reset to be allowed, got %v, %v", allowed, err)
+	}
+	client.Del(ctx, resetsKey+"_other")
+}
+
+// TestService_WindowChange tests that changing the window of a populated key
+// at runtime neither lets every request through nor denies them spuriously
+// This is an integration test that requires Redis to be running
+func TestService_WindowChange(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	newService := func(policy string) *ratelimiter.Service {
+		return ratelimiter.NewService(client, &config.RateLimitConfig{
+			DefaultLimit:       3,
+			WindowSize:         1,
+			WindowChangePolicy: policy,
+			Algorithm:          "sliding_window",
+			EnableLocalCache:   false,
+			LocalCacheTTL:      60,
+		}, zap.NewNop())
+	}
+
+	// fill uses up the user's limit
+	fill := func(t *testing.T, service *ratelimiter.Service, userID string) {
+		t.Helper()
+		for i := 0; i < 3; i++ {
+			if allowed, err := service.RateLimit(ctx, userID, 3); err != nil || !allowed {
+				t.Fatalf("expected request %d to be allowed, got %v, %v", i+1, allowed, err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	check := func(t *testing.T, service *ratelimiter.Service, userID string, expected bool, when string) {
+		t.Helper()
+		allowed, err := service.RateLimit(ctx, userID, 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allowed != expected {
+			t.Errorf("%s: expected allowed to be %v, got %v", when, expected, allowed)
+		}
+	}
+
+	t.Run("gradual shrink keeps counting old requests", func(t *testing.T) {
+		service := newService("gradual")
+		userID := "test_user_window_gradual"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		fill(t, service, userID)
+		service.SetWindowSize(200 * time.Millisecond)
+
+		// Older than the new window but still inside the old one
+		time.Sleep(300 * time.Millisecond)
+		check(t, service, userID, false, "before the old window passed")
+
+		// The old requests have aged out and the new window is in effect
+		time.Sleep(800 * time.Millisecond)
+		check(t, service, userID, true, "after the old window passed")
+	})
+
+	t.Run("immediate shrink switches at once", func(t *testing.T) {
+		service := newService("immediate")
+		userID := "test_user_window_immediate"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		fill(t, service, userID)
+		service.SetWindowSize(200 * time.Millisecond)
+
+		time.Sleep(300 * time.Millisecond)
+		check(t, service, userID, true, "after the new window passed")
+	})
+
+	t.Run("growing doesn't deny for expired requests", func(t *testing.T) {
+		service := newService("gradual")
+		userID := "test_user_window_grow"
+		_ = service.Reset(ctx, userID)
+		defer service.Reset(ctx, userID)
+
+		fill(t, service, userID)
+		check(t, service, userID, false, "before the change")
+
+		// The requests expired under the old window, so the longer one
+		// mustn't count them again
+		time.Sleep(1200 * time.Millisecond)
+		service.SetWindowSize(5 * time.Second)
+		check(t, service, userID, true, "after growing the window")
+	})
+}
+
+// recordingSink keeps the audit events written to it
+type recordingSink struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (s *recordingSink) Write(event audit.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *recordingSink) Close() error { return nil }
+
+// thresholds returns the thresholds of the usage threshold events written
+func (s *recordingSink) thresholds() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var thresholds []int
+	for _, event := range s.events {
+		if event.Type == audit.EventThreshold {
+			thresholds = append(thresholds, event.Threshold)
+		}
+	}
+	return thresholds
+}
+
+// TestService_UsageThresholds tests that crossing a usage threshold is
+// reported once, not on every request above it
+// This is an integration test that requires Redis to be running
+func TestService_UsageThresholds(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		limit      int
+		thresholds []int
+		requests   int
+		// expected lists the thresholds reported after each request
+		expected [][]int
+	}{
+		{
+			name:       "one crossing per threshold",
+			limit:      5,
+			thresholds: []int{80, 100},
+			requests:   7,
+			expected:   [][]int{nil, nil, nil, {80}, {80, 100}, {80, 100}, {80, 100}},
+		},
+		{
+			name:       "several thresholds crossed at once",
+			limit:      2,
+			thresholds: []int{50, 80, 100},
+			requests:   3,
+			expected:   [][]int{{50}, {50, 80, 100}, {50, 80, 100}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := "test_user_thresholds"
+			thresholdKey := "rate_limit:threshold:" + userID
+			sink := &recordingSink{}
+			service := ratelimiter.NewService(client, &config.RateLimitConfig{
+				DefaultLimit:    tt.limit,
+				WindowSize:      10,
+				Algorithm:       "sliding_window",
+				LocalCacheTTL:   60,
+				UsageThresholds: tt.thresholds,
+			}, zap.NewNop(), ratelimiter.WithAuditSink(sink))
+			_ = service.Reset(ctx, userID)
+			client.Del(ctx, thresholdKey)
+			defer service.Reset(ctx, userID)
+			defer client.Del(ctx, thresholdKey)
+
+			for i := 0; i < tt.requests; i++ {
+				if _, err := service.RateLimit(ctx, userID, tt.limit); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if got := sink.thresholds(); !reflect.DeepEqual(got, tt.expected[i]) {
+					t.Errorf("after request %d: expected thresholds %v, got %v", i+1, tt.expected[i], got)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
+
+// TestService_ReplicaReads tests where remaining-request reads go when a
+// replica lags behind the primary, and that the conservative policy
+// discounts the requests the replica may not have seen
+// This is an integration test that requires Redis to be running
+func TestService_ReplicaReads(t *testing.T) {
+	primary := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer primary.Close()
+	// Another database stands in for a replica lagging behind the primary
+	replica := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+		DB:   14,
+	})
+	defer replica.Close()
+
+	ctx := context.Background()
+	if err := primary.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	userID := "test_user_replica_reads"
+	limit := 10
+	newConfig := func(reads string, staleness time.Duration) *config.RateLimitConfig {
+		return &config.RateLimitConfig{
+			DefaultLimit:     limit,
+			WindowSize:       10,
+			Algorithm:        "sliding_window",
+			LocalCacheTTL:    60,
+			ReplicaReads:     reads,
+			ReplicaStaleness: staleness,
+		}
+	}
+
+	// The primary has admitted 6 requests, of which the replica has seen 2
+	writers := []struct {
+		client   *redis.Client
+		requests int
+	}{
+		{primary, 6},
+		{replica, 2},
+	}
+	for _, w := range writers {
+		writer := ratelimiter.NewService(w.client, newConfig("primary", 0), zap.NewNop())
+		_ = writer.Reset(ctx, userID)
+		defer writer.Reset(ctx, userID)
+		for i := 0; i < w.requests; i++ {
+			if _, err := writer.RateLimit(ctx, userID, limit); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		reads     string
+		staleness time.Duration
+		expected  int
+	}{
+		{name: "primary ignores the replica", reads: "primary", staleness: time.Second, expected: 4},
+		{name: "replica reports what it has seen", reads: "replica", staleness: time.Second, expected: 8},
+		// 10 requests per 10s window may admit 1 more within 1s of lag
+		{name: "conservative discounts one second of lag", reads: "conservative", staleness: time.Second, expected: 7},
+		{name: "conservative discounts three seconds of lag", reads: "conservative", staleness: 3 * time.Second, expected: 5},
+		{name: "conservative never goes below zero", reads: "conservative", staleness: time.Minute, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := ratelimiter.NewService(primary, newConfig(tt.reads, tt.staleness), zap.NewNop(),
+				ratelimiter.WithReplicaClient(replica))
+
+			remaining, err := service.GetRemaining(ctx, userID, limit)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if remaining != tt.expected {
+				t.Errorf("expected %d remaining, got %d", tt.expected, remaining)
+			}
+		})
+	}
+}
+
+func TestService_MemoryFallback(t *testing.T) {
+	evalShaReturns := func(mock redismock.ClientMock, keys, args int, result interface{}, err error) {
+		expectation := mock.CustomMatch(func(expected, actual []interface{}) error {
+			return nil
+		}).ExpectEvalSha("", make([]string, keys), make([]interface{}, args)...)
+		if err != nil {
+			expectation.SetErr(err)
+		} else {
+			expectation.SetVal(result)
+		}
+	}
+	unreachable := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name         string
+		fallbackSize int
+		checkErr     error
+		expected     []bool
+		expectErr    bool
+	}{
+		{name: "redis unreachable", fallbackSize: 100, checkErr: unreachable, expected: []bool{true, true, false}},
+		{name: "fallback disabled", fallbackSize: 0, checkErr: unreachable, expected: []bool{false, false, false}, expectErr: true},
+		{name: "other errors are not masked", fallbackSize: 100, checkErr: errors.New("NOPERM no permissions"), expected: []bool{false, false, false}, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			cfg := &config.RateLimitConfig{
+				DefaultLimit:       2,
+				WindowSize:         1,
+				Algorithm:          "sliding_window",
+				MemoryFallbackSize: tt.fallbackSize,
+			}
+			service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+			// The user's limit and the campaign are looked up on the first
+			// check only, then cached
+			expectNoCampaign(mock)
+			mock.ExpectGet("rate_limit:config:user1").RedisNil()
+			mock.ExpectGet("rate_limit:default").RedisNil()
+			evalShaReturns(mock, 1, 6, nil, tt.checkErr)
+			for range tt.expected[1:] {
+				evalShaReturns(mock, 1, 6, nil, tt.checkErr)
+			}
+
+			for i, expected := range tt.expected {
+				decision, err := service.Check(context.Background(), "user1", 2)
+				if tt.expectErr != (err != nil) {
+					t.Fatalf("request %d: expected error %v, got %v", i+1, tt.expectErr, err)
+				}
+				if decision.Allowed != expected {
+					t.Errorf("request %d: expected allowed=%v, got %+v", i+1, expected, decision)
+				}
+				if !tt.expectErr && !expected && decision.Reason != ratelimiter.ReasonOverLimit {
+					t.Errorf("request %d: expected reason %q, got %q", i+1, ratelimiter.ReasonOverLimit, decision.Reason)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestService_RateLimitBatch(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	cfg := &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   1,
+		Algorithm:    "sliding_window",
+	}
+	service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+	checks := []ratelimiter.Check{
+		{Key: "user1", Limit: 5, Window: time.Minute},
+		{Key: "key:abc", Limit: 1, Window: time.Minute},
+		{Key: "ip:10.0.0.1", Limit: 100, Window: time.Hour},
+	}
+	// Each check runs its own script, all sent in one pipeline
+	var keys []string
+	match := mock.CustomMatch(func(expected, actual []interface{}) error {
+		keys = append(keys, actual[3].(string))
+		return nil
+	})
+	match.ExpectEval("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(5)})
+	match.ExpectEval("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(0), int64(0)})
+	match.ExpectEval("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(100)})
+
+	results, err := service.RateLimitBatch(context.Background(), checks)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	expectedKeys := []string{"rate_limit:sliding:user1", "rate_limit:sliding:key%3Aabc", "rate_limit:sliding:ip%3A10.0.0.1"}
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Errorf("expected the checks to run against %v, got %v", expectedKeys, keys)
+	}
+
+	// The denied check doesn't keep the others from being allowed
+	expected := []struct {
+		key       string
+		allowed   bool
+		remaining int
+	}{
+		{key: "user1", allowed: true, remaining: 4},
+		{key: "key:abc", allowed: false, remaining: 0},
+		{key: "ip:10.0.0.1", allowed: true, remaining: 99},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for i, want := range expected {
+		got := results[i]
+		if got.Key != want.key || got.Allowed != want.allowed || got.Remaining != want.remaining || got.Err != nil {
+			t.Errorf("result %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+	if ratelimiter.AllAllowed(results) {
+		t.Error("expected the batch to be denied when any check is denied")
+	}
+}
+
+func TestService_RateLimitBatch_Errors(t *testing.T) {
+	evalReturns := func(mock redismock.ClientMock, result interface{}, err error) {
+		expectation := mock.CustomMatch(func(expected, actual []interface{}) error {
+			return nil
+		}).ExpectEval("", []string{""}, make([]interface{}, 6)...)
+		if err != nil {
+			expectation.SetErr(err)
+		} else {
+			expectation.SetVal(result)
+		}
+	}
+	cfg := &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   1,
+		Algorithm:    "sliding_window",
+	}
+	checks := []ratelimiter.Check{
+		{Key: "user1", Limit: 5, Window: time.Minute},
+		{Key: "user2", Limit: 5, Window: time.Minute},
+	}
+
+	t.Run("every check fails", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, cfg, zap.NewNop())
+		evalReturns(mock, nil, errors.New("connection refused"))
+		evalReturns(mock, nil, errors.New("connection refused"))
+
+		results, err := service.RateLimitBatch(context.Background(), checks)
+		if err == nil {
+			t.Fatal("expected an error when no check could be run")
+		}
+		if len(results) != 2 || results[0].Err == nil || results[1].Err == nil {
+			t.Errorf("expected every result to report its error, got %+v", results)
+		}
+	})
+
+	t.Run("empty batch", func(t *testing.T) {
+		db, _ := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, cfg, zap.NewNop())
+		results, err := service.RateLimitBatch(context.Background(), nil)
+		if err != nil || len(results) != 0 || !ratelimiter.AllAllowed(results) {
+			t.Errorf("expected an empty batch to be allowed, got %v, %v", results, err)
+		}
+	})
+}
+
+// pipelineSizes records the number of commands in each pipeline sent
+type pipelineSizes struct {
+	sizes []int
+}
+
+func (h *pipelineSizes) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (h *pipelineSizes) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	return nil
+}
+
+func (h *pipelineSizes) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	h.sizes = append(h.sizes, len(cmds))
+	return ctx, nil
+}
+
+func (h *pipelineSizes) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	return nil
+}
+
+// TestService_RateLimitBatch_Chunks checks that large batches are split into
+// pipelines of 1000 checks, and that batches over the maximum are rejected
+func TestService_RateLimitBatch_Chunks(t *testing.T) {
+	checks := make([]ratelimiter.Check, 2500)
+	for i := range checks {
+		checks[i] = ratelimiter.Check{Key: fmt.Sprintf("user%d", i), Limit: 5, Window: time.Minute}
+	}
+
+	t.Run("split into pipelines", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		pipelines := &pipelineSizes{}
+		db.AddHook(pipelines)
+		service := ratelimiter.NewService(db, &config.RateLimitConfig{
+			DefaultLimit: 10,
+			WindowSize:   1,
+			Algorithm:    "sliding_window",
+			MaxBatchSize: 10000,
+		}, zap.NewNop())
+
+		match := mock.CustomMatch(func(expected, actual []interface{}) error {
+			return nil
+		})
+		for range checks {
+			match.ExpectEval("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(4)})
+		}
+
+		results, err := service.RateLimitBatch(context.Background(), checks)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(results) != len(checks) || !ratelimiter.AllAllowed(results) {
+			t.Errorf("expected all %d checks to be allowed, got %d results", len(checks), len(results))
+		}
+		if want := []int{1000, 1000, 500}; !reflect.DeepEqual(pipelines.sizes, want) {
+			t.Errorf("expected pipelines of %v checks, got %v", want, pipelines.sizes)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("over the maximum", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, &config.RateLimitConfig{
+			DefaultLimit: 10,
+			WindowSize:   1,
+			Algorithm:    "sliding_window",
+			MaxBatchSize: 2000,
+		}, zap.NewNop())
+
+		results, err := service.RateLimitBatch(context.Background(), checks)
+		if !errors.Is(err, ratelimiter.ErrBatchTooLarge) {
+			t.Fatalf("expected ErrBatchTooLarge, got %v", err)
+		}
+		if results != nil {
+			t.Errorf("expected no results, got %d", len(results))
+		}
+		// Nothing is sent to Redis
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestService_RateLimitBatch_PartialFailure checks that a failed check
+// doesn't take the rest of its batch down with it
+// This is an integration test that requires Redis to be running
+func TestService_RateLimitBatch_PartialFailure(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   1,
+		Algorithm:    "sliding_window",
+	}
+	service := ratelimiter.NewService(client, cfg, zap.NewNop())
+
+	// A key of the wrong type makes its check fail
+	client.Del(ctx, "rate_limit:sliding:batch_user1")
+	client.Set(ctx, "rate_limit:sliding:batch_user2", "corrupt", time.Minute)
+	defer client.Del(ctx, "rate_limit:sliding:batch_user1", "rate_limit:sliding:batch_user2")
+
+	results, err := service.RateLimitBatch(ctx, []ratelimiter.Check{
+		{Key: "batch_user1", Limit: 5, Window: time.Minute},
+		{Key: "batch_user2", Limit: 5, Window: time.Minute},
+	})
+	if err != nil {
+		t.Fatalf("expected no error while a check succeeded, got %v", err)
+	}
+	if results[0].Err != nil || !results[0].Allowed || results[0].Remaining != 4 {
+		t.Errorf("expected the first check to be allowed, got %+v", results[0])
+	}
+	if results[1].Err == nil || results[1].Allowed {
+		t.Errorf("expected the second check to report its error, got %+v", results[1])
+	}
+	if ratelimiter.AllAllowed(results) {
+		t.Error("expected a failed check to deny the batch")
+	}
+}
+
+// TestService_RateLimitWithWindow checks that the same user limited over two
+// windows at once, keyed by route, is counted independently in each
+// This is an integration test that requires Redis to be running
+func TestService_RateLimitWithWindow(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:     3,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}, zap.NewNop())
+
+	userID := "test_user_with_window"
+	shortCtx := ratelimiter.WithRoute(ctx, "/short")
+	longCtx := ratelimiter.WithRoute(ctx, "/long")
+	shortWindow := 300 * time.Millisecond
+	longWindow := 5 * time.Second
+	_ = service.Reset(shortCtx, userID)
+	_ = service.Reset(longCtx, userID)
+	defer service.Reset(shortCtx, userID)
+	defer service.Reset(longCtx, userID)
+
+	limiters := []struct {
+		ctx    context.Context
+		window time.Duration
+	}{{shortCtx, shortWindow}, {longCtx, longWindow}}
+
+	// Use up both limits concurrently
+	var wg sync.WaitGroup
+	for _, limiter := range limiters {
+		wg.Add(1)
+		go func(ctx context.Context, window time.Duration) {
+			defer wg.Done()
+			for i := 0; i < 3; i++ {
+				if allowed, err := service.RateLimitWithWindow(ctx, userID, 3, window); err != nil || !allowed {
+					t.Errorf("window %v: expected request %d to be allowed, got %v, %v", window, i+1, allowed, err)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		}(limiter.ctx, limiter.window)
+	}
+	wg.Wait()
+
+	for _, limiter := range limiters {
+		if allowed, _ := service.RateLimitWithWindow(limiter.ctx, userID, 3, limiter.window); allowed {
+			t.Errorf("window %v: expected a fourth request to be denied", limiter.window)
+		}
+	}
+
+	// Only the short window has passed
+	time.Sleep(shortWindow + 100*time.Millisecond)
+
+	if remaining, err := service.GetRemainingWithWindow(shortCtx, userID, 3, shortWindow); err != nil || remaining != 3 {
+		t.Errorf("expected the short window to have emptied, got %d, %v", remaining, err)
+	}
+	if remaining, err := service.GetRemainingWithWindow(longCtx, userID, 3, longWindow); err != nil || remaining != 0 {
+		t.Errorf("expected the long window to still be full, got %d, %v", remaining, err)
+	}
+	if allowed, _ := service.RateLimitWithWindow(shortCtx, userID, 3, shortWindow); !allowed {
+		t.Error("expected the short window to allow requests again")
+	}
+	if allowed, _ := service.RateLimitWithWindow(longCtx, userID, 3, longWindow); allowed {
+		t.Error("expected the long window to still deny requests")
+	}
+}
+
+// TestService_KeyNamespace checks that services sharing a Redis under
+// different namespaces keep their counters and custom limits apart
+// This is an integration test that requires Redis to be running
+func TestService_KeyNamespace(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	newService := func(namespace string) *ratelimiter.Service {
+		return ratelimiter.NewService(client, &config.RateLimitConfig{
+			DefaultLimit:     2,
+			WindowSize:       10,
+			Algorithm:        "sliding_window",
+			EnableLocalCache: false,
+			LocalCacheTTL:    60,
+			KeyNamespace:     namespace,
+		}, zap.NewNop())
+	}
+	billing := newService("billing")
+	search := newService("search")
+
+	userID := "test_user_namespace"
+	for _, service := range []*ratelimiter.Service{billing, search} {
+		_ = service.Reset(ctx, userID)
+		_ = service.DeleteUserLimit(ctx, userID)
+		defer service.Reset(ctx, userID)
+		defer service.DeleteUserLimit(ctx, userID)
+	}
+
+	// billing uses up its limit without touching search's
+	for i, expected := range []bool{true, true, false} {
+		if allowed, err := billing.RateLimit(ctx, userID, 2); err != nil || allowed != expected {
+			t.Fatalf("billing request %d: expected allowed=%v, got %v, %v", i+1, expected, allowed, err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if remaining, err := search.GetRemaining(ctx, userID, 2); err != nil || remaining != 2 {
+		t.Errorf("expected search's window to be untouched, got %d, %v", remaining, err)
+	}
+	if n, _ := client.Exists(ctx, "billing:rate_limit:sliding:"+userID).Result(); n != 1 {
+		t.Error("expected billing's window to be kept under its namespace")
+	}
+
+	// Custom limits are namespaced too
+	if err := search.SetUserLimit(ctx, userID, 5); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key := search.UserConfigKey(userID); key != "search:rate_limit:config:"+userID {
+		t.Errorf("expected search's config key under its namespace, got %s", key)
+	}
+	if limit, custom, err := billing.GetUserLimit(ctx, userID); err != nil || custom || limit != 2 {
+		t.Errorf("expected billing to keep the default limit, got %d, %v, %v", limit, custom, err)
+	}
+
+	// Resetting one namespace leaves the other alone
+	if allowed, _ := search.RateLimit(ctx, userID, 2); !allowed {
+		t.Error("expected search to allow the request")
+	}
+	if err := search.Reset(ctx, userID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if allowed, _ := billing.RateLimit(ctx, userID, 2); allowed {
+		t.Error("expected billing to still be limited after search's reset")
+	}
+}
+
+// TestService_RateLimitScoped checks that each scope of a user's requests
+// has a budget of its own, at the scope's custom limit if it has one
+// This is an integration test that requires Redis to be running
+func TestService_RateLimitScoped(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	service := ratelimiter.NewService(client, &config.RateLimitConfig{
+		DefaultLimit:     5,
+		WindowSize:       10,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: false,
+		LocalCacheTTL:    60,
+	}, zap.NewNop())
+
+	userID := "test_user_scoped"
+	_ = service.ResetScoped(ctx, userID, "read")
+	_ = service.ResetScoped(ctx, userID, "write")
+	defer service.ResetScoped(ctx, userID, "read")
+	defer service.ResetScoped(ctx, userID, "write")
+
+	// Writes are held to 2, reads keep the default of 5
+	if err := service.SetScopedLimit(ctx, userID, "write", 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer service.DeleteUserLimit(ratelimiter.WithScope(ctx, "write"), userID)
+
+	for i, expected := range []bool{true, true, false} {
+		allowed, err := service.RateLimitScoped(ctx, userID, "write", 5)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allowed != expected {
+			t.Fatalf("write %d: expected allowed %v, got %v", i+1, expected, allowed)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Exhausting writes leaves reads untouched
+	if allowed, err := service.RateLimitScoped(ctx, userID, "read", 5); err != nil || !allowed {
+		t.Fatalf("expected a read to be allowed, got %v, %v", allowed, err)
+	}
+	if remaining, err := service.GetRemainingScoped(ctx, userID, "read", 5); err != nil || remaining != 4 {
+		t.Errorf("expected 4 reads left, got %d, %v", remaining, err)
+	}
+	if remaining, err := service.GetRemainingScoped(ctx, userID, "write", 5); err != nil || remaining != 0 {
+		t.Errorf("expected no writes left, got %d, %v", remaining, err)
+	}
+	// Nor do scoped requests count against the user's unscoped budget
+	if remaining, err := service.GetRemaining(ctx, userID, 5); err != nil || remaining != 5 {
+		t.Errorf("expected the unscoped budget to be untouched, got %d, %v", remaining, err)
+	}
+
+	// Resetting writes leaves the read already made counted
+	if err := service.ResetScoped(ctx, userID, "write"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remaining, err := service.GetRemainingScoped(ctx, userID, "write", 5); err != nil || remaining != 2 {
+		t.Errorf("expected writes to be reset, got %d, %v", remaining, err)
+	}
+	if remaining, err := service.GetRemainingScoped(ctx, userID, "read", 5); err != nil || remaining != 4 {
+		t.Errorf("expected reads to be left in place, got %d, %v", remaining, err)
+	}
+}
+
+// TestService_ScopedLimitLookup checks the keys a scoped check reads its limit
+// from and counts the request under, and that a blocked user is blocked in
+// every scope
+func TestService_ScopedLimitLookup(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   1,
+		Algorithm:    "sliding_window",
+	}
+	ctx := context.Background()
+
+	t.Run("scope's own limit", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+		expectNoCampaign(mock)
+		mock.ExpectGet("rate_limit:config:user1").SetVal("100")
+		mock.ExpectGet("rate_limit:config:user1:scope:write").SetVal("5")
+		mock.CustomMatch(func(expected, actual []interface{}) error {
+			if key := actual[3]; key != "rate_limit:sliding:user1:scope:write" {
+				return fmt.Errorf("expected the write scope's key, got %v", key)
+			}
+			if limit := actual[6]; limit != "5" {
+				return fmt.Errorf("expected the write scope's limit of 5, got %v", limit)
+			}
+			return nil
+		}).ExpectEvalSha("", make([]string, 1), make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(4)})
+
+		if allowed, err := service.RateLimitScoped(ctx, "user1", "write", 10); err != nil || !allowed {
+			t.Errorf("expected the request to be allowed, got %v, %v", allowed, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("blocked user", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+		expectNoCampaign(mock)
+		mock.ExpectGet("rate_limit:config:user1").SetVal("blocked")
+
+		if allowed, err := service.RateLimitScoped(ctx, "user1", "read", 10); err != nil || allowed {
+			t.Errorf("expected the request to be denied, got %v, %v", allowed, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestService_PreloadLimits checks that preloaded limits are served from the
+// local cache without another Redis lookup
+func TestService_PreloadLimits(t *testing.T) {
+	ctx := context.Background()
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       1,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: true,
+		LocalCacheTTL:    60,
+	}
+
+	t.Run("served from cache", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+		mock.ExpectGet("rate_limit:config:user1").SetVal("5")
+		mock.ExpectGet("rate_limit:config:user3").SetVal("blocked")
+		mock.ExpectGet("rate_limit:config:user4").SetVal("not a limit")
+		if err := service.PreloadLimits(ctx, []string{"user1", "user3", "user4"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+
+		// No further expectations: any Redis lookup fails
+		if limit, configured, err := service.GetUserLimit(ctx, "user1"); err != nil || !configured || limit != 5 {
+			t.Errorf("expected user1's limit of 5 from the cache, got %d, %v, %v", limit, configured, err)
+		}
+		if blocked, err := service.IsBlocked(ctx, "user3"); err != nil || !blocked {
+			t.Errorf("expected user3 to be cached as blocked, got %v, %v", blocked, err)
+		}
+		// Invalid limits aren't cached
+		if _, err := service.IsBlocked(ctx, "user4"); err == nil {
+			t.Error("expected user4's limit to be looked up in Redis")
+		}
+	})
+
+	t.Run("users without a custom limit", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		service := ratelimiter.NewService(db, cfg, zap.NewNop())
+
+		// A nil reply ends a mocked pipeline, failing the rest of its
+		// commands, so this user is preloaded apart from those with a limit
+		mock.ExpectGet("rate_limit:config:user2").RedisNil()
+		if err := service.PreloadLimits(ctx, []string{"user2"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if blocked, err := service.IsBlocked(ctx, "user2"); err != nil || blocked {
+			t.Errorf("expected user2 to be cached without a custom limit, got %v, %v", blocked, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("cache disabled", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		uncached := *cfg
+		uncached.EnableLocalCache = false
+		service := ratelimiter.NewService(db, &uncached, zap.NewNop())
+
+		if err := service.PreloadLimits(ctx, []string{"user1"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// TestService_PreloadAllLimits checks that every configured limit, scoped or
+// not, is preloaded into the local cache
+// This is an integration test that requires Redis to be running
+func TestService_PreloadAllLimits(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := &config.RateLimitConfig{
+		DefaultLimit:     10,
+		WindowSize:       1,
+		Algorithm:        "sliding_window",
+		EnableLocalCache: true,
+		LocalCacheTTL:    60,
+		KeyNamespace:     "preload_test",
+	}
+	writer := ratelimiter.NewService(client, cfg, zap.NewNop())
+	users := map[string]int{"alice": 5, "bob:v2": 7, "default": 9}
+	for userID, limit := range users {
+		if err := writer.SetUserLimit(ctx, userID, limit); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := writer.SetScopedLimit(ctx, "alice", "write", 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keys, _ := client.Keys(ctx, "preload_test:rate_limit:config:*").Result()
+	defer client.Del(ctx, keys...)
+
+	service := ratelimiter.NewService(client, cfg, zap.NewNop())
+	loaded, err := service.PreloadAllLimits(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded != 4 {
+		t.Errorf("expected 4 limits preloaded, got %d", loaded)
+	}
+
+	// Served from the cache even once gone from Redis
+	if err := client.Del(ctx, keys...).Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for userID, expected := range users {
+		if limit, configured, err := service.GetUserLimit(ctx, userID); err != nil || !configured || limit != expected {
+			t.Errorf("expected %s's limit of %d from the cache, got %d, %v, %v", userID, expected, limit, configured, err)
+		}
+	}
+	if remaining, err := service.GetRemainingScoped(ctx, "alice", "write", 10); err != nil || remaining != 2 {
+		t.Errorf("expected alice's write limit of 2 from the cache, got %d, %v", remaining, err)
+	}
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"ratelimit-challenge/pkg/ratelimiter"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -36,7 +37,7 @@ func TestSlidingWindow_Allow(t *testing.T) {
 	windowSize := 1 * time.Second
 
 	// Clean up before test
-	_ = sw.Reset(ctx, userID)
+	_ = sw.Reset(ctx, userID, windowSize)
 
 	t.Run("allow request when under limit", func(t *testing.T) {
 		// Make requests up to the limit
@@ -54,7 +55,7 @@ func TestSlidingWindow_Allow(t *testing.T) {
 	t.Run("deny request when over limit", func(t *testing.T) {
 		// Use a different user ID to avoid interference
 		testUserID := "test_user_deny"
-		_ = sw.Reset(ctx, testUserID)
+		_ = sw.Reset(ctx, testUserID, windowSize)
 
 		// Fill up to limit (make exactly 'limit' requests)
 		// Add small delay to ensure requests are within the same window
@@ -80,11 +81,11 @@ func TestSlidingWindow_Allow(t *testing.T) {
 		}
 
 		// Clean up
-		_ = sw.Reset(ctx, testUserID)
+		_ = sw.Reset(ctx, testUserID, windowSize)
 	})
 
 	// Clean up after test
-	_ = sw.Reset(ctx, userID)
+	_ = sw.Reset(ctx, userID, windowSize)
 }
 
 func TestSlidingWindow_GetRemaining(t *testing.T) {
@@ -98,6 +99,27 @@ func TestSlidingWindow_GetRemaining(t *testing.T) {
 	windowSize := 1 * time.Second
 
 	t.Run("get remaining requests", func(t *testing.T) {
+		// Only entries inside the window are counted; nothing is removed
+		mock.Regexp().ExpectZCount("rate_limit:sliding:user123", `\(\d+`, `\+inf`).SetVal(3)
+
+		remaining, err := sw.GetRemaining(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := 7 // 10 - 3
+		if remaining != expected {
+			t.Errorf("expected remaining %d, got %d", expected, remaining)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("prune on read", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		sw := ratelimiter.NewSlidingWindow(db, logger, ratelimiter.WithPruneOnRead(true))
+
 		now := time.Now()
 		windowStart := now.Add(-windowSize).UnixMilli()
 
@@ -109,9 +131,8 @@ func TestSlidingWindow_GetRemaining(t *testing.T) {
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		expected := 7 // 10 - 3
-		if remaining != expected {
-			t.Errorf("expected remaining %d, got %d", expected, remaining)
+		if remaining != 7 {
+			t.Errorf("expected remaining 7, got %d", remaining)
 		}
 
 		if err := mock.ExpectationsWereMet(); err != nil {
@@ -120,6 +141,63 @@ func TestSlidingWindow_GetRemaining(t *testing.T) {
 	})
 }
 
+// TestSlidingWindow_GetRemainingReadOnly tests that reading the remaining
+// requests leaves the window untouched, whatever Allow decides next
+// This is an integration test that requires Redis to be running
+func TestSlidingWindow_GetRemainingReadOnly(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	sw := ratelimiter.NewSlidingWindow(client, zap.NewNop())
+	userID := "test_user_sliding_read_only"
+	key := "rate_limit:sliding:" + userID
+	limit := 2
+	windowSize := 10 * time.Second
+
+	_ = sw.Reset(ctx, userID, windowSize)
+	defer sw.Reset(ctx, userID, windowSize)
+
+	// An expired entry outside the window and one inside it
+	now := time.Now()
+	expired := now.Add(-time.Minute).UnixMilli()
+	client.ZAdd(ctx, key,
+		&redis.Z{Score: float64(expired), Member: strconv.FormatInt(expired, 10)},
+		&redis.Z{Score: float64(now.UnixMilli()), Member: strconv.FormatInt(now.UnixMilli(), 10)},
+	)
+
+	for i := 0; i < 3; i++ {
+		remaining, err := sw.GetRemaining(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if remaining != 1 {
+			t.Errorf("read %d: expected 1 remaining, got %d", i+1, remaining)
+		}
+	}
+	if size := client.ZCard(ctx, key).Val(); size != 2 {
+		t.Errorf("expected reads to leave both entries in place, got %d", size)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	for i, expected := range []bool{true, false} {
+		allowed, err := sw.Allow(ctx, userID, limit, windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if allowed != expected {
+			t.Errorf("request %d: expected allowed=%v, got %v", i+1, expected, allowed)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
 func TestSlidingWindow_Reset(t *testing.T) {
 	db, mock := redismock.NewClientMock()
 	logger := zap.NewNop()
@@ -131,7 +209,7 @@ func TestSlidingWindow_Reset(t *testing.T) {
 	t.Run("reset rate limit", func(t *testing.T) {
 		mock.ExpectDel("rate_limit:sliding:user123").SetVal(1)
 
-		err := sw.Reset(ctx, userID)
+		err := sw.Reset(ctx, userID, time.Minute)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -141,3 +219,128 @@ func TestSlidingWindow_Reset(t *testing.T) {
 		}
 	})
 }
+
+// TestSlidingWindow_ConcurrentRequests tests that requests made at once, many
+// of them in the same millisecond, are each counted
+// This is an integration test that requires Redis to be running
+func TestSlidingWindow_ConcurrentRequests(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		PoolSize: 50,
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	sw := ratelimiter.NewSlidingWindow(client, zap.NewNop())
+	userID := "test_user_sliding_concurrent"
+	requests := 50
+	limit := 1000
+	windowSize := 10 * time.Second
+
+	_ = sw.Reset(ctx, userID, windowSize)
+	defer sw.Reset(ctx, userID, windowSize)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if _, err := sw.Allow(ctx, userID, limit, windowSize); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// A costed request in the same burst numbers its slots past the others
+	if allowed, err := sw.AllowN(ctx, userID, limit, 3, windowSize); err != nil || !allowed {
+		t.Fatalf("expected the costed request to be allowed, got allowed=%v err=%v", allowed, err)
+	}
+
+	if count := client.ZCard(ctx, "rate_limit:sliding:"+userID).Val(); count != int64(requests+3) {
+		t.Errorf("expected %d entries, one per request slot, got %d", requests+3, count)
+	}
+	remaining, err := sw.GetRemaining(ctx, userID, limit, windowSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if remaining != limit-requests-3 {
+		t.Errorf("expected %d remaining, got %d", limit-requests-3, remaining)
+	}
+}
+
+func TestSlidingWindow_Dump(t *testing.T) {
+	logger := zap.NewNop()
+	ctx := context.Background()
+	windowSize := 1 * time.Second
+
+	now := time.Now().Truncate(time.Millisecond)
+	first, second, third := now.Add(-300*time.Millisecond), now.Add(-200*time.Millisecond), now.Add(-100*time.Millisecond)
+	// Out of order, to check the timestamps get sorted
+	entries := []redis.Z{
+		{Score: float64(third.UnixMilli()), Member: "c"},
+		{Score: float64(first.UnixMilli()), Member: "a"},
+		{Score: float64(second.UnixMilli()), Member: "b"},
+	}
+
+	t.Run("timestamps are parsed and sorted ascending", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		sw := ratelimiter.NewSlidingWindow(db, logger)
+
+		mock.Regexp().ExpectZCount("rate_limit:sliding:user123", `\(\d+`, `\+inf`).SetVal(3)
+		mock.Regexp().ExpectZRangeByScoreWithScores("rate_limit:sliding:user123", &redis.ZRangeBy{Min: `\(\d+`, Max: `\+inf`}).SetVal(entries)
+
+		times, err := sw.Dump(ctx, "user123", windowSize)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []time.Time{first, second, third}
+		if len(times) != len(expected) {
+			t.Fatalf("expected %d timestamps, got %d", len(expected), len(times))
+		}
+		for i := range expected {
+			if !times[i].Equal(expected[i]) {
+				t.Errorf("expected timestamp %d to be %v, got %v", i, expected[i], times[i])
+			}
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("capped with total count", func(t *testing.T) {
+		db, mock := redismock.NewClientMock()
+		sw := ratelimiter.NewSlidingWindow(db, logger)
+
+		mock.Regexp().ExpectZCount("rate_limit:sliding:user123", `\(\d+`, `\+inf`).SetVal(3)
+		mock.Regexp().ExpectZRangeByScoreWithScores("rate_limit:sliding:user123", &redis.ZRangeBy{Min: `\(\d+`, Max: `\+inf`, Count: 2}).SetVal(entries[1:])
+
+		times, total, err := sw.DumpN(ctx, "user123", windowSize, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(times) != 2 {
+			t.Errorf("expected 2 timestamps, got %d", len(times))
+		}
+		if total != 3 {
+			t.Errorf("expected a total of 3, got %d", total)
+		}
+
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
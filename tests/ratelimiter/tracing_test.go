@@ -0,0 +1,159 @@
+package ratelimiter
+
+import (
+	"context"
+	"errors"
+	"ratelimit-challenge/internal/config"
+	service "ratelimit-challenge/internal/service/ratelimiter"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+)
+
+// spanAttributes returns a span's attributes keyed by name
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	return attributes
+}
+
+func TestTracing_LimiterSpans(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm string
+	}{
+		{name: "sliding window", algorithm: "sliding_window"},
+		{name: "leaky bucket", algorithm: "leaky_bucket"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			db, mock := redismock.NewClientMock()
+
+			var limiter ratelimiter.RateLimiter
+			if tt.algorithm == "sliding_window" {
+				limiter = ratelimiter.NewSlidingWindow(db, zap.NewNop(), ratelimiter.WithTracerProvider(tp))
+			} else {
+				limiter = ratelimiter.NewLeakyBucket(db, zap.NewNop(), ratelimiter.WithTracerProvider(tp))
+			}
+
+			match := mock.CustomMatch(func(expected, actual []interface{}) error {
+				return nil
+			})
+			match.ExpectEvalSha("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(0), int64(0)})
+			match.ExpectEvalSha("", []string{""}, make([]interface{}, 6)...).SetErr(errors.New("connection refused"))
+
+			if allowed, err := limiter.Allow(context.Background(), "user1", 5, time.Minute); err != nil || allowed {
+				t.Fatalf("expected the request to be denied, got %v, %v", allowed, err)
+			}
+			limiter.Allow(context.Background(), "user1", 5, time.Minute)
+
+			spans := recorder.Ended()
+			if len(spans) != 2 {
+				t.Fatalf("expected 2 spans, got %d", len(spans))
+			}
+			for _, span := range spans {
+				if span.Name() != "ratelimiter.Allow" {
+					t.Errorf("expected span ratelimiter.Allow, got %s", span.Name())
+				}
+				attributes := spanAttributes(span)
+				if got := attributes["user_id"].AsString(); got != ratelimiter.HashUserID("user1") || got == "user1" {
+					t.Errorf("expected a hashed user_id, got %q", got)
+				}
+				if got := attributes["algorithm"].AsString(); got != tt.algorithm {
+					t.Errorf("expected algorithm %s, got %s", tt.algorithm, got)
+				}
+				if got := attributes["limit"].AsInt64(); got != 5 {
+					t.Errorf("expected limit 5, got %d", got)
+				}
+			}
+
+			if allowed, ok := spanAttributes(spans[0])["allowed"]; !ok || allowed.AsBool() {
+				t.Errorf("expected the first span to record allowed=false, got %v", allowed)
+			}
+			if status := spans[1].Status(); status.Code != codes.Error {
+				t.Errorf("expected the failed check's span to have an error status, got %v", status)
+			}
+		})
+	}
+}
+
+func TestTracing_ServiceSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	db, mock := redismock.NewClientMock()
+	cfg := &config.RateLimitConfig{
+		DefaultLimit: 10,
+		WindowSize:   60,
+		Algorithm:    "sliding_window",
+	}
+	svc := service.NewService(db, cfg, zap.NewNop(), service.WithTracerProvider(tp))
+
+	match := mock.CustomMatch(func(expected, actual []interface{}) error {
+		return nil
+	})
+	expectNoCampaign(mock)
+	mock.ExpectGet("rate_limit:config:user1").RedisNil()
+	mock.ExpectGet("rate_limit:default").RedisNil()
+	match.ExpectEvalSha("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(10)})
+
+	// The check nests under the caller's span, e.g. the HTTP request's
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "GET /api/v1/resource")
+	if allowed, err := svc.RateLimit(ctx, "user1", 10); err != nil || !allowed {
+		t.Fatalf("expected the request to be allowed, got %v, %v", allowed, err)
+	}
+	parent.End()
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	check, limiter := spans["ratelimiter.RateLimit"], spans["ratelimiter.Allow"]
+	if check == nil || limiter == nil {
+		t.Fatalf("expected a service and a limiter span, got %v", spans)
+	}
+	if check.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("expected the service span to nest under the caller's span")
+	}
+	if limiter.Parent().SpanID() != check.SpanContext().SpanID() {
+		t.Error("expected the limiter span to nest under the service span")
+	}
+
+	attributes := spanAttributes(check)
+	if got := attributes["user_id"].AsString(); got != ratelimiter.HashUserID("user1") {
+		t.Errorf("expected a hashed user_id, got %q", got)
+	}
+	if got := attributes["algorithm"].AsString(); got != "sliding_window" {
+		t.Errorf("expected algorithm sliding_window, got %s", got)
+	}
+	if got := attributes["limit"].AsInt64(); got != 10 {
+		t.Errorf("expected limit 10, got %d", got)
+	}
+	if !attributes["allowed"].AsBool() {
+		t.Error("expected the span to record allowed=true")
+	}
+}
+
+func TestTracing_NoProvider(t *testing.T) {
+	// Without a registered provider, spans are dropped and checks work as usual
+	db, mock := redismock.NewClientMock()
+	limiter := ratelimiter.NewSlidingWindow(db, zap.NewNop())
+	mock.CustomMatch(func(expected, actual []interface{}) error {
+		return nil
+	}).ExpectEvalSha("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(5)})
+
+	if allowed, err := limiter.Allow(context.Background(), "user1", 5, time.Minute); err != nil || !allowed {
+		t.Fatalf("expected the request to be allowed, got %v, %v", allowed, err)
+	}
+}
@@ -0,0 +1,108 @@
+package ratelimiter
+
+import (
+	"context"
+	"ratelimit-challenge/pkg/ratelimiter"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/go-redis/redismock/v8"
+	"go.uber.org/zap"
+)
+
+// TestKeyTTL_Padding checks the expiry both algorithms set on their keys
+// This is an integration test that requires Redis to be running
+func TestKeyTTL_Padding(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	logger := zap.NewNop()
+
+	tests := []struct {
+		name        string
+		padding     time.Duration
+		windowSize  time.Duration
+		expectedTTL time.Duration
+	}{
+		{name: "configured padding", padding: 3 * time.Second, windowSize: 2 * time.Second, expectedTTL: 5 * time.Second},
+		{name: "default for large window", windowSize: 60 * time.Second, expectedTTL: 66 * time.Second},
+		{name: "default for small window", windowSize: 500 * time.Millisecond, expectedTTL: 600 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiters := map[string]ratelimiter.RateLimiter{
+				"rate_limit:sliding:": ratelimiter.NewSlidingWindow(client, logger, ratelimiter.WithTTLPadding(tt.padding)),
+				"rate_limit:leaky:":   ratelimiter.NewLeakyBucket(client, logger, ratelimiter.WithTTLPadding(tt.padding)),
+			}
+
+			for prefix, limiter := range limiters {
+				userID := "test_user_ttl"
+				_ = limiter.Reset(ctx, userID, tt.windowSize)
+				defer limiter.Reset(ctx, userID, tt.windowSize)
+
+				if _, err := limiter.Allow(ctx, userID, 5, tt.windowSize); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				ttl := client.PTTL(ctx, prefix+userID).Val()
+				if ttl > tt.expectedTTL || ttl < tt.expectedTTL-50*time.Millisecond {
+					t.Errorf("%s: expected TTL close to %v, got %v", prefix, tt.expectedTTL, ttl)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyTTL_Configured(t *testing.T) {
+	logger := zap.NewNop()
+
+	tests := []struct {
+		name        string
+		keyTTL      time.Duration
+		windowSize  time.Duration
+		expectedTTL time.Duration
+	}{
+		{name: "derived from window", windowSize: time.Minute, expectedTTL: 66 * time.Second},
+		{name: "configured", keyTTL: 24 * time.Hour, windowSize: time.Minute, expectedTTL: 24 * time.Hour},
+		{name: "raised to the window", keyTTL: time.Second, windowSize: time.Minute, expectedTTL: 66 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := redismock.NewClientMock()
+			limiters := map[string]ratelimiter.RateLimiter{
+				"sliding window": ratelimiter.NewSlidingWindow(db, logger, ratelimiter.WithKeyTTL(tt.keyTTL)),
+				"leaky bucket":   ratelimiter.NewLeakyBucket(db, logger, ratelimiter.WithKeyTTL(tt.keyTTL)),
+			}
+
+			for name, limiter := range limiters {
+				// The TTL in milliseconds is the third argument from the end
+				var ttlArg interface{}
+				mock.CustomMatch(func(expected, actual []interface{}) error {
+					ttlArg = actual[len(actual)-3]
+					return nil
+				}).ExpectEvalSha("", []string{""}, make([]interface{}, 6)...).SetVal([]interface{}{int64(1), int64(5)})
+
+				if _, err := limiter.Allow(context.Background(), "user1", 5, tt.windowSize); err != nil {
+					t.Fatalf("%s: unexpected error: %v", name, err)
+				}
+				if expected := strconv.FormatInt(tt.expectedTTL.Milliseconds(), 10); ttlArg != expected {
+					t.Errorf("%s: expected the script to expire keys after %sms, got %v", name, expected, ttlArg)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"ratelimit-challenge/internal/config"
+	"ratelimit-challenge/internal/server"
+	"ratelimit-challenge/internal/service/ratelimiter"
+	ratelimiterpkg "ratelimit-challenge/pkg/ratelimiter"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// newTestConfig returns a config with a limit of one request per window
+func newTestConfig(middleware []string) *config.Config {
+	return &config.Config{
+		API: config.HTTPConfig{
+			Middleware: middleware,
+		},
+		Logger: config.LoggerConfig{
+			RequestSampleRate: 1,
+		},
+		RateLimit: config.RateLimitConfig{
+			DefaultLimit:     1,
+			WindowSize:       10,
+			Algorithm:        "sliding_window",
+			EnableLocalCache: false,
+			LocalCacheTTL:    60,
+			DisconnectPolicy: "count",
+		},
+	}
+}
+
+func TestServer_MiddlewareOrder(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		middleware []string
+		// A denied request only carries CORS headers if CORS runs first
+		expectCORS bool
+	}{
+		{name: "cors before rate limit", middleware: []string{"cors", "rate_limit"}, expectCORS: true},
+		{name: "cors after rate limit", middleware: []string{"rate_limit", "cors"}, expectCORS: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(tt.middleware)
+			service := ratelimiter.NewService(client, &cfg.RateLimit, zap.NewNop())
+			srv := server.NewServer(cfg, zap.NewNop(), service, client, prometheus.NewRegistry())
+
+			userID := "test_user_middleware_order"
+			_ = service.Reset(ctx, userID)
+			defer service.Reset(ctx, userID)
+
+			var rec *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest(http.MethodGet, "/api/v1/test", nil)
+				req.Header.Set("X-User-ID", userID)
+				req.Header.Set("Origin", "http://example.com")
+				rec = httptest.NewRecorder()
+				srv.ServeHTTP(rec, req)
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			if rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("expected status 429, got %d", rec.Code)
+			}
+			hasCORS := rec.Header().Get("Access-Control-Allow-Origin") != ""
+			if hasCORS != tt.expectCORS {
+				t.Errorf("expected CORS headers %v, got %v", tt.expectCORS, hasCORS)
+			}
+		})
+	}
+}
+
+func TestServer_HealthNotRateLimited(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	cfg := newTestConfig(config.MiddlewareNames)
+	service := ratelimiter.NewService(client, &cfg.RateLimit, zap.NewNop())
+	srv := server.NewServer(cfg, zap.NewNop(), service, client, prometheus.NewRegistry())
+
+	userID := "test_user_health"
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set("X-User-ID", userID)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200 for health check %d, got %d", i+1, rec.Code)
+		}
+	}
+}
+
+func TestServer_Metrics(t *testing.T) {
+	client := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Skipf("Skipping integration test: Redis not available: %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	cfg := newTestConfig(config.MiddlewareNames)
+	service := ratelimiter.NewService(client, &cfg.RateLimit, zap.NewNop(),
+		ratelimiter.WithMetrics(ratelimiterpkg.NewMetrics(registry)),
+	)
+	srv := server.NewServer(cfg, zap.NewNop(), service, client, registry)
+
+	userID := "test_user_metrics"
+	_ = service.Reset(ctx, userID)
+	defer service.Reset(ctx, userID)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/test", nil)
+	req.Header.Set("X-User-ID", userID)
+	srv.ServeHTTP(httptest.NewRecorder(), req)
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	expected := `rate_limit_redis_duration_seconds_count{algorithm="sliding_window",operation="allow"} 1`
+	if !strings.Contains(rec.Body.String(), expected) {
+		t.Errorf("expected metrics to contain %q, got:\n%s", expected, rec.Body.String())
+	}
+}